@@ -0,0 +1,84 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package calculator
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"google.golang.org/api/bigquery/v2"
+)
+
+// Table names can't be query parameters, so they are validated before being put into the query
+var billingExportTableRegexp = regexp.MustCompile(`^[\w-]+\.[\w-]+\.[\w-]+$`)
+
+const billedClusterCostQuery = "SELECT IFNULL(SUM(cost), 0) + IFNULL(SUM((SELECT SUM(c.amount) FROM UNNEST(credits) c)), 0) " +
+	"FROM `%s` " +
+	"WHERE project.id = @project " +
+	"AND usage_start_time >= TIMESTAMP_SUB(CURRENT_TIMESTAMP(), INTERVAL @days DAY) " +
+	"AND EXISTS (SELECT 1 FROM UNNEST(labels) l WHERE l.key = 'goog-k8s-cluster-name' AND l.value = @cluster) " +
+	"AND EXISTS (SELECT 1 FROM UNNEST(labels) l WHERE l.key = 'goog-k8s-cluster-location' AND l.value = @location)"
+
+// GetBilledClusterCost returns the actual spend, credits included, of the resources labeled with the
+// cluster name and location over the last days, read from a Cloud Billing BigQuery export table
+// (project.dataset.table). Clusters of the same name in other locations of the project aren't counted.
+func GetBilledClusterCost(ctx context.Context, bigqueryService *bigquery.Service, table string, project string, clusterName string, location string, days int) (float64, error) {
+	if !billingExportTableRegexp.MatchString(table) {
+		return 0, fmt.Errorf("invalid billing export table %q, expected project.dataset.table", table)
+	}
+
+	useLegacySql := false
+	request := &bigquery.QueryRequest{
+		Query:         fmt.Sprintf(billedClusterCostQuery, table),
+		UseLegacySql:  &useLegacySql,
+		ParameterMode: "NAMED",
+		TimeoutMs:     60000,
+		QueryParameters: []*bigquery.QueryParameter{
+			{Name: "project", ParameterType: &bigquery.QueryParameterType{Type: "STRING"}, ParameterValue: &bigquery.QueryParameterValue{Value: project}},
+			{Name: "cluster", ParameterType: &bigquery.QueryParameterType{Type: "STRING"}, ParameterValue: &bigquery.QueryParameterValue{Value: clusterName}},
+			{Name: "location", ParameterType: &bigquery.QueryParameterType{Type: "STRING"}, ParameterValue: &bigquery.QueryParameterValue{Value: location}},
+			{Name: "days", ParameterType: &bigquery.QueryParameterType{Type: "INT64"}, ParameterValue: &bigquery.QueryParameterValue{Value: strconv.Itoa(days)}},
+		},
+	}
+
+	// Query jobs run in the project owning the export table
+	response, err := bigqueryService.Jobs.Query(strings.Split(table, ".")[0], request).Context(ctx).Do()
+	if err != nil {
+		return 0, fmt.Errorf("unable to query billing export: %v", err)
+	}
+
+	if !response.JobComplete {
+		return 0, fmt.Errorf("billing export query didn't complete in time")
+	}
+
+	if len(response.Rows) == 0 || len(response.Rows[0].F) == 0 || response.Rows[0].F[0].V == nil {
+		return 0, nil
+	}
+
+	value, ok := response.Rows[0].F[0].V.(string)
+	if !ok {
+		return 0, fmt.Errorf("unexpected billing export query result: %v", response.Rows[0].F[0].V)
+	}
+
+	cost, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("unable to parse billing export query result: %v", err)
+	}
+
+	return cost, nil
+}
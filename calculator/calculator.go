@@ -16,37 +16,189 @@ package calculator
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
 	"math"
+	"path"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/GoogleCloudPlatform/autopilot-cost-calculator/cluster"
+	"golang.org/x/exp/slices"
+	"golang.org/x/exp/slog"
+	"google.golang.org/api/option"
 	"gopkg.in/ini.v1"
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/client-go/kubernetes"
+	metricsapi "k8s.io/metrics/pkg/apis/metrics/v1beta1"
 	metricsv "k8s.io/metrics/pkg/client/clientset/versioned"
 )
 
 const CLUSTER_FEE = 0.1
 
+// HOURS_PER_MONTH is the average number of hours in a month used for monthly projections
+const HOURS_PER_MONTH = 730
+
+// computeClassKeys maps a compute class to the prefix of its keys in the ratios and increments config sections.
+var computeClassKeys = map[cluster.ComputeClass]string{
+	cluster.ComputeClassGeneralPurpose: "generalpurpose",
+	cluster.ComputeClassBalanced:       "balanced",
+	cluster.ComputeClassScaleout:       "scaleout",
+	cluster.ComputeClassScaleoutArm:    "scaleout",
+	cluster.ComputeClassPerformance:    "performance",
+	cluster.ComputeClassAccelerator:    "accelerator",
+	cluster.ComputeClassGPUPod:         "gpupod",
+}
+
+// upperLimitKeys maps a compute class to the config keys holding its per-pod mCPU and memory maximums.
+var upperLimitKeys = map[cluster.ComputeClass][2]string{
+	cluster.ComputeClassGeneralPurpose: {"generalpurpose_mcpu_max", "generalpurpose_memory_max"},
+	cluster.ComputeClassBalanced:       {"balanced_mcpu_max", "balanced_memory_max"},
+	cluster.ComputeClassScaleout:       {"scaleout_mcpu_max", "scaleout_memory_max"},
+	cluster.ComputeClassScaleoutArm:    {"scaleout_arm_mcpu_max", "scaleout_arm_memory_max"},
+	cluster.ComputeClassPerformance:    {"performance_mcpu_max", "performance_memory_max"},
+}
+
+// gpuUpperLimitKeys maps a GPU model to the config keys holding the per-pod mCPU and memory maximums
+// for GPU Pod and Accelerator compute classes.
+var gpuUpperLimitKeys = map[string][2]string{
+	"nvidia-tesla-t4":   {"gpupod_t4_mcpu_max", "gpupod_t4_memory_max"},
+	"nvidia-l4":         {"gpupod_l4_mcpu_max", "gpupod_l4_memory_max"},
+	"nvidia-tesla-a100": {"gpupod_a100_40_mcpu_max", "gpupod_a100_40_memory_max"},
+	"nvidia-a100-80gb":  {"gpupod_a100_80_mcpu_max", "gpupod_a100_80_memory_max"},
+	"nvidia-h100-80gb":  {"accelerator_h100_80_mcpu_max", "accelerator_h100_80_memory_max"},
+}
+
+// WorkloadFilter restricts which pods are costed. Empty fields match everything.
+// Only running pods are costed, unless IncludePending is set.
+type WorkloadFilter struct {
+	Namespaces []string
+	Selector   labels.Selector
+	// Exclude lists glob patterns, like monitoring/* or */job-*, of the namespace/name of pods left out of the estimate
+	Exclude        []string
+	IncludePending bool
+	// IncludeSystem also costs the pods of the SystemNamespaces, which are marked as System workloads
+	IncludeSystem bool
+	// NodePool only costs the pods running on the nodes of this node pool, pending pods are left out
+	NodePool string
+	// Pods only costs the pods with these names, in any namespace
+	Pods []string
+}
+
+// SystemNamespaces hold the GKE managed system pods, which are left out of the estimate unless IncludeSystem is set
+var SystemNamespaces = []string{"kube-system", "gke-gmp-system", "gmp-system"}
+
+// namespaceFieldSelector returns the field selector leaving out the system namespaces, empty with IncludeSystem.
+func (filter WorkloadFilter) namespaceFieldSelector() string {
+	if filter.IncludeSystem {
+		return ""
+	}
+
+	var selectors []string
+	for _, namespace := range SystemNamespaces {
+		selectors = append(selectors, "metadata.namespace!="+namespace)
+	}
+
+	return strings.Join(selectors, ",")
+}
+
+// IsSystemNamespace returns whether the namespace is one of the SystemNamespaces.
+func IsSystemNamespace(namespace string) bool {
+	return slices.Contains(SystemNamespaces, namespace)
+}
+
+// PodOwner returns the controller of the pod as Kind/Name, like Deployment/web, empty for pods without one. Pods of
+// a ReplicaSet created by a Deployment are owned by the Deployment, whose name stays the same across rollouts.
+func PodOwner(pod *corev1.Pod) string {
+	owner := metav1.GetControllerOf(pod)
+	if owner == nil {
+		return ""
+	}
+
+	if hash := pod.Labels[appsv1.DefaultDeploymentUniqueLabelKey]; owner.Kind == "ReplicaSet" && hash != "" {
+		if deployment, ok := strings.CutSuffix(owner.Name, "-"+hash); ok {
+			return "Deployment/" + deployment
+		}
+	}
+
+	return owner.Kind + "/" + owner.Name
+}
+
+// Excluded returns whether the pod matches one of the exclude patterns. Invalid patterns never match.
+func (filter WorkloadFilter) Excluded(namespace string, name string) bool {
+	for _, pattern := range filter.Exclude {
+		if matched, _ := path.Match(pattern, namespace+"/"+name); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// podNamed returns whether the pod is one of the Pods, always true without Pods.
+func (filter WorkloadFilter) podNamed(name string) bool {
+	return len(filter.Pods) == 0 || slices.Contains(filter.Pods, name)
+}
+
+// Basis selects which resources of the pods are billed.
+type Basis string
+
+const (
+	// BasisMax bills the highest of the requests and the usage, like Autopilot does for running pods
+	BasisMax Basis = "max"
+	// BasisRequests bills the requests only, which also allows costing pending pods without usage
+	BasisRequests Basis = "requests"
+	// BasisLimits bills the limits of the containers above their requests, the potential cost of burstable pods
+	// if their requests were raised to what they can burst to
+	BasisLimits Basis = "limits"
+)
+
 type PricingService struct {
 	AutopilotPricing AutopilotPriceList
 	GCEPricing       GCEPriceList
 	Config           *ini.File
-	clientset        *kubernetes.Clientset
-	metricsClientset *metricsv.Clientset
+	Filter           WorkloadFilter
+	Basis            Basis
+	PerContainer     bool
+	Explain          bool
+	// CheckCompatibility lists the settings Autopilot would reject in the Incompatibilities of the workloads, see
+	// AutopilotIncompatibilities
+	CheckCompatibility bool
+	// AllowedClasses restricts the compute classes DecideComputeClass picks from, nil allows all of them, see
+	// ClusterComputeClasses
+	AllowedClasses map[cluster.ComputeClass]bool
+	// CustomComputeClasses maps the custom compute classes of the cluster to the Autopilot class they are billed as,
+	// nil prices pods selecting them like the others
+	CustomComputeClasses map[string]cluster.ComputeClass
+	// RulesVersion selects the versioned rule sections of the config, see RulesVersionFor. Empty uses the current rules
+	RulesVersion     string
+	Clientset        kubernetes.Interface
+	MetricsClientset metricsv.Interface
+	// ProrateJobs bills the pods of Jobs for runs of JobRuntime, or of their deadline when JobRuntime is 0, instead
+	// of running all month. Jobs of a CronJob run as often as its schedule, the others once a month
+	ProrateJobs bool
+	JobRuntime  time.Duration
+	// Usage replaces the metrics-server usage of the containers it has, see GetContainerUsage. Nil uses the snapshot
+	// of metrics-server
+	Usage ContainerUsage
+	// Progress is called with the number of pods described so far and the total, nil reports nothing
+	Progress func(done int, total int)
 }
 
-func NewService(sku map[string]string, region string, clientset *kubernetes.Clientset, metricsClientset *metricsv.Clientset, config *ini.File) (*PricingService, error) {
-	apPricing, err := GetAutopilotPricing(sku["autopilot"], region)
+// NewService fetches the pricing of the region effective at the pricing date, the zero time for now, and sets up
+// the pricing service. The client options are passed to the Cloud Billing service.
+func NewService(sku map[string]string, skuMap SKUMap, region string, pricingDate time.Time, clientset kubernetes.Interface, metricsClientset metricsv.Interface, config *ini.File, clientOptions ...option.ClientOption) (*PricingService, error) {
+	apPricing, err := GetAutopilotPricing(sku["autopilot"], region, DefaultCurrency, pricingDate, skuMap, clientOptions...)
 	if err != nil {
 		return nil, err
 	}
 
-	gcePricing, err := GetGCEPricing(sku["gce"], region)
+	gcePricing, err := GetGCEPricing(sku["gce"], region, DefaultCurrency, pricingDate, skuMap, clientOptions...)
 	if err != nil {
 		return nil, err
 	}
@@ -54,148 +206,217 @@ func NewService(sku map[string]string, region string, clientset *kubernetes.Clie
 	service := &PricingService{
 		AutopilotPricing: apPricing,
 		GCEPricing:       gcePricing,
-		clientset:        clientset,
-		metricsClientset: metricsClientset,
+		Clientset:        clientset,
+		MetricsClientset: metricsClientset,
 		Config:           config,
+		Basis:            BasisMax,
 	}
 
 	return service, nil
 }
 
-func (service *PricingService) CalculatePricing(cpu int64, memory int64, storage int64, gpu int64, gpuModel string, class cluster.ComputeClass, instanceType string, spot bool) float64 {
+// resourceCost prices the mCPU, memory and storage with their prices per vCPU or GiB and hour, as normalized by
+// NormalizePrice. The mCPU are thousandths of a vCPU and the MB of memory and storage are priced as thousandths of a
+// GiB, hence the division by 1000.
+func resourceCost(cpuPrice float64, memoryPrice float64, storagePrice float64, cpu int64, memory int64, storage int64) cluster.CostBreakdown {
+	return cluster.CostBreakdown{
+		CPU:     cpuPrice * float64(cpu) / 1000,
+		Memory:  memoryPrice * float64(memory) / 1000,
+		Storage: storagePrice * float64(storage) / 1000,
+	}
+}
+
+// addMachinePrice adds the price of the GCE machine type running the workload to the breakdown.
+func (service *PricingService) addMachinePrice(breakdown cluster.CostBreakdown, instanceType string, spot bool) cluster.CostBreakdown {
+	cpuPrice, memoryPrice := service.gceMachinePrice(instanceType, spot)
+	breakdown.CPU += cpuPrice
+	breakdown.Memory += memoryPrice
+
+	return breakdown
+}
+
+// CalculatePricing returns the hourly cost of the resources of a workload on the compute class, broken down per resource.
+func (service *PricingService) CalculatePricing(cpu int64, memory int64, storage int64, gpu int64, gpuModel string, class cluster.ComputeClass, instanceType string, spot bool) cluster.CostBreakdown {
+	breakdown := service.resourcePricing(cpu, memory, storage, gpu, gpuModel, class, instanceType, spot)
+	breakdown.Total = breakdown.CPU + breakdown.Memory + breakdown.Storage + breakdown.GPU
+
+	return breakdown
+}
+
+// resourcePricing prices each resource of a workload with the prices of the compute class.
+func (service *PricingService) resourcePricing(cpu int64, memory int64, storage int64, gpu int64, gpuModel string, class cluster.ComputeClass, instanceType string, spot bool) cluster.CostBreakdown {
+	pricing := service.AutopilotPricing
+
 	// If spot, calculations are done based on spot pricing
 	if spot {
 		switch class {
 		case cluster.ComputeClassPerformance:
-			perfPrice := service.AutopilotPricing.SpotPerformanceCpuPricePremium*float64(cpu)/1000 + service.AutopilotPricing.SpotPerformanceMemoryPricePremium*float64(memory)/1000 + service.AutopilotPricing.SpotPerformanceLocalSSDPricePremium*float64(storage)/1000
-			if perfPrice == 0 {
-				log.Printf("Requested Spot Performance (%s) pricing is not available in %s region.", instanceType, service.AutopilotPricing.Region)
+			breakdown := resourceCost(pricing.SpotPerformanceCpuPricePremium, pricing.SpotPerformanceMemoryPricePremium, pricing.SpotPerformanceLocalSSDPricePremium, cpu, memory, storage)
+			if breakdown.CPU+breakdown.Memory+breakdown.Storage == 0 {
+				slog.Warn("Requested Spot Performance pricing is not available in the region", "instance_type", instanceType, "region", pricing.Region)
 			}
 
-			gcePrice, _ := service.GetGCEMachinePrice(instanceType, spot)
-
-			return perfPrice + gcePrice
+			return service.addMachinePrice(breakdown, instanceType, spot)
 		case cluster.ComputeClassAccelerator:
 			// TODO lookup machine type and add to the price
-			acceleratorPrice := service.AutopilotPricing.SpotAcceleratorCpuPricePremium*float64(cpu)/1000 + service.AutopilotPricing.SpotAcceleratorMemoryGPUPricePremium*float64(memory)/1000 + service.AutopilotPricing.AcceleratorLocalSSDPricePremium*float64(storage)/1000
+			breakdown := resourceCost(pricing.SpotAcceleratorCpuPricePremium, pricing.SpotAcceleratorMemoryGPUPricePremium, pricing.AcceleratorLocalSSDPricePremium, cpu, memory, storage)
 			switch gpuModel {
 			case "nvidia-tesla-t4":
-				acceleratorPrice += service.AutopilotPricing.SpotAcceleratorT4GPUPricePremium * float64(gpu)
+				breakdown.GPU = pricing.SpotAcceleratorT4GPUPricePremium * float64(gpu)
 			case "nvidia-l4":
-				acceleratorPrice += service.AutopilotPricing.SpotAcceleratorL4GPUPricePremium * float64(gpu)
+				breakdown.GPU = pricing.SpotAcceleratorL4GPUPricePremium * float64(gpu)
 			case "nvidia-tesla-a100":
-				acceleratorPrice += service.AutopilotPricing.SpotAcceleratorA10040GGPUPricePremium * float64(gpu)
+				breakdown.GPU = pricing.SpotAcceleratorA10040GGPUPricePremium * float64(gpu)
 			case "nvidia-a100-80gb":
-				acceleratorPrice += service.AutopilotPricing.SpotAcceleratorA10080GGPUPricePremium * float64(gpu)
+				breakdown.GPU = pricing.SpotAcceleratorA10080GGPUPricePremium * float64(gpu)
 			case "nvidia-h100-80gb":
-				acceleratorPrice += service.AutopilotPricing.SpotAcceleratorH100GPUPricePremium * float64(gpu)
+				breakdown.GPU = pricing.SpotAcceleratorH100GPUPricePremium * float64(gpu)
 			default:
-				acceleratorPrice = 0
-				log.Printf("Requested Spot GPU (%s) pricing for Accelerator compute class (%s) is not available in %s region.", gpuModel, instanceType, service.AutopilotPricing.Region)
+				breakdown = cluster.CostBreakdown{}
+				slog.Warn("Requested Spot GPU pricing for Accelerator compute class is not available in the region", "gpu", gpuModel, "instance_type", instanceType, "region", pricing.Region)
 			}
 
-			gcePrice, _ := service.GetGCEMachinePrice(instanceType, spot)
-			return acceleratorPrice + gcePrice
+			return service.addMachinePrice(breakdown, instanceType, spot)
 
 		case cluster.ComputeClassGPUPod:
-			acceleratorPrice := service.AutopilotPricing.SpotGPUPodvCPUPrice*float64(cpu)/1000 + service.AutopilotPricing.SpotGPUPodMemoryPrice*float64(memory)/1000 + service.AutopilotPricing.SpotGPUPodLocalSSDPrice*float64(storage)/1000
+			breakdown := resourceCost(pricing.SpotGPUPodvCPUPrice, pricing.SpotGPUPodMemoryPrice, pricing.SpotGPUPodLocalSSDPrice, cpu, memory, storage)
 			switch gpuModel {
 			case "nvidia-tesla-t4":
-				acceleratorPrice += service.AutopilotPricing.SpotNVIDIAT4PodGPUPrice * float64(gpu)
+				breakdown.GPU = pricing.SpotNVIDIAT4PodGPUPrice * float64(gpu)
 			case "nvidia-l4":
-				acceleratorPrice += service.AutopilotPricing.SpotNVIDIAL4PodGPUPrice * float64(gpu)
+				breakdown.GPU = pricing.SpotNVIDIAL4PodGPUPrice * float64(gpu)
 			case "nvidia-tesla-a100":
-				acceleratorPrice += service.AutopilotPricing.SpotNVIDIAA10040GPodGPUPrice * float64(gpu)
+				breakdown.GPU = pricing.SpotNVIDIAA10040GPodGPUPrice * float64(gpu)
 			case "nvidia-a100-80gb":
-				acceleratorPrice += service.AutopilotPricing.SpotNVIDIAA10080GPodGPUPrice * float64(gpu)
+				breakdown.GPU = pricing.SpotNVIDIAA10080GPodGPUPrice * float64(gpu)
 			default:
-				acceleratorPrice = 0
-				log.Printf("Requested Spot GPU (%s) pricing is not available in %s region.", gpuModel, service.AutopilotPricing.Region)
+				breakdown = cluster.CostBreakdown{}
+				slog.Warn("Requested Spot GPU pricing is not available in the region", "gpu", gpuModel, "region", pricing.Region)
 			}
-			return acceleratorPrice
+			return breakdown
 
 		case cluster.ComputeClassBalanced:
-			return service.AutopilotPricing.SpotCpuPrice*float64(cpu)/1000 + service.AutopilotPricing.SpotMemoryPrice*float64(memory)/1000 + service.AutopilotPricing.StoragePrice*float64(storage)/1000
+			return resourceCost(pricing.SpotCpuPrice, pricing.SpotMemoryPrice, pricing.StoragePrice, cpu, memory, storage)
 
 		case cluster.ComputeClassScaleout:
-			return service.AutopilotPricing.SpotCpuScaleoutPrice*float64(cpu)/1000 + service.AutopilotPricing.SpotMemoryScaleoutPrice*float64(memory)/1000 + service.AutopilotPricing.StoragePrice*float64(storage)/1000
+			return resourceCost(pricing.SpotCpuScaleoutPrice, pricing.SpotMemoryScaleoutPrice, pricing.StoragePrice, cpu, memory, storage)
 
 		case cluster.ComputeClassScaleoutArm:
-			armPrice := service.AutopilotPricing.SpotArmCpuScaleoutPrice*float64(cpu)/1000 + service.AutopilotPricing.SpotArmMemoryScaleoutPrice*float64(memory)/1000 + service.AutopilotPricing.StoragePrice*float64(storage)/1000
-			if armPrice == 0 {
-				log.Printf("Request Spot ARM (%s) pricing is not available in %s region.", instanceType, service.AutopilotPricing.Region)
-			}
-			return armPrice
+			// Missing ARM pricing is reported once for all the workloads by PopulateWorkloads
+			return resourceCost(pricing.SpotArmCpuScaleoutPrice, pricing.SpotArmMemoryScaleoutPrice, pricing.StoragePrice, cpu, memory, storage)
 
 		default:
-			return service.AutopilotPricing.SpotCpuPrice*float64(cpu)/1000 + service.AutopilotPricing.SpotMemoryPrice*float64(memory)/1000 + service.AutopilotPricing.StoragePrice*float64(storage)/1000
+			return resourceCost(pricing.SpotCpuPrice, pricing.SpotMemoryPrice, pricing.StoragePrice, cpu, memory, storage)
 		}
 	}
 
 	switch class {
 	case cluster.ComputeClassPerformance:
-		perfPrice := service.AutopilotPricing.PerformanceCpuPricePremium*float64(cpu)/1000 + service.AutopilotPricing.PerformanceMemoryPricePremium*float64(memory)/1000 + service.AutopilotPricing.PerformanceLocalSSDPricePremium*float64(storage)/1000
-		if perfPrice == 0 {
-			log.Printf("Requested Performance(%s) pricing is not available in %s region.", instanceType, service.AutopilotPricing.Region)
+		breakdown := resourceCost(pricing.PerformanceCpuPricePremium, pricing.PerformanceMemoryPricePremium, pricing.PerformanceLocalSSDPricePremium, cpu, memory, storage)
+		if breakdown.CPU+breakdown.Memory+breakdown.Storage == 0 {
+			slog.Warn("Requested Performance pricing is not available in the region", "instance_type", instanceType, "region", pricing.Region)
 		}
 
-		gcePrice, _ := service.GetGCEMachinePrice(instanceType, spot)
-		return perfPrice + gcePrice
+		return service.addMachinePrice(breakdown, instanceType, spot)
 	case cluster.ComputeClassAccelerator:
-		acceleratorPrice := service.AutopilotPricing.AcceleratorCpuPricePremium*float64(cpu)/1000 + service.AutopilotPricing.AcceleratorMemoryGPUPricePremium*float64(memory)/1000 + service.AutopilotPricing.AcceleratorLocalSSDPricePremium*float64(storage)/1000
+		breakdown := resourceCost(pricing.AcceleratorCpuPricePremium, pricing.AcceleratorMemoryGPUPricePremium, pricing.AcceleratorLocalSSDPricePremium, cpu, memory, storage)
 		switch gpuModel {
 		case "nvidia-tesla-t4":
-			acceleratorPrice += service.AutopilotPricing.AcceleratorT4GPUPricePremium * float64(gpu)
+			breakdown.GPU = pricing.AcceleratorT4GPUPricePremium * float64(gpu)
 		case "nvidia-l4":
-			acceleratorPrice += service.AutopilotPricing.AcceleratorL4GPUPricePremium * float64(gpu)
+			breakdown.GPU = pricing.AcceleratorL4GPUPricePremium * float64(gpu)
 		case "nvidia-tesla-a100":
-			acceleratorPrice += service.AutopilotPricing.AcceleratorA10040GGPUPricePremium * float64(gpu)
+			breakdown.GPU = pricing.AcceleratorA10040GGPUPricePremium * float64(gpu)
 		case "nvidia-a100-80gb":
-			acceleratorPrice += service.AutopilotPricing.AcceleratorA10080GGPUPricePremium * float64(gpu)
+			breakdown.GPU = pricing.AcceleratorA10080GGPUPricePremium * float64(gpu)
 		case "nvidia-h100-80gb":
-			acceleratorPrice += service.AutopilotPricing.AcceleratorH100GPUPricePremium * float64(gpu)
+			breakdown.GPU = pricing.AcceleratorH100GPUPricePremium * float64(gpu)
 		default:
-			acceleratorPrice = 0
-			log.Printf("Requested spot GPU (%s) pricing for Accelerator compute class (%s) is not available in %s region.", gpuModel, instanceType, service.AutopilotPricing.Region)
+			breakdown = cluster.CostBreakdown{}
+			slog.Warn("Requested GPU pricing for Accelerator compute class is not available in the region", "gpu", gpuModel, "instance_type", instanceType, "region", pricing.Region)
 		}
 
-		gcePrice, _ := service.GetGCEMachinePrice(instanceType, spot)
-
-		return acceleratorPrice + gcePrice
+		return service.addMachinePrice(breakdown, instanceType, spot)
 	case cluster.ComputeClassGPUPod:
-		acceleratorPrice := service.AutopilotPricing.GPUPodvCPUPrice*float64(cpu)/1000 + service.AutopilotPricing.GPUPodMemoryPrice*float64(memory)/1000 + service.AutopilotPricing.GPUPodLocalSSDPrice*float64(storage)/1000
+		breakdown := resourceCost(pricing.GPUPodvCPUPrice, pricing.GPUPodMemoryPrice, pricing.GPUPodLocalSSDPrice, cpu, memory, storage)
 		switch gpuModel {
 		case "nvidia-tesla-t4":
-			acceleratorPrice += service.AutopilotPricing.NVIDIAT4PodGPUPrice * float64(gpu)
+			breakdown.GPU = pricing.NVIDIAT4PodGPUPrice * float64(gpu)
 		case "nvidia-l4":
-			acceleratorPrice += service.AutopilotPricing.NVIDIAL4PodGPUPrice * float64(gpu)
+			breakdown.GPU = pricing.NVIDIAL4PodGPUPrice * float64(gpu)
 		case "nvidia-tesla-a100":
-			acceleratorPrice += service.AutopilotPricing.NVIDIAA10040GPodGPUPrice * float64(gpu)
+			breakdown.GPU = pricing.NVIDIAA10040GPodGPUPrice * float64(gpu)
 		case "nvidia-a100-80gb":
-			acceleratorPrice += service.AutopilotPricing.NVIDIAA10080GPodGPUPrice * float64(gpu)
+			breakdown.GPU = pricing.NVIDIAA10080GPodGPUPrice * float64(gpu)
 		default:
-			acceleratorPrice = 0
-			log.Printf("Requested GPU (%s) pricing is not available in %s region.", gpuModel, service.AutopilotPricing.Region)
+			breakdown = cluster.CostBreakdown{}
+			slog.Warn("Requested GPU pricing is not available in the region", "gpu", gpuModel, "region", pricing.Region)
 		}
-		return acceleratorPrice
+		return breakdown
 	case cluster.ComputeClassBalanced:
-		return service.AutopilotPricing.CpuBalancedPrice*float64(cpu)/1000 + service.AutopilotPricing.MemoryBalancedPrice*float64(memory)/1000 + service.AutopilotPricing.StoragePrice*float64(storage)/1000
+		return resourceCost(pricing.CpuBalancedPrice, pricing.MemoryBalancedPrice, pricing.StoragePrice, cpu, memory, storage)
 	case cluster.ComputeClassScaleout:
-		return service.AutopilotPricing.CpuScaleoutPrice*float64(cpu)/1000 + service.AutopilotPricing.MemoryScaleoutPrice*float64(memory)/1000 + service.AutopilotPricing.StoragePrice*float64(storage)/1000
+		return resourceCost(pricing.CpuScaleoutPrice, pricing.MemoryScaleoutPrice, pricing.StoragePrice, cpu, memory, storage)
 	case cluster.ComputeClassScaleoutArm:
-		armPrice := service.AutopilotPricing.CpuArmScaleoutPrice*float64(cpu)/1000 + service.AutopilotPricing.MemoryArmScaleoutPrice*float64(memory)/1000 + service.AutopilotPricing.StoragePrice*float64(storage)/1000
-		if armPrice == 0 {
-			log.Printf("Request ARM (%s) pricing is not available in %s region.", instanceType, service.AutopilotPricing.Region)
-		}
-		return armPrice
+		// Missing ARM pricing is reported once for all the workloads by PopulateWorkloads
+		return resourceCost(pricing.CpuArmScaleoutPrice, pricing.MemoryArmScaleoutPrice, pricing.StoragePrice, cpu, memory, storage)
 	default:
-		return service.AutopilotPricing.CpuPrice*float64(cpu)/1000 + service.AutopilotPricing.MemoryPrice*float64(memory)/1000 + service.AutopilotPricing.StoragePrice*float64(storage)/1000
+		return resourceCost(pricing.CpuPrice, pricing.MemoryPrice, pricing.StoragePrice, cpu, memory, storage)
 	}
 }
 
 func (service *PricingService) GetGCEMachinePrice(instanceType string, spot bool) (float64, error) {
+	cpuPrice, memoryPrice := service.gceMachinePrice(instanceType, spot)
+	return cpuPrice + memoryPrice, nil
+}
+
+// pricedMachineFamilies are the machine families gceMachinePrice has GCE pricing for.
+var pricedMachineFamilies = map[string]bool{"a2": true, "a3": true, "g2": true, "h3": true, "c2": true, "c2d": true}
+
+// EfficiencyScore returns the Autopilot cost of the workloads of a node over the Standard cost of the node. Below 1
+// the workloads are cheaper on Autopilot, the lower the more the node is lightly loaded. Without a Standard cost
+// there is no score and it returns 0.
+func EfficiencyScore(autopilotCost float64, standardCost float64) float64 {
+	if standardCost <= 0 {
+		return 0
+	}
+
+	return autopilotCost / standardCost
+}
+
+// PopulateNodeEfficiency sets the Standard cost and the efficiency score of the nodes, once their workloads are
+// costed. Nodes of machine families without GCE pricing are left without a score.
+func (service *PricingService) PopulateNodeEfficiency(nodes map[string]cluster.Node) {
+	unpriced := 0
+	for name, node := range nodes {
+		family, _, _ := strings.Cut(node.InstanceType, "-")
+		if !pricedMachineFamilies[family] || strings.Count(node.InstanceType, "-") < 2 {
+			if name != cluster.UnscheduledNodeName {
+				unpriced++
+			}
+			continue
+		}
+
+		node.StandardCost, _ = service.GetGCEMachinePrice(node.InstanceType, node.Spot)
+		node.Efficiency = EfficiencyScore(node.Cost, node.StandardCost)
+		nodes[name] = node
+	}
+
+	if unpriced > 0 {
+		slog.Info("Nodes of machine families without GCE pricing have no efficiency score", "nodes", unpriced)
+	}
+}
 
+// gceMachinePrice returns the hourly price of the CPUs and of the memory of the GCE machine type.
+func (service *PricingService) gceMachinePrice(instanceType string, spot bool) (float64, float64) {
 	instanceInfo := strings.Split(instanceType, "-")
+	// Workloads without a node, like pending pods or the pods of a manifest, have no machine type to price
+	if len(instanceInfo) < 3 {
+		if instanceType != "" {
+			slog.Warn("GCE machine type can't be parsed, leaving out its price", "instance_type", instanceType)
+		}
+		return 0, 0
+	}
 	cpus, _ := strconv.Atoi(instanceInfo[2])
 	ram := 0.0
 	classType := instanceInfo[1]
@@ -215,70 +436,158 @@ func (service *PricingService) GetGCEMachinePrice(instanceType string, spot bool
 	}
 
 	ram = math.Ceil(ram)
-	fmt.Printf("Parsing %s - %d %f %s %s", instanceType, cpus, ram, machineType, classType)
+	slog.Debug("Parsed GCE machine type", "instance_type", instanceType, "cpus", cpus, "ram", ram, "machine_type", machineType, "class_type", classType)
 
 	if spot {
 		switch machineType {
 		case "a2":
-			return service.GCEPricing.SpotA2CpuPrice*float64(cpus) + service.GCEPricing.SpotA2MemoryPrice*ram, nil
+			return service.GCEPricing.SpotA2CpuPrice * float64(cpus), service.GCEPricing.SpotA2MemoryPrice * ram
 		case "a3":
-			return service.GCEPricing.SpotA3CpuPrice*float64(cpus) + service.GCEPricing.SpotA3MemoryPrice*ram, nil
+			return service.GCEPricing.SpotA3CpuPrice * float64(cpus), service.GCEPricing.SpotA3MemoryPrice * ram
 		case "g2":
-			return service.GCEPricing.SpotG2DCpuPrice*float64(cpus) + service.GCEPricing.SpotG2DMemoryPrice*ram, nil
+			return service.GCEPricing.SpotG2DCpuPrice * float64(cpus), service.GCEPricing.SpotG2DMemoryPrice * ram
 		case "h3":
-			fmt.Printf("H3 Machine type is not available in Preemptible Spot format. Defaulting to a regular price.")
-			return service.GCEPricing.H3CpuPrice*float64(cpus) + service.GCEPricing.H3MemoryPrice*ram, nil
+			slog.Warn("H3 Machine type is not available in Preemptible Spot format. Defaulting to a regular price.")
+			return service.GCEPricing.H3CpuPrice * float64(cpus), service.GCEPricing.H3MemoryPrice * ram
 		case "c2":
-			return service.GCEPricing.SpotC2CpuPrice*float64(cpus) + service.GCEPricing.SpotC2MemoryPrice*ram, nil
+			return service.GCEPricing.SpotC2CpuPrice * float64(cpus), service.GCEPricing.SpotC2MemoryPrice * ram
 		case "c2d":
-			return service.GCEPricing.SpotC2DCpuPrice*float64(cpus) + service.GCEPricing.SpotC2DMemoryPrice*ram, nil
+			return service.GCEPricing.SpotC2DCpuPrice * float64(cpus), service.GCEPricing.SpotC2DMemoryPrice * ram
 		default:
-			fmt.Printf("GCE Machine type %s is not implemented for price querying. Only supported ones are A2, A3, G2, H3, C2 and C2D", instanceType)
+			slog.Warn("GCE Machine type is not implemented for price querying. Only supported ones are A2, A3, G2, H3, C2 and C2D", "instance_type", instanceType)
 		}
-		return 0, nil
+		return 0, 0
 	}
 
-	fmt.Printf("%#v", service.GCEPricing)
+	slog.Debug("GCE pricing", "pricing", service.GCEPricing)
 
 	switch machineType {
 	case "a2":
-		return service.GCEPricing.A2CpuPrice*float64(cpus) + service.GCEPricing.A2MemoryPrice*ram, nil
+		return service.GCEPricing.A2CpuPrice * float64(cpus), service.GCEPricing.A2MemoryPrice * ram
 	case "a3":
-		return service.GCEPricing.A3CpuPrice*float64(cpus) + service.GCEPricing.A3MemoryPrice*ram, nil
+		return service.GCEPricing.A3CpuPrice * float64(cpus), service.GCEPricing.A3MemoryPrice * ram
 	case "g2":
-		return service.GCEPricing.G2CpuPrice*float64(cpus) + service.GCEPricing.G2MemoryPrice*ram, nil
+		return service.GCEPricing.G2CpuPrice * float64(cpus), service.GCEPricing.G2MemoryPrice * ram
 	case "h3":
-		return service.GCEPricing.H3CpuPrice*float64(cpus) + service.GCEPricing.H3MemoryPrice*ram, nil
+		return service.GCEPricing.H3CpuPrice * float64(cpus), service.GCEPricing.H3MemoryPrice * ram
 	case "c2":
-		return service.GCEPricing.C2CpuPrice*float64(cpus) + service.GCEPricing.C2MemoryPrice*ram, nil
+		return service.GCEPricing.C2CpuPrice * float64(cpus), service.GCEPricing.C2MemoryPrice * ram
 	case "c2d":
-		return service.GCEPricing.C2DCpuPrice*float64(cpus) + service.GCEPricing.C2DMemoryPrice*ram, nil
+		return service.GCEPricing.C2DCpuPrice * float64(cpus), service.GCEPricing.C2DMemoryPrice * ram
 	default:
-		fmt.Printf("GCE Machine type %s is not implemented for price querying. Only supported ones are A2, A3, G2, H3, C2 and C2D", instanceType)
+		slog.Warn("GCE Machine type is not implemented for price querying. Only supported ones are A2, A3, G2, H3, C2 and C2D", "instance_type", instanceType)
 	}
 
-	return 0, nil
+	return 0, 0
 }
 
 func (service *PricingService) PopulateWorkloads(nodes map[string]cluster.Node) ([]cluster.Workload, error) {
 	var workloads []cluster.Workload
+	missingArmPricing := 0
+	excluded := 0
+	proratedJobs := 0
+	jobs := newJobOwners()
 
-	podMetricsList, err := service.metricsClientset.MetricsV1beta1().PodMetricses("").List(context.TODO(), metav1.ListOptions{FieldSelector: "metadata.namespace!=kube-system,metadata.namespace!=gke-gmp-system,metadata.namespace!=gmp-system"})
+	podMetricsList, err := service.listPodMetrics()
 	if err != nil {
-		log.Fatalf(err.Error())
+		return nil, err
 	}
+	service.Usage.apply(podMetricsList)
 
-	for _, v := range podMetricsList.Items {
-		pod, err := cluster.DescribePod(service.clientset, v.Name, v.Namespace)
+	pods := make(map[string]*corev1.Pod)
+	for i, v := range podMetricsList {
+		if service.Progress != nil {
+			service.Progress(i+1, len(podMetricsList))
+		}
+		if !service.Filter.podNamed(v.Name) {
+			continue
+		}
+
+		pod, err := cluster.DescribePod(service.Clientset, v.Name, v.Namespace)
+		// Pods can be deleted between listing their metrics and describing them
+		if apierrors.IsNotFound(err) {
+			slog.Warn("Skipping workload whose pod was deleted", "pod", v.Name, "namespace", v.Namespace)
+			continue
+		}
 		if err != nil {
 			return nil, err
 		}
+		pods[v.Namespace+"/"+v.Name] = pod
+	}
+
+	// Pending pods have no metrics yet, so they are costed from their requests with an empty usage
+	if service.includePending() {
+		pendingPods, err := service.listPendingPods()
+		if err != nil {
+			return nil, err
+		}
+
+		for i := range pendingPods {
+			pod := &pendingPods[i]
+			if _, ok := pods[pod.Namespace+"/"+pod.Name]; ok || !service.Filter.podNamed(pod.Name) {
+				continue
+			}
+
+			podMetrics := metricsapi.PodMetrics{ObjectMeta: metav1.ObjectMeta{Name: pod.Name, Namespace: pod.Namespace}}
+			for _, container := range pod.Spec.Containers {
+				podMetrics.Containers = append(podMetrics.Containers, metricsapi.ContainerMetrics{Name: container.Name})
+			}
+			podMetricsList = append(podMetricsList, podMetrics)
+			pods[pod.Namespace+"/"+pod.Name] = pod
+		}
+	}
+
+	if len(service.Filter.Pods) > 0 {
+		found := make(map[string]bool)
+		for _, pod := range pods {
+			found[pod.Name] = true
+		}
+		for _, name := range service.Filter.Pods {
+			if !found[name] {
+				slog.Warn("No running pod found with this name", "pod", name)
+			}
+		}
+	}
+
+	for _, v := range podMetricsList {
+		pod, ok := pods[v.Namespace+"/"+v.Name]
+		if !ok {
+			continue
+		}
+
+		// Metrics might not carry the pod labels, so the selector is checked against the pod itself
+		if service.Filter.Selector != nil && !service.Filter.Selector.Matches(labels.Set(pod.Labels)) {
+			continue
+		}
+
+		if service.Filter.Excluded(pod.Namespace, pod.Name) {
+			excluded++
+			continue
+		}
+
+		if service.Filter.NodePool != "" && nodes[pod.Spec.NodeName].NodePool != service.Filter.NodePool {
+			continue
+		}
+
+		if !service.shouldCost(pod) {
+			continue
+		}
+
+		// Autopilot doesn't run Windows workloads, so they would be priced as Linux ones
+		if isWindows(pod, nodes[pod.Spec.NodeName]) {
+			slog.Warn("Skipping Windows workload, it's unsupported on Autopilot", "pod", pod.Name, "namespace", pod.Namespace, "node", pod.Spec.NodeName)
+			continue
+		}
 
 		var cpu int64 = 0
 		var memory int64 = 0
 		var storage int64 = 0
 		var gpu int64 = 0
+		var requested, used, limited podResources
+		burstable := false
 		podContainerCount := 0
+		var containerWorkloads []cluster.Workload
+		var containerRequests, containerUsages, containerLimits []podResources
 
 		gpuModel := pod.Spec.NodeSelector["cloud.google.com/gke-accelerator"]
 
@@ -286,28 +595,41 @@ func (service *PricingService) PopulateWorkloads(nodes map[string]cluster.Node)
 		for _, container := range v.Containers {
 
 			cpuUsage := container.Usage.Cpu().MilliValue()
-			memoryUsage := container.Usage.Memory().MilliValue() / 1000000000            // Division to get MiB
-			storageUsage := container.Usage.StorageEphemeral().MilliValue() / 1000000000 // Division to get MiB
+			memoryUsage := cluster.MB(container.Usage.Memory())
+			storageUsage := cluster.MB(container.Usage.StorageEphemeral())
 			gpuUsage := int64(0)
+			containerUsed := podResources{cpu: cpuUsage, memory: memoryUsage, storage: storageUsage}
+			var containerRequested, containerLimited podResources
+
+			if service.Basis == BasisRequests || service.Basis == BasisLimits {
+				cpuUsage, memoryUsage, storageUsage = 0, 0, 0
+			}
 
 			for _, specContainer := range pod.Spec.Containers {
 				if container.Name == specContainer.Name {
 					cpuRequest := specContainer.Resources.Requests[corev1.ResourceCPU]
 					memoryRequest := specContainer.Resources.Requests[corev1.ResourceMemory]
-					storageRequest := specContainer.Resources.Requests[corev1.ResourceStorage]
+					storageRequest := specContainer.Resources.Requests[corev1.ResourceEphemeralStorage]
 					gpuRequests := specContainer.Resources.Requests["nvidia.com/gpu"]
+					containerRequested = podResources{cpu: cpuRequest.MilliValue(), memory: cluster.MB(&memoryRequest), storage: cluster.MB(&storageRequest)}
+					containerLimited = containerRequested.max(containerLimitResources(specContainer))
+					burstable = burstable || containerLimited.cpu > containerRequested.cpu || containerLimited.memory > containerRequested.memory
+
+					if service.Basis == BasisLimits {
+						cpuUsage, memoryUsage, storageUsage = containerLimited.cpu, containerLimited.memory, containerLimited.storage
+					}
 
 					// Usage is less than requests, so we set request as usage since the billing works like that
 					if cpuUsage < cpuRequest.MilliValue() {
 						cpuUsage = cpuRequest.MilliValue()
 					}
 
-					if memoryUsage < memoryRequest.MilliValue()/1000000000 {
-						memoryUsage = memoryRequest.MilliValue() / 1000000000
+					if memoryUsage < containerRequested.memory {
+						memoryUsage = containerRequested.memory
 					}
 
-					if storageUsage < storageRequest.MilliValue()/1000000000 {
-						storageUsage = memoryRequest.MilliValue() / 1000000000
+					if storageUsage < containerRequested.storage {
+						storageUsage = containerRequested.storage
 					}
 
 					gpuUsage = gpuRequests.Value()
@@ -319,112 +641,444 @@ func (service *PricingService) PopulateWorkloads(nodes map[string]cluster.Node)
 			storage += storageUsage
 			gpu += gpuUsage
 			podContainerCount++
+			requested = requested.add(containerRequested)
+			used = used.add(containerUsed)
+			limited = limited.add(containerLimited)
+			containerRequests = append(containerRequests, containerRequested)
+			containerUsages = append(containerUsages, containerUsed)
+			containerLimits = append(containerLimits, containerLimited)
+
+			containerWorkloads = append(containerWorkloads, cluster.Workload{
+				Name:              v.Name + "/" + container.Name,
+				Containers:        1,
+				Cpu:               cpuUsage,
+				Memory:            memoryUsage,
+				RawCpu:            cpuUsage,
+				RawMemory:         memoryUsage,
+				Storage:           storageUsage,
+				AcceleratorAmount: gpuUsage,
+			})
+		}
+
+		// Pending pods have no usage yet, so right-sizing leaves them as requested
+		if pod.Status.Phase == corev1.PodPending {
+			used, containerUsages = requested, containerRequests
 		}
 
-		// Check and modify the limits of summed workloads from the Pod
-		cpu, memory, storage = ValidateAndRoundResources(cpu, memory, storage)
+		// Autopilot applies its minimums and rounding to the pod as a whole, the sum of its containers, and not to
+		// each container, so many tiny containers are billed like a single container with their total resources
+		rawCpu, rawMemory := cpu, memory
+		cpu, memory, storage = service.ValidateAndRoundResources(cpu, memory, storage)
 
-		computeClass := service.DecideComputeClass(
+		computeClass, classReason := service.ExplainComputeClass(
 			v.Name,
 			nodes[pod.Spec.NodeName].InstanceType,
 			cpu,
 			memory,
 			gpu,
 			gpuModel,
-			strings.Contains(nodes[pod.Spec.NodeName].InstanceType, service.Config.Section("").Key("gce_arm64_prefix").String()),
+			nodes[pod.Spec.NodeName].Arm64(),
 		)
 
-		cost := service.CalculatePricing(cpu, memory, storage, gpu, gpuModel, computeClass, nodes[pod.Spec.NodeName].InstanceType, nodes[pod.Spec.NodeName].Spot)
+		if class, ok := service.CustomComputeClasses[pod.Spec.NodeSelector[ComputeClassSelector]]; ok {
+			computeClass = class
+			classReason = fmt.Sprintf("custom compute class %s is billed as %s", pod.Spec.NodeSelector[ComputeClassSelector], cluster.ComputeClasses[class])
+		}
+
+		// Autopilot bills the resources rounded up to the increments of the chosen compute class
+		cpu, memory = service.RoundResources(computeClass, cpu, memory)
+
+		service.ValidateUpperLimits(v.Name, computeClass, gpuModel, cpu, memory)
+
+		breakdown := service.CalculatePricing(cpu, memory, storage, gpu, gpuModel, computeClass, nodes[pod.Spec.NodeName].InstanceType, nodes[pod.Spec.NodeName].Spot)
+		spotCost := service.CalculatePricing(cpu, memory, storage, gpu, gpuModel, computeClass, nodes[pod.Spec.NodeName].InstanceType, true).Total
 
 		workloadObject := cluster.Workload{
 			Name:              v.Name,
+			Namespace:         pod.Namespace,
+			Owner:             PodOwner(pod),
 			Containers:        podContainerCount,
 			Node_name:         pod.Spec.NodeName,
 			Cpu:               cpu,
 			Memory:            memory,
+			RawCpu:            rawCpu,
+			RawMemory:         rawMemory,
+			RequestedCpu:      requested.cpu,
+			UsedCpu:           used.cpu,
+			RequestedMemory:   requested.memory,
+			UsedMemory:        used.memory,
 			Storage:           storage,
 			AcceleratorType:   gpuModel,
 			AcceleratorAmount: gpu,
-			Cost:              cost,
+			Cost:              breakdown.Total,
+			Breakdown:         breakdown,
+			SpotCost:          spotCost,
+			RequestCost:       requested.cost(service, gpu, gpuModel, computeClass, nodes[pod.Spec.NodeName], true),
+			UsageCost:         used.cost(service, gpu, gpuModel, computeClass, nodes[pod.Spec.NodeName], true),
+			LimitCost:         limited.cost(service, gpu, gpuModel, computeClass, nodes[pod.Spec.NodeName], true),
+			Burstable:         burstable,
 			ComputeClass:      computeClass,
 		}
+		if service.Explain {
+			workloadObject.ClassReason = classReason
+		}
 
-		workloads = append(workloads, workloadObject)
+		workloadObject.System = IsSystemNamespace(pod.Namespace)
+		if service.CheckCompatibility {
+			workloadObject.Incompatibilities = AutopilotIncompatibilities(pod)
+			if len(workloadObject.Incompatibilities) > 0 {
+				slog.Warn("Workload would be rejected by Autopilot", "workload", v.Name, "namespace", pod.Namespace, "settings", strings.Join(workloadObject.Incompatibilities, ", "))
+			}
+		}
+		podWorkloads := []cluster.Workload{workloadObject}
+
+		// Containers are priced on the compute class of their pod, without the pod minimums and rounding,
+		// so they can add up to less than the pod
+		if service.PerContainer {
+			podWorkloads = containerWorkloads
+			for i := range podWorkloads {
+				podWorkloads[i].Node_name = pod.Spec.NodeName
+				podWorkloads[i].Namespace = pod.Namespace
+				podWorkloads[i].Owner = workloadObject.Owner
+				podWorkloads[i].AcceleratorType = gpuModel
+				podWorkloads[i].ComputeClass = computeClass
+				podWorkloads[i].ClassReason = workloadObject.ClassReason
+				podWorkloads[i].System = workloadObject.System
+				podWorkloads[i].Incompatibilities = workloadObject.Incompatibilities
+				containerBreakdown := service.CalculatePricing(podWorkloads[i].Cpu, podWorkloads[i].Memory, podWorkloads[i].Storage, podWorkloads[i].AcceleratorAmount, gpuModel, computeClass, nodes[pod.Spec.NodeName].InstanceType, nodes[pod.Spec.NodeName].Spot)
+				podWorkloads[i].Cost = containerBreakdown.Total
+				podWorkloads[i].Breakdown = containerBreakdown
+				podWorkloads[i].SpotCost = service.CalculatePricing(podWorkloads[i].Cpu, podWorkloads[i].Memory, podWorkloads[i].Storage, podWorkloads[i].AcceleratorAmount, gpuModel, computeClass, nodes[pod.Spec.NodeName].InstanceType, true).Total
+				podWorkloads[i].RequestCost = containerRequests[i].cost(service, podWorkloads[i].AcceleratorAmount, gpuModel, computeClass, nodes[pod.Spec.NodeName], false)
+				podWorkloads[i].UsageCost = containerUsages[i].cost(service, podWorkloads[i].AcceleratorAmount, gpuModel, computeClass, nodes[pod.Spec.NodeName], false)
+				podWorkloads[i].LimitCost = containerLimits[i].cost(service, podWorkloads[i].AcceleratorAmount, gpuModel, computeClass, nodes[pod.Spec.NodeName], false)
+				podWorkloads[i].Burstable = containerLimits[i].cpu > containerRequests[i].cpu || containerLimits[i].memory > containerRequests[i].memory
+				podWorkloads[i].RequestedCpu, podWorkloads[i].RequestedMemory = containerRequests[i].cpu, containerRequests[i].memory
+				podWorkloads[i].UsedCpu, podWorkloads[i].UsedMemory = containerUsages[i].cpu, containerUsages[i].memory
+			}
+		}
 
-		if entry, ok := nodes[pod.Spec.NodeName]; ok {
-			entry.Workloads = append(entry.Workloads, workloadObject)
-			entry.Cost += cost
-			nodes[pod.Spec.NodeName] = entry
+		if runtime, runs, ok := service.jobRuns(pod, jobs); ok {
+			for i := range podWorkloads {
+				prorateJob(&podWorkloads[i], runtime, runs)
+			}
+			proratedJobs++
+		}
+
+		workloads = append(workloads, podWorkloads...)
+
+		if computeClass == cluster.ComputeClassScaleoutArm && !service.armPricingAvailable(nodes[pod.Spec.NodeName].Spot) {
+			missingArmPricing++
+		}
+
+		// Pods whose node isn't listed (filtered, deleted or not scheduled yet) are grouped under a
+		// placeholder node, so the node totals always add up to the workload totals
+		nodeName := pod.Spec.NodeName
+		if nodeName == "" {
+			nodeName = cluster.UnscheduledNodeName
+		}
+		entry, ok := nodes[nodeName]
+		if !ok {
+			entry = cluster.Node{Name: nodeName}
+		}
+		for _, podWorkload := range podWorkloads {
+			entry.Workloads = append(entry.Workloads, podWorkload)
+			// System pods are normally managed by GKE, so they aren't part of the billable cost
+			if !podWorkload.System {
+				entry.Cost += podWorkload.Cost
+			}
 		}
+		nodes[nodeName] = entry
 
 	}
 
+	if excluded > 0 {
+		slog.Info("Workloads matching the exclude patterns were left out of the estimate", "workloads", excluded)
+	}
+
+	if proratedJobs > 0 {
+		slog.Info("Pods of Jobs are billed for their runs per month", "pods", proratedJobs)
+	}
+
+	// Clusters can mix x86 and ARM node pools, so this is only worth a warning when ARM workloads exist
+	if missingArmPricing > 0 {
+		slog.Warn("ARM pricing is not available in the region, ARM workloads are priced without it", "region", service.AutopilotPricing.Region, "workloads", missingArmPricing)
+	}
+
 	return workloads, nil
 
 }
 
+// podResources are the mCPU, memory and storage in MB of a pod or container.
+type podResources struct {
+	cpu     int64
+	memory  int64
+	storage int64
+}
+
+func (resources podResources) add(other podResources) podResources {
+	return podResources{resources.cpu + other.cpu, resources.memory + other.memory, resources.storage + other.storage}
+}
+
+// max returns the highest of both resources, one by one.
+func (resources podResources) max(other podResources) podResources {
+	if other.cpu > resources.cpu {
+		resources.cpu = other.cpu
+	}
+	if other.memory > resources.memory {
+		resources.memory = other.memory
+	}
+	if other.storage > resources.storage {
+		resources.storage = other.storage
+	}
+
+	return resources
+}
+
+// containerLimitResources returns the limits of a container, zero for the resources without a limit.
+func containerLimitResources(container corev1.Container) podResources {
+	cpuLimit := container.Resources.Limits[corev1.ResourceCPU]
+	memoryLimit := container.Resources.Limits[corev1.ResourceMemory]
+	storageLimit := container.Resources.Limits[corev1.ResourceEphemeralStorage]
+
+	return podResources{cpu: cpuLimit.MilliValue(), memory: cluster.MB(&memoryLimit), storage: cluster.MB(&storageLimit)}
+}
+
+// cost prices the resources on the compute class of the workload. Pods get Autopilot's minimums and rounding,
+// containers are priced as they are, like in the per-container mode.
+func (resources podResources) cost(service *PricingService, gpu int64, gpuModel string, computeClass cluster.ComputeClass, node cluster.Node, pod bool) float64 {
+	cpu, memory, storage := resources.cpu, resources.memory, resources.storage
+	if pod {
+		cpu, memory, storage = service.ValidateAndRoundResources(cpu, memory, storage)
+		cpu, memory = service.RoundResources(computeClass, cpu, memory)
+	}
+
+	return service.CalculatePricing(cpu, memory, storage, gpu, gpuModel, computeClass, node.InstanceType, node.Spot).Total
+}
+
+// includePending returns whether pending pods are costed, which is only possible from their requests.
+func (service *PricingService) includePending() bool {
+	return service.Filter.IncludePending || service.Basis == BasisRequests || service.Basis == BasisLimits
+}
+
+// shouldCost returns whether the pod is billed. Terminating pods are going away and are skipped,
+// pending pods are only costed when included and when they request resources.
+func (service *PricingService) shouldCost(pod *corev1.Pod) bool {
+	if pod.DeletionTimestamp != nil {
+		return false
+	}
+
+	switch pod.Status.Phase {
+	case corev1.PodRunning:
+		return true
+	case corev1.PodPending:
+		if !service.includePending() {
+			return false
+		}
+		for _, container := range pod.Spec.Containers {
+			if len(container.Resources.Requests) > 0 {
+				return true
+			}
+		}
+		slog.Debug("Skipping pending pod without requests", "pod", pod.Name, "namespace", pod.Namespace)
+	}
+
+	return false
+}
+
+// isWindows returns whether the pod runs, or is meant to run, on a Windows node.
+func isWindows(pod *corev1.Pod, node cluster.Node) bool {
+	if pod.Spec.OS != nil && pod.Spec.OS.Name == corev1.Windows {
+		return true
+	}
+
+	return pod.Spec.NodeSelector[corev1.LabelOSStable] == string(corev1.Windows) || node.OS == string(corev1.Windows)
+}
+
+// armPricingAvailable returns whether the Scale-Out ARM pricing was found for the region.
+func (service *PricingService) armPricingAvailable(spot bool) bool {
+	if spot {
+		return service.AutopilotPricing.SpotArmCpuScaleoutPrice != 0 && service.AutopilotPricing.SpotArmMemoryScaleoutPrice != 0
+	}
+
+	return service.AutopilotPricing.CpuArmScaleoutPrice != 0 && service.AutopilotPricing.MemoryArmScaleoutPrice != 0
+}
+
+// RequiredClusterRole is a ClusterRole granting what the estimate reads from a cluster, the optional parts like
+// the HPA projections included. Without it, like with RBAC limited to some namespaces, listing the pod metrics
+// across all namespaces is forbidden.
+const RequiredClusterRole = `apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: autopilot-cost-calculator
+rules:
+- apiGroups: [""]
+  resources: ["nodes", "pods"]
+  verbs: ["get", "list"]
+- apiGroups: ["metrics.k8s.io"]
+  resources: ["pods"]
+  verbs: ["list"]
+- apiGroups: ["apps"]
+  resources: ["deployments", "statefulsets", "replicasets"]
+  verbs: ["get"]
+- apiGroups: ["batch"]
+  resources: ["jobs", "cronjobs"]
+  verbs: ["get"]
+- apiGroups: ["autoscaling"]
+  resources: ["horizontalpodautoscalers"]
+  verbs: ["list"]
+- apiGroups: ["policy"]
+  resources: ["poddisruptionbudgets"]
+  verbs: ["list"]
+- apiGroups: ["cloud.google.com"]
+  resources: ["computeclasses"]
+  verbs: ["list"]`
+
+// ErrMetricsForbidden is wrapped by the error of a pod metrics list the RBAC of the credentials forbids, they need
+// the RequiredClusterRole
+var ErrMetricsForbidden = errors.New("listing the pod metrics is forbidden")
+
+// listPodMetrics returns the pod metrics for the namespaces in the filter, or for all
+// non-system namespaces when no namespace was requested.
+func (service *PricingService) listPodMetrics() ([]metricsapi.PodMetrics, error) {
+	namespaces := service.Filter.Namespaces
+	if len(namespaces) == 0 {
+		namespaces = []string{""}
+	}
+
+	listOptions := metav1.ListOptions{FieldSelector: service.Filter.namespaceFieldSelector()}
+	if service.Filter.Selector != nil {
+		listOptions.LabelSelector = service.Filter.Selector.String()
+	}
+
+	var podMetrics []metricsapi.PodMetrics
+	for _, namespace := range namespaces {
+		podMetricsList, err := service.MetricsClientset.MetricsV1beta1().PodMetricses(namespace).List(context.TODO(), listOptions)
+		if apierrors.IsForbidden(err) {
+			scope := "across all namespaces"
+			if namespace != "" {
+				scope = "in namespace " + namespace
+			}
+			return nil, fmt.Errorf("%w %s: %v", ErrMetricsForbidden, scope, err)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error getting pod metrics: %v", err)
+		}
+		podMetrics = append(podMetrics, podMetricsList.Items...)
+	}
+
+	return podMetrics, nil
+}
+
+// listPendingPods returns the pending pods for the namespaces in the filter, or for all
+// non-system namespaces when no namespace was requested.
+func (service *PricingService) listPendingPods() ([]corev1.Pod, error) {
+	namespaces := service.Filter.Namespaces
+	if len(namespaces) == 0 {
+		namespaces = []string{""}
+	}
+
+	listOptions := metav1.ListOptions{FieldSelector: strings.TrimSuffix("status.phase=Pending,"+service.Filter.namespaceFieldSelector(), ",")}
+	if service.Filter.Selector != nil {
+		listOptions.LabelSelector = service.Filter.Selector.String()
+	}
+
+	var pods []corev1.Pod
+	for _, namespace := range namespaces {
+		podList, err := service.Clientset.CoreV1().Pods(namespace).List(context.TODO(), listOptions)
+		if err != nil {
+			return nil, fmt.Errorf("error getting pending pods: %v", err)
+		}
+		pods = append(pods, podList.Items...)
+	}
+
+	return pods, nil
+}
+
 func (service *PricingService) DecideComputeClass(workloadName string, machineType string, mCPU int64, memory int64, gpu int64, gpuModel string, arm64 bool) cluster.ComputeClass {
-	ratio := math.Ceil(float64(memory) / float64(mCPU))
+	computeClass, _ := service.ExplainComputeClass(workloadName, machineType, mCPU, memory, gpu, gpuModel, arm64)
+	return computeClass
+}
 
-	ratioRegularMin, _ := service.Config.Section("ratios").Key("generalpurpose_min").Float64()
-	ratioRegularMax, _ := service.Config.Section("ratios").Key("generalpurpose_max").Float64()
-	ratioBalancedMin, _ := service.Config.Section("ratios").Key("balanced_min").Float64()
-	ratioBalancedMax, _ := service.Config.Section("ratios").Key("balanced_max").Float64()
-	ratioScaleoutMin, _ := service.Config.Section("ratios").Key("scaleout_min").Float64()
-	ratioScaleoutMax, _ := service.Config.Section("ratios").Key("scaleout_max").Float64()
-	ratioPerformanceMin, _ := service.Config.Section("ratios").Key("performance_min").Float64()
-	ratioPerformanceMax, _ := service.Config.Section("ratios").Key("performance_max").Float64()
-
-	scaleoutMcpuMax, _ := service.Config.Section("limits").Key("scaleout_mcpu_max").Int64()
-	scaleoutMemoryMax, _ := service.Config.Section("limits").Key("scaleout_memory_max").Int64()
-	scaleoutArmMcpuMax, _ := service.Config.Section("limits").Key("scaleout_arm_mcpu_max").Int64()
-	scaleoutArmMemoryMax, _ := service.Config.Section("limits").Key("scaleout_arm_memory_max").Int64()
-	regularMcpuMax, _ := service.Config.Section("limits").Key("generalpurpose_mcpu_max").Int64()
-	regularMemoryMax, _ := service.Config.Section("limits").Key("generalpurpose_memory_max").Int64()
-	balancedMcpuMax, _ := service.Config.Section("limits").Key("balanced_mcpu_max").Int64()
-	balancedMemoryMax, _ := service.Config.Section("limits").Key("balanced_mcpu_max").Int64()
-	performanceMcpuMax, _ := service.Config.Section("limits").Key("performance_mcpu_max").Int64()
-	performanceMemoryMax, _ := service.Config.Section("limits").Key("performance_memory_max").Int64()
-
-	gpupodT4McpuMin, _ := service.Config.Section("limits").Key("gpupod_t4_mcpu_min").Int64()
-	gpupodT4McpuMax, _ := service.Config.Section("limits").Key("gpupod_t4_mcpu_max").Int64()
-	gpupodT4MemoryMin, _ := service.Config.Section("limits").Key("gpupod_t4_memory_min").Int64()
-	gpupodT4MemoryMax, _ := service.Config.Section("limits").Key("gpupod_t4_memory_max").Int64()
-
-	gpupodL4McpuMin, _ := service.Config.Section("limits").Key("gpupod_l4_mcpu_min").Int64()
-	gpupodL4McpuMax, _ := service.Config.Section("limits").Key("gpupod_l4_mcpu_max").Int64()
-	gpupodL4MemoryMin, _ := service.Config.Section("limits").Key("gpupod_l4_memory_min").Int64()
-	gpupodL4MemoryMax, _ := service.Config.Section("limits").Key("gpupod_l4_memory_max").Int64()
-
-	gpupodA10040McpuMin, _ := service.Config.Section("limits").Key("gpupod_a100_40_mcpu_min").Int64()
-	gpupodA10040McpuMax, _ := service.Config.Section("limits").Key("gpupod_a100_40_mcpu_max").Int64()
-	gpupodA10040MemoryMin, _ := service.Config.Section("limits").Key("gpupod_a100_40_memory_min").Int64()
-	gpupodA10040MemoryMax, _ := service.Config.Section("limits").Key("gpupod_a100_40_memory_max").Int64()
-
-	gpupodA10080McpuMin, _ := service.Config.Section("limits").Key("gpupod_a100_80_mcpu_min").Int64()
-	gpupodA10080McpuMax, _ := service.Config.Section("limits").Key("gpupod_a100_80_mcpu_max").Int64()
-	gpupodA10080MemoryMin, _ := service.Config.Section("limits").Key("gpupod_a100_80_memory_min").Int64()
-	gpupodA10080MemoryMax, _ := service.Config.Section("limits").Key("gpupod_a100_80_memory_max").Int64()
-
-	accelerator_mcpu_min, _ := service.Config.Section("limits").Key("accelerator_mcpu_min").Int64()
-	accelerator_memory_min, _ := service.Config.Section("limits").Key("accelerator_memory_min").Int64()
-	accelerator_h100_80_mcpu_max, _ := service.Config.Section("limits").Key("accelerator_h100_80_mcpu_max").Int64()
-	accelerator_h100_80_memory_max, _ := service.Config.Section("limits").Key("accelerator_h100_80_memory_max").Int64()
+// ExplainComputeClass decides the compute class like DecideComputeClass and also returns why it was chosen:
+// the machine type, GPU or architecture that forced it, or the memory per vCPU ratio and the limits crossed.
+func (service *PricingService) ExplainComputeClass(workloadName string, machineType string, mCPU int64, memory int64, gpu int64, gpuModel string, arm64 bool) (cluster.ComputeClass, string) {
+
+	ratioRegularMin, _ := service.rules("ratios").Key("generalpurpose_min").Float64()
+	ratioRegularMax, _ := service.rules("ratios").Key("generalpurpose_max").Float64()
+	ratioBalancedMin, _ := service.rules("ratios").Key("balanced_min").Float64()
+	ratioBalancedMax, _ := service.rules("ratios").Key("balanced_max").Float64()
+	ratioScaleoutMin, _ := service.rules("ratios").Key("scaleout_min").Float64()
+	ratioScaleoutMax, _ := service.rules("ratios").Key("scaleout_max").Float64()
+	ratioPerformanceMin, _ := service.rules("ratios").Key("performance_min").Float64()
+	ratioPerformanceMax, _ := service.rules("ratios").Key("performance_max").Float64()
+
+	// Idle and memory-heavy workloads can have ratios above every class, or infinite ones without CPU. Autopilot
+	// raises their CPU to the maximum ratio of their class instead, so the ratio is capped to the highest one of
+	// the allowed classes, and the CPU is raised when the resources are rounded for the class.
+	maxRatio := ratioRegularMax
+	if service.classAllowed(cluster.ComputeClassBalanced) {
+		maxRatio = math.Max(maxRatio, ratioBalancedMax)
+	}
+	if service.classAllowed(cluster.ComputeClassScaleout) {
+		maxRatio = math.Max(maxRatio, ratioScaleoutMax)
+	}
+	ratio, capped := memoryRatio(mCPU, memory, maxRatio)
+	ratioNote := ""
+	if capped {
+		ratioNote = ", ratio capped"
+	}
+
+	scaleoutMcpuMax, _ := service.rules("limits").Key("scaleout_mcpu_max").Int64()
+	scaleoutMemoryMax, _ := service.rules("limits").Key("scaleout_memory_max").Int64()
+	scaleoutArmMcpuMax, _ := service.rules("limits").Key("scaleout_arm_mcpu_max").Int64()
+	scaleoutArmMemoryMax, _ := service.rules("limits").Key("scaleout_arm_memory_max").Int64()
+	regularMcpuMax, _ := service.rules("limits").Key("generalpurpose_mcpu_max").Int64()
+	regularMemoryMax, _ := service.rules("limits").Key("generalpurpose_memory_max").Int64()
+	balancedMcpuMax, _ := service.rules("limits").Key("balanced_mcpu_max").Int64()
+	balancedMemoryMax, _ := service.rules("limits").Key("balanced_mcpu_max").Int64()
+	performanceMcpuMax, _ := service.rules("limits").Key("performance_mcpu_max").Int64()
+	performanceMemoryMax, _ := service.rules("limits").Key("performance_memory_max").Int64()
+
+	gpupodT4McpuMin, _ := service.rules("limits").Key("gpupod_t4_mcpu_min").Int64()
+	gpupodT4McpuMax, _ := service.rules("limits").Key("gpupod_t4_mcpu_max").Int64()
+	gpupodT4MemoryMin, _ := service.rules("limits").Key("gpupod_t4_memory_min").Int64()
+	gpupodT4MemoryMax, _ := service.rules("limits").Key("gpupod_t4_memory_max").Int64()
+
+	gpupodL4McpuMin, _ := service.rules("limits").Key("gpupod_l4_mcpu_min").Int64()
+	gpupodL4McpuMax, _ := service.rules("limits").Key("gpupod_l4_mcpu_max").Int64()
+	gpupodL4MemoryMin, _ := service.rules("limits").Key("gpupod_l4_memory_min").Int64()
+	gpupodL4MemoryMax, _ := service.rules("limits").Key("gpupod_l4_memory_max").Int64()
+
+	gpupodA10040McpuMin, _ := service.rules("limits").Key("gpupod_a100_40_mcpu_min").Int64()
+	gpupodA10040McpuMax, _ := service.rules("limits").Key("gpupod_a100_40_mcpu_max").Int64()
+	gpupodA10040MemoryMin, _ := service.rules("limits").Key("gpupod_a100_40_memory_min").Int64()
+	gpupodA10040MemoryMax, _ := service.rules("limits").Key("gpupod_a100_40_memory_max").Int64()
+
+	gpupodA10080McpuMin, _ := service.rules("limits").Key("gpupod_a100_80_mcpu_min").Int64()
+	gpupodA10080McpuMax, _ := service.rules("limits").Key("gpupod_a100_80_mcpu_max").Int64()
+	gpupodA10080MemoryMin, _ := service.rules("limits").Key("gpupod_a100_80_memory_min").Int64()
+	gpupodA10080MemoryMax, _ := service.rules("limits").Key("gpupod_a100_80_memory_max").Int64()
+
+	accelerator_mcpu_min, _ := service.rules("limits").Key("accelerator_mcpu_min").Int64()
+	accelerator_memory_min, _ := service.rules("limits").Key("accelerator_memory_min").Int64()
+	accelerator_h100_80_mcpu_max, _ := service.rules("limits").Key("accelerator_h100_80_mcpu_max").Int64()
+	accelerator_h100_80_memory_max, _ := service.rules("limits").Key("accelerator_h100_80_memory_max").Int64()
 
 	computeOptimizedMachineTypes := strings.Split(service.Config.Section("").Key("gce_compute_optimized_prefixed").String(), ",")
 	for _, computeOptimizedMachineType := range computeOptimizedMachineTypes {
 		if strings.Contains(machineType, computeOptimizedMachineType) {
-			return cluster.ComputeClassPerformance
+			return cluster.ComputeClassPerformance, fmt.Sprintf("machine type %s is compute optimized", machineType)
 		}
 	}
 
 	// check if GPU is H100, then return ComputeClassAccelerator since it's the only one supporting these GPUs
 	if gpuModel == service.Config.Section("").Key("nvidia_h100_identifier").String() {
 		if ratio < ratioPerformanceMin || ratio > ratioPerformanceMax || mCPU > performanceMcpuMax || memory > performanceMemoryMax {
-			log.Printf("Requested memory or CPU out of acceptable range for Performance compute class (%s) workload (%s).\n", machineType, workloadName)
+			slog.Warn("Requested memory or CPU out of acceptable range for Performance compute class", "instance_type", machineType, "workload", workloadName)
 		}
 
-		return cluster.ComputeClassPerformance
+		return cluster.ComputeClassPerformance, fmt.Sprintf("GPU %s is only available on the Performance class", gpuModel)
 	}
 
 	acceleratorOptimizedMachineTypes := strings.Split(service.Config.Section("").Key("gce_accelerator_optimized_prefixed").String(), ",")
@@ -433,27 +1087,27 @@ func (service *PricingService) DecideComputeClass(workloadName string, machineTy
 			switch gpuModel {
 			case "nvidia-tesla-t4":
 				if mCPU > gpupodT4McpuMax || mCPU < accelerator_mcpu_min || memory > gpupodT4MemoryMax || memory < accelerator_memory_min {
-					log.Printf("Requested memory or CPU out of acceptable range for %s Accelerator compute class (%s) workload (%s).\n", machineType, gpuModel, workloadName)
+					slog.Warn("Requested memory or CPU out of acceptable range for Accelerator compute class", "instance_type", machineType, "gpu", gpuModel, "workload", workloadName)
 				}
 			case "nvidia-l4":
 				if mCPU > gpupodL4McpuMax || mCPU < accelerator_mcpu_min || memory > gpupodL4MemoryMax || memory < accelerator_memory_min {
-					log.Printf("Requested memory or CPU out of acceptable range for %s Accelerator compute class (%s) workload (%s).\n", machineType, gpuModel, workloadName)
+					slog.Warn("Requested memory or CPU out of acceptable range for Accelerator compute class", "instance_type", machineType, "gpu", gpuModel, "workload", workloadName)
 				}
 			case "nvidia-tesla-a100":
 				if mCPU > gpupodA10040McpuMax || mCPU < accelerator_mcpu_min || memory > gpupodA10040MemoryMax || memory < accelerator_memory_min {
-					log.Printf("Requested memory or CPU out of acceptable range for %s Accelerator compute class (%s) workload (%s).\n", machineType, gpuModel, workloadName)
+					slog.Warn("Requested memory or CPU out of acceptable range for Accelerator compute class", "instance_type", machineType, "gpu", gpuModel, "workload", workloadName)
 				}
 			case "nvidia-a100-80gb":
 				if mCPU > gpupodA10080McpuMax || mCPU < accelerator_mcpu_min || memory > gpupodA10080MemoryMax || memory < accelerator_memory_min {
-					log.Printf("Requested memory or CPU out of acceptable range for %s Accelerator compute class (%s) workload (%s).\n", machineType, gpuModel, workloadName)
+					slog.Warn("Requested memory or CPU out of acceptable range for Accelerator compute class", "instance_type", machineType, "gpu", gpuModel, "workload", workloadName)
 				}
 			case "nvidia-h100-80gb":
 				if mCPU > accelerator_h100_80_mcpu_max || mCPU < accelerator_mcpu_min || memory > accelerator_h100_80_memory_max || memory < accelerator_memory_min {
-					log.Printf("Requested memory or CPU out of acceptable range for %s Accelerator compute class (%s) workload (%s).\n", machineType, gpuModel, workloadName)
+					slog.Warn("Requested memory or CPU out of acceptable range for Accelerator compute class", "instance_type", machineType, "gpu", gpuModel, "workload", workloadName)
 				}
 			}
 
-			return cluster.ComputeClassAccelerator
+			return cluster.ComputeClassAccelerator, fmt.Sprintf("machine type %s is accelerator optimized", machineType)
 		}
 	}
 
@@ -462,77 +1116,202 @@ func (service *PricingService) DecideComputeClass(workloadName string, machineTy
 		switch gpuModel {
 		case "nvidia-tesla-t4":
 			if mCPU > gpupodT4McpuMax || mCPU < gpupodT4McpuMin || memory > gpupodT4MemoryMax || memory < gpupodT4MemoryMin {
-				log.Printf("Requested memory or CPU out of acceptable range for %s GPU workload (%s).\n", gpuModel, workloadName)
+				slog.Warn("Requested memory or CPU out of acceptable range for GPU workload", "gpu", gpuModel, "workload", workloadName)
 			}
 		case "nvidia-l4":
 			if mCPU > gpupodL4McpuMax || mCPU < gpupodL4McpuMin || memory > gpupodL4MemoryMax || memory < gpupodL4MemoryMin {
-				log.Printf("Requested memory or CPU out of acceptable range for %s GPU workload (%s).\n", gpuModel, workloadName)
+				slog.Warn("Requested memory or CPU out of acceptable range for GPU workload", "gpu", gpuModel, "workload", workloadName)
 			}
 		case "nvidia-tesla-a100":
 			if mCPU > gpupodA10040McpuMax || mCPU < gpupodA10040McpuMin || memory > gpupodA10040MemoryMax || memory < gpupodA10040MemoryMin {
-				log.Printf("Requested memory or CPU out of acceptable range for %s GPU workload (%s).\n", gpuModel, workloadName)
+				slog.Warn("Requested memory or CPU out of acceptable range for GPU workload", "gpu", gpuModel, "workload", workloadName)
 			}
 		case "nvidia-a100-80gb":
 			if mCPU > gpupodA10080McpuMax || mCPU < gpupodA10080McpuMin || memory > gpupodA10080MemoryMax || memory < gpupodA10080MemoryMin {
-				log.Printf("Requested memory or CPU out of acceptable range for %s GPU workload (%s).\n", gpuModel, workloadName)
+				slog.Warn("Requested memory or CPU out of acceptable range for GPU workload", "gpu", gpuModel, "workload", workloadName)
 			}
 		}
-		return cluster.ComputeClassGPUPod
+		return cluster.ComputeClassGPUPod, fmt.Sprintf("requests %d %s GPU", gpu, gpuModel)
 	}
 
 	// ARM64 is still experimental
-	if arm64 {
+	if arm64 && service.classAllowed(cluster.ComputeClassScaleoutArm) {
 		if ratio < ratioScaleoutMin || ratio > ratioScaleoutMax || mCPU > scaleoutArmMcpuMax || memory > scaleoutArmMemoryMax {
-			log.Printf("Requesting arm64 but requested mCPU () or memory or ratio are out of accepted range(%s).\n", workloadName)
+			slog.Warn("Requesting arm64 but requested mCPU, memory or ratio are out of accepted range", "workload", workloadName)
 		}
 
-		return cluster.ComputeClassScaleoutArm
+		return cluster.ComputeClassScaleoutArm, fmt.Sprintf("arm64 node, ratio %g", ratio)
 	}
 
 	// For T2a machines, default to scale-out compute class, since it's the only one supporting it
-	if ratio >= ratioRegularMin && ratio <= ratioRegularMax && mCPU <= regularMcpuMax && memory <= regularMemoryMax {
-		return cluster.ComputeClassGeneralPurpose
+	regularMiss := service.classMiss(cluster.ComputeClassGeneralPurpose, ratio, ratioRegularMin, ratioRegularMax, mCPU, regularMcpuMax, memory, regularMemoryMax)
+	if regularMiss == "" {
+		return cluster.ComputeClassGeneralPurpose, fmt.Sprintf("ratio %g within General-purpose %g-%g%s", ratio, ratioRegularMin, ratioRegularMax, ratioNote)
 	}
 
 	// If we are out of Regular range, suggest Scale-Out
-	if ratio >= ratioScaleoutMin && ratio <= ratioScaleoutMax && mCPU <= scaleoutMcpuMax && memory <= scaleoutMemoryMax {
-		return cluster.ComputeClassScaleout
+	scaleoutMiss := service.classMiss(cluster.ComputeClassScaleout, ratio, ratioScaleoutMin, ratioScaleoutMax, mCPU, scaleoutMcpuMax, memory, scaleoutMemoryMax)
+	if scaleoutMiss == "" {
+		return cluster.ComputeClassScaleout, fmt.Sprintf("%s, ratio %g within Scale-out %g-%g%s", regularMiss, ratio, ratioScaleoutMin, ratioScaleoutMax, ratioNote)
 	}
 
 	// If usage is more than general-purpose limits, default to balanced
-	if ratio >= ratioBalancedMin && ratio <= ratioBalancedMax && mCPU <= balancedMcpuMax && memory <= balancedMemoryMax {
-		return cluster.ComputeClassBalanced
+	balancedMiss := service.classMiss(cluster.ComputeClassBalanced, ratio, ratioBalancedMin, ratioBalancedMax, mCPU, balancedMcpuMax, memory, balancedMemoryMax)
+	if balancedMiss == "" {
+		return cluster.ComputeClassBalanced, fmt.Sprintf("%s, %s, ratio %g within Balanced %g-%g%s", regularMiss, scaleoutMiss, ratio, ratioBalancedMin, ratioBalancedMax, ratioNote)
 	}
 
-	log.Printf("Couldn't find a matching compute class for %s. Defaulting to 'General-purpose'. Please check the pricing manually.\n", workloadName)
+	// Default to the first allowed class, General-purpose unless it's excluded
+	fallback := cluster.ComputeClassGeneralPurpose
+	for _, class := range []cluster.ComputeClass{cluster.ComputeClassGeneralPurpose, cluster.ComputeClassBalanced, cluster.ComputeClassScaleout} {
+		if service.classAllowed(class) {
+			fallback = class
+			break
+		}
+	}
+	if !service.classAllowed(fallback) {
+		slog.Warn("None of General-purpose, Balanced or Scale-out is allowed, defaulting to General-purpose anyway", "workload", workloadName)
+	}
+	slog.Warn("Couldn't find a matching compute class. Please check the pricing manually.", "workload", workloadName, "default", cluster.ComputeClasses[fallback])
 
-	return cluster.ComputeClassGeneralPurpose
+	return fallback, fmt.Sprintf("no matching class (%s, %s, %s), defaulted to %s", regularMiss, scaleoutMiss, balancedMiss, cluster.ComputeClasses[fallback])
 }
 
-// TODO: implement ini file minimums
-func ValidateAndRoundResources(mCPU int64, memory int64, storage int64) (int64, int64, int64) {
-	// Lowest possible mCPU request, but this is different for DaemonSets that are not yet implemented
-	if mCPU < 50 {
-		mCPU = 50
+// memoryRatio returns the memory per vCPU ratio rounded up, capped to maxRatio. Returns whether it was
+// capped, which includes workloads without CPU.
+func memoryRatio(mCPU int64, memory int64, maxRatio float64) (float64, bool) {
+	if mCPU <= 0 {
+		return maxRatio, true
 	}
 
-	// Minumum memory request, however it's 1G for Scaleout, we don't yet account for this
-	if memory < 52 {
-		memory = 52
+	ratio := math.Ceil(float64(memory) / float64(mCPU))
+	if ratio > maxRatio {
+		return maxRatio, true
 	}
 
-	if storage < 10 {
-		storage = 10
+	return ratio, false
+}
+
+// classMiss describes why the resources can't use a compute class: the class isn't allowed or the first of
+// its limits that they cross. Returns an empty string when they fit in the class.
+func (service *PricingService) classMiss(computeClass cluster.ComputeClass, ratio float64, ratioMin float64, ratioMax float64, mCPU int64, mCPUMax int64, memory int64, memoryMax int64) string {
+	class := cluster.ComputeClasses[computeClass]
+	switch {
+	case !service.classAllowed(computeClass):
+		return fmt.Sprintf("%s not allowed", class)
+	case ratio < ratioMin || ratio > ratioMax:
+		return fmt.Sprintf("ratio %g outside %s %g-%g", ratio, class, ratioMin, ratioMax)
+	case mCPU > mCPUMax:
+		return fmt.Sprintf("mCPU %d above %s maximum %d", mCPU, class, mCPUMax)
+	case memory > memoryMax:
+		return fmt.Sprintf("memory %d above %s maximum %d", memory, class, memoryMax)
 	}
 
-	mCPUMissing := (50 - (mCPU % 50))
-	if mCPUMissing == 50 {
-		// Nothing to do here, return original values
-		return mCPU, memory, storage
+	return ""
+}
+
+// classAllowed returns whether workloads can be placed on the compute class: it must be in AllowedClasses, when
+// set, and have pricing in the region, since the cluster can't run it otherwise.
+func (service *PricingService) classAllowed(class cluster.ComputeClass) bool {
+	if service.AllowedClasses != nil && !service.AllowedClasses[class] {
+		return false
 	}
 
-	// Add missing value to reach nearst 250mCPU step
-	mCPU += mCPUMissing
+	switch class {
+	case cluster.ComputeClassBalanced:
+		return service.AutopilotPricing.CpuBalancedPrice != 0
+	case cluster.ComputeClassScaleout:
+		return service.AutopilotPricing.CpuScaleoutPrice != 0
+	}
+
+	return true
+}
+
+// RoundResources rounds mCPU and memory up to what Autopilot bills for the compute class:
+//   - mCPU and memory are rounded up to the class increments from the increments config section.
+//   - If memory per vCPU is below the class minimum ratio, memory is raised to match it.
+//   - If memory per vCPU is above the class maximum ratio, mCPU is raised to match it and rounded again.
+//
+// Resources are never lowered, so the estimate errs on the side of the billed value.
+func (service *PricingService) RoundResources(class cluster.ComputeClass, mCPU int64, memory int64) (int64, int64) {
+	key, ok := computeClassKeys[class]
+	if !ok {
+		return mCPU, memory
+	}
+
+	mCPUIncrement, _ := service.rules("increments").Key(key + "_mcpu").Int64()
+	memoryIncrement, _ := service.rules("increments").Key(key + "_memory").Int64()
+	ratioMin, _ := service.rules("ratios").Key(key + "_min").Float64()
+	ratioMax, _ := service.rules("ratios").Key(key + "_max").Float64()
+
+	mCPU = roundUp(mCPU, mCPUIncrement)
+	memory = roundUp(memory, memoryIncrement)
+
+	if mCPU > 0 && float64(memory)/float64(mCPU) < ratioMin {
+		memory = roundUp(int64(math.Ceil(float64(mCPU)*ratioMin)), memoryIncrement)
+	}
+
+	if ratioMax > 0 && float64(memory)/float64(mCPU) > ratioMax {
+		mCPU = roundUp(int64(math.Ceil(float64(memory)/ratioMax)), mCPUIncrement)
+	}
+
+	return mCPU, memory
+}
+
+// roundUp rounds value up to the nearest multiple of increment. Non-positive increments leave the value as is.
+func roundUp(value int64, increment int64) int64 {
+	if increment <= 0 || value%increment == 0 {
+		return value
+	}
+
+	return value + increment - value%increment
+}
+
+// ValidateUpperLimits checks the resources against the per-pod maximums of the chosen compute class.
+// Workloads that don't fit are logged, since Autopilot would reject them as they are and the estimate
+// assumes they get reshaped. Returns false when a maximum is exceeded.
+func (service *PricingService) ValidateUpperLimits(workloadName string, class cluster.ComputeClass, gpuModel string, mCPU int64, memory int64) bool {
+	keys, ok := upperLimitKeys[class]
+	if class == cluster.ComputeClassGPUPod || class == cluster.ComputeClassAccelerator {
+		keys, ok = gpuUpperLimitKeys[gpuModel]
+	}
+	if !ok {
+		return true
+	}
+
+	mCPUMax, _ := service.rules("limits").Key(keys[0]).Int64()
+	memoryMax, _ := service.rules("limits").Key(keys[1]).Int64()
+
+	if mCPU > mCPUMax || memory > memoryMax {
+		slog.Warn("Workload exceeds the per pod maximum of its compute class. The estimate assumes it is reshaped to fit.", "workload", workloadName, "mcpu", mCPU, "memory", memory, "compute_class", cluster.ComputeClasses[class], "mcpu_max", mCPUMax, "memory_max", memoryMax)
+		return false
+	}
+
+	return true
+}
+
+// ValidateAndRoundResources raises the resources to the General-purpose minimums of the limits config section
+// and rounds mCPU up to its increment, following the rules version of the service.
+func (service *PricingService) ValidateAndRoundResources(mCPU int64, memory int64, storage int64) (int64, int64, int64) {
+	mCPUMin, _ := service.rules("limits").Key("generalpurpose_mcpu_min").Int64()
+	memoryMin, _ := service.rules("limits").Key("generalpurpose_memory_min").Int64()
+	storageMin, _ := service.rules("limits").Key("generalpurpose_storage_min").Int64()
+	mCPUIncrement, _ := service.rules("increments").Key("generalpurpose_mcpu").Int64()
+
+	// Lowest possible mCPU request, but this is different for DaemonSets that are not yet implemented
+	if mCPU < mCPUMin {
+		mCPU = mCPUMin
+	}
+
+	// Minumum memory request, however it's 1G for Scaleout, we don't yet account for this
+	if memory < memoryMin {
+		memory = memoryMin
+	}
+
+	if storage < storageMin {
+		storage = storageMin
+	}
 
-	return mCPU, memory, storage
+	// Add missing value to reach the nearest mCPU increment
+	return roundUp(mCPU, mCPUIncrement), memory, storage
 }
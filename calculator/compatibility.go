@@ -0,0 +1,104 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package calculator
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// allowedCapabilities are the Linux capabilities Autopilot lets containers add, the default ones of containerd
+// and SYS_PTRACE, see https://cloud.google.com/kubernetes-engine/docs/concepts/autopilot-security
+var allowedCapabilities = map[corev1.Capability]bool{
+	"AUDIT_WRITE":      true,
+	"CHOWN":            true,
+	"DAC_OVERRIDE":     true,
+	"FOWNER":           true,
+	"FSETID":           true,
+	"KILL":             true,
+	"MKNOD":            true,
+	"NET_BIND_SERVICE": true,
+	"NET_RAW":          true,
+	"SETFCAP":          true,
+	"SETGID":           true,
+	"SETPCAP":          true,
+	"SETUID":           true,
+	"SYS_CHROOT":       true,
+	"SYS_PTRACE":       true,
+}
+
+// allowedHostPathPrefix is the only host path Autopilot allows, read-only
+const allowedHostPathPrefix = "/var/log/"
+
+// AutopilotIncompatibilities lists the settings of the pod that Autopilot rejects at admission, like privileged
+// containers, host namespaces, hostPath volumes outside of /var/log or capabilities beyond the allowed ones. The
+// pod would have to be changed to run on Autopilot, so its estimate doesn't apply as is.
+func AutopilotIncompatibilities(pod *corev1.Pod) []string {
+	var reasons []string
+	if pod.Spec.HostNetwork {
+		reasons = append(reasons, "hostNetwork")
+	}
+	if pod.Spec.HostPID {
+		reasons = append(reasons, "hostPID")
+	}
+	if pod.Spec.HostIPC {
+		reasons = append(reasons, "hostIPC")
+	}
+
+	readOnlyMounts := readOnlyVolumeMounts(pod)
+	for _, volume := range pod.Spec.Volumes {
+		if volume.HostPath == nil {
+			continue
+		}
+		path := strings.TrimSuffix(volume.HostPath.Path, "/") + "/"
+		if !strings.HasPrefix(path, allowedHostPathPrefix) || !readOnlyMounts[volume.Name] {
+			reasons = append(reasons, fmt.Sprintf("hostPath volume %s (%s)", volume.Name, volume.HostPath.Path))
+		}
+	}
+
+	for _, container := range append(append([]corev1.Container{}, pod.Spec.InitContainers...), pod.Spec.Containers...) {
+		securityContext := container.SecurityContext
+		if securityContext == nil {
+			continue
+		}
+		if securityContext.Privileged != nil && *securityContext.Privileged {
+			reasons = append(reasons, fmt.Sprintf("privileged container %s", container.Name))
+		}
+		if securityContext.Capabilities != nil {
+			for _, capability := range securityContext.Capabilities.Add {
+				if !allowedCapabilities[corev1.Capability(strings.TrimPrefix(string(capability), "CAP_"))] {
+					reasons = append(reasons, fmt.Sprintf("capability %s of container %s", capability, container.Name))
+				}
+			}
+		}
+	}
+
+	return reasons
+}
+
+// readOnlyVolumeMounts returns whether each volume of the pod is only mounted read-only by its containers.
+func readOnlyVolumeMounts(pod *corev1.Pod) map[string]bool {
+	readOnly := make(map[string]bool)
+	for _, container := range append(append([]corev1.Container{}, pod.Spec.InitContainers...), pod.Spec.Containers...) {
+		for _, mount := range container.VolumeMounts {
+			current, ok := readOnly[mount.Name]
+			readOnly[mount.Name] = mount.ReadOnly && (current || !ok)
+		}
+	}
+
+	return readOnly
+}
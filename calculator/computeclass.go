@@ -0,0 +1,129 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package calculator
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/autopilot-cost-calculator/cluster"
+	"golang.org/x/exp/slog"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+// ComputeClassSelector is the node selector label pods select a compute class with
+const ComputeClassSelector = "cloud.google.com/compute-class"
+
+// ComputeClassResource is the custom resource of the custom compute classes defined in a cluster
+var ComputeClassResource = schema.GroupVersionResource{Group: "cloud.google.com", Version: "v1", Resource: "computeclasses"}
+
+// familyComputeClasses maps the machine families with an Autopilot compute class of their own. The arm64 and
+// accelerator optimized families come from the config.
+var familyComputeClasses = map[string]cluster.ComputeClass{
+	"e2":  cluster.ComputeClassGeneralPurpose,
+	"n2":  cluster.ComputeClassBalanced,
+	"n2d": cluster.ComputeClassBalanced,
+	"t2d": cluster.ComputeClassScaleout,
+}
+
+// LoadCustomComputeClasses reads the custom ComputeClass objects of the cluster and maps each of them to the
+// nearest billed Autopilot class, see customComputeClass. Clusters without the ComputeClass CRD have none.
+func (service *PricingService) LoadCustomComputeClasses(client dynamic.Interface) error {
+	list, err := client.Resource(ComputeClassResource).List(context.TODO(), metav1.ListOptions{})
+	if apierrors.IsNotFound(err) {
+		slog.Warn("The cluster has no ComputeClass resource, pods are priced without custom compute classes")
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error getting compute classes: %v", err)
+	}
+
+	service.CustomComputeClasses = make(map[string]cluster.ComputeClass)
+	for _, item := range list.Items {
+		class, family, ok := service.customComputeClass(item)
+		if !ok {
+			slog.Warn("Skipping compute class without a machine family, its pods are priced like others", "compute_class", item.GetName())
+			continue
+		}
+
+		slog.Debug("Mapped custom compute class", "compute_class", item.GetName(), "machine_family", family, "billed_as", cluster.ComputeClasses[class])
+		service.CustomComputeClasses[item.GetName()] = class
+	}
+
+	return nil
+}
+
+// customComputeClass maps a custom compute class to the Autopilot class of the machine family of its first
+// priority naming one, with the machine family or type. Families without a class of their own are billed per
+// node, like the Performance class. It returns false when no priority names a machine family.
+func (service *PricingService) customComputeClass(computeClass unstructured.Unstructured) (cluster.ComputeClass, string, bool) {
+	priorities, _, _ := unstructured.NestedSlice(computeClass.Object, "spec", "priorities")
+	for _, priority := range priorities {
+		fields, ok := priority.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		family, _, _ := unstructured.NestedString(fields, "machineFamily")
+		if family == "" {
+			machineType, _, _ := unstructured.NestedString(fields, "machineType")
+			family, _, _ = strings.Cut(machineType, "-")
+		}
+		if family != "" {
+			return service.familyComputeClass(strings.ToLower(family)), family, true
+		}
+	}
+
+	return 0, "", false
+}
+
+// familyComputeClass returns the Autopilot compute class of a machine family.
+func (service *PricingService) familyComputeClass(family string) cluster.ComputeClass {
+	if class, ok := familyComputeClasses[family]; ok {
+		return class
+	}
+
+	config := service.Config.Section("")
+	if config.Key("gce_arm64_prefix").String() == family+"-" {
+		return cluster.ComputeClassScaleoutArm
+	}
+	for _, prefix := range strings.Split(config.Key("gce_accelerator_optimized_prefixed").String(), ",") {
+		if prefix == family+"-" {
+			return cluster.ComputeClassAccelerator
+		}
+	}
+
+	return cluster.ComputeClassPerformance
+}
+
+// ClusterComputeClasses returns the compute classes the cluster can run, for AllowedClasses: General-purpose,
+// which every Autopilot cluster has, the classes of the machine families of its node pools and the ones its
+// custom compute classes are mapped to, once loaded.
+func (service *PricingService) ClusterComputeClasses(machineFamilies []string) map[cluster.ComputeClass]bool {
+	classes := map[cluster.ComputeClass]bool{cluster.ComputeClassGeneralPurpose: true}
+	for _, family := range machineFamilies {
+		classes[service.familyComputeClass(strings.ToLower(family))] = true
+	}
+	for _, class := range service.CustomComputeClasses {
+		classes[class] = true
+	}
+
+	return classes
+}
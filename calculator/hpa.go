@@ -0,0 +1,188 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package calculator
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/autopilot-cost-calculator/cluster"
+	"golang.org/x/exp/slog"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// HPAProjection is the hourly cost of the pods scaled by a HorizontalPodAutoscaler at its minimum, current and
+// maximum replicas, each replica costing the average of its currently costed pods.
+type HPAProjection struct {
+	Namespace       string  `json:"namespace"`
+	Name            string  `json:"name"`
+	Target          string  `json:"target"`
+	MinReplicas     int32   `json:"min_replicas"`
+	CurrentReplicas int32   `json:"current_replicas"`
+	MaxReplicas     int32   `json:"max_replicas"`
+	ReplicaCost     float64 `json:"replica_cost"`
+	MinCost         float64 `json:"min_cost"`
+	CurrentCost     float64 `json:"current_cost"`
+	MaxCost         float64 `json:"max_cost"`
+}
+
+// ProjectHPAs projects the cost of the workloads scaled by the HorizontalPodAutoscalers of the namespaces in the
+// filter. HPAs whose target can't be read or isn't supported, or whose pods weren't costed, are left out.
+func (service *PricingService) ProjectHPAs(workloads []cluster.Workload) ([]HPAProjection, error) {
+	namespaces := service.Filter.Namespaces
+	if len(namespaces) == 0 {
+		namespaces = []string{""}
+	}
+
+	listOptions := metav1.ListOptions{FieldSelector: service.Filter.namespaceFieldSelector()}
+
+	index := indexPodWorkloads(workloads)
+	var projections []HPAProjection
+	for _, namespace := range namespaces {
+		hpaList, err := service.Clientset.AutoscalingV2().HorizontalPodAutoscalers(namespace).List(context.TODO(), listOptions)
+		if err != nil {
+			return nil, fmt.Errorf("error getting horizontal pod autoscalers: %v", err)
+		}
+
+		for _, hpa := range hpaList.Items {
+			projection, ok, err := service.projectHPA(hpa, index)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				projections = append(projections, projection)
+			}
+		}
+	}
+
+	return projections, nil
+}
+
+// projectHPA projects the cost of a single HPA, it returns false when none of its pods were costed.
+func (service *PricingService) projectHPA(hpa autoscalingv2.HorizontalPodAutoscaler, index podWorkloads) (HPAProjection, bool, error) {
+	target := hpa.Spec.ScaleTargetRef
+	selector, err := service.scaleTargetSelector(hpa.Namespace, target)
+	if err != nil {
+		slog.Warn("Skipping HPA whose scale target can't be read", "hpa", hpa.Name, "namespace", hpa.Namespace, "error", err)
+		return HPAProjection{}, false, nil
+	}
+	if selector == nil {
+		slog.Debug("Skipping HPA with an unsupported scale target", "hpa", hpa.Name, "namespace", hpa.Namespace, "kind", target.Kind)
+		return HPAProjection{}, false, nil
+	}
+
+	cost, pods, err := service.selectedPodsCost(hpa.Namespace, selector, index)
+	if err != nil {
+		return HPAProjection{}, false, fmt.Errorf("error getting pods of %s/%s: %v", target.Kind, target.Name, err)
+	}
+	if pods == 0 {
+		return HPAProjection{}, false, nil
+	}
+
+	minReplicas := int32(1)
+	if hpa.Spec.MinReplicas != nil {
+		minReplicas = *hpa.Spec.MinReplicas
+	}
+	currentReplicas := hpa.Status.CurrentReplicas
+	if currentReplicas == 0 {
+		currentReplicas = pods
+	}
+
+	replicaCost := cost / float64(pods)
+	return HPAProjection{
+		Namespace:       hpa.Namespace,
+		Name:            hpa.Name,
+		Target:          target.Kind + "/" + target.Name,
+		MinReplicas:     minReplicas,
+		CurrentReplicas: currentReplicas,
+		MaxReplicas:     hpa.Spec.MaxReplicas,
+		ReplicaCost:     replicaCost,
+		MinCost:         replicaCost * float64(minReplicas),
+		CurrentCost:     replicaCost * float64(currentReplicas),
+		MaxCost:         replicaCost * float64(hpa.Spec.MaxReplicas),
+	}, true, nil
+}
+
+// podWorkloads indexes the costed workloads by the namespace and name of their pod.
+type podWorkloads map[[2]string][]cluster.Workload
+
+// indexPodWorkloads indexes the workloads by pod, containers of the per-container mode are named pod/container.
+func indexPodWorkloads(workloads []cluster.Workload) podWorkloads {
+	index := make(podWorkloads)
+	for _, workload := range workloads {
+		podName, _, _ := strings.Cut(workload.Name, "/")
+		key := [2]string{workload.Namespace, podName}
+		index[key] = append(index[key], workload)
+	}
+
+	return index
+}
+
+// selectedPodsCost returns the hourly cost of the costed pods matching the selector in the namespace, and their
+// number.
+func (service *PricingService) selectedPodsCost(namespace string, selector labels.Selector, index podWorkloads) (float64, int32, error) {
+	podList, err := service.Clientset.CoreV1().Pods(namespace).List(context.TODO(), metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// Workloads are matched to the pods by namespace, name and node
+	var cost float64
+	var pods int32
+	for _, pod := range podList.Items {
+		costed := false
+		for _, workload := range index[[2]string{pod.Namespace, pod.Name}] {
+			if workload.Node_name == pod.Spec.NodeName {
+				cost += workload.Cost
+				costed = true
+			}
+		}
+		if costed {
+			pods++
+		}
+	}
+
+	return cost, pods, nil
+}
+
+// scaleTargetSelector returns the pod selector of the Deployment, StatefulSet or ReplicaSet scaled by an HPA, nil
+// for other kinds of targets.
+func (service *PricingService) scaleTargetSelector(namespace string, target autoscalingv2.CrossVersionObjectReference) (labels.Selector, error) {
+	switch target.Kind {
+	case "Deployment":
+		deployment, err := service.Clientset.AppsV1().Deployments(namespace).Get(context.TODO(), target.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("error getting %s/%s: %v", target.Kind, target.Name, err)
+		}
+		return metav1.LabelSelectorAsSelector(deployment.Spec.Selector)
+	case "StatefulSet":
+		statefulSet, err := service.Clientset.AppsV1().StatefulSets(namespace).Get(context.TODO(), target.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("error getting %s/%s: %v", target.Kind, target.Name, err)
+		}
+		return metav1.LabelSelectorAsSelector(statefulSet.Spec.Selector)
+	case "ReplicaSet":
+		replicaSet, err := service.Clientset.AppsV1().ReplicaSets(namespace).Get(context.TODO(), target.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("error getting %s/%s: %v", target.Kind, target.Name, err)
+		}
+		return metav1.LabelSelectorAsSelector(replicaSet.Spec.Selector)
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,302 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package calculator
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/GoogleCloudPlatform/autopilot-cost-calculator/cluster"
+	"golang.org/x/exp/slog"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// minimumBilledRuntime is the shortest time Autopilot bills a pod for, beyond it pods are billed per second
+const minimumBilledRuntime = time.Minute
+
+// BilledRuntime rounds a runtime up to the billing granularity of Autopilot: whole seconds, and at least a minute.
+func BilledRuntime(runtime time.Duration) time.Duration {
+	billed := time.Duration(math.Ceil(runtime.Seconds())) * time.Second
+	if billed < minimumBilledRuntime {
+		return minimumBilledRuntime
+	}
+	return billed
+}
+
+// jobOwners caches the Jobs and CronJobs owning the pods by namespace/name, nil when they can't be read.
+type jobOwners struct {
+	jobs     map[string]*batchv1.Job
+	cronJobs map[string]*batchv1.CronJob
+}
+
+func newJobOwners() jobOwners {
+	return jobOwners{jobs: make(map[string]*batchv1.Job), cronJobs: make(map[string]*batchv1.CronJob)}
+}
+
+// job returns the Job, nil when it can't be read.
+func (owners jobOwners) job(client kubernetes.Interface, namespace string, name string) *batchv1.Job {
+	key := namespace + "/" + name
+	job, ok := owners.jobs[key]
+	if !ok {
+		var err error
+		job, err = client.BatchV1().Jobs(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			slog.Warn("Not prorating the pods of a Job that can't be read", "job", name, "namespace", namespace, "error", err)
+			job = nil
+		}
+		owners.jobs[key] = job
+	}
+
+	return job
+}
+
+// cronJob returns the CronJob, nil when it can't be read.
+func (owners jobOwners) cronJob(client kubernetes.Interface, namespace string, name string) *batchv1.CronJob {
+	key := namespace + "/" + name
+	cronJob, ok := owners.cronJobs[key]
+	if !ok {
+		var err error
+		cronJob, err = client.BatchV1().CronJobs(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			slog.Warn("Not prorating the pods of a CronJob that can't be read", "cronjob", name, "namespace", namespace, "error", err)
+			cronJob = nil
+		}
+		owners.cronJobs[key] = cronJob
+	}
+
+	return cronJob
+}
+
+// jobRuns returns the runtime of a run of a pod run by a Job and its runs per month when the Jobs are prorated. A
+// run lasts JobRuntime when set, otherwise the activeDeadlineSeconds of the Job or of the pod, the longest it can
+// run. Jobs created by a CronJob run as often as its schedule, the others once. It returns false for the other
+// pods, for Jobs without a deadline and for Jobs or CronJobs that can't be read, which are billed as running all
+// month.
+func (service *PricingService) jobRuns(pod *corev1.Pod, owners jobOwners) (time.Duration, float64, bool) {
+	owner := metav1.GetControllerOf(pod)
+	if !service.ProrateJobs || owner == nil || owner.Kind != "Job" {
+		return 0, 0, false
+	}
+
+	job := owners.job(service.Clientset, pod.Namespace, owner.Name)
+	if job == nil {
+		return 0, 0, false
+	}
+
+	runs := 1.0
+	if jobOwner := metav1.GetControllerOf(job); jobOwner != nil && jobOwner.Kind == "CronJob" {
+		cronJob := owners.cronJob(service.Clientset, pod.Namespace, jobOwner.Name)
+		if cronJob == nil {
+			return 0, 0, false
+		}
+
+		var err error
+		runs, err = RunsPerMonth(cronJob.Spec.Schedule)
+		if err != nil {
+			slog.Warn("Not prorating the pod of a CronJob with an unsupported schedule", "pod", pod.Name, "namespace", pod.Namespace, "cronjob", cronJob.Name, "error", err)
+			return 0, 0, false
+		}
+	}
+
+	switch {
+	case service.JobRuntime > 0:
+		return service.JobRuntime, runs, true
+	case job.Spec.ActiveDeadlineSeconds != nil:
+		return time.Duration(*job.Spec.ActiveDeadlineSeconds) * time.Second, runs, true
+	case pod.Spec.ActiveDeadlineSeconds != nil:
+		return time.Duration(*pod.Spec.ActiveDeadlineSeconds) * time.Second, runs, true
+	}
+
+	slog.Debug("Not prorating the pod of a Job without a deadline, use -job-runtime", "pod", pod.Name, "namespace", pod.Namespace, "job", owner.Name)
+	return 0, 0, false
+}
+
+// prorateJob scales the hourly costs of a workload run by a Job, which assume it runs all month, to the runs per
+// month of the billed runtime.
+func prorateJob(workload *cluster.Workload, runtime time.Duration, runs float64) {
+	workload.JobRuntime = BilledRuntime(runtime).Seconds()
+	workload.JobRuns = runs
+	factor := jobShare(*workload)
+
+	workload.Cost *= factor
+	workload.Breakdown.CPU *= factor
+	workload.Breakdown.Memory *= factor
+	workload.Breakdown.Storage *= factor
+	workload.Breakdown.GPU *= factor
+	workload.Breakdown.Total *= factor
+	workload.SpotCost *= factor
+	workload.RequestCost *= factor
+	workload.UsageCost *= factor
+	workload.LimitCost *= factor
+}
+
+// jobShare is the share of the month a workload prorated as a Job runs for, its runs of JobRuntime, and 1 for the
+// other workloads.
+func jobShare(workload cluster.Workload) float64 {
+	if workload.JobRuntime <= 0 {
+		return 1
+	}
+
+	return math.Min(workload.JobRuntime/3600*workload.JobRuns/HOURS_PER_MONTH, 1)
+}
+
+// cronMacros are the schedules of the macros CronJobs accept
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// cronFields are the bounds and names of the minute, hour, day of month, month and day of week fields of a schedule.
+// 7 is also Sunday in the day of week field.
+var cronFields = [5]struct {
+	min, max int
+	names    map[string]int
+}{
+	{0, 59, nil},
+	{0, 23, nil},
+	{1, 31, nil},
+	{1, 12, map[string]int{"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6, "jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12}},
+	{0, 7, map[string]int{"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6}},
+}
+
+// RunsPerMonth returns the average runs per month of a CronJob schedule, in the five fields cron format or one of
+// its macros like @hourly, counted over a year. A TZ= or CRON_TZ= prefix is ignored, the time zone doesn't change
+// the number of runs.
+func RunsPerMonth(schedule string) (float64, error) {
+	fields := strings.Fields(schedule)
+	if len(fields) > 0 && (strings.HasPrefix(fields[0], "TZ=") || strings.HasPrefix(fields[0], "CRON_TZ=")) {
+		fields = fields[1:]
+	}
+	if len(fields) == 1 {
+		macro, ok := cronMacros[strings.ToLower(fields[0])]
+		if !ok {
+			return 0, fmt.Errorf("unknown schedule %q", schedule)
+		}
+		fields = strings.Fields(macro)
+	}
+	if len(fields) != len(cronFields) {
+		return 0, fmt.Errorf("expected five fields in schedule %q", schedule)
+	}
+
+	var matches [5][]bool
+	var restricted [5]bool
+	for i, field := range fields {
+		var err error
+		matches[i], err = parseCronField(field, cronFields[i].min, cronFields[i].max, cronFields[i].names)
+		if err != nil {
+			return 0, fmt.Errorf("invalid schedule %q: %v", schedule, err)
+		}
+		restricted[i] = !strings.HasPrefix(field, "*") && !strings.HasPrefix(field, "?")
+	}
+	minutes, hours := 0, 0
+	for _, match := range matches[0] {
+		if match {
+			minutes++
+		}
+	}
+	for _, match := range matches[1] {
+		if match {
+			hours++
+		}
+	}
+
+	// Like cron, a day matches either the day of month or the day of week when both are restricted, that is not
+	// starting with *
+	runs := 0
+	start := time.Date(2023, time.January, 1, 0, 0, 0, 0, time.UTC)
+	for day := start; day.Year() == start.Year(); day = day.AddDate(0, 0, 1) {
+		weekday := int(day.Weekday())
+		dayOfMonth := matches[2][day.Day()]
+		dayOfWeek := matches[4][weekday] || (weekday == 0 && matches[4][7])
+		dayMatches := dayOfMonth && dayOfWeek
+		if restricted[2] && restricted[4] {
+			dayMatches = dayOfMonth || dayOfWeek
+		}
+		if dayMatches && matches[3][int(day.Month())] {
+			runs += hours * minutes
+		}
+	}
+
+	return float64(runs) / 12, nil
+}
+
+// parseCronField returns which values from min to max a field of a cron schedule matches, indexed by value. Fields
+// are comma separated lists of *, values or ranges like 1-5, each with an optional step like */15.
+func parseCronField(field string, min int, max int, names map[string]int) ([]bool, error) {
+	matches := make([]bool, max+1)
+	for _, item := range strings.Split(field, ",") {
+		valueRange, stepValue, hasStep := strings.Cut(item, "/")
+		step := 1
+		if hasStep {
+			var err error
+			step, err = strconv.Atoi(stepValue)
+			if err != nil || step <= 0 {
+				return nil, fmt.Errorf("invalid step %q", stepValue)
+			}
+		}
+
+		first, last := min, max
+		if valueRange != "*" && valueRange != "?" {
+			firstValue, lastValue, isRange := strings.Cut(valueRange, "-")
+			var err error
+			if first, err = cronValue(firstValue, min, max, names); err != nil {
+				return nil, err
+			}
+			last = first
+			if isRange {
+				if last, err = cronValue(lastValue, min, max, names); err != nil {
+					return nil, err
+				}
+			} else if hasStep {
+				last = max
+			}
+			if last < first {
+				return nil, fmt.Errorf("invalid range %q", valueRange)
+			}
+		}
+
+		for value := first; value <= last; value += step {
+			matches[value] = true
+		}
+	}
+
+	return matches, nil
+}
+
+// cronValue parses a value of a cron field, a number from min to max or one of the names.
+func cronValue(value string, min int, max int, names map[string]int) (int, error) {
+	if number, ok := names[strings.ToLower(value)]; ok {
+		return number, nil
+	}
+
+	number, err := strconv.Atoi(value)
+	if err != nil || number < min || number > max {
+		return 0, fmt.Errorf("invalid value %q, expected %d to %d", value, min, max)
+	}
+
+	return number, nil
+}
@@ -0,0 +1,170 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package calculator
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/GoogleCloudPlatform/autopilot-cost-calculator/cluster"
+	"golang.org/x/exp/slog"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+// ManifestWorkload is a workload of a manifest, with the pod template of its replicas.
+type ManifestWorkload struct {
+	Kind      string
+	Name      string
+	Namespace string
+	Replicas  int32
+	Spec      corev1.PodSpec
+}
+
+// ManifestEstimate is the hourly cost of a workload of a manifest, per pod and for all its replicas. The resources
+// are the billed ones of a pod, after Autopilot's minimums and rounding.
+type ManifestEstimate struct {
+	Kind         string               `json:"kind"`
+	Name         string               `json:"name"`
+	Namespace    string               `json:"namespace"`
+	Replicas     int32                `json:"replicas"`
+	Cpu          int64                `json:"mcpu"`
+	Memory       int64                `json:"memory"`
+	Storage      int64                `json:"storage"`
+	ComputeClass cluster.ComputeClass `json:"-"`
+	Class        string               `json:"compute_class"`
+	Spot         bool                 `json:"spot"`
+	PodCost      float64              `json:"pod_cost"`
+	TotalCost    float64              `json:"total_cost"`
+}
+
+// ParseManifest reads the workloads of a YAML or JSON manifest, with one or more documents. Pods, Deployments,
+// StatefulSets, ReplicaSets and Jobs are read, other kinds are skipped. Replicas default to 1 like in the API.
+func ParseManifest(r io.Reader) ([]ManifestWorkload, error) {
+	decoder := yaml.NewYAMLOrJSONDecoder(r, 4096)
+	deserializer := scheme.Codecs.UniversalDeserializer()
+
+	var workloads []ManifestWorkload
+	for {
+		var raw runtime.RawExtension
+		err := decoder.Decode(&raw)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading manifest: %v", err)
+		}
+		if len(bytes.TrimSpace(raw.Raw)) == 0 || bytes.Equal(bytes.TrimSpace(raw.Raw), []byte("null")) {
+			continue
+		}
+
+		object, gvk, err := deserializer.Decode(raw.Raw, nil, nil)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding manifest: %v", err)
+		}
+
+		workload, ok := manifestWorkload(object)
+		if !ok {
+			slog.Warn("Skipping manifest object of an unsupported kind", "kind", gvk.Kind)
+			continue
+		}
+		workload.Kind = gvk.Kind
+		workloads = append(workloads, workload)
+	}
+
+	return workloads, nil
+}
+
+// manifestWorkload returns the pod template and replicas of the object, or false for unsupported kinds.
+func manifestWorkload(object runtime.Object) (ManifestWorkload, bool) {
+	replicas := func(replicas *int32) int32 {
+		if replicas == nil {
+			return 1
+		}
+		return *replicas
+	}
+
+	switch o := object.(type) {
+	case *corev1.Pod:
+		return ManifestWorkload{Name: o.Name, Namespace: o.Namespace, Replicas: 1, Spec: o.Spec}, true
+	case *appsv1.Deployment:
+		return ManifestWorkload{Name: o.Name, Namespace: o.Namespace, Replicas: replicas(o.Spec.Replicas), Spec: o.Spec.Template.Spec}, true
+	case *appsv1.StatefulSet:
+		return ManifestWorkload{Name: o.Name, Namespace: o.Namespace, Replicas: replicas(o.Spec.Replicas), Spec: o.Spec.Template.Spec}, true
+	case *appsv1.ReplicaSet:
+		return ManifestWorkload{Name: o.Name, Namespace: o.Namespace, Replicas: replicas(o.Spec.Replicas), Spec: o.Spec.Template.Spec}, true
+	case *batchv1.Job:
+		return ManifestWorkload{Name: o.Name, Namespace: o.Namespace, Replicas: replicas(o.Spec.Parallelism), Spec: o.Spec.Template.Spec}, true
+	}
+
+	return ManifestWorkload{}, false
+}
+
+// EstimateManifest prices the workloads of a manifest from the requests of their containers, like PopulateWorkloads
+// prices pending pods. The node selectors of the pod template pick Spot Pods, arm64, GPUs and built-in compute
+// classes, custom compute classes are priced like other pods.
+func (service *PricingService) EstimateManifest(workloads []ManifestWorkload) []ManifestEstimate {
+	estimates := make([]ManifestEstimate, 0, len(workloads))
+	for _, workload := range workloads {
+		var requested podResources
+		var gpu int64
+		for _, container := range workload.Spec.Containers {
+			cpuRequest := container.Resources.Requests[corev1.ResourceCPU]
+			memoryRequest := container.Resources.Requests[corev1.ResourceMemory]
+			storageRequest := container.Resources.Requests[corev1.ResourceEphemeralStorage]
+			gpuRequest := container.Resources.Requests["nvidia.com/gpu"]
+
+			requested = requested.add(podResources{cpu: cpuRequest.MilliValue(), memory: cluster.MB(&memoryRequest), storage: cluster.MB(&storageRequest)})
+			gpu += gpuRequest.Value()
+		}
+
+		nodeSelector := workload.Spec.NodeSelector
+		gpuModel := nodeSelector["cloud.google.com/gke-accelerator"]
+		spot := nodeSelector["cloud.google.com/gke-spot"] == "true"
+		arm64 := nodeSelector[corev1.LabelArchStable] == "arm64"
+
+		cpu, memory, storage := service.ValidateAndRoundResources(requested.cpu, requested.memory, requested.storage)
+		computeClass := service.DecideComputeClass(workload.Name, "", cpu, memory, gpu, gpuModel, arm64)
+		if class, err := cluster.ParseComputeClass(nodeSelector[ComputeClassSelector]); err == nil {
+			computeClass = class
+		}
+		cpu, memory = service.RoundResources(computeClass, cpu, memory)
+		service.ValidateUpperLimits(workload.Name, computeClass, gpuModel, cpu, memory)
+
+		podCost := service.CalculatePricing(cpu, memory, storage, gpu, gpuModel, computeClass, "", spot).Total
+		estimates = append(estimates, ManifestEstimate{
+			Kind:         workload.Kind,
+			Name:         workload.Name,
+			Namespace:    workload.Namespace,
+			Replicas:     workload.Replicas,
+			Cpu:          cpu,
+			Memory:       memory,
+			Storage:      storage,
+			ComputeClass: computeClass,
+			Class:        cluster.ComputeClasses[computeClass],
+			Spot:         spot,
+			PodCost:      podCost,
+			TotalCost:    podCost * float64(workload.Replicas),
+		})
+	}
+
+	return estimates
+}
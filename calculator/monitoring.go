@@ -0,0 +1,161 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package calculator
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"golang.org/x/exp/slog"
+	"google.golang.org/api/monitoring/v3"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metricsapi "k8s.io/metrics/pkg/apis/metrics/v1beta1"
+)
+
+// usageAlignmentPeriod is the resolution of the usage samples the percentile is taken from
+const usageAlignmentPeriod = time.Minute
+
+// usageMetrics are the Cloud Monitoring metrics of the container usage, with the aligner turning them into a
+// sample per usageAlignmentPeriod: the CPU usage time is cumulative, so its rate is the cores used, and only the
+// non-evictable memory is counted, like the working set of metrics-server.
+var usageMetrics = []struct {
+	resource corev1.ResourceName
+	filter   string
+	aligner  string
+}{
+	{corev1.ResourceCPU, `metric.type="kubernetes.io/container/cpu/core_usage_time"`, "ALIGN_RATE"},
+	{corev1.ResourceMemory, `metric.type="kubernetes.io/container/memory/used_bytes" AND metric.labels.memory_type="non-evictable"`, "ALIGN_MEAN"},
+}
+
+// ContainerUsage is the CPU and memory usage of containers, by namespace/pod/container, see GetContainerUsage.
+type ContainerUsage map[string]corev1.ResourceList
+
+// containerUsageKey is the key of a container in the ContainerUsage.
+func containerUsageKey(namespace string, pod string, container string) string {
+	return namespace + "/" + pod + "/" + container
+}
+
+// GetContainerUsage returns the percentile of the CPU and memory usage of the containers of the cluster over the
+// window before now, read from Cloud Monitoring, instead of the single snapshot of metrics-server. The location is
+// the one of the cluster, a region or a zone.
+func GetContainerUsage(ctx context.Context, monitoringService *monitoring.Service, project string, location string, clusterName string, since time.Duration, percentile float64, now time.Time) (ContainerUsage, error) {
+	samples := make(map[string]map[corev1.ResourceName][]float64)
+	for _, metric := range usageMetrics {
+		filter := fmt.Sprintf(`%s AND resource.type="k8s_container" AND resource.labels.location=%q AND resource.labels.cluster_name=%q`, metric.filter, location, clusterName)
+		call := monitoringService.Projects.TimeSeries.List("projects/" + project).
+			Filter(filter).
+			IntervalStartTime(now.Add(-since).UTC().Format(time.RFC3339)).
+			IntervalEndTime(now.UTC().Format(time.RFC3339)).
+			AggregationAlignmentPeriod(fmt.Sprintf("%ds", int(usageAlignmentPeriod.Seconds()))).
+			AggregationPerSeriesAligner(metric.aligner)
+
+		err := call.Pages(ctx, func(response *monitoring.ListTimeSeriesResponse) error {
+			for _, series := range response.TimeSeries {
+				if series.Resource == nil {
+					continue
+				}
+				labels := series.Resource.Labels
+				key := containerUsageKey(labels["namespace_name"], labels["pod_name"], labels["container_name"])
+				if samples[key] == nil {
+					samples[key] = make(map[corev1.ResourceName][]float64)
+				}
+				for _, point := range series.Points {
+					if point.Value == nil {
+						continue
+					}
+					switch {
+					case point.Value.DoubleValue != nil:
+						samples[key][metric.resource] = append(samples[key][metric.resource], *point.Value.DoubleValue)
+					case point.Value.Int64Value != nil:
+						samples[key][metric.resource] = append(samples[key][metric.resource], float64(*point.Value.Int64Value))
+					}
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("unable to fetch container usage from cloud monitoring: %v", err)
+		}
+	}
+
+	usage := make(ContainerUsage, len(samples))
+	for key, resources := range samples {
+		list := corev1.ResourceList{}
+		if cpu := resources[corev1.ResourceCPU]; len(cpu) > 0 {
+			list[corev1.ResourceCPU] = *resource.NewMilliQuantity(int64(math.Round(Percentile(cpu, percentile)*1000)), resource.DecimalSI)
+		}
+		if memory := resources[corev1.ResourceMemory]; len(memory) > 0 {
+			list[corev1.ResourceMemory] = *resource.NewQuantity(int64(math.Round(Percentile(memory, percentile))), resource.BinarySI)
+		}
+		usage[key] = list
+	}
+
+	return usage, nil
+}
+
+// Percentile returns the nearest-rank percentile of the values, 0 when there are none. The values are sorted in
+// place.
+func Percentile(values []float64, percentile float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+
+	sort.Float64s(values)
+	rank := int(math.Ceil(percentile / 100 * float64(len(values))))
+	if rank < 1 {
+		rank = 1
+	}
+	if rank > len(values) {
+		rank = len(values)
+	}
+	return values[rank-1]
+}
+
+// apply replaces the CPU and memory usage of the containers of the pod metrics with the ones in the usage. The
+// containers missing from it, like the ones started after the window, keep their metrics-server snapshot.
+func (usage ContainerUsage) apply(podMetrics []metricsapi.PodMetrics) {
+	if usage == nil {
+		return
+	}
+
+	missing := 0
+	for i := range podMetrics {
+		for j := range podMetrics[i].Containers {
+			container := &podMetrics[i].Containers[j]
+			containerUsage, ok := usage[containerUsageKey(podMetrics[i].Namespace, podMetrics[i].Name, container.Name)]
+			if !ok {
+				missing++
+				continue
+			}
+
+			resources := container.Usage.DeepCopy()
+			if resources == nil {
+				resources = corev1.ResourceList{}
+			}
+			for name, quantity := range containerUsage {
+				resources[name] = quantity
+			}
+			container.Usage = resources
+		}
+	}
+
+	if missing > 0 {
+		slog.Warn("Containers without usage in Cloud Monitoring are costed on their current usage", "containers", missing)
+	}
+}
@@ -0,0 +1,140 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package calculator
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/GoogleCloudPlatform/autopilot-cost-calculator/cluster"
+	"golang.org/x/exp/slog"
+	policyv1 "k8s.io/api/policy/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// PDBProjection is the hourly cost of the pods covered by a PodDisruptionBudget at its current replicas and at the
+// minimum replicas it keeps available, each replica costing the average of its currently costed pods.
+type PDBProjection struct {
+	Namespace       string  `json:"namespace"`
+	Name            string  `json:"name"`
+	MinReplicas     int32   `json:"min_replicas"`
+	CurrentReplicas int32   `json:"current_replicas"`
+	ReplicaCost     float64 `json:"replica_cost"`
+	MinCost         float64 `json:"min_cost"`
+	CurrentCost     float64 `json:"current_cost"`
+}
+
+// ProjectPDBs projects the cost of the workloads covered by the PodDisruptionBudgets of the namespaces in the
+// filter at the minimum replicas the PDBs keep available. Only the PDBs allowing fewer replicas than currently
+// costed are returned, as a conservative floor of the cost.
+func (service *PricingService) ProjectPDBs(workloads []cluster.Workload) ([]PDBProjection, error) {
+	namespaces := service.Filter.Namespaces
+	if len(namespaces) == 0 {
+		namespaces = []string{""}
+	}
+
+	listOptions := metav1.ListOptions{FieldSelector: service.Filter.namespaceFieldSelector()}
+
+	index := indexPodWorkloads(workloads)
+	var projections []PDBProjection
+	for _, namespace := range namespaces {
+		pdbList, err := service.Clientset.PolicyV1().PodDisruptionBudgets(namespace).List(context.TODO(), listOptions)
+		if err != nil {
+			return nil, fmt.Errorf("error getting pod disruption budgets: %v", err)
+		}
+
+		for _, pdb := range pdbList.Items {
+			projection, ok, err := service.projectPDB(pdb, index)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				projections = append(projections, projection)
+			}
+		}
+	}
+
+	return projections, nil
+}
+
+// projectPDB projects the cost of a single PDB, it returns false when none of its pods were costed or when it
+// doesn't allow fewer replicas than the current ones.
+func (service *PricingService) projectPDB(pdb policyv1.PodDisruptionBudget, index podWorkloads) (PDBProjection, bool, error) {
+	// In policy/v1 a nil selector selects no pods, while an empty one selects all the pods of the namespace
+	if pdb.Spec.Selector == nil {
+		return PDBProjection{}, false, nil
+	}
+
+	selector, err := metav1.LabelSelectorAsSelector(pdb.Spec.Selector)
+	if err != nil {
+		slog.Warn("Skipping PDB with an invalid selector", "pdb", pdb.Name, "namespace", pdb.Namespace, "error", err)
+		return PDBProjection{}, false, nil
+	}
+
+	cost, pods, err := service.selectedPodsCost(pdb.Namespace, selector, index)
+	if err != nil {
+		return PDBProjection{}, false, fmt.Errorf("error getting pods of PDB %s: %v", pdb.Name, err)
+	}
+	if pods == 0 {
+		return PDBProjection{}, false, nil
+	}
+
+	minReplicas, err := pdbMinReplicas(pdb.Spec, pods)
+	if err != nil {
+		slog.Warn("Skipping PDB with an invalid budget", "pdb", pdb.Name, "namespace", pdb.Namespace, "error", err)
+		return PDBProjection{}, false, nil
+	}
+	if minReplicas >= pods {
+		return PDBProjection{}, false, nil
+	}
+
+	replicaCost := cost / float64(pods)
+	return PDBProjection{
+		Namespace:       pdb.Namespace,
+		Name:            pdb.Name,
+		MinReplicas:     minReplicas,
+		CurrentReplicas: pods,
+		ReplicaCost:     replicaCost,
+		MinCost:         replicaCost * float64(minReplicas),
+		CurrentCost:     cost,
+	}, true, nil
+}
+
+// pdbMinReplicas returns the replicas a PDB keeps available out of the current ones, from its minAvailable or
+// maxUnavailable. Percentages are rounded up, like the disruption controller does. A PDB with neither keeps them
+// all available.
+func pdbMinReplicas(spec policyv1.PodDisruptionBudgetSpec, current int32) (int32, error) {
+	if spec.MinAvailable != nil {
+		minAvailable, err := intstr.GetScaledValueFromIntOrPercent(spec.MinAvailable, int(current), true)
+		if err != nil {
+			return 0, err
+		}
+		return int32(minAvailable), nil
+	}
+
+	if spec.MaxUnavailable != nil {
+		maxUnavailable, err := intstr.GetScaledValueFromIntOrPercent(spec.MaxUnavailable, int(current), true)
+		if err != nil {
+			return 0, err
+		}
+		if int32(maxUnavailable) >= current {
+			return 0, nil
+		}
+		return current - int32(maxUnavailable), nil
+	}
+
+	return current, nil
+}
@@ -15,15 +15,27 @@
 package calculator
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
 	"strings"
+	"time"
 
 	"golang.org/x/exp/slices"
+	"golang.org/x/exp/slog"
 	"google.golang.org/api/cloudbilling/v1"
 	"google.golang.org/api/option"
 )
 
+// DefaultCurrency is the currency the prices are fetched in unless another one is asked for, and the one of
+// CLUSTER_FEE
+const DefaultCurrency = "USD"
+
 type GCEPriceList struct {
 	// generic for all
 	Region string
@@ -54,6 +66,9 @@ type GCEPriceList struct {
 	SpotA2MemoryPrice  float64
 	SpotA3CpuPrice     float64
 	SpotA3MemoryPrice  float64
+
+	// Sources are the SKUs the prices were set from by field, see RegionPricing.Explain
+	Sources map[string]PriceSource `json:"-"`
 }
 
 type AutopilotPriceList struct {
@@ -128,9 +143,45 @@ type AutopilotPriceList struct {
 	SpotAcceleratorA10040GGPUPricePremium float64
 	SpotAcceleratorA10080GGPUPricePremium float64
 	SpotAcceleratorH100GPUPricePremium    float64
+
+	// Currency is the currency of the prices, converted from USD by the Cloud Billing API at ConversionRate
+	Currency       string  `json:"-"`
+	ConversionRate float64 `json:"-"`
+
+	// Sources are the SKUs the prices were set from by field, see RegionPricing.Explain
+	Sources map[string]PriceSource `json:"-"`
+}
+
+// PriceSource is the SKU a price field was set from, with the price per vCPU, GiB or GPU and hour computed from
+// it. Fields without a matching SKU have no SKU ID.
+type PriceSource struct {
+	List        string
+	Field       string
+	SkuID       string
+	Description string
+	Price       float64
+}
+
+// zoneSuffix matches the zone letter ending a zone like europe-west1-b.
+var zoneSuffix = regexp.MustCompile(`-[a-z]$`)
+
+// regionPattern matches region names like europe-west1 or northamerica-northeast1.
+var regionPattern = regexp.MustCompile(`^[a-z]+-[a-z]+[0-9]+$`)
+
+// RegionFromLocation returns the region of a zonal location like europe-west1-b, or of a regional one like
+// europe-west1, which is returned as is.
+func RegionFromLocation(location string) (string, error) {
+	region := zoneSuffix.ReplaceAllString(strings.ToLower(strings.TrimSpace(location)), "")
+	if !regionPattern.MatchString(region) {
+		return "", fmt.Errorf("unable to derive the region of location %q, expected a region like europe-west1 or a zone like europe-west1-b", location)
+	}
+
+	return region, nil
 }
 
-func GetGCEPricing(sku string, region string) (GCEPriceList, error) {
+// GetGCEPricing fetches the GCE machine prices of the region in the currency effective at the pricing date, the
+// zero time for now. The client options are passed to the Cloud Billing service, eg. to set the quota project.
+func GetGCEPricing(sku string, region string, currency string, pricingDate time.Time, skuMap SKUMap, clientOptions ...option.ClientOption) (GCEPriceList, error) {
 	pricing := GCEPriceList{
 		Region:         region,
 		H3CpuPrice:     0,
@@ -161,107 +212,222 @@ func GetGCEPricing(sku string, region string) (GCEPriceList, error) {
 	}
 
 	// If the "region" is actual "zone", we need to remove the zone to get the pricing for the whole region.
-	if len(strings.Split(region, "-")) > 2 {
-		region = strings.Join(
-			strings.Split(region, "-")[:len(
-				strings.Split(
-					region,
-					"-",
-				),
-			)-1],
-			"-",
-		)
+	region, err := RegionFromLocation(region)
+	if err != nil {
+		return GCEPriceList{}, err
 	}
 
-	ctx := context.Background()
-
-	cloudbillingService, err := cloudbilling.NewService(ctx, option.WithScopes(cloudbilling.CloudPlatformScope))
+	skus, err := listRegionSKUs(context.Background(), sku, region, currency, pricingDate, clientOptions...)
 	if err != nil {
-		err = fmt.Errorf("unable to initialize cloud billing service: %v", err)
+		err = fmt.Errorf("unable to fetch gce cloud billing information: %v", err)
 		return GCEPriceList{}, err
 	}
 
-	err = cloudbillingService.Services.Skus.List("services/"+sku).CurrencyCode("USD").Pages(ctx, func(pricingInfo *cloudbilling.ListSkusResponse) error {
-		for _, sku := range pricingInfo.Skus {
-			if !slices.Contains(sku.ServiceRegions, region) {
-				continue
-			}
+	for _, sku := range skus {
+		price := NormalizePrice(SKUPrice(sku.PricingInfo[0].PricingExpression), sku.PricingInfo[0].PricingExpression.UsageUnit)
+		pricing.Sources = recordPriceSources(pricing.Sources, pricing.setPrice(region, sku.Description, price, skuMap), sku, price)
+	}
 
-			decimal := sku.PricingInfo[0].PricingExpression.TieredRates[0].UnitPrice.Units * 1000000000
-			mantissa := sku.PricingInfo[0].PricingExpression.TieredRates[0].UnitPrice.Nanos * int64(sku.PricingInfo[0].PricingExpression.DisplayQuantity)
-
-			price := float64(decimal+mantissa) / 1000000000
-
-			switch {
-			case strings.HasPrefix(sku.Description, "H3 Instance Core"):
-				pricing.H3CpuPrice = price
-			case strings.HasPrefix(sku.Description, "H3 Instance Ram"):
-				pricing.H3MemoryPrice = price
-
-			case strings.HasPrefix(sku.Description, "Compute optimized Instance Core"):
-				pricing.C2CpuPrice = price
-			case strings.HasPrefix(sku.Description, "Compute optimized Instance Ram"):
-				pricing.C2MemoryPrice = price
-			case strings.HasPrefix(sku.Description, "Spot Preemptible Compute optimized Instance Core"):
-				pricing.SpotC2CpuPrice = price
-			case strings.HasPrefix(sku.Description, "Spot Preemptible Compute optimized Instance Ram"):
-
-				pricing.SpotC2MemoryPrice = price
-			case strings.HasPrefix(sku.Description, "C2D AMD Instance Core"):
-				pricing.C2DCpuPrice = price
-			case strings.HasPrefix(sku.Description, "C2D AMD Instance Ram"):
-				pricing.C2DMemoryPrice = price
-			case strings.HasPrefix(sku.Description, "Spot Preemptible C2D AMD Instance Core"):
-				pricing.SpotC2DCpuPrice = price
-			case strings.HasPrefix(sku.Description, "Spot Preemptible C2D AMD Instance Ram"):
-				pricing.SpotC2DMemoryPrice = price
-
-			case strings.HasPrefix(sku.Description, "G2 Instance Core"):
-				pricing.G2CpuPrice = price
-			case strings.HasPrefix(sku.Description, "G2 Instance Ram"):
-				pricing.G2MemoryPrice = price
-			case strings.HasPrefix(sku.Description, "Spot Preemptible G2 Instance Core"):
-				pricing.SpotG2DCpuPrice = price
-			case strings.HasPrefix(sku.Description, "Spot Preemptible G2 Instance Ram"):
-				pricing.SpotG2DMemoryPrice = price
-
-			case strings.HasPrefix(sku.Description, "A2 Instance Core"):
-				pricing.A2CpuPrice = price
-			case strings.HasPrefix(sku.Description, "A2 Instance Ram"):
-				pricing.A2MemoryPrice = price
-			case strings.HasPrefix(sku.Description, "Spot Preemptible A2 Instance Core"):
-				pricing.SpotA2CpuPrice = price
-			case strings.HasPrefix(sku.Description, "Spot Preemptible A2 Instance Ram"):
-				pricing.SpotA2MemoryPrice = price
-
-			case strings.HasPrefix(sku.Description, "A3 Instance Core"):
-				pricing.A3CpuPrice = price
-			case strings.HasPrefix(sku.Description, "A3 Instance Ram"):
-				pricing.A3MemoryPrice = price
-			case strings.HasPrefix(sku.Description, "Spot Preemptible A3 Instance Core"):
-				pricing.SpotA3CpuPrice = price
-			case strings.HasPrefix(sku.Description, "Spot Preemptible A3 Instance Ram"):
-				pricing.SpotA3MemoryPrice = price
+	return pricing, nil
+}
 
-			}
+// listRegionSKUs lists the SKUs of the Cloud Billing service available in the region and priced in the currency at
+// the pricing date, the zero time for now, one per description, see SelectSKUs.
+func listRegionSKUs(ctx context.Context, service string, region string, currency string, pricingDate time.Time, clientOptions ...option.ClientOption) ([]*cloudbilling.Sku, error) {
+	cloudbillingService, err := cloudbilling.NewService(ctx, append([]option.ClientOption{option.WithScopes(cloudbilling.CloudPlatformScope)}, clientOptions...)...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to initialize cloud billing service: %v", err)
+	}
 
+	call := cloudbillingService.Services.Skus.List("services/" + service).CurrencyCode(currency)
+	at := time.Now()
+	if !pricingDate.IsZero() {
+		// The API only lists the latest pricing unless asked for a time range, which can't be in the future.
+		// Scheduled prices are listed with the latest ones.
+		if pricingDate.Before(at) {
+			call = call.StartTime(pricingDate.UTC().Format(time.RFC3339)).EndTime(pricingDate.Add(time.Second).UTC().Format(time.RFC3339))
 		}
+		at = pricingDate
+	}
 
+	var skus []*cloudbilling.Sku
+	err = call.Pages(ctx, func(response *cloudbilling.ListSkusResponse) error {
+		for _, sku := range response.Skus {
+			if slices.Contains(sku.ServiceRegions, region) {
+				skus = append(skus, sku)
+			}
+		}
 		return nil
 	})
+	if err != nil {
+		return nil, err
+	}
+
+	return SelectSKUs(skus, at), nil
+}
 
+// SelectSKUs keeps the SKUs priced at the given time, each with the PricingInfo effective then as its only one, see
+// SKUPricingInfo. It also keeps one SKU per description, compared like the SKU patterns without case and extra
+// spaces. The API can list several, like a legacy and a current one, so the one whose pricing took effect last is
+// kept, then the one with the lowest ID. The SKUs are returned sorted by description and ID, so that the prices set
+// from them are the same from one run to the next whatever the order of the API.
+func SelectSKUs(skus []*cloudbilling.Sku, at time.Time) []*cloudbilling.Sku {
+	selected := make(map[string]*cloudbilling.Sku)
+	for _, sku := range skus {
+		pricingInfo := SKUPricingInfo(sku, at)
+		if pricingInfo == nil {
+			slog.Debug("Skipping SKU without pricing at the pricing date", "description", sku.Description, "sku", sku.SkuId)
+			continue
+		}
+		priced := *sku
+		priced.PricingInfo = []*cloudbilling.PricingInfo{pricingInfo}
+
+		key := skuDescriptionKey(sku.Description)
+		current, ok := selected[key]
+		if ok && !skuPrecedes(&priced, current) {
+			slog.Debug("Skipping duplicate SKU", "description", sku.Description, "sku", sku.SkuId, "kept", current.SkuId)
+			continue
+		}
+		if ok {
+			slog.Debug("Skipping duplicate SKU", "description", current.Description, "sku", current.SkuId, "kept", sku.SkuId)
+		}
+		selected[key] = &priced
+	}
+
+	result := make([]*cloudbilling.Sku, 0, len(selected))
+	for _, sku := range selected {
+		result = append(result, sku)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		keyI, keyJ := skuDescriptionKey(result[i].Description), skuDescriptionKey(result[j].Description)
+		if keyI != keyJ {
+			return keyI < keyJ
+		}
+		return result[i].SkuId < result[j].SkuId
+	})
+
+	return result
+}
+
+// SKUPricingInfo returns the pricing of the SKU effective at the given time: the one that took effect last, but not
+// after it, so that scheduled prices aren't used before their time. Pricing without a valid effective time counts
+// as always effective. It's nil when no pricing is effective yet.
+func SKUPricingInfo(sku *cloudbilling.Sku, at time.Time) *cloudbilling.PricingInfo {
+	var selected *cloudbilling.PricingInfo
+	var selectedTime time.Time
+	for _, pricingInfo := range sku.PricingInfo {
+		if pricingInfo == nil || pricingInfo.PricingExpression == nil {
+			continue
+		}
+		effective := pricingInfoEffectiveTime(pricingInfo)
+		if effective.After(at) {
+			continue
+		}
+		if selected == nil || effective.After(selectedTime) {
+			selected, selectedTime = pricingInfo, effective
+		}
+	}
+
+	return selected
+}
+
+// skuPrecedes returns whether the SKU takes precedence over the other one with the same description: its pricing
+// took effect later or, at the same time, it has the lower ID.
+func skuPrecedes(sku *cloudbilling.Sku, other *cloudbilling.Sku) bool {
+	effective, otherEffective := pricingInfoEffectiveTime(sku.PricingInfo[0]), pricingInfoEffectiveTime(other.PricingInfo[0])
+	if !effective.Equal(otherEffective) {
+		return effective.After(otherEffective)
+	}
+
+	return sku.SkuId < other.SkuId
+}
+
+// pricingInfoEffectiveTime returns when the pricing took effect, the zero time when it's unknown.
+func pricingInfoEffectiveTime(pricingInfo *cloudbilling.PricingInfo) time.Time {
+	effective, err := time.Parse(time.RFC3339, pricingInfo.EffectiveTime)
 	if err != nil {
-		err = fmt.Errorf("unable to fetch gce cloud billing information: %v", err)
-		return GCEPriceList{}, err
+		return time.Time{}
 	}
+	return effective
+}
 
-	return pricing, nil
+// skuDescriptionKey normalizes a SKU description to compare it without case and extra spaces.
+func skuDescriptionKey(description string) string {
+	return strings.ToLower(strings.Join(strings.Fields(description), " "))
+}
+
+// PriceFromMoney converts a Cloud Billing amount to a float. Nanos are billionths of a unit and have the sign of
+// the units, so -1.25 is -1 units and -250000000 nanos.
+func PriceFromMoney(money *cloudbilling.Money) float64 {
+	if money == nil {
+		return 0
+	}
+
+	return float64(money.Units) + float64(money.Nanos)/1e9
+}
+
+// SKUPrice returns the price per usage unit of a SKU. Tiers starting at a higher usage are cheaper volume rates,
+// while a free first tier is an allowance, so the price is the one of the first tier that isn't free.
+func SKUPrice(expression *cloudbilling.PricingExpression) float64 {
+	if expression == nil {
+		return 0
+	}
+
+	tiers := make([]*cloudbilling.TierRate, len(expression.TieredRates))
+	copy(tiers, expression.TieredRates)
+	sort.SliceStable(tiers, func(i, j int) bool {
+		return tiers[i].StartUsageAmount < tiers[j].StartUsageAmount
+	})
+
+	for _, tier := range tiers {
+		if price := PriceFromMoney(tier.UnitPrice); price != 0 {
+			return price
+		}
+	}
+
+	return 0
+}
+
+// usageTimeHours are the hours in the time units of the SKU usage units
+var usageTimeHours = map[string]float64{"s": 1.0 / 3600, "min": 1.0 / 60, "h": 1, "d": 24, "mo": HOURS_PER_MONTH}
+
+// usageQuantityGiB are the GiB in the byte units of the SKU usage units
+var usageQuantityGiB = map[string]float64{"MiBy": 1.0 / 1024, "GiBy": 1, "TiBy": 1024}
+
+// NormalizePrice converts the price of a SKU per usage unit, like GiBy.mo for storage or h for vCPUs, to a price
+// per hour and per vCPU or GiB, the basis of the price lists. Prices of unknown usage units are kept as is.
+func NormalizePrice(price float64, usageUnit string) float64 {
+	quantity, timeUnit, hasQuantity := strings.Cut(usageUnit, ".")
+	if !hasQuantity {
+		quantity, timeUnit = "", usageUnit
+	}
+
+	hours, ok := usageTimeHours[timeUnit]
+	if !ok {
+		slog.Debug("Unknown usage unit of SKU, keeping its price", "usage_unit", usageUnit)
+		return price
+	}
+
+	gib := 1.0
+	if quantity != "" {
+		gib, ok = usageQuantityGiB[quantity]
+		if !ok {
+			slog.Debug("Unknown usage unit of SKU, keeping its price", "usage_unit", usageUnit)
+			return price
+		}
+	}
+
+	return price / hours / gib
 }
 
-func GetAutopilotPricing(sku string, region string) (AutopilotPriceList, error) {
+// GetAutopilotPricing fetches the Autopilot prices of the region in the currency effective at the pricing date, the
+// zero time for now. The client options are passed to the Cloud Billing service, eg. to set the quota project.
+func GetAutopilotPricing(sku string, region string, currency string, pricingDate time.Time, skuMap SKUMap, clientOptions ...option.ClientOption) (AutopilotPriceList, error) {
 	// Init all to zeroes
 	pricing := AutopilotPriceList{
 		Region:                     region,
+		Currency:                   currency,
+		ConversionRate:             1,
 		StoragePrice:               0,
 		CpuPrice:                   0,
 		MemoryPrice:                0,
@@ -325,197 +491,362 @@ func GetAutopilotPricing(sku string, region string) (AutopilotPriceList, error)
 	}
 
 	// If the "region" is actual "zone", we need to remove the zone to get the pricing for the whole region.
-	if len(strings.Split(region, "-")) > 2 {
-		region = strings.Join(
-			strings.Split(region, "-")[:len(
-				strings.Split(
-					region,
-					"-",
-				),
-			)-1],
-			"-",
-		)
+	region, err := RegionFromLocation(region)
+	if err != nil {
+		return AutopilotPriceList{}, err
 	}
 
-	ctx := context.Background()
-
-	cloudbillingService, err := cloudbilling.NewService(ctx, option.WithScopes(cloudbilling.CloudPlatformScope))
+	skus, err := listRegionSKUs(context.Background(), sku, region, currency, pricingDate, clientOptions...)
 	if err != nil {
-		err = fmt.Errorf("unable to initialize cloud billing service: %v", err)
+		err = fmt.Errorf("unable to fetch autopilot cloud billing information: %v", err)
 		return AutopilotPriceList{}, err
 	}
 
-	err = cloudbillingService.Services.Skus.List("services/"+sku).CurrencyCode("USD").Pages(ctx, func(pricingInfo *cloudbilling.ListSkusResponse) error {
-		for _, sku := range pricingInfo.Skus {
-			if !slices.Contains(sku.ServiceRegions, region) {
-				continue
-			}
+	for _, sku := range skus {
+		if rate := sku.PricingInfo[0].CurrencyConversionRate; rate > 0 {
+			pricing.ConversionRate = rate
+		}
+		price := NormalizePrice(SKUPrice(sku.PricingInfo[0].PricingExpression), sku.PricingInfo[0].PricingExpression.UsageUnit)
+		pricing.Sources = recordPriceSources(pricing.Sources, pricing.setPrice(region, sku.Description, price, skuMap), sku, price)
+	}
 
-			decimal := sku.PricingInfo[0].PricingExpression.TieredRates[0].UnitPrice.Units * 1000000000
-			mantissa := sku.PricingInfo[0].PricingExpression.TieredRates[0].UnitPrice.Nanos * int64(sku.PricingInfo[0].PricingExpression.DisplayQuantity)
-
-			price := float64(decimal+mantissa) / 1000000000
-
-			switch sku.Description {
-			case "Autopilot Pod Ephemeral Storage Requests (" + region + ")":
-				pricing.StoragePrice = price
-
-			case "Autopilot Pod Memory Requests (" + region + ")":
-				pricing.MemoryPrice = price
-
-			case "Autopilot Pod mCPU Requests (" + region + ")":
-				pricing.CpuPrice = price
-
-			case "Autopilot Balanced Pod Memory Requests (" + region + ")":
-				pricing.MemoryBalancedPrice = price
-
-			case "Autopilot Balanced Pod mCPU Requests (" + region + ")":
-				pricing.CpuBalancedPrice = price
-
-			case "Autopilot Scale-Out x86 Pod Memory Requests (" + region + ")":
-				pricing.MemoryScaleoutPrice = price
-
-			case "Autopilot Scale-Out x86 Pod mCPU Requests (" + region + ")":
-				pricing.CpuScaleoutPrice = price
-
-			case "Autopilot Scale-Out Arm Spot Pod Memory Requests (" + region + ")":
-				pricing.MemoryArmScaleoutPrice = price
-
-			case "Autopilot Scale-Out Arm Spot Pod mCPU Requests (" + region + ")":
-				pricing.CpuArmScaleoutPrice = price
-
-			case "Autopilot Spot Pod Memory Requests (" + region + ")":
-				pricing.SpotMemoryPrice = price
-
-			case "Autopilot Spot Pod mCPU Requests (" + region + ")":
-				pricing.SpotCpuPrice = price
-
-			case "Autopilot Balanced Spot Pod Memory Requests (" + region + ")":
-				pricing.SpotMemoryBalancedPrice = price
-
-			case "Autopilot Balanced Spot Pod mCPU Requests (" + region + ")":
-				pricing.SpotCpuBalancedPrice = price
-
-			case "Autopilot Scale-Out x86 Spot Pod Memory Requests (" + region + ")":
-				pricing.SpotMemoryScaleoutPrice = price
-
-			case "Autopilot Scale-Out x86 Spot Pod mCPU Requests (" + region + ")":
-				pricing.SpotCpuScaleoutPrice = price
-
-			case "Autopilot Scale-Out Arm Spot Pod Memory Requests (" + region + ")":
-				pricing.SpotArmMemoryScaleoutPrice = price
-
-			case "Autopilot Scale-Out Arm Spot Pod mCPU Requests (" + region + ")":
-				pricing.SpotArmCpuScaleoutPrice = price
-
-			case "Autopilot NVIDIA T4 Pod mCPU Requests (" + region + ")":
-			case "Autopilot NVIDIA L4 Pod mCPU Requests (" + region + ")":
-			case "Autopilot NVIDIA A100 Pod mCPU Requests (" + region + ")":
-			case "Autopilot NVIDIA A100 80GB Pod mCPU Requests (" + region + ")":
-				pricing.GPUPodvCPUPrice = price
-			case "Autopilot NVIDIA T4 Pod Memory Requests (" + region + ")":
-			case "Autopilot NVIDIA L4 Pod Memory Requests (" + region + ")":
-			case "Autopilot NVIDIA A100 Pod Memory Requests (" + region + ")":
-			case "Autopilot NVIDIA A100 80GB Pod Memory Requests (" + region + ")":
-				pricing.GPUPodMemoryPrice = price
-			case "Autopilot NVIDIA T4 Pod GPU Requests (" + region + ")":
-				pricing.NVIDIAT4PodGPUPrice = price
-			case "Autopilot NVIDIA L4 Pod GPU Requests (" + region + ")":
-				pricing.NVIDIAL4PodGPUPrice = price
-			case "Autopilot NVIDIA A100 Pod GPU Requests (" + region + ")":
-				pricing.NVIDIAA10040GPodGPUPrice = price
-			case "Autopilot NVIDIA A100 80GB Pod GPU Requests (" + region + ")":
-				pricing.NVIDIAA10080GPodGPUPrice = price
-			case "Autopilot GPU Pod Local SSD (" + region + ")":
-				pricing.SpotGPUPodLocalSSDPrice = price
-
-			case "Autopilot NVIDIA T4 Spot Pod mCPU Requests (" + region + ")":
-			case "Autopilot NVIDIA L4 Spot Pod mCPU Requests (" + region + ")":
-			case "Autopilot NVIDIA A100 Spot Pod mCPU Requests (" + region + ")":
-			case "Autopilot NVIDIA A100 80GB Spot Pod mCPU Requests (" + region + ")":
-				pricing.GPUPodvCPUPrice = price
-			case "Autopilot NVIDIA T4 Spot Pod Memory Requests (" + region + ")":
-			case "Autopilot NVIDIA L4 Spot Pod Memory Requests (" + region + ")":
-			case "Autopilot NVIDIA A100 Spot Pod Memory Requests (" + region + ")":
-			case "Autopilot NVIDIA A100 80GB Spot Pod Memory Requests (" + region + ")":
-				pricing.GPUPodMemoryPrice = price
-			case "Autopilot NVIDIA T4 Spot Pod GPU Requests (" + region + ")":
-				pricing.NVIDIAT4PodGPUPrice = price
-			case "Autopilot NVIDIA L4 Spot Pod GPU Requests (" + region + ")":
-				pricing.NVIDIAL4PodGPUPrice = price
-			case "Autopilot NVIDIA A100 Spot Pod GPU Requests (" + region + ")":
-				pricing.NVIDIAA10040GPodGPUPrice = price
-			case "Autopilot NVIDIA A100 80GB Spot Pod GPU Requests (" + region + ")":
-				pricing.NVIDIAA10080GPodGPUPrice = price
-			case "Autopilot GPU Spot Pod Local SSD (" + region + ")":
-				pricing.SpotGPUPodLocalSSDPrice = price
-
-			case "Autopilot PD Balanced Premium (" + region + ")":
-				pricing.PerformancePDPricePremium = price
-				pricing.SpotPerformancePDPricePremium = price
-				pricing.AcceleratorPDPricePremium = price
-				pricing.SpotAcceleratorPDPricePremium = price
-
-			case "Autopilot Performance CPU Premium (" + region + ")":
-				pricing.PerformanceCpuPricePremium = price
-			case "Autopilot Performance Memory Premium (" + region + ")":
-				pricing.PerformanceMemoryPricePremium = price
-			case "Autopilot Local SSD Premium (" + region + ")":
-				pricing.PerformanceLocalSSDPricePremium = price
-				pricing.AcceleratorLocalSSDPricePremium = price
-
-			case "Autopilot Spot PD Balanced Premium (" + region + ")":
-				pricing.PerformancePDPricePremium = price
-				pricing.SpotPerformancePDPricePremium = price
-				pricing.AcceleratorPDPricePremium = price
-				pricing.SpotAcceleratorPDPricePremium = price
-
-			case "Autopilot Performance Spot CPU Premium (" + region + ")":
-				pricing.SpotPerformanceCpuPricePremium = price
-			case "Autopilot Performance Spot Memory Premium (" + region + ")":
-				pricing.SpotPerformanceMemoryPricePremium = price
-			case "Autopilot Local SSD Spot Premium (" + region + ")":
-				pricing.SpotPerformanceLocalSSDPricePremium = price
-				pricing.SpotAcceleratorLocalSSDPricePremium = price
-
-			case "Autopilot Accelerator CPU Premium (" + region + ")":
-				pricing.AcceleratorCpuPricePremium = price
-			case "Autopilot Accelerator Memory Premium (" + region + ")":
-				pricing.AcceleratorMemoryGPUPricePremium = price
-			case "Autopilot T4 Premium (" + region + ")":
-				pricing.AcceleratorT4GPUPricePremium = price
-			case "Autopilot L4 Premium (" + region + ")":
-				pricing.AcceleratorL4GPUPricePremium = price
-			case "Autopilot A100 40GB Premium (" + region + ")":
-				pricing.AcceleratorA10040GGPUPricePremium = price
-			case "Autopilot A100 80GB Premium (" + region + ")":
-				pricing.AcceleratorA10080GGPUPricePremium = price
-			case "Autopilot H100 80GB Premium (" + region + ")":
-				pricing.AcceleratorH100GPUPricePremium = price
-
-			case "Autopilot Accelerator Spot CPU Premium (" + region + ")":
-				pricing.SpotAcceleratorCpuPricePremium = price
-			case "Autopilot Accelerator Spot Memory Premium (" + region + ")":
-				pricing.SpotAcceleratorMemoryGPUPricePremium = price
-			case "Autopilot T4 Spot Premium (" + region + ")":
-				pricing.SpotAcceleratorT4GPUPricePremium = price
-			case "Autopilot L4 Spot Premium (" + region + ")":
-				pricing.SpotAcceleratorL4GPUPricePremium = price
-			case "Autopilot A100 40GB Spot Premium (" + region + ")":
-				pricing.SpotAcceleratorA10040GGPUPricePremium = price
-			case "Autopilot A100 80GB Spot Premium (" + region + ")":
-				pricing.SpotAcceleratorA10080GGPUPricePremium = price
-			case "Autopilot H100 80GB Spot Premium (" + region + ")":
-				pricing.SpotAcceleratorH100GPUPricePremium = price
-			}
+	return pricing, nil
+}
+
+// SetPrice sets the price of the fields whose SKU has the description.
+// The SKU map takes precedence over the built-in descriptions. Returns whether a field matched.
+func (pricing *GCEPriceList) SetPrice(region string, description string, price float64, skuMap SKUMap) bool {
+	return len(pricing.setPrice(region, description, price, skuMap)) > 0
+}
+
+// setPrice is SetPrice returning the fields set.
+func (pricing *GCEPriceList) setPrice(region string, description string, price float64, skuMap SKUMap) []string {
+	if fields := skuMap.apply(pricing, description, price); len(fields) > 0 {
+		return fields
+	}
+
+	return setBuiltInPrice(pricing, gceSKUs, region, description, price)
+}
+
+// SetPrice sets the price of the fields whose SKU has the description, for the region without zone.
+// The SKU map takes precedence over the built-in descriptions. Returns whether a field matched.
+func (pricing *AutopilotPriceList) SetPrice(region string, description string, price float64, skuMap SKUMap) bool {
+	return len(pricing.setPrice(region, description, price, skuMap)) > 0
+}
+
+// setPrice is SetPrice returning the fields set.
+func (pricing *AutopilotPriceList) setPrice(region string, description string, price float64, skuMap SKUMap) []string {
+	if fields := skuMap.apply(pricing, description, price); len(fields) > 0 {
+		return fields
+	}
+
+	return setBuiltInPrice(pricing, autopilotSKUs, region, description, price)
+}
+
+// recordPriceSources records the SKU as the source of the fields it set. SKUs are applied in a stable order, see
+// SelectSKUs, so the last one recorded for a field is the one its price comes from.
+func recordPriceSources(sources map[string]PriceSource, fields []string, sku *cloudbilling.Sku, price float64) map[string]PriceSource {
+	if len(fields) == 0 {
+		return sources
+	}
+	if sources == nil {
+		sources = make(map[string]PriceSource)
+	}
+
+	for _, field := range fields {
+		sources[field] = PriceSource{Field: field, SkuID: sku.SkuId, Description: sku.Description, Price: price}
+	}
+	return sources
+}
+
+// priceFields returns the names of the price fields of a price list, in their declaration order.
+func priceFields(priceList any) []string {
+	listType := reflect.TypeOf(priceList)
+	var fields []string
+	for i := 0; i < listType.NumField(); i++ {
+		if listType.Field(i).Type.Kind() == reflect.Float64 {
+			fields = append(fields, listType.Field(i).Name)
 		}
-		return nil
-	})
+	}
+	return fields
+}
+
+// skuPattern matches the description of a SKU to the price fields it sets
+type skuPattern struct {
+	pattern *regexp.Regexp
+	// regional SKUs end with the region in parentheses, which must match the requested one
+	regional bool
+	fields   []string
+}
+
+// descriptionPattern turns a SKU description into a pattern that ignores case and extra spaces.
+func descriptionPattern(description string) string {
+	words := strings.Fields(description)
+	for i, word := range words {
+		words[i] = regexp.QuoteMeta(word)
+	}
+
+	return `(?i)^\s*` + strings.Join(words, `\s+`)
+}
+
+// autopilotSKU matches the full description of a regional Autopilot SKU, followed by its region.
+func autopilotSKU(description string, fields ...string) skuPattern {
+	return skuPattern{
+		pattern:  regexp.MustCompile(descriptionPattern(description) + `\s*\(\s*([^)]*?)\s*\)\s*$`),
+		regional: true,
+		fields:   fields,
+	}
+}
+
+// gceSKU matches the beginning of the description of a GCE SKU.
+func gceSKU(prefix string, fields ...string) skuPattern {
+	return skuPattern{
+		pattern: regexp.MustCompile(descriptionPattern(prefix)),
+		fields:  fields,
+	}
+}
+
+// setBuiltInPrice sets the price of the fields of the first pattern matching the description. Returns the fields
+// set, none when no pattern matched.
+func setBuiltInPrice(priceList any, patterns []skuPattern, region string, description string, price float64) []string {
+	for _, sku := range patterns {
+		match := sku.pattern.FindStringSubmatch(description)
+		if match == nil || (sku.regional && !strings.EqualFold(match[1], region)) {
+			continue
+		}
+
+		for _, field := range sku.fields {
+			setPriceField(priceList, field, price)
+		}
+		return sku.fields
+	}
+
+	return nil
+}
+
+// setPriceField sets a float64 field of the price list by its name. Returns whether the field exists.
+func setPriceField(priceList any, field string, price float64) bool {
+	value := reflect.ValueOf(priceList).Elem().FieldByName(field)
+	if !value.IsValid() || value.Kind() != reflect.Float64 {
+		return false
+	}
+
+	value.SetFloat(price)
+	return true
+}
+
+var gceSKUs = []skuPattern{
+	gceSKU("H3 Instance Core", "H3CpuPrice"),
+	gceSKU("H3 Instance Ram", "H3MemoryPrice"),
+	gceSKU("Compute optimized Instance Core", "C2CpuPrice"),
+	gceSKU("Compute optimized Instance Ram", "C2MemoryPrice"),
+	gceSKU("Spot Preemptible Compute optimized Instance Core", "SpotC2CpuPrice"),
+	gceSKU("Spot Preemptible Compute optimized Instance Ram", "SpotC2MemoryPrice"),
+	gceSKU("C2D AMD Instance Core", "C2DCpuPrice"),
+	gceSKU("C2D AMD Instance Ram", "C2DMemoryPrice"),
+	gceSKU("Spot Preemptible C2D AMD Instance Core", "SpotC2DCpuPrice"),
+	gceSKU("Spot Preemptible C2D AMD Instance Ram", "SpotC2DMemoryPrice"),
+	gceSKU("G2 Instance Core", "G2CpuPrice"),
+	gceSKU("G2 Instance Ram", "G2MemoryPrice"),
+	gceSKU("Spot Preemptible G2 Instance Core", "SpotG2DCpuPrice"),
+	gceSKU("Spot Preemptible G2 Instance Ram", "SpotG2DMemoryPrice"),
+	gceSKU("A2 Instance Core", "A2CpuPrice"),
+	gceSKU("A2 Instance Ram", "A2MemoryPrice"),
+	gceSKU("Spot Preemptible A2 Instance Core", "SpotA2CpuPrice"),
+	gceSKU("Spot Preemptible A2 Instance Ram", "SpotA2MemoryPrice"),
+	gceSKU("A3 Instance Core", "A3CpuPrice"),
+	gceSKU("A3 Instance Ram", "A3MemoryPrice"),
+	gceSKU("Spot Preemptible A3 Instance Core", "SpotA3CpuPrice"),
+	gceSKU("Spot Preemptible A3 Instance Ram", "SpotA3MemoryPrice"),
+}
+
+// The first matching pattern wins. The mCPU and memory SKUs of the T4, L4 and A100 40GB GPU Pods aren't
+// matched, the GPU Pod prices, on demand and Spot, are set from the A100 80GB ones.
+var autopilotSKUs = []skuPattern{
+	autopilotSKU("Autopilot Pod Ephemeral Storage Requests", "StoragePrice"),
+	autopilotSKU("Autopilot Pod Memory Requests", "MemoryPrice"),
+	autopilotSKU("Autopilot Pod mCPU Requests", "CpuPrice"),
+	autopilotSKU("Autopilot Balanced Pod Memory Requests", "MemoryBalancedPrice"),
+	autopilotSKU("Autopilot Balanced Pod mCPU Requests", "CpuBalancedPrice"),
+	autopilotSKU("Autopilot Scale-Out x86 Pod Memory Requests", "MemoryScaleoutPrice"),
+	autopilotSKU("Autopilot Scale-Out x86 Pod mCPU Requests", "CpuScaleoutPrice"),
+	autopilotSKU("Autopilot Scale-Out Arm Pod Memory Requests", "MemoryArmScaleoutPrice"),
+	autopilotSKU("Autopilot Scale-Out Arm Pod mCPU Requests", "CpuArmScaleoutPrice"),
+	autopilotSKU("Autopilot Spot Pod Memory Requests", "SpotMemoryPrice"),
+	autopilotSKU("Autopilot Spot Pod mCPU Requests", "SpotCpuPrice"),
+	autopilotSKU("Autopilot Balanced Spot Pod Memory Requests", "SpotMemoryBalancedPrice"),
+	autopilotSKU("Autopilot Balanced Spot Pod mCPU Requests", "SpotCpuBalancedPrice"),
+	autopilotSKU("Autopilot Scale-Out x86 Spot Pod Memory Requests", "SpotMemoryScaleoutPrice"),
+	autopilotSKU("Autopilot Scale-Out x86 Spot Pod mCPU Requests", "SpotCpuScaleoutPrice"),
+	autopilotSKU("Autopilot Scale-Out Arm Spot Pod Memory Requests", "SpotArmMemoryScaleoutPrice"),
+	autopilotSKU("Autopilot Scale-Out Arm Spot Pod mCPU Requests", "SpotArmCpuScaleoutPrice"),
+	autopilotSKU("Autopilot NVIDIA A100 80GB Pod mCPU Requests", "GPUPodvCPUPrice"),
+	autopilotSKU("Autopilot NVIDIA A100 80GB Pod Memory Requests", "GPUPodMemoryPrice"),
+	autopilotSKU("Autopilot NVIDIA T4 Pod GPU Requests", "NVIDIAT4PodGPUPrice"),
+	autopilotSKU("Autopilot NVIDIA L4 Pod GPU Requests", "NVIDIAL4PodGPUPrice"),
+	autopilotSKU("Autopilot NVIDIA A100 Pod GPU Requests", "NVIDIAA10040GPodGPUPrice"),
+	autopilotSKU("Autopilot NVIDIA A100 80GB Pod GPU Requests", "NVIDIAA10080GPodGPUPrice"),
+	autopilotSKU("Autopilot GPU Pod Local SSD", "GPUPodLocalSSDPrice"),
+	autopilotSKU("Autopilot NVIDIA A100 80GB Spot Pod mCPU Requests", "SpotGPUPodvCPUPrice"),
+	autopilotSKU("Autopilot NVIDIA A100 80GB Spot Pod Memory Requests", "SpotGPUPodMemoryPrice"),
+	autopilotSKU("Autopilot NVIDIA T4 Spot Pod GPU Requests", "SpotNVIDIAT4PodGPUPrice"),
+	autopilotSKU("Autopilot NVIDIA L4 Spot Pod GPU Requests", "SpotNVIDIAL4PodGPUPrice"),
+	autopilotSKU("Autopilot NVIDIA A100 Spot Pod GPU Requests", "SpotNVIDIAA10040GPodGPUPrice"),
+	autopilotSKU("Autopilot NVIDIA A100 80GB Spot Pod GPU Requests", "SpotNVIDIAA10080GPodGPUPrice"),
+	autopilotSKU("Autopilot GPU Spot Pod Local SSD", "SpotGPUPodLocalSSDPrice"),
+	autopilotSKU("Autopilot PD Balanced Premium", "PerformancePDPricePremium", "SpotPerformancePDPricePremium", "AcceleratorPDPricePremium", "SpotAcceleratorPDPricePremium"),
+	autopilotSKU("Autopilot Performance CPU Premium", "PerformanceCpuPricePremium"),
+	autopilotSKU("Autopilot Performance Memory Premium", "PerformanceMemoryPricePremium"),
+	autopilotSKU("Autopilot Local SSD Premium", "PerformanceLocalSSDPricePremium", "AcceleratorLocalSSDPricePremium"),
+	autopilotSKU("Autopilot Spot PD Balanced Premium", "PerformancePDPricePremium", "SpotPerformancePDPricePremium", "AcceleratorPDPricePremium", "SpotAcceleratorPDPricePremium"),
+	autopilotSKU("Autopilot Performance Spot CPU Premium", "SpotPerformanceCpuPricePremium"),
+	autopilotSKU("Autopilot Performance Spot Memory Premium", "SpotPerformanceMemoryPricePremium"),
+	autopilotSKU("Autopilot Local SSD Spot Premium", "SpotPerformanceLocalSSDPricePremium", "SpotAcceleratorLocalSSDPricePremium"),
+	autopilotSKU("Autopilot Accelerator CPU Premium", "AcceleratorCpuPricePremium"),
+	autopilotSKU("Autopilot Accelerator Memory Premium", "AcceleratorMemoryGPUPricePremium"),
+	autopilotSKU("Autopilot T4 Premium", "AcceleratorT4GPUPricePremium"),
+	autopilotSKU("Autopilot L4 Premium", "AcceleratorL4GPUPricePremium"),
+	autopilotSKU("Autopilot A100 40GB Premium", "AcceleratorA10040GGPUPricePremium"),
+	autopilotSKU("Autopilot A100 80GB Premium", "AcceleratorA10080GGPUPricePremium"),
+	autopilotSKU("Autopilot H100 80GB Premium", "AcceleratorH100GPUPricePremium"),
+	autopilotSKU("Autopilot Accelerator Spot CPU Premium", "SpotAcceleratorCpuPricePremium"),
+	autopilotSKU("Autopilot Accelerator Spot Memory Premium", "SpotAcceleratorMemoryGPUPricePremium"),
+	autopilotSKU("Autopilot T4 Spot Premium", "SpotAcceleratorT4GPUPricePremium"),
+	autopilotSKU("Autopilot L4 Spot Premium", "SpotAcceleratorL4GPUPricePremium"),
+	autopilotSKU("Autopilot A100 40GB Spot Premium", "SpotAcceleratorA10040GGPUPricePremium"),
+	autopilotSKU("Autopilot A100 80GB Spot Premium", "SpotAcceleratorA10080GGPUPricePremium"),
+	autopilotSKU("Autopilot H100 80GB Spot Premium", "SpotAcceleratorH100GPUPricePremium"),
+}
 
+// SKUMap maps price fields of AutopilotPriceList and GCEPriceList to a regular expression matching the
+// description of their SKU. It patches the built-in matching when SKUs are renamed.
+type SKUMap map[string]*regexp.Regexp
+
+// LoadSKUMap reads a SKU map from a JSON file with price field names as keys and regular expressions as values.
+func LoadSKUMap(path string) (SKUMap, error) {
+	contents, err := os.ReadFile(path)
 	if err != nil {
-		err = fmt.Errorf("unable to fetch autopilot cloud billing information: %v", err)
-		return AutopilotPriceList{}, err
+		return nil, fmt.Errorf("error reading sku map: %v", err)
 	}
 
-	return pricing, nil
+	var patterns map[string]string
+	err = json.Unmarshal(contents, &patterns)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing sku map: %v", err)
+	}
+
+	skuMap := make(SKUMap)
+	for field, pattern := range patterns {
+		if !isPriceField(AutopilotPriceList{}, field) && !isPriceField(GCEPriceList{}, field) {
+			return nil, fmt.Errorf("unknown price field %q in sku map", field)
+		}
+
+		skuMap[field], err = regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing sku map pattern for %s: %v", field, err)
+		}
+	}
+
+	return skuMap, nil
+}
+
+// isPriceField returns whether the price list has a float64 field with the name.
+func isPriceField(priceList any, field string) bool {
+	value := reflect.ValueOf(priceList).FieldByName(field)
+	return value.IsValid() && value.Kind() == reflect.Float64
+}
+
+// RateOverrides are negotiated rates replacing the list prices of an AutopilotPriceList once fetched. Discount is
+// a percentage off every price, Fields replace or discount single prices by field name instead.
+type RateOverrides struct {
+	Discount float64                 `json:"discount_percent"`
+	Fields   map[string]RateOverride `json:"fields"`
+}
+
+// RateOverride replaces a price by Price, in USD per vCPU, GiB or GPU and hour, or else discounts it by Discount
+// percent.
+type RateOverride struct {
+	Price    *float64 `json:"price"`
+	Discount *float64 `json:"discount_percent"`
+}
+
+// LoadRateOverrides reads rate overrides from a JSON file like
+// {"discount_percent": 5, "fields": {"CpuPrice": {"discount_percent": 10}, "MemoryPrice": {"price": 0.005}}}.
+func LoadRateOverrides(path string) (*RateOverrides, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading rate overrides: %v", err)
+	}
+
+	var overrides RateOverrides
+	decoder := json.NewDecoder(bytes.NewReader(contents))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&overrides); err != nil {
+		return nil, fmt.Errorf("error parsing rate overrides: %v", err)
+	}
+
+	if overrides.Discount < 0 || overrides.Discount > 100 {
+		return nil, fmt.Errorf("discount_percent of the rate overrides must be between 0 and 100, got %v", overrides.Discount)
+	}
+	for field, override := range overrides.Fields {
+		if !isPriceField(AutopilotPriceList{}, field) {
+			return nil, fmt.Errorf("unknown Autopilot price field %q in rate overrides", field)
+		}
+		if (override.Price == nil) == (override.Discount == nil) {
+			return nil, fmt.Errorf("rate override of %s needs either a price or a discount_percent", field)
+		}
+		if override.Price != nil && *override.Price < 0 {
+			return nil, fmt.Errorf("rate override of %s has a negative price", field)
+		}
+		if override.Discount != nil && (*override.Discount < 0 || *override.Discount > 100) {
+			return nil, fmt.Errorf("discount_percent of %s must be between 0 and 100, got %v", field, *override.Discount)
+		}
+	}
+
+	return &overrides, nil
+}
+
+// Apply replaces the prices of the price list with the negotiated rates, and returns the fields it changed in their
+// declaration order. Prices replaced in USD are converted to the currency of the price list.
+func (overrides *RateOverrides) Apply(pricing *AutopilotPriceList) []string {
+	conversionRate := pricing.ConversionRate
+	// Price lists not fetched from the Cloud Billing API have no rate, they are in USD
+	if conversionRate == 0 {
+		conversionRate = 1
+	}
+
+	var fields []string
+	for _, field := range priceFields(*pricing) {
+		value := reflect.ValueOf(pricing).Elem().FieldByName(field)
+		price := value.Float()
+		override, ok := overrides.Fields[field]
+		switch {
+		case ok && override.Price != nil:
+			price = *override.Price * conversionRate
+		case ok:
+			price *= 1 - *override.Discount/100
+		default:
+			price *= 1 - overrides.Discount/100
+		}
+
+		if price != value.Float() {
+			value.SetFloat(price)
+			fields = append(fields, field)
+		}
+	}
+
+	return fields
+}
+
+// apply sets the price on every field of the price list whose pattern matches the description.
+// Returns the fields set, none when no pattern matched.
+func (skuMap SKUMap) apply(priceList any, description string, price float64) []string {
+	var fields []string
+	for field, pattern := range skuMap {
+		if pattern.MatchString(description) && setPriceField(priceList, field, price) {
+			fields = append(fields, field)
+		}
+	}
+
+	return fields
 }
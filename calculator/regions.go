@@ -0,0 +1,137 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package calculator
+
+import (
+	"time"
+
+	"github.com/GoogleCloudPlatform/autopilot-cost-calculator/cluster"
+	"google.golang.org/api/option"
+)
+
+// RegionPricing holds the Autopilot and GCE price lists of a region.
+type RegionPricing struct {
+	Autopilot AutopilotPriceList `json:"autopilot"`
+	GCE       GCEPriceList       `json:"gce"`
+}
+
+// Explain lists every price field of the Autopilot and then of the GCE price list with the SKU it was set from,
+// to debug the prices of a region.
+func (pricing RegionPricing) Explain() []PriceSource {
+	var sources []PriceSource
+	for _, list := range []struct {
+		name      string
+		priceList any
+		sources   map[string]PriceSource
+	}{
+		{"autopilot", pricing.Autopilot, pricing.Autopilot.Sources},
+		{"gce", pricing.GCE, pricing.GCE.Sources},
+	} {
+		for _, field := range priceFields(list.priceList) {
+			source, ok := list.sources[field]
+			if !ok {
+				source = PriceSource{Field: field}
+			}
+			source.List = list.name
+			sources = append(sources, source)
+		}
+	}
+
+	return sources
+}
+
+// RegionCost is the cost of the workloads priced in a region, cluster fee included.
+type RegionCost struct {
+	Region      string  `json:"region"`
+	HourlyCost  float64 `json:"hourly_cost"`
+	MonthlyCost float64 `json:"monthly_cost"`
+}
+
+// CurrencyCost is the cost of the workloads priced in a currency, cluster fee included.
+type CurrencyCost struct {
+	Currency    string  `json:"currency"`
+	HourlyCost  float64 `json:"hourly_cost"`
+	MonthlyCost float64 `json:"monthly_cost"`
+}
+
+// GetRegionPricing fetches the Autopilot and GCE price lists of a region effective at the pricing date, the zero
+// time for now.
+func GetRegionPricing(sku map[string]string, skuMap SKUMap, region string, pricingDate time.Time, clientOptions ...option.ClientOption) (RegionPricing, error) {
+	return GetCurrencyPricing(sku, skuMap, region, DefaultCurrency, pricingDate, clientOptions...)
+}
+
+// GetCurrencyPricing fetches the Autopilot and GCE price lists of a region in a currency, like EUR, as converted
+// from USD by the Cloud Billing API.
+func GetCurrencyPricing(sku map[string]string, skuMap SKUMap, region string, currency string, pricingDate time.Time, clientOptions ...option.ClientOption) (RegionPricing, error) {
+	apPricing, err := GetAutopilotPricing(sku["autopilot"], region, currency, pricingDate, skuMap, clientOptions...)
+	if err != nil {
+		return RegionPricing{}, err
+	}
+
+	gcePricing, err := GetGCEPricing(sku["gce"], region, currency, pricingDate, skuMap, clientOptions...)
+	if err != nil {
+		return RegionPricing{}, err
+	}
+
+	return RegionPricing{Autopilot: apPricing, GCE: gcePricing}, nil
+}
+
+// CompareRegions reprices the workloads with the price lists of each region. The workloads keep their resources
+// and compute classes, only the prices change, and the cluster fee is the same in every region.
+func (service *PricingService) CompareRegions(workloads []cluster.Workload, nodes map[string]cluster.Node, clusterFee float64, regions []RegionPricing) []RegionCost {
+	costs := make([]RegionCost, 0, len(regions))
+	for _, region := range regions {
+		hourlyCost := clusterFee + service.repricedCost(workloads, nodes, region)
+		costs = append(costs, RegionCost{
+			Region:      region.Autopilot.Region,
+			HourlyCost:  hourlyCost,
+			MonthlyCost: hourlyCost * HOURS_PER_MONTH,
+		})
+	}
+
+	return costs
+}
+
+// CompareCurrencies reprices the workloads with the price lists of the same region in each currency. The cluster
+// fee, in USD, is converted at the rate of the price list.
+func (service *PricingService) CompareCurrencies(workloads []cluster.Workload, nodes map[string]cluster.Node, clusterFee float64, currencies []RegionPricing) []CurrencyCost {
+	costs := make([]CurrencyCost, 0, len(currencies))
+	for _, currency := range currencies {
+		hourlyCost := clusterFee*currency.Autopilot.ConversionRate + service.repricedCost(workloads, nodes, currency)
+		costs = append(costs, CurrencyCost{
+			Currency:    currency.Autopilot.Currency,
+			HourlyCost:  hourlyCost,
+			MonthlyCost: hourlyCost * HOURS_PER_MONTH,
+		})
+	}
+
+	return costs
+}
+
+// repricedCost is the hourly cost of the workloads with the price lists, keeping their resources and compute classes.
+// The workloads of Jobs stay prorated to their runs per month.
+func (service *PricingService) repricedCost(workloads []cluster.Workload, nodes map[string]cluster.Node, pricing RegionPricing) float64 {
+	repriced := *service
+	repriced.AutopilotPricing = pricing.Autopilot
+	repriced.GCEPricing = pricing.GCE
+
+	cost := 0.0
+	for _, workload := range workloads {
+		node := nodes[workload.Node_name]
+		cost += repriced.CalculatePricing(workload.Cpu, workload.Memory, workload.Storage, workload.AcceleratorAmount, workload.AcceleratorType, workload.ComputeClass, node.InstanceType, node.Spot).Total * jobShare(workload)
+	}
+
+	return cost
+}
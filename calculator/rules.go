@@ -0,0 +1,111 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package calculator
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gopkg.in/ini.v1"
+)
+
+// ruleSections are the config sections holding the Autopilot rules. A section named after one of them followed
+// by a GKE version, like [ratios.1.23], holds the rules of that version that differ from the current ones.
+var ruleSections = []string{"limits", "ratios", "increments"}
+
+// gkeMinorVersion is a GKE version reduced to its major and minor parts, the granularity of the rule sets.
+type gkeMinorVersion struct {
+	major int
+	minor int
+}
+
+func (version gkeMinorVersion) String() string {
+	return fmt.Sprintf("%d.%d", version.major, version.minor)
+}
+
+func (version gkeMinorVersion) before(other gkeMinorVersion) bool {
+	return version.major < other.major || (version.major == other.major && version.minor < other.minor)
+}
+
+// parseGKEVersion parses the major and minor parts of a GKE version like 1.27.3-gke.100 or v1.27.
+func parseGKEVersion(version string) (gkeMinorVersion, error) {
+	parts := strings.SplitN(strings.TrimPrefix(strings.TrimSpace(version), "v"), ".", 3)
+	if len(parts) < 2 {
+		return gkeMinorVersion{}, fmt.Errorf("invalid GKE version %q, expected major.minor like 1.27", version)
+	}
+
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return gkeMinorVersion{}, fmt.Errorf("invalid GKE version %q, expected major.minor like 1.27", version)
+	}
+
+	minor, err := strconv.Atoi(strings.SplitN(parts[1], "-", 2)[0])
+	if err != nil {
+		return gkeMinorVersion{}, fmt.Errorf("invalid GKE version %q, expected major.minor like 1.27", version)
+	}
+
+	return gkeMinorVersion{major: major, minor: minor}, nil
+}
+
+// RulesVersionFor returns the rule set of the config that applies to the GKE version: the oldest versioned rule
+// set that isn't older than the cluster. An empty version, or one newer than all the rule sets, gets the current
+// rules, returned as "".
+func RulesVersionFor(config *ini.File, gkeVersion string) (string, error) {
+	if gkeVersion == "" {
+		return "", nil
+	}
+
+	version, err := parseGKEVersion(gkeVersion)
+	if err != nil {
+		return "", err
+	}
+
+	var selected *gkeMinorVersion
+	for _, section := range config.Sections() {
+		for _, name := range ruleSections {
+			if !strings.HasPrefix(section.Name(), name+".") {
+				continue
+			}
+
+			rulesVersion, err := parseGKEVersion(strings.TrimPrefix(section.Name(), name+"."))
+			if err != nil {
+				return "", fmt.Errorf("invalid rule set section [%s]: %v", section.Name(), err)
+			}
+
+			if !rulesVersion.before(version) && (selected == nil || rulesVersion.before(*selected)) {
+				selected = &rulesVersion
+			}
+		}
+	}
+
+	if selected == nil {
+		return "", nil
+	}
+
+	return selected.String(), nil
+}
+
+// rules returns the config section of the Autopilot rules for the rules version of the service. Keys missing
+// from a versioned section are looked up in the current section by ini.
+func (service *PricingService) rules(name string) *ini.Section {
+	if service.RulesVersion != "" {
+		if section, err := service.Config.GetSection(name + "." + service.RulesVersion); err == nil {
+			return section
+		}
+	}
+
+	return service.Config.Section(name)
+}
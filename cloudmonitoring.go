@@ -0,0 +1,89 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/GoogleCloudPlatform/autopilot-cost-calculator/cluster"
+	"google.golang.org/api/monitoring/v3"
+)
+
+const (
+	// monitoringCostMetric is the custom metric of the estimated hourly costs, of the workloads on the k8s_pod
+	// resource and of the cluster, fee included, on the k8s_cluster resource
+	monitoringCostMetric = "custom.googleapis.com/autopilot/estimated_cost"
+	// monitoringBatchSize is the most time series Cloud Monitoring accepts per request
+	monitoringBatchSize = 200
+)
+
+// newMonitoringTimeSeries builds a point of the cost metric per workload, labeled with its namespace and name, and
+// one for the cluster total. The location is the one of the cluster, a region or a zone.
+func newMonitoringTimeSeries(project string, location string, clusterName string, workloads []cluster.Workload, clusterFee float64, now time.Time) []*monitoring.TimeSeries {
+	interval := &monitoring.TimeInterval{EndTime: now.UTC().Format(time.RFC3339Nano)}
+	point := func(cost float64) []*monitoring.Point {
+		return []*monitoring.Point{{Interval: interval, Value: &monitoring.TypedValue{DoubleValue: &cost}}}
+	}
+	series := func(resourceType string, labels map[string]string, metricLabels map[string]string, cost float64) *monitoring.TimeSeries {
+		resourceLabels := map[string]string{"project_id": project, "location": location, "cluster_name": clusterName}
+		for key, value := range labels {
+			resourceLabels[key] = value
+		}
+		return &monitoring.TimeSeries{
+			Metric:     &monitoring.Metric{Type: monitoringCostMetric, Labels: metricLabels},
+			Resource:   &monitoring.MonitoredResource{Type: resourceType, Labels: resourceLabels},
+			MetricKind: "GAUGE",
+			ValueType:  "DOUBLE",
+			Unit:       "USD/h",
+			Points:     point(cost),
+		}
+	}
+
+	totalHourly := clusterFee
+	var timeSeries []*monitoring.TimeSeries
+	for _, workload := range workloads {
+		// System workloads are normally managed, so they are left out of the total like in the report
+		if !workload.System {
+			totalHourly += workload.Cost
+		}
+		timeSeries = append(timeSeries, series("k8s_pod",
+			map[string]string{"namespace_name": workload.Namespace, "pod_name": workload.Name},
+			map[string]string{"compute_class": cluster.ComputeClasses[workload.ComputeClass]},
+			workload.Cost))
+	}
+
+	return append(timeSeries, series("k8s_cluster", nil, nil, totalHourly))
+}
+
+// exportMonitoringMetrics writes the cost metric of the workloads and of the cluster to Cloud Monitoring, in
+// batches of monitoringBatchSize time series.
+func exportMonitoringMetrics(ctx context.Context, monitoringService *monitoring.Service, project string, location string, clusterName string, workloads []cluster.Workload, clusterFee float64) error {
+	timeSeries := newMonitoringTimeSeries(project, location, clusterName, workloads, clusterFee, time.Now())
+	for start := 0; start < len(timeSeries); start += monitoringBatchSize {
+		end := start + monitoringBatchSize
+		if end > len(timeSeries) {
+			end = len(timeSeries)
+		}
+
+		request := &monitoring.CreateTimeSeriesRequest{TimeSeries: timeSeries[start:end]}
+		if _, err := monitoringService.Projects.TimeSeries.Create("projects/"+project, request).Context(ctx).Do(); err != nil {
+			return fmt.Errorf("error writing the cost metrics to cloud monitoring: %v", err)
+		}
+	}
+
+	return nil
+}
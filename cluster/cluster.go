@@ -22,6 +22,8 @@ import (
 	"strings"
 
 	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
@@ -38,21 +40,79 @@ const (
 	ComputeClassPerformance    ComputeClass = 4
 	ComputeClassAccelerator    ComputeClass = 5
 	ComputeClassGPUPod         ComputeClass = 6
+	// ComputeClassMixed marks the rows summing workloads of several compute classes, it isn't in ComputeClasses
+	ComputeClassMixed ComputeClass = -1
 )
 
+// UnscheduledNodeName groups the workloads of pods that aren't scheduled on a node
+const UnscheduledNodeName = "(unscheduled)"
+
 var ComputeClasses [7]string = [7]string{"General-purpose", "Balanced", "Scale-out", "Scale-out arm64", "Performance", "Accelerator", "GPU Pod"}
 
+// ParseComputeClass returns the compute class by its name from ComputeClasses, ignoring case.
+func ParseComputeClass(name string) (ComputeClass, error) {
+	for class, className := range ComputeClasses {
+		if strings.EqualFold(strings.TrimSpace(name), className) {
+			return ComputeClass(class), nil
+		}
+	}
+
+	return 0, fmt.Errorf("unknown compute class %q, expected one of: %s", name, strings.Join(ComputeClasses[:], ", "))
+}
+
+// CostBreakdown is the hourly cost of a workload per resource. The machine price of the Performance and
+// Accelerator compute classes is split into CPU and Memory.
+type CostBreakdown struct {
+	CPU     float64 `json:"cpu"`
+	Memory  float64 `json:"memory"`
+	Storage float64 `json:"storage"`
+	GPU     float64 `json:"gpu"`
+	Total   float64 `json:"total"`
+}
+
 type Workload struct {
-	Name              string
-	Node_name         string
-	Containers        int
-	Cpu               int64
-	Memory            int64
+	Name      string
+	Namespace string `json:"namespace"`
+	// Owner is the controller of the pod as Kind/Name, like Deployment/web, empty for pods without one
+	Owner      string `json:"owner,omitempty"`
+	Node_name  string
+	Containers int
+	Cpu        int64
+	Memory     int64
+	// RawCpu and RawMemory are the summed requests and usage, before Autopilot's minimums and rounding
+	RawCpu    int64 `json:"raw_cpu"`
+	RawMemory int64 `json:"raw_memory"`
+	// RequestedCpu and UsedCpu are the summed requests and usage of the containers in mCPU, RequestedMemory and
+	// UsedMemory in MB
+	RequestedCpu      int64 `json:"requested_cpu"`
+	UsedCpu           int64 `json:"used_cpu"`
+	RequestedMemory   int64 `json:"requested_memory"`
+	UsedMemory        int64 `json:"used_memory"`
 	Storage           int64
 	AcceleratorType   string
 	AcceleratorAmount int64
 	Cost              float64
-	ComputeClass      ComputeClass
+	Breakdown         CostBreakdown `json:"breakdown"`
+	SpotCost          float64       `json:"spot_cost"`
+	// RequestCost and UsageCost price the workload on its requests alone and on its usage alone, the difference
+	// is what right-sizing the requests to match the usage would save
+	RequestCost float64 `json:"request_cost"`
+	UsageCost   float64 `json:"usage_cost"`
+	// LimitCost prices the workload on the highest of its limits and requests, what it would cost with its
+	// requests raised to the limits it can burst to. Burstable workloads have limits above their requests.
+	LimitCost      float64 `json:"limit_cost"`
+	Burstable      bool    `json:"burstable,omitempty"`
+	ComputeClass   ComputeClass
+	ClassReason    string  `json:"class_reason,omitempty"`
+	PercentOfTotal float64 `json:"percent_of_total"`
+	// System workloads run in the GKE system namespaces, they are normally managed and left out of the totals
+	System bool `json:"system,omitempty"`
+	// JobRuntime is the billed seconds of a run of a Job workload whose costs are prorated to its JobRuns per month,
+	// one or the runs of the schedule of its CronJob
+	JobRuntime float64 `json:"job_runtime_seconds,omitempty"`
+	JobRuns    float64 `json:"job_runs_per_month,omitempty"`
+	// Incompatibilities are the settings of the pod Autopilot would reject at admission, only checked on request
+	Incompatibilities []string `json:"incompatibilities,omitempty"`
 }
 
 type Node struct {
@@ -61,8 +121,24 @@ type Node struct {
 	InstanceType string
 	Region       string
 	Spot         bool
+	// Cost is the Autopilot cost of the workloads of the node and StandardCost the GCE price of the node itself
 	Cost         float64
-	Accelerator  string
+	StandardCost float64 `json:"standard_cost"`
+	// Efficiency is the Cost over the StandardCost, below 1 the workloads are cheaper on Autopilot
+	Efficiency  float64 `json:"efficiency"`
+	Accelerator string
+	OS          string
+	NodePool    string `json:"node_pool"`
+	// Zone, KubeletVersion and InternalIPs are listed in the wide node table, InternalIPs has both families
+	// on dual-stack nodes
+	Zone           string   `json:"zone"`
+	KubeletVersion string   `json:"kubelet_version"`
+	InternalIPs    []string `json:"internal_ips,omitempty"`
+	// Arch is the CPU architecture of the node from its kubernetes.io/arch label, empty without the label
+	Arch string `json:"arch,omitempty"`
+	// Cpu and Memory are the allocatable mCPU and MB of the node
+	Cpu    int64
+	Memory int64
 }
 
 func GetKubeConfig() (*rest.Config, string, error) {
@@ -99,27 +175,92 @@ func GetCurrentContext(kubeConfigPath string) ([]string, error) {
 	return strings.Split(config.CurrentContext, "_"), nil
 }
 
-func GetClusterNodes(clientset *kubernetes.Clientset) (map[string]Node, error) {
+// NodePoolLabel is the label of GKE nodes naming their node pool
+const NodePoolLabel = "cloud.google.com/gke-nodepool"
+
+// FilterNodePool returns the nodes of the node pool.
+func FilterNodePool(nodes map[string]Node, nodePool string) map[string]Node {
+	filtered := make(map[string]Node)
+	for name, node := range nodes {
+		if node.NodePool == nodePool {
+			filtered[name] = node
+		}
+	}
+
+	return filtered
+}
+
+// GetClusterNodes lists the nodes of the cluster, in pages of pageSize nodes, see ListNodes.
+func GetClusterNodes(clientset kubernetes.Interface, pageSize int64) (map[string]Node, error) {
 	nodes := make(map[string]Node)
 
-	clusterNodes, err := ListNodes(clientset)
+	clusterNodes, err := ListNodes(clientset, pageSize)
 	if err != nil {
-		err = fmt.Errorf("error getting nodes: %v", err)
 		return nil, err
 	}
 
 	for _, clusterNode := range clusterNodes.Items {
 		nodes[clusterNode.Name] = Node{
-			Name:         clusterNode.Name,
-			Region:       clusterNode.Labels["topology.kubernetes.io/region"],
-			Spot:         clusterNode.Labels["cloud.google.com/gke-spot"] == "true",
-			Accelerator:  clusterNode.Labels["cloud.google.com/gke-accelerator"],
-			InstanceType: clusterNode.Labels["beta.kubernetes.io/instance-type"]}
+			Name:           clusterNode.Name,
+			Region:         clusterNode.Labels["topology.kubernetes.io/region"],
+			Spot:           clusterNode.Labels["cloud.google.com/gke-spot"] == "true",
+			Accelerator:    clusterNode.Labels["cloud.google.com/gke-accelerator"],
+			OS:             clusterNode.Labels[v1.LabelOSStable],
+			NodePool:       clusterNode.Labels[NodePoolLabel],
+			InstanceType:   clusterNode.Labels["beta.kubernetes.io/instance-type"],
+			Cpu:            clusterNode.Status.Allocatable.Cpu().MilliValue(),
+			Memory:         MB(clusterNode.Status.Allocatable.Memory()),
+			Zone:           clusterNode.Labels[v1.LabelTopologyZone],
+			KubeletVersion: clusterNode.Status.NodeInfo.KubeletVersion,
+			InternalIPs:    nodeInternalIPs(clusterNode),
+			Arch:           clusterNode.Labels[v1.LabelArchStable],
+		}
 	}
 
 	return nodes, nil
 }
 
+// arm64MachineFamilies are the GCE machine families with Arm CPUs
+var arm64MachineFamilies = map[string]bool{
+	"t2a": true,
+	"c4a": true,
+}
+
+// MachineTypeArch returns the CPU architecture of a GCE machine type from its machine family, arm64 or amd64.
+func MachineTypeArch(machineType string) string {
+	family, _, _ := strings.Cut(strings.ToLower(machineType), "-")
+	if arm64MachineFamilies[family] {
+		return "arm64"
+	}
+	return "amd64"
+}
+
+// Arm64 reports whether the node has Arm CPUs. The kubernetes.io/arch label is authoritative, nodes without it
+// are looked up by their machine type.
+func (node Node) Arm64() bool {
+	if node.Arch != "" {
+		return node.Arch == "arm64"
+	}
+	return MachineTypeArch(node.InstanceType) == "arm64"
+}
+
+// MB converts a memory or storage quantity to MB, millions of bytes, the unit of the memory and storage of the
+// workloads and of the rules. It scales the value in bytes, as the milli-units of MilliValue overflow an int64 past about 9 PB.
+func MB(quantity *resource.Quantity) int64 {
+	return quantity.Value() / 1000000
+}
+
+// nodeInternalIPs returns the internal addresses of the node, an IPv4 and an IPv6 one on dual-stack nodes.
+func nodeInternalIPs(node v1.Node) []string {
+	var ips []string
+	for _, address := range node.Status.Addresses {
+		if address.Type == v1.NodeInternalIP {
+			ips = append(ips, address.Address)
+		}
+	}
+	return ips
+}
+
 func ListPods(client kubernetes.Interface) (*v1.PodList, error) {
 	pods, err := client.CoreV1().Pods("").List(
 		context.Background(),
@@ -141,19 +282,46 @@ func ListNamespaces(client kubernetes.Interface) (*v1.NamespaceList, error) {
 	return namespaces, nil
 }
 
-func ListNodes(client kubernetes.Interface) (*v1.NodeList, error) {
-	nodes, err := client.CoreV1().Nodes().List(context.Background(), metav1.ListOptions{})
-	if err != nil {
-		err = fmt.Errorf("error getting namespaces: %v", err)
-		return nil, err
+// maxNodeListRestarts bounds how many times ListNodes starts over when its continue token expires
+const maxNodeListRestarts = 3
+
+// ListNodes lists the nodes in pages of pageSize, 0 lists them at once. The pages are a consistent snapshot, but
+// when the nodes change so much during the listing that the continue token expires, the listing starts over.
+func ListNodes(client kubernetes.Interface, pageSize int64) (*v1.NodeList, error) {
+	for restarts := 0; ; restarts++ {
+		nodes, err := listNodePages(client, pageSize)
+		if apierrors.IsResourceExpired(err) && restarts < maxNodeListRestarts {
+			continue
+		}
+		if err != nil {
+			err = fmt.Errorf("error getting nodes: %w", err)
+			return nil, err
+		}
+		return nodes, nil
+	}
+}
+
+func listNodePages(client kubernetes.Interface, pageSize int64) (*v1.NodeList, error) {
+	nodes := &v1.NodeList{}
+	listOptions := metav1.ListOptions{Limit: pageSize}
+	for {
+		page, err := client.CoreV1().Nodes().List(context.Background(), listOptions)
+		if err != nil {
+			return nil, err
+		}
+
+		nodes.Items = append(nodes.Items, page.Items...)
+		if page.Continue == "" {
+			return nodes, nil
+		}
+		listOptions.Continue = page.Continue
 	}
-	return nodes, nil
 }
 
 func DescribePod(client kubernetes.Interface, podName string, namespace string) (*v1.Pod, error) {
 	pod, err := client.CoreV1().Pods(namespace).Get(context.Background(), podName, metav1.GetOptions{})
 	if err != nil {
-		err = fmt.Errorf("error getting pods: %v", err)
+		err = fmt.Errorf("error getting pods: %w", err)
 		return nil, err
 	}
 	return pod, nil
@@ -0,0 +1,125 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// configFlagName is the flag pointing to the YAML file with the flag defaults. It can't be set from the file.
+	configFlagName = "config"
+	// envFlagPrefix prefixes the environment variables setting flags, eg. AUTOPILOT_CALC_MIN_COST for -min-cost
+	envFlagPrefix = "AUTOPILOT_CALC_"
+)
+
+// flagValues holds the values of flags by flag name. Flags that can be repeated may have several values.
+type flagValues map[string][]string
+
+// loadConfigFile reads a YAML file mapping flag names to their values, eg. `top: 20` or
+// `namespace: [default, payments]` for flags that can be repeated.
+func loadConfigFile(path string) (flagValues, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading config file: %v", err)
+	}
+
+	var settings map[string]interface{}
+	if err := yaml.Unmarshal(content, &settings); err != nil {
+		return nil, fmt.Errorf("error parsing config file %s: %v", path, err)
+	}
+
+	values := flagValues{}
+	for name, setting := range settings {
+		switch setting := setting.(type) {
+		case []interface{}:
+			for _, item := range setting {
+				values[name] = append(values[name], fmt.Sprint(item))
+			}
+		case map[string]interface{}:
+			return nil, fmt.Errorf("error parsing config file %s: %s must be a value or a list", path, name)
+		case nil:
+			values[name] = []string{""}
+		default:
+			values[name] = []string{fmt.Sprint(setting)}
+		}
+	}
+
+	return values, nil
+}
+
+// applyFlagLayers sets the flags that weren't passed on the command line from the layers, where a later layer
+// takes precedence over an earlier one. Flags keep their defaults when no layer sets them, so the precedence is
+// defaults < layers < command line.
+func applyFlagLayers(flags *flag.FlagSet, layers ...flagValues) error {
+	explicit := map[string]bool{}
+	flags.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	resolved := flagValues{}
+	for _, layer := range layers {
+		for name, values := range layer {
+			if flags.Lookup(name) == nil || name == configFlagName {
+				return fmt.Errorf("unknown flag %q", name)
+			}
+			resolved[name] = values
+		}
+	}
+
+	for name, values := range resolved {
+		if explicit[name] {
+			continue
+		}
+
+		for _, value := range values {
+			if err := flags.Set(name, value); err != nil {
+				return fmt.Errorf("invalid value %q for flag %s: %v", value, name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// envFlagName returns the environment variable setting a flag, eg. AUTOPILOT_CALC_MIN_COST for min-cost.
+func envFlagName(name string) string {
+	return envFlagPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// envFlagValues returns the values of the flags set by environment variables. Flags that can be repeated take
+// comma separated values.
+func envFlagValues(flags *flag.FlagSet, lookupEnv func(string) (string, bool)) flagValues {
+	values := flagValues{}
+	flags.VisitAll(func(f *flag.Flag) {
+		value, ok := lookupEnv(envFlagName(f.Name))
+		if !ok {
+			return
+		}
+
+		if _, repeated := f.Value.(*stringSliceFlag); repeated {
+			values[f.Name] = strings.Split(value, ",")
+			return
+		}
+		values[f.Name] = []string{value}
+	})
+
+	return values
+}
@@ -0,0 +1,193 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/autopilot-cost-calculator/calculator"
+	"github.com/GoogleCloudPlatform/autopilot-cost-calculator/cluster"
+)
+
+// Changes of a workload between two reports
+const (
+	workloadAdded   = "added"
+	workloadRemoved = "removed"
+	workloadChanged = "changed"
+)
+
+// workloadDelta is the monthly cost of a workload in two reports, zero in the one it's missing from.
+type workloadDelta struct {
+	Namespace string
+	Name      string
+	Change    string
+	Before    float64
+	After     float64
+}
+
+// reportDiff compares the monthly costs of two reports, the totals include the cluster fee.
+type reportDiff struct {
+	Workloads []workloadDelta
+	Before    float64
+	After     float64
+	Added     int
+	Removed   int
+	Changed   int
+}
+
+// readReportFile reads a report written with -json, its workloads are the ones listed on its nodes.
+func readReportFile(path string) (Report, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return Report{}, fmt.Errorf("error reading %s: %v", path, err)
+	}
+
+	var report Report
+	if err := json.Unmarshal(contents, &report); err != nil {
+		return Report{}, fmt.Errorf("error parsing %s: %v", path, err)
+	}
+	for _, node := range report.Nodes {
+		report.Workloads = append(report.Workloads, billableWorkloads(node.Workloads)...)
+	}
+
+	return report, nil
+}
+
+// workloadKey identifies a workload across reports by namespace and owner, as the names of the pods of a
+// Deployment change on every rollout. Workloads without an owner are identified by their name, and containers of
+// the per-container mode, named pod/container, by their owner and container name.
+func workloadKey(workload cluster.Workload) [2]string {
+	if workload.Owner == "" {
+		return [2]string{workload.Namespace, workload.Name}
+	}
+	if _, container, ok := strings.Cut(workload.Name, "/"); ok {
+		return [2]string{workload.Namespace, workload.Owner + "/" + container}
+	}
+
+	return [2]string{workload.Namespace, workload.Owner}
+}
+
+// monthlyWorkloadCosts sums the monthly cost of the workloads of the report by their workloadKey, so the replicas
+// of an owner add up.
+func monthlyWorkloadCosts(report Report) map[[2]string]float64 {
+	costs := make(map[[2]string]float64)
+	for _, workload := range report.Workloads {
+		costs[workloadKey(workload)] += workload.Cost * calculator.HOURS_PER_MONTH
+	}
+
+	return costs
+}
+
+// diffReports lists the workloads added, removed or whose cost changed from the before to the after report,
+// matched by namespace and owner, or name without an owner, largest change first.
+func diffReports(before Report, after Report) reportDiff {
+	diff := reportDiff{Before: before.MonthlyCost, After: after.MonthlyCost}
+	beforeCosts, afterCosts := monthlyWorkloadCosts(before), monthlyWorkloadCosts(after)
+
+	for key, beforeCost := range beforeCosts {
+		afterCost, ok := afterCosts[key]
+		switch {
+		case !ok:
+			diff.Workloads = append(diff.Workloads, workloadDelta{Namespace: key[0], Name: key[1], Change: workloadRemoved, Before: beforeCost})
+			diff.Removed++
+		case !almostEqualCost(beforeCost, afterCost):
+			diff.Workloads = append(diff.Workloads, workloadDelta{Namespace: key[0], Name: key[1], Change: workloadChanged, Before: beforeCost, After: afterCost})
+			diff.Changed++
+		}
+	}
+	for key, afterCost := range afterCosts {
+		if _, ok := beforeCosts[key]; !ok {
+			diff.Workloads = append(diff.Workloads, workloadDelta{Namespace: key[0], Name: key[1], Change: workloadAdded, After: afterCost})
+			diff.Added++
+		}
+	}
+
+	sort.Slice(diff.Workloads, func(i, j int) bool {
+		deltaI, deltaJ := math.Abs(diff.Workloads[i].After-diff.Workloads[i].Before), math.Abs(diff.Workloads[j].After-diff.Workloads[j].Before)
+		if deltaI != deltaJ {
+			return deltaI > deltaJ
+		}
+		if diff.Workloads[i].Namespace != diff.Workloads[j].Namespace {
+			return diff.Workloads[i].Namespace < diff.Workloads[j].Namespace
+		}
+		return diff.Workloads[i].Name < diff.Workloads[j].Name
+	})
+
+	return diff
+}
+
+// almostEqualCost tells apart monthly costs that differ by more than the rounding of the json output.
+func almostEqualCost(a float64, b float64) bool {
+	return math.Abs(a-b) < 1e-6
+}
+
+// displayReportDiff writes a line per added (+), removed (-) and changed (~) workload with its monthly cost
+// delta, then the totals and the net change.
+func displayReportDiff(w io.Writer, diff reportDiff) {
+	marks := map[string]string{workloadAdded: "+", workloadRemoved: "-", workloadChanged: "~"}
+	for _, workload := range diff.Workloads {
+		fmt.Fprintf(w, "%s %s/%s: $%.2f -> $%.2f per month (%+.2f)\n", marks[workload.Change], workload.Namespace, workload.Name,
+			workload.Before, workload.After, workload.After-workload.Before)
+	}
+	if len(diff.Workloads) > 0 {
+		fmt.Fprintln(w)
+	}
+
+	fmt.Fprintf(w, "%d added, %d removed, %d changed workloads\n", diff.Added, diff.Removed, diff.Changed)
+	fmt.Fprintf(w, "Total: $%.2f -> $%.2f per month, net change %+.2f (%+.1f%%)\n", diff.Before, diff.After, diff.After-diff.Before, costPercent(diff.After-diff.Before, diff.Before))
+}
+
+// runDiff compares two json reports, the one before and the one after a change, workload by workload. With
+// -max-increase it exits with code 2 when the monthly total grew by more, for regression tests.
+func runDiff(args []string) {
+	flags := flag.NewFlagSet("diff", flag.ExitOnError)
+	maxIncreaseFlag := flags.Float64("max-increase", -1, "Exit with code 2 when the monthly total of the after report exceeds the before one by more than this, eg. 0 to fail on any increase")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s diff [flags] BEFORE.json AFTER.json\n\nCompares two reports written with -json. Flags:\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	logs := addLogFlags(flags, "Only write the comparison, without logs below errors")
+	flags.Parse(args)
+	logs.setup()
+
+	if flags.NArg() != 2 {
+		flags.Usage()
+		os.Exit(1)
+	}
+
+	before, err := readReportFile(flags.Arg(0))
+	if err != nil {
+		fatal("Error loading the before report", "error", err)
+	}
+	after, err := readReportFile(flags.Arg(1))
+	if err != nil {
+		fatal("Error loading the after report", "error", err)
+	}
+
+	diff := diffReports(before, after)
+	displayReportDiff(os.Stdout, diff)
+
+	if *maxIncreaseFlag >= 0 && diff.After-diff.Before > *maxIncreaseFlag {
+		fmt.Fprintf(os.Stderr, "Monthly cost increased by $%.2f, more than the maximum of $%.2f\n", diff.After-diff.Before, *maxIncreaseFlag)
+		os.Exit(2)
+	}
+}
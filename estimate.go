@@ -0,0 +1,755 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/GoogleCloudPlatform/autopilot-cost-calculator/calculator"
+	"github.com/GoogleCloudPlatform/autopilot-cost-calculator/cluster"
+	"golang.org/x/exp/slog"
+	"golang.org/x/term"
+	"google.golang.org/api/bigquery/v2"
+	container "google.golang.org/api/container/v1"
+	"google.golang.org/api/monitoring/v3"
+	"google.golang.org/api/option"
+	"gopkg.in/ini.v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	metricsv "k8s.io/metrics/pkg/client/clientset/versioned"
+)
+
+// estimateOptions are the flags of the estimate and compare commands, and the settings parse reads from them.
+type estimateOptions struct {
+	flags *flag.FlagSet
+	// compareOnly limits the text output to the Standard nodes compared with the Autopilot cost of their workloads
+	compareOnly bool
+
+	config               string
+	json                 bool
+	printSchema          bool
+	jsonFile             string
+	namespaces           stringSliceFlag
+	excludeWorkloads     stringSliceFlag
+	nodePool             string
+	selector             string
+	includeSystemCost    bool
+	includePending       bool
+	basis                string
+	usageSource          string
+	since                time.Duration
+	prorateJobs          bool
+	jobRuntime           time.Duration
+	perContainer         bool
+	explainPricing       bool
+	explain              bool
+	checkCompatibility   bool
+	minCost              float64
+	showAdjustments      bool
+	includeHPA           bool
+	currency             string
+	compareRegions       string
+	pdbAware             bool
+	histogram            bool
+	breakdown            bool
+	wide                 bool
+	gkeVersion           string
+	customComputeClasses bool
+	allowedClasses       string
+	percentIncludesFee   bool
+	skuMapFile           string
+	rateOverridesFile    string
+	noColor              bool
+	precision            int
+	rawUnits             bool
+	freeTierCluster      string
+	nodePageSize         int64
+	fleet                string
+	project              string
+	billingProject       string
+	pricingDate          string
+	billingExport        string
+	billingDays          int
+	commitment           string
+	html                 bool
+	htmlFile             string
+	outputDir            string
+	formats              string
+	watch                bool
+	interval             time.Duration
+	summaryOnly          bool
+	slackWebhook         string
+	slackRequired        bool
+	exportMonitoring     bool
+	top                  int
+	budget               float64
+	namespaceBudgets     namespaceBudgetFlag
+	allowAutopilot       bool
+	logs                 logFlags
+	// pods are the names of the pods to estimate, the arguments left after the flags
+	pods []string
+
+	// Set by parse
+	cfg                *ini.File
+	pricingTime        time.Time
+	currencies         []string
+	rateOverrides      *calculator.RateOverrides
+	outputFormats      []string
+	tablesOnStdout     bool
+	billingBasis       calculator.Basis
+	labelSelector      labels.Selector
+	commitmentDiscount float64
+	allowedClassSet    map[cluster.ComputeClass]bool
+	rulesVersion       string
+	pricingSKUs        map[string]string
+	skuMap             calculator.SKUMap
+	billingOptions     []option.ClientOption
+	// colors is set by runEstimate, once the output is configured
+	colors bool
+}
+
+// newEstimateOptions defines the flags of the estimate and compare commands on flags, the options get their values
+// once the flags are parsed.
+func newEstimateOptions(flags *flag.FlagSet, compareOnly bool) *estimateOptions {
+	options := &estimateOptions{flags: flags, compareOnly: compareOnly, namespaceBudgets: namespaceBudgetFlag{}}
+	flags.StringVar(&options.config, configFlagName, "", "YAML file with defaults for the flags, by flag name, flags passed on the command line take precedence")
+	flags.BoolVar(&options.json, "json", false, "Generate json file with the results")
+	flags.BoolVar(&options.printSchema, "print-schema", false, "Print the JSON Schema of the json output and exit")
+	flags.StringVar(&options.jsonFile, "json-file", "", "json file location, - for stdout")
+	flags.Var(&options.namespaces, "namespace", "Only cost workloads in this namespace (can be repeated)")
+	flags.Var(&options.excludeWorkloads, "exclude-workloads", "Leave out the workloads whose namespace/name matches this glob pattern, eg. monitoring/* (can be repeated)")
+	flags.StringVar(&options.nodePool, "node-pool", "", "Only cost the nodes of this node pool and the workloads running on them")
+	flags.StringVar(&options.selector, "selector", "", "Only cost workloads matching this label selector (eg. team=payments)")
+	flags.BoolVar(&options.includeSystemCost, "include-system-cost", false, "Also list the workloads of the GKE system namespaces, like kube-system, marked as normally managed and left out of the totals")
+	flags.BoolVar(&options.includePending, "include-pending", false, "Also cost pending pods from their requests")
+	flags.StringVar(&options.basis, "basis", string(calculator.BasisMax), "Resources to bill: max (highest of requests and usage), requests, or limits (highest of limits and requests, the potential cost of burstable pods)")
+	flags.StringVar(&options.usageSource, "usage-source", usageSourceMetricsServer, "Source of the usage of the workloads: metrics-server (current snapshot) or monitoring (p95 over -since from Cloud Monitoring)")
+	flags.DurationVar(&options.since, "since", 24*time.Hour, "Window the p95 usage is read over with -usage-source=monitoring")
+	flags.BoolVar(&options.prorateJobs, "prorate-jobs", false, "Bill the pods of Jobs for their runs per month, one or the schedule of their CronJob, of -job-runtime or their activeDeadlineSeconds each, instead of running all month")
+	flags.DurationVar(&options.jobRuntime, "job-runtime", 0, "Expected runtime of a run of a Job (eg. 15m), implies -prorate-jobs, defaults to the activeDeadlineSeconds of the Job")
+	flags.BoolVar(&options.perContainer, "per-container", false, "Cost each container separately instead of each pod")
+	flags.BoolVar(&options.explainPricing, "explain-pricing", false, "Print the SKU each price field of the region was set from, with its price, and exit")
+	flags.BoolVar(&options.explain, "explain", false, "Show why each workload got its compute class")
+	flags.BoolVar(&options.checkCompatibility, "check-compatibility", false, "List the workloads Autopilot would reject at admission, like privileged pods or pods with hostPath volumes")
+	flags.Float64Var(&options.minCost, "min-cost", 0, "Aggregate the workloads costing less than this per hour in an others line, totals still include them")
+	flags.BoolVar(&options.showAdjustments, "show-adjustments", false, "Show the raw mCPU and memory of each workload before Autopilot's minimums and rounding")
+	flags.BoolVar(&options.includeHPA, "include-hpa", false, "Project the cost of the workloads scaled by an HPA at their min and max replicas")
+	flags.StringVar(&options.currency, "currency", "", "Comma separated currencies to also show the totals in, eg. USD,EUR, priced with the SKUs of each currency")
+	flags.StringVar(&options.compareRegions, "compare-regions", "", "Comma separated regions to reprice the workloads in, eg. us-central1,europe-west1, to compare the cost per region")
+	flags.BoolVar(&options.pdbAware, "pdb-aware", false, "Project the cost of the workloads covered by a PodDisruptionBudget at the minimum replicas it keeps available")
+	flags.BoolVar(&options.histogram, "histogram", false, "Show the number of workloads per hourly cost bucket, on a log scale")
+	flags.BoolVar(&options.breakdown, "breakdown", false, "Show the CPU, memory and storage cost of each workload")
+	flags.BoolVar(&options.wide, "wide", false, "Also show the zone, node pool, kubelet version and internal IPs of each node")
+	flags.StringVar(&options.gkeVersion, "gke-version", "", "Apply the Autopilot rules of a GKE version (eg. 1.23), defaults to the current rules")
+	flags.BoolVar(&options.customComputeClasses, "custom-compute-classes", false, "Read the custom ComputeClass objects of the cluster and price the pods selecting one as the nearest Autopilot compute class")
+	flags.StringVar(&options.allowedClasses, "allowed-classes", "", "Comma separated compute classes workloads can be placed on (eg. General-purpose,Balanced), defaults to the ones of the node pools of the cluster available in the region")
+	flags.BoolVar(&options.percentIncludesFee, "percent-include-fee", false, "Include the cluster fee in the total the workload percentages are based on")
+	flags.StringVar(&options.skuMapFile, "sku-map", "", "JSON file mapping price fields to regular expressions of their SKU descriptions, to override the built-in matching")
+	flags.StringVar(&options.rateOverridesFile, "rate-overrides", "", "JSON file of negotiated rates replacing the fetched Autopilot prices, with a discount_percent on all of them and fields replacing single prices")
+	flags.BoolVar(&options.noColor, "no-color", false, "Disable colors in the output")
+	flags.IntVar(&options.precision, "precision", defaultCostPrecision, "Number of decimals of the costs in the tables, from 2 to 6")
+	flags.BoolVar(&options.rawUnits, "raw-units", false, "Show the CPU, memory and storage of the tables as plain mCPU and MB integers, for machine parsing")
+	flags.StringVar(&options.freeTierCluster, "free-tier-cluster", "", "Cluster whose cluster management fee is waived by the free tier of the billing account, by name or, when the fleet has several clusters of that name, as projects/PROJECT/locations/LOCATION/clusters/NAME")
+	flags.Int64Var(&options.nodePageSize, "node-page-size", 500, "Number of nodes per page when listing the nodes of large clusters, 0 lists them at once")
+	flags.StringVar(&options.fleet, "fleet", "", "Estimate every GKE cluster registered to the fleet of this project, listed with the GKE Hub API, in a combined report")
+	flags.StringVar(&options.project, "project", "", "Project of the cluster, defaults to the one in the name of the current kubectl context")
+	flags.StringVar(&options.billingProject, "billing-project", "", "Project billed for the quota of the Cloud Billing API requests, defaults to the one of the credentials")
+	flags.StringVar(&options.pricingDate, "pricing-date", "", "Date of the prices to estimate with, as 2006-01-02 or RFC 3339, for historical estimates or scheduled price changes, defaults to now")
+	flags.StringVar(&options.billingExport, "billing-export", "", "Billing BigQuery export table (project.dataset.table) to compare the estimate with the actual cluster spend")
+	flags.IntVar(&options.billingDays, "billing-days", 30, "Number of past days of actual spend to read from the billing export")
+	flags.StringVar(&options.commitment, "commitment", "", "Committed use discounts of the Standard cluster, 1y or 3y, also applied to the Autopilot cost compared with -billing-export")
+	flags.BoolVar(&options.html, "html", false, "Generate a standalone html report")
+	flags.StringVar(&options.htmlFile, "html-file", "report.html", "html report location, - for stdout instead of the tables")
+	flags.StringVar(&options.outputDir, "output-dir", "", "Directory to write the report to in each of -formats, as report-CLUSTER-TIMESTAMP.EXT, - writes the single format of -formats to stdout instead of the tables")
+	flags.StringVar(&options.formats, "formats", strings.Join(reportFormats, ","), "Comma separated formats written to -output-dir: json, csv, md and html")
+	flags.BoolVar(&options.watch, "watch", false, "Keep the workload table on screen and refresh it periodically")
+	flags.DurationVar(&options.interval, "interval", 30*time.Second, "Refresh interval of the watch mode")
+	flags.BoolVar(&options.summaryOnly, "summary-only", false, "Only print the summary line with the headline numbers to stdout")
+	flags.StringVar(&options.slackWebhook, "slack-webhook", "", "Slack incoming webhook URL to post the report summary to")
+	flags.BoolVar(&options.slackRequired, "slack-required", false, "Fail the run when the report can't be posted to Slack")
+	flags.BoolVar(&options.exportMonitoring, "export-monitoring", false, "Write the workload and cluster cost estimates to Cloud Monitoring as the custom metric "+monitoringCostMetric)
+	flags.IntVar(&options.top, "top", 0, "Only list the N costliest workloads, the totals still include all of them")
+	flags.Float64Var(&options.budget, "budget", 0, "Monthly budget, exit with code 2 when the estimated monthly cost exceeds it")
+	flags.Var(options.namespaceBudgets, "namespace-budget", "Monthly budgets of namespaces, eg. team-a=500,team-b=200, exit with code 2 when a namespace exceeds its budget")
+	flags.BoolVar(&options.allowAutopilot, "allow-autopilot", false, "Report the workload cost of a cluster that is already in Autopilot mode")
+	options.logs = addLogFlags(flags, "Only write the requested json, summary-only, html or budget output, without the report tables, the summary line on stderr, the progress or logs below errors")
+
+	return options
+}
+
+// parseEstimateFlags parses the flags of the estimate and compare commands from the command line. Flags are resolved
+// as defaults < config file < environment < command line. It exits with the standard logger on errors, as the
+// logger is set up from the flags.
+func parseEstimateFlags(args []string, compareOnly bool) *estimateOptions {
+	options := newEstimateOptions(flag.CommandLine, compareOnly)
+	flag.CommandLine.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [estimate|compare|pricing|what-if|diff|trend] [flags] [POD...]\n\nestimate is the default, run pricing -h, what-if -h, diff -h or trend -h for their flags. Naming pods estimates just them, in any namespace. Flags of estimate and compare:\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.CommandLine.Parse(args)
+	options.pods = flag.CommandLine.Args()
+
+	envValues := envFlagValues(flag.CommandLine, os.LookupEnv)
+	if options.config == "" && len(envValues[configFlagName]) > 0 {
+		options.config = envValues[configFlagName][0]
+	}
+	delete(envValues, configFlagName)
+
+	var flagLayers []flagValues
+	if options.config != "" {
+		configValues, err := loadConfigFile(options.config)
+		if err != nil {
+			log.Fatalf("Error loading config: %v", err)
+		}
+		flagLayers = append(flagLayers, configValues)
+	}
+	if err := applyFlagLayers(flag.CommandLine, append(flagLayers, envValues)...); err != nil {
+		log.Fatalf("Error applying config and environment flags: %v", err)
+	}
+
+	return options
+}
+
+// parse validates the flags and reads the settings they set: the prices and the rules of the estimate, the filter of
+// the workloads and the outputs.
+func (options *estimateOptions) parse() error {
+	if options.precision < minCostPrecision || options.precision > maxCostPrecision {
+		return fmt.Errorf("-precision %d is out of range, from %d to %d", options.precision, minCostPrecision, maxCostPrecision)
+	}
+
+	var err error
+	options.pricingTime, err = parsePricingDate(options.pricingDate)
+	if err != nil {
+		return fmt.Errorf("invalid -pricing-date: %v", err)
+	}
+
+	options.currencies, err = parseCurrencies(options.currency)
+	if err != nil {
+		return fmt.Errorf("invalid -currency: %v", err)
+	}
+
+	if options.rateOverridesFile != "" {
+		options.rateOverrides, err = calculator.LoadRateOverrides(options.rateOverridesFile)
+		if err != nil {
+			return fmt.Errorf("invalid -rate-overrides: %v", err)
+		}
+	}
+
+	if options.outputDir != "" {
+		options.outputFormats, err = parseFormats(options.formats)
+		if err != nil {
+			return fmt.Errorf("invalid -formats: %v", err)
+		}
+		if options.outputDir == stdoutPath && len(options.outputFormats) != 1 {
+			return fmt.Errorf("-output-dir %s writes to stdout and needs a single format in -formats, got %s", stdoutPath, strings.Join(options.outputFormats, ","))
+		}
+	}
+	// The reports written to stdout replace the tables, and only one of them can be written there
+	stdoutReports := 0
+	for _, toStdout := range []bool{options.summaryOnly, options.json && (options.jsonFile == "" || options.jsonFile == stdoutPath), options.html && options.htmlFile == stdoutPath, options.outputDir == stdoutPath} {
+		if toStdout {
+			stdoutReports++
+		}
+	}
+	if stdoutReports > 1 {
+		return fmt.Errorf("only one of -summary-only, -json, -html-file %s and -output-dir %s can write to stdout", stdoutPath, stdoutPath)
+	}
+	options.tablesOnStdout = stdoutReports == 0
+
+	options.cfg, err = ini.Load("config.ini")
+	if err != nil {
+		return fmt.Errorf("unable to read config.ini: %v", err)
+	}
+
+	options.billingBasis = calculator.Basis(options.basis)
+	if options.billingBasis != calculator.BasisMax && options.billingBasis != calculator.BasisRequests && options.billingBasis != calculator.BasisLimits {
+		return fmt.Errorf("unknown basis %q, use max, requests or limits", options.basis)
+	}
+
+	if options.usageSource != usageSourceMetricsServer && options.usageSource != usageSourceMonitoring {
+		return fmt.Errorf("unknown usage source %q, use metrics-server or monitoring", options.usageSource)
+	}
+	if options.since <= 0 {
+		return fmt.Errorf("-since must be positive, got %v", options.since)
+	}
+	if options.jobRuntime < 0 {
+		return fmt.Errorf("-job-runtime can't be negative, got %v", options.jobRuntime)
+	}
+	if options.billingDays <= 0 {
+		return fmt.Errorf("-billing-days must be positive, got %d", options.billingDays)
+	}
+
+	options.labelSelector, err = labels.Parse(options.selector)
+	if err != nil {
+		return fmt.Errorf("error parsing label selector %q: %v", options.selector, err)
+	}
+
+	for _, pattern := range options.excludeWorkloads {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("error parsing exclude pattern %q: %v", pattern, err)
+		}
+	}
+
+	options.commitmentDiscount, err = commitmentDiscount(options.cfg, options.commitment)
+	if err != nil {
+		return fmt.Errorf("error parsing the commitment: %v", err)
+	}
+
+	if options.allowedClasses != "" {
+		options.allowedClassSet = make(map[cluster.ComputeClass]bool)
+		for _, name := range strings.Split(options.allowedClasses, ",") {
+			class, err := cluster.ParseComputeClass(name)
+			if err != nil {
+				return fmt.Errorf("error parsing allowed compute classes: %v", err)
+			}
+			options.allowedClassSet[class] = true
+		}
+	}
+
+	options.rulesVersion, err = calculator.RulesVersionFor(options.cfg, options.gkeVersion)
+	if err != nil {
+		return fmt.Errorf("error selecting the Autopilot rules: %v", err)
+	}
+	if options.rulesVersion != "" {
+		slog.Info("Using the Autopilot rules of an older GKE version", "gke_version", options.gkeVersion, "rules", options.rulesVersion)
+	}
+
+	options.pricingSKUs, options.skuMap, options.billingOptions, err = pricingSources(options.cfg, options.skuMapFile, options.billingProject)
+	if err != nil {
+		return fmt.Errorf("error loading sku map: %v", err)
+	}
+
+	return nil
+}
+
+// clusterEstimate is the estimate of the workloads of a cluster, the current one or one of a fleet.
+type clusterEstimate struct {
+	fleetCluster
+	region        string
+	clusterObject *container.Cluster
+	// reportOnly is set for clusters already in Autopilot mode, whose workloads are reported without comparing them
+	// to Standard nodes
+	reportOnly     bool
+	fee            float64
+	kubeConfig     *rest.Config
+	pricingService *calculator.PricingService
+	// overriddenRates are the price fields replaced by -rate-overrides
+	overriddenRates []string
+	nodes           map[string]cluster.Node
+	// workloads are the billable workloads, the nodes also list the system ones
+	workloads []cluster.Workload
+}
+
+// newClusterEstimate sets up the clients of the cluster reached with the kube config, and the pricing service of
+// the region of its location. The pricing service applies the flags once configured.
+func (options *estimateOptions) newClusterEstimate(fc fleetCluster, clusterObject *container.Cluster, kubeConfig *rest.Config, fee float64) (*clusterEstimate, error) {
+	region, err := calculator.RegionFromLocation(fc.Location)
+	if err != nil {
+		return nil, err
+	}
+
+	clientset, err := kubernetes.NewForConfig(kubeConfig)
+	if err != nil {
+		return nil, fmt.Errorf("error setting kubernetes config: %v", err)
+	}
+	metricsClientset, err := metricsv.NewForConfig(kubeConfig)
+	if err != nil {
+		return nil, fmt.Errorf("error setting kubernetes metrics config: %v", err)
+	}
+
+	pricingService, err := calculator.NewService(options.pricingSKUs, options.skuMap, region, options.pricingTime, clientset, metricsClientset, options.cfg, options.billingOptions...)
+	if err != nil {
+		return nil, fmt.Errorf("error initializing pricing service: %v", err)
+	}
+
+	return &clusterEstimate{
+		fleetCluster:   fc,
+		region:         region,
+		clusterObject:  clusterObject,
+		reportOnly:     isAutopilot(clusterObject),
+		fee:            fee,
+		kubeConfig:     kubeConfig,
+		pricingService: pricingService,
+	}, nil
+}
+
+// configure applies the flags to the pricing service of the cluster: the negotiated rates, the workload filter and
+// the pricing rules, its custom compute classes, the compute classes of its node pools, and the usage read from
+// Cloud Monitoring.
+func (estimate *clusterEstimate) configure(ctx context.Context, options *estimateOptions) error {
+	pricingService := estimate.pricingService
+	if options.rateOverrides != nil {
+		estimate.overriddenRates = options.rateOverrides.Apply(&pricingService.AutopilotPricing)
+		slog.Info("Negotiated rates replace the list prices", "fields", len(estimate.overriddenRates))
+	}
+	pricingService.Filter = calculator.WorkloadFilter{
+		Namespaces:     options.namespaces,
+		Exclude:        options.excludeWorkloads,
+		Selector:       options.labelSelector,
+		IncludePending: options.includePending,
+		IncludeSystem:  options.includeSystemCost,
+		NodePool:       options.nodePool,
+		Pods:           options.pods,
+	}
+	pricingService.Basis = options.billingBasis
+	pricingService.PerContainer = options.perContainer
+	pricingService.ProrateJobs = options.prorateJobs || options.jobRuntime > 0
+	pricingService.JobRuntime = options.jobRuntime
+	pricingService.Explain = options.explain
+	pricingService.CheckCompatibility = options.checkCompatibility
+	pricingService.AllowedClasses = options.allowedClassSet
+	pricingService.RulesVersion = options.rulesVersion
+
+	if options.customComputeClasses {
+		dynamicClient, err := dynamic.NewForConfig(estimate.kubeConfig)
+		if err != nil {
+			return fmt.Errorf("error setting kubernetes dynamic config: %v", err)
+		}
+		if err := pricingService.LoadCustomComputeClasses(dynamicClient); err != nil {
+			return fmt.Errorf("error reading the custom compute classes: %v", err)
+		}
+	}
+
+	// Once the custom compute classes are loaded, the compute classes are limited to the ones the node pools of the
+	// cluster can run, unless -allowed-classes sets them
+	if options.allowedClassSet == nil {
+		if families, ok := clusterMachineFamilies(estimate.clusterObject); ok {
+			pricingService.AllowedClasses = pricingService.ClusterComputeClasses(families)
+			slog.Debug("Restricted the compute classes to the node pools of the cluster", "cluster", estimate.Name, "machine_families", families)
+		}
+	}
+
+	if options.usageSource == usageSourceMonitoring {
+		monitoringService, err := monitoring.NewService(ctx)
+		if err != nil {
+			return fmt.Errorf("error initializing Cloud Monitoring client: %v", err)
+		}
+		pricingService.Usage, err = calculator.GetContainerUsage(ctx, monitoringService, estimate.Project, estimate.Location, estimate.Name, options.since, usagePercentile, time.Now())
+		if err != nil {
+			return fmt.Errorf("error getting the container usage: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// estimateWorkloads lists the nodes and estimates their workloads, for the first run and every refresh of the watch
+// mode. It returns the nodes with all their workloads and the billable workloads. The progress of the pods described
+// is drawn on a terminal when shown.
+func (estimate *clusterEstimate) estimateWorkloads(options *estimateOptions, showProgress bool) (map[string]cluster.Node, []cluster.Workload, error) {
+	nodes, err := cluster.GetClusterNodes(estimate.pricingService.Clientset, options.nodePageSize)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error getting cluster nodes: %w", err)
+	}
+	if options.nodePool != "" {
+		nodes = cluster.FilterNodePool(nodes, options.nodePool)
+		if len(nodes) == 0 {
+			return nil, nil, fmt.Errorf("no nodes found in the node pool %s", options.nodePool)
+		}
+	}
+
+	// Pods are described one by one, so large clusters take a while
+	var stopProgress func()
+	if showProgress && !*options.logs.quiet && term.IsTerminal(int(os.Stderr.Fd())) {
+		estimate.pricingService.Progress, stopProgress = startProgress(os.Stderr)
+	}
+	workloads, err := estimate.pricingService.PopulateWorkloads(nodes)
+	if stopProgress != nil {
+		stopProgress()
+		estimate.pricingService.Progress = nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("error populating workloads: %w", err)
+	}
+	estimate.pricingService.PopulateNodeEfficiency(nodes)
+
+	setPercentOfTotal(nodes, workloads, estimate.fee, options.percentIncludesFee)
+	// The system workloads are only listed on their nodes, the totals and the other outputs leave them out
+	workloads = billableWorkloads(workloads)
+	if len(workloads) == 0 {
+		slog.Warn(noBillableWorkloadsMessage, "cluster", estimate.Name)
+	}
+
+	return nodes, workloads, nil
+}
+
+// billedHourlyCost returns the actual hourly spend of the Standard cluster read from -billing-export, to compare the
+// estimate with. It's negative when unknown.
+func (options *estimateOptions) billedHourlyCost(ctx context.Context, estimate *clusterEstimate) (float64, error) {
+	if options.billingExport == "" || estimate.reportOnly {
+		return -1, nil
+	}
+
+	bigqueryService, err := bigquery.NewService(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("error initializing BigQuery client: %v", err)
+	}
+	billedCost, err := calculator.GetBilledClusterCost(ctx, bigqueryService, options.billingExport, estimate.Project, estimate.Name, estimate.Location, options.billingDays)
+	if err != nil {
+		return 0, err
+	}
+
+	return billedCost / float64(options.billingDays*24), nil
+}
+
+// buildReport returns the report of the estimate, with the projections and the comparisons requested by the flags.
+// The billed hourly cost is negative when unknown.
+func (options *estimateOptions) buildReport(estimate *clusterEstimate, billedHourlyCost float64) (Report, error) {
+	pricingService, workloads, nodes := estimate.pricingService, estimate.workloads, estimate.nodes
+	report := newReport(estimate.Name, estimate.region, aggregateCheapWorkloads(workloads, options.minCost), estimate.fee, billedHourlyCost)
+	if options.commitment != "" {
+		report.applyCommitment(options.commitment, options.commitmentDiscount, spotHourlyCost(nodes))
+	}
+	report.ClassDistribution = classDistribution(workloads)
+	report.RateOverrides = estimate.overriddenRates
+	report.BurstableWorkloads = burstableWorkloads(workloads)
+	if options.histogram {
+		report.Histogram = costHistogram(workloads)
+	}
+
+	var err error
+	if options.includeHPA {
+		report.HPAProjections, err = pricingService.ProjectHPAs(workloads)
+		if err != nil {
+			return Report{}, fmt.Errorf("error projecting the HPA replicas: %v", err)
+		}
+	}
+	if options.pdbAware {
+		report.PDBProjections, err = pricingService.ProjectPDBs(workloads)
+		if err != nil {
+			return Report{}, fmt.Errorf("error projecting the PDB minimum replicas: %v", err)
+		}
+	}
+
+	if options.compareRegions != "" {
+		var regions []calculator.RegionPricing
+		for _, region := range strings.Split(options.compareRegions, ",") {
+			regionPricing, err := calculator.GetRegionPricing(options.pricingSKUs, options.skuMap, strings.TrimSpace(region), options.pricingTime, options.billingOptions...)
+			if err != nil {
+				return Report{}, fmt.Errorf("error getting the pricing of region %s: %v", region, err)
+			}
+			if options.rateOverrides != nil {
+				options.rateOverrides.Apply(&regionPricing.Autopilot)
+			}
+			regions = append(regions, regionPricing)
+		}
+		report.RegionComparison = pricingService.CompareRegions(workloads, nodes, estimate.fee, regions)
+	}
+
+	if len(options.currencies) > 0 {
+		var pricings []calculator.RegionPricing
+		for _, currency := range options.currencies {
+			// The prices of the estimate are already in the default currency
+			if currency == calculator.DefaultCurrency {
+				pricings = append(pricings, calculator.RegionPricing{Autopilot: pricingService.AutopilotPricing, GCE: pricingService.GCEPricing})
+				continue
+			}
+			currencyPricing, err := calculator.GetCurrencyPricing(options.pricingSKUs, options.skuMap, estimate.region, currency, options.pricingTime, options.billingOptions...)
+			if err != nil {
+				return Report{}, fmt.Errorf("error getting the pricing in %s: %v", currency, err)
+			}
+			if options.rateOverrides != nil {
+				options.rateOverrides.Apply(&currencyPricing.Autopilot)
+			}
+			pricings = append(pricings, currencyPricing)
+		}
+		report.CurrencyTotals = pricingService.CompareCurrencies(workloads, nodes, estimate.fee, pricings)
+	}
+
+	return report, nil
+}
+
+// watchTable keeps the workload table of the estimate on screen, refreshing it every -interval. The pricing is kept
+// from the start, only the nodes and the pod metrics are refreshed.
+func (options *estimateOptions) watchTable(estimate *clusterEstimate) error {
+	oneYearDiscount, threeYearDiscount, highlight := workloadTableSettings(options.cfg, options.colors)
+	newModel := func(nodes map[string]cluster.Node) tableModel {
+		return workloadTableModel(nodes, oneYearDiscount, threeYearDiscount, estimate.fee, highlight, options.top, options.minCost, options.breakdown, options.showAdjustments)
+	}
+
+	refresh := func() (tableModel, error) {
+		nodes, _, err := estimate.estimateWorkloads(options, false)
+		if err != nil {
+			return tableModel{}, err
+		}
+
+		return newModel(nodes), nil
+	}
+
+	return watchWorkloadTable(os.Stdout, newModel(estimate.nodes), options.interval, refresh)
+}
+
+// writeOutputs writes the report of the estimate to the outputs of the flags, the tables by default, exports it, and
+// returns the exit code of the budget checks.
+func (options *estimateOptions) writeOutputs(estimate *clusterEstimate, report Report, warnings *warningCollector) int {
+	summary := summaryLine(estimate.Name, estimate.region, estimate.workloads, estimate.fee)
+	if options.summaryOnly {
+		fmt.Println(summary)
+	} else if options.json {
+		report.Nodes = reportNodes(estimate.nodes, options.minCost)
+		report.Warnings = warnings.Warnings()
+
+		if options.jsonFile != "" && options.jsonFile != stdoutPath {
+			if err := writeReportFile(options.jsonFile, writeJSONReport, report); err != nil {
+				fatal("Error writing json output", "error", err)
+			}
+			slog.Info("JSON output saved", "file", options.jsonFile)
+		} else if err := writeJSONReport(os.Stdout, report); err != nil {
+			fatal("Error writing json output", "error", err)
+		}
+	} else if !*options.logs.quiet && options.tablesOnStdout {
+		options.displayTables(os.Stdout, estimate, report)
+	}
+
+	if options.html {
+		if err := writeReportFile(options.htmlFile, writeHTMLReport, report); err != nil {
+			fatal("Error writing html report", "error", err)
+		}
+		if options.htmlFile != stdoutPath {
+			slog.Info("HTML report saved", "file", options.htmlFile)
+		}
+	}
+
+	if options.outputDir != "" {
+		if report.Nodes == nil {
+			report.Nodes = reportNodes(estimate.nodes, options.minCost)
+			report.Warnings = warnings.Warnings()
+		}
+
+		paths, err := writeReportFiles(options.outputDir, options.outputFormats, report, time.Now())
+		if err != nil {
+			fatal("Error writing the reports", "error", err)
+		}
+		if options.outputDir != stdoutPath {
+			slog.Info("Reports saved", "files", paths)
+		}
+	}
+
+	if options.slackWebhook != "" {
+		err := postSlackReport(options.slackWebhook, estimate.Name, estimate.region, estimate.workloads, estimate.fee, report.BilledHourlyCost)
+		if err != nil && options.slackRequired {
+			fatal("Error posting the report to Slack", "error", err)
+		} else if err != nil {
+			slog.Error("Error posting the report to Slack", "error", err)
+		}
+	}
+
+	if options.exportMonitoring {
+		monitoringService, err := monitoring.NewService(context.Background())
+		if err == nil {
+			err = exportMonitoringMetrics(context.Background(), monitoringService, estimate.Project, estimate.Location, estimate.Name, estimate.workloads, estimate.fee)
+		}
+		if err != nil {
+			slog.Error("Error exporting the metrics to Cloud Monitoring", "error", err)
+		} else {
+			slog.Info("Metrics exported to Cloud Monitoring", "metric", monitoringCostMetric)
+		}
+	}
+
+	if !options.summaryOnly && !*options.logs.quiet {
+		fmt.Fprintln(os.Stderr, summary)
+	}
+
+	exitCode := 0
+	if options.budget > 0 {
+		exitCode = checkBudget(os.Stderr, estimate.workloads, estimate.fee, options.budget)
+	}
+	if len(options.namespaceBudgets) > 0 {
+		if code := checkNamespaceBudgets(os.Stderr, estimate.workloads, options.namespaceBudgets); code != 0 {
+			exitCode = code
+		}
+	}
+
+	return exitCode
+}
+
+// displayTables writes the tables of the estimate to w, followed by the lines and tables of the projections and
+// comparisons of the report.
+func (options *estimateOptions) displayTables(w io.Writer, estimate *clusterEstimate, report Report) {
+	if headline := report.monthlyDeltaHeadline(); headline != "" {
+		headlineStyle := greenTextStyle
+		if *report.EstimatedMonthlyDelta > 0 {
+			headlineStyle = redTextStyle
+		}
+		fmt.Fprintln(w, headlineStyle.Render(headline))
+		fmt.Fprintln(w)
+	}
+
+	if options.compareOnly {
+		displayComparison(w, estimate.region, estimate.nodes, estimate.fee, options.wide)
+	} else {
+		displayReport(w, estimate, options)
+
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, blueTextStyle.Render(report.rightSizingLine()))
+		for _, line := range report.overProvisioningLines() {
+			fmt.Fprintln(w, blueTextStyle.Render(line))
+		}
+		if options.billingBasis == calculator.BasisLimits && report.BurstableWorkloads > 0 {
+			fmt.Fprintln(w, blueTextStyle.Render(report.burstingLine()))
+		}
+	}
+	if len(report.RateOverrides) > 0 {
+		fmt.Fprintln(w, blueTextStyle.Render(fmt.Sprintf("Costs use the negotiated rates of -rate-overrides for %d of the Autopilot list prices", len(report.RateOverrides))))
+	}
+
+	if billedHourlyCost := report.BilledHourlyCost; billedHourlyCost >= 0 {
+		estimatedHourlyCost := estimatedHourlyCost(estimate.nodes, estimate.fee)
+
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, blueTextStyle.Render(fmt.Sprintf("Billed cost of the cluster in the last %d days: $%.2f ($%.4f per hour)", options.billingDays, billedHourlyCost*float64(options.billingDays*24), billedHourlyCost)))
+		fmt.Fprintln(w, blueTextStyle.Render(fmt.Sprintf("Estimated Autopilot cost: $%.4f per hour, %+.4f per hour compared to the billed cost", estimatedHourlyCost, estimatedHourlyCost-billedHourlyCost)))
+	}
+
+	if len(report.HPAProjections) > 0 {
+		fmt.Fprintln(w)
+		displayHPAProjections(w, report)
+	}
+
+	if len(report.PDBProjections) > 0 {
+		fmt.Fprintln(w)
+		displayPDBProjections(w, report)
+	}
+
+	if report.Histogram != nil {
+		fmt.Fprintln(w)
+		displayCostHistogram(w, report.Histogram)
+	}
+
+	if len(report.RegionComparison) > 0 {
+		fmt.Fprintln(w)
+		displayRegionComparison(w, report)
+	}
+
+	if len(report.CurrencyTotals) > 0 {
+		fmt.Fprintln(w)
+		displayCurrencyTotals(w, report.CurrencyTotals)
+	}
+
+	if incompatible := incompatibleWorkloads(estimate.workloads); len(incompatible) > 0 {
+		fmt.Fprintln(w)
+		displayIncompatibleWorkloads(w, incompatible)
+	}
+}
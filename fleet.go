@@ -0,0 +1,277 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"golang.org/x/exp/slices"
+	"golang.org/x/exp/slog"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	container "google.golang.org/api/container/v1"
+	"google.golang.org/api/gkehub/v1"
+	"k8s.io/client-go/rest"
+)
+
+// fleetUnsupportedFlags are the flags of the estimate of a single cluster that -fleet doesn't support
+var fleetUnsupportedFlags = []string{
+	"watch", "summary-only", "explain-pricing", "billing-export", "include-hpa", "pdb-aware", "compare-regions",
+	"html", "output-dir", "slack-webhook", "export-monitoring", "budget", "namespace-budget", "currency",
+}
+
+// fleetCluster is a GKE cluster registered to a fleet.
+type fleetCluster struct {
+	Project  string `json:"project"`
+	Location string `json:"location"`
+	Name     string `json:"cluster"`
+}
+
+// path is the resource name of the cluster in the GKE API.
+func (fc fleetCluster) path() string {
+	return fmt.Sprintf("projects/%s/locations/%s/clusters/%s", fc.Project, fc.Location, fc.Name)
+}
+
+// listFleetClusters lists the GKE clusters registered to the fleet of the project, from the memberships of all its
+// locations. Memberships of clusters outside of GKE, or whose cluster was deleted, are skipped.
+func listFleetClusters(ctx context.Context, hubService *gkehub.Service, project string) ([]fleetCluster, error) {
+	var clusters []fleetCluster
+	err := hubService.Projects.Locations.Memberships.List(fmt.Sprintf("projects/%s/locations/-", project)).Pages(ctx, func(response *gkehub.ListMembershipsResponse) error {
+		for _, location := range response.Unreachable {
+			slog.Warn("Fleet location unreachable, its clusters are missing from the estimate", "location", location)
+		}
+		for _, membership := range response.Resources {
+			if membership.Endpoint == nil || membership.Endpoint.GkeCluster == nil {
+				slog.Debug("Skipping a fleet membership that isn't a GKE cluster", "membership", membership.Name)
+				continue
+			}
+			if membership.Endpoint.GkeCluster.ClusterMissing {
+				slog.Warn("Skipping a fleet membership whose GKE cluster is missing", "membership", membership.Name)
+				continue
+			}
+
+			fc, err := parseGKEResourceLink(membership.Endpoint.GkeCluster.ResourceLink)
+			if err != nil {
+				slog.Warn("Skipping a fleet membership", "membership", membership.Name, "error", err)
+				continue
+			}
+			clusters = append(clusters, fc)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list the fleet memberships of project %s: %v", project, err)
+	}
+
+	return clusters, nil
+}
+
+// parseGKEResourceLink returns the cluster of the resource link of a fleet membership, like
+// //container.googleapis.com/projects/PROJECT/locations/LOCATION/clusters/NAME.
+func parseGKEResourceLink(resourceLink string) (fleetCluster, error) {
+	parts := strings.Split(strings.TrimPrefix(resourceLink, "//container.googleapis.com/"), "/")
+	if len(parts) != 6 || parts[0] != "projects" || (parts[2] != "locations" && parts[2] != "zones") || parts[4] != "clusters" {
+		return fleetCluster{}, fmt.Errorf("unexpected GKE cluster resource link %q", resourceLink)
+	}
+
+	return fleetCluster{Project: parts[1], Location: parts[3], Name: parts[5]}, nil
+}
+
+// fleetKubeConfig returns the config to reach the API server of a GKE cluster at its endpoint, authenticated with
+// the tokens of the application default credentials like the gke-gcloud-auth-plugin of kubectl.
+func fleetKubeConfig(clusterObject *container.Cluster, tokenSource oauth2.TokenSource) (*rest.Config, error) {
+	if clusterObject.Endpoint == "" {
+		return nil, fmt.Errorf("cluster %s has no endpoint", clusterObject.Name)
+	}
+
+	config := &rest.Config{
+		Host: "https://" + clusterObject.Endpoint,
+		WrapTransport: func(rt http.RoundTripper) http.RoundTripper {
+			return &oauth2.Transport{Source: tokenSource, Base: rt}
+		},
+	}
+	if clusterObject.MasterAuth != nil && clusterObject.MasterAuth.ClusterCaCertificate != "" {
+		caData, err := base64.StdEncoding.DecodeString(clusterObject.MasterAuth.ClusterCaCertificate)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CA certificate of cluster %s: %v", clusterObject.Name, err)
+		}
+		config.TLSClientConfig.CAData = caData
+	}
+
+	return config, nil
+}
+
+// fleetClusterReport is the estimate of a cluster of the fleet, or the error that kept it from being estimated.
+type fleetClusterReport struct {
+	fleetCluster
+	Error  string  `json:"error,omitempty"`
+	Report *Report `json:"report,omitempty"`
+}
+
+// fleetReport combines the estimates of the clusters of a fleet, the costs sum the clusters that were estimated.
+type fleetReport struct {
+	Project     string               `json:"project"`
+	Clusters    []fleetClusterReport `json:"clusters"`
+	HourlyCost  float64              `json:"hourly_cost"`
+	MonthlyCost float64              `json:"monthly_cost"`
+	Failed      int                  `json:"failed_clusters"`
+}
+
+// runFleetEstimate estimates every cluster registered to the fleet of -fleet with the options of the estimate of a
+// single cluster, and writes the combined report.
+func runFleetEstimate(options *estimateOptions) {
+	if options.compareOnly {
+		fatal("The compare command doesn't support -fleet")
+	}
+	options.flags.Visit(func(f *flag.Flag) {
+		if slices.Contains(fleetUnsupportedFlags, f.Name) {
+			fatal("This flag isn't supported with -fleet", "flag", f.Name)
+		}
+	})
+
+	ctx := context.Background()
+	hubService, err := gkehub.NewService(ctx)
+	if err != nil {
+		fatal("Error initializing GKE Hub client", "error", err)
+	}
+	fleetClusters, err := listFleetClusters(ctx, hubService, options.fleet)
+	if err != nil {
+		fatal("Error listing the fleet clusters", "error", err)
+	}
+	if len(fleetClusters) == 0 {
+		fatal("No GKE clusters registered to the fleet", "project", options.fleet)
+	}
+
+	fees, err := clusterFees(fleetClusters, clusterFee(options.cfg), options.freeTierCluster)
+	if err != nil {
+		fatal("Error applying the free tier", "error", err)
+	}
+
+	svc, err := container.NewService(ctx)
+	if err != nil {
+		fatal("Error initializing GKE client", "error", err)
+	}
+	tokenSource, err := google.DefaultTokenSource(ctx, container.CloudPlatformScope)
+	if err != nil {
+		fatal("Error getting the application default credentials", "error", err)
+	}
+	clusters := newClusterCache(svc)
+
+	fleet := estimateFleet(options.fleet, fleetClusters, func(fc fleetCluster) (Report, error) {
+		clusterObject, err := clusters.Get(fc.path())
+		if err != nil {
+			return Report{}, err
+		}
+		if isAutopilot(clusterObject) && !options.allowAutopilot {
+			return Report{}, fmt.Errorf("already an Autopilot cluster, use -allow-autopilot to report the cost of its workloads")
+		}
+		kubeConfig, err := fleetKubeConfig(clusterObject, tokenSource)
+		if err != nil {
+			return Report{}, err
+		}
+
+		estimate, err := options.newClusterEstimate(fc, clusterObject, kubeConfig, fees[fc.path()])
+		if err != nil {
+			return Report{}, err
+		}
+		if err := estimate.configure(ctx, options); err != nil {
+			return Report{}, err
+		}
+		estimate.nodes, estimate.workloads, err = estimate.estimateWorkloads(options, false)
+		if err != nil {
+			return Report{}, err
+		}
+
+		report, err := options.buildReport(estimate, -1)
+		if err != nil {
+			return Report{}, err
+		}
+		report.Nodes = reportNodes(estimate.nodes, options.minCost)
+		return report, nil
+	})
+
+	if options.json {
+		if options.jsonFile != "" && options.jsonFile != stdoutPath {
+			if err := writeReportFile(options.jsonFile, writeJSONFleetReport, fleet); err != nil {
+				fatal("Error writing json output", "error", err)
+			}
+			slog.Info("JSON output saved", "file", options.jsonFile)
+		} else if err := writeJSONFleetReport(os.Stdout, fleet); err != nil {
+			fatal("Error writing json output", "error", err)
+		}
+	} else if !*options.logs.quiet {
+		displayFleetReport(os.Stdout, fleet)
+	}
+}
+
+// estimateFleet estimates each cluster of the fleet in turn. A cluster that can't be estimated, like one whose API
+// server isn't reachable, gets its error in the report and the others are still estimated.
+func estimateFleet(project string, clusters []fleetCluster, estimate func(fleetCluster) (Report, error)) fleetReport {
+	fleet := fleetReport{Project: project, Clusters: make([]fleetClusterReport, 0, len(clusters))}
+	for _, fc := range clusters {
+		clusterReport := fleetClusterReport{fleetCluster: fc}
+		report, err := estimate(fc)
+		if err != nil {
+			slog.Error("Error estimating a fleet cluster", "cluster", fc.Name, "location", fc.Location, "project", fc.Project, "error", err)
+			clusterReport.Error = err.Error()
+			fleet.Failed++
+		} else {
+			clusterReport.Report = &report
+			fleet.HourlyCost += report.HourlyCost
+			fleet.MonthlyCost += report.MonthlyCost
+		}
+		fleet.Clusters = append(fleet.Clusters, clusterReport)
+	}
+
+	return fleet
+}
+
+// writeJSONFleetReport writes the fleet report as indented json, with the json report of each estimated cluster.
+func writeJSONFleetReport(w io.Writer, fleet fleetReport) error {
+	contents, err := json.MarshalIndent(fleet, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(contents)
+	return err
+}
+
+// displayFleetReport writes a line per cluster of the fleet with its costs, or its error, and the fleet total.
+func displayFleetReport(w io.Writer, fleet fleetReport) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "CLUSTER\tLOCATION\tPROJECT\tWORKLOADS\tHOURLY\tMONTHLY\tERROR")
+	for _, clusterReport := range fleet.Clusters {
+		if clusterReport.Report == nil {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t\t\t\t%s\n", clusterReport.Name, clusterReport.Location, clusterReport.Project, clusterReport.Error)
+			continue
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t$%.4f\t$%.2f\t\n", clusterReport.Name, clusterReport.Location, clusterReport.Project,
+			len(clusterReport.Report.Workloads), clusterReport.Report.HourlyCost, clusterReport.Report.MonthlyCost)
+	}
+	tw.Flush()
+
+	fmt.Fprintf(w, "\nEstimated cost of the fleet of %s: $%.4f per hour, $%.2f per month, %d of %d clusters estimated\n",
+		fleet.Project, fleet.HourlyCost, fleet.MonthlyCost, len(fleet.Clusters)-fleet.Failed, len(fleet.Clusters))
+}
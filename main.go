@@ -17,134 +17,931 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
 
 	"github.com/GoogleCloudPlatform/autopilot-cost-calculator/calculator"
 	"github.com/GoogleCloudPlatform/autopilot-cost-calculator/cluster"
+	"golang.org/x/exp/slices"
+	"golang.org/x/exp/slog"
 	container "google.golang.org/api/container/v1"
+	"google.golang.org/api/option"
 	"gopkg.in/ini.v1"
-	"k8s.io/client-go/kubernetes"
-	metricsv "k8s.io/metrics/pkg/client/clientset/versioned"
 )
 
+// stringSliceFlag collects the values of a flag that can be passed multiple times.
+type stringSliceFlag []string
+
+func (s *stringSliceFlag) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringSliceFlag) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+// namespaceBudgetFlag collects the monthly budgets of namespaces, passed as comma separated namespace=budget pairs.
+type namespaceBudgetFlag map[string]float64
+
+func (budgets namespaceBudgetFlag) String() string {
+	var pairs []string
+	for namespace, budget := range budgets {
+		pairs = append(pairs, namespace+"="+strconv.FormatFloat(budget, 'f', -1, 64))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (budgets namespaceBudgetFlag) Set(value string) error {
+	for _, pair := range strings.Split(value, ",") {
+		namespace, amount, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || namespace == "" {
+			return fmt.Errorf("expected namespace=budget, got %q", pair)
+		}
+
+		budget, err := strconv.ParseFloat(amount, 64)
+		if err != nil || budget <= 0 {
+			return fmt.Errorf("invalid budget %q for namespace %s", amount, namespace)
+		}
+		budgets[namespace] = budget
+	}
+
+	return nil
+}
+
+// Usage sources of -usage-source
+const (
+	usageSourceMetricsServer = "metrics-server"
+	usageSourceMonitoring    = "monitoring"
+)
+
+// usagePercentile is the percentile of the usage over -since read from Cloud Monitoring
+const usagePercentile = 95
+
+// defaultSubcommand runs when no subcommand is given, for backward compatibility
+const defaultSubcommand = "estimate"
+
+// subcommands are the commands of the tool by name, each parsing its own flags from the arguments left after the
+// subcommand name.
+var subcommands = map[string]func(args []string){
+	"estimate": func(args []string) { runEstimate(args, false) },
+	"pricing":  runPricing,
+	"compare":  func(args []string) { runEstimate(args, true) },
+	"what-if":  runWhatIf,
+	"diff":     runDiff,
+	"trend":    runTrend,
+}
+
 func main() {
-	cfg, err := ini.Load("config.ini")
-	if err != nil {
-		fmt.Printf("Fail to read file: %v", err)
-		os.Exit(1)
+	name, args := parseSubcommand(os.Args[1:], subcommands)
+	subcommands[name](args)
+}
+
+// parseSubcommand returns the subcommand named by the first argument and the arguments left for it. Arguments
+// not starting with a known subcommand, like flags, are the ones of the default estimate subcommand.
+func parseSubcommand(args []string, commands map[string]func(args []string)) (string, []string) {
+	if len(args) > 0 {
+		if _, ok := commands[args[0]]; ok {
+			return args[0], args[1:]
+		}
 	}
 
-	jsonFlag := flag.Bool("json", false, "Generate json file with the results")
-	jsonFileFlag := flag.String("json-file", "", "json file location")
-	flag.Parse()
+	return defaultSubcommand, args
+}
+
+// runEstimate estimates the Autopilot cost of the workloads of the current cluster, or of the clusters of a fleet.
+// With compareOnly, the text output is limited to the Standard nodes compared with the Autopilot cost of their
+// workloads.
+func runEstimate(args []string, compareOnly bool) {
+	options := parseEstimateFlags(args, compareOnly)
+
+	if options.printSchema {
+		schema, err := reportSchemaFS.ReadFile(reportSchemaFile)
+		if err != nil {
+			log.Fatalf("Error reading the json schema: %v", err)
+		}
+		os.Stdout.Write(schema)
+		return
+	}
+
+	// Warnings are also collected for the json output, where logs on stderr are easily lost
+	warnings := newWarningCollector(options.logs.setup().Handler())
+	slog.SetDefault(slog.New(warnings))
+
+	if err := options.parse(); err != nil {
+		fatal("Invalid flags", "error", err)
+	}
+	costPrecision = options.precision
+	rawUnits = options.rawUnits
+	options.colors = ConfigureOutput(os.Stdout, options.noColor)
+
+	if options.fleet != "" {
+		runFleetEstimate(options)
+		return
+	}
+	runClusterEstimate(options, warnings)
+}
+
+// runClusterEstimate estimates the workloads of the cluster of the current kubectl context, and writes the report to
+// the outputs of the flags. It exits with the code of the budget checks.
+func runClusterEstimate(options *estimateOptions, warnings *warningCollector) {
+	ctx := context.Background()
 
 	// Setting up kube configurations
 	kubeConfig, kubeConfigPath, err := cluster.GetKubeConfig()
 	if err != nil {
-		log.Fatalf("Error getting kubernetes config: %v\n", err)
+		fatal("Error getting kubernetes config", "error", err)
 	}
 
-	clientset, err := kubernetes.NewForConfig(kubeConfig)
+	svc, err := container.NewService(ctx)
 	if err != nil {
-		log.Fatalf("Error setting kubernetes config: %v\n", err)
+		fatal("Error initializing GKE client", "error", err)
 	}
 
-	metricsClientset, err := metricsv.NewForConfig(kubeConfig)
+	// Extract the information out of kube config file
+	currentContext, err := cluster.GetCurrentContext(kubeConfigPath)
 	if err != nil {
-		log.Fatalf("Error setting kubernetes metrics config: %v\n", err)
+		fatal("Error getting GKE context", "error", err)
 	}
 
-	svc, err := container.NewService(context.Background())
+	clusterProject, err := resolveClusterProject(options.project, currentContext)
 	if err != nil {
-		log.Fatalf("Error initializing GKE client: %v", err)
+		fatal("Error getting the cluster project", "error", err)
 	}
+	// The location is a zone for zonal clusters, the pricing and the reports use its region
+	currentCluster := fleetCluster{Project: clusterProject, Location: currentContext[2], Name: currentContext[3]}
 
-	// Extract the information out of kube config file
-	currentContext, err := cluster.GetCurrentContext(kubeConfigPath)
+	fees, err := clusterFees([]fleetCluster{currentCluster}, clusterFee(options.cfg), options.freeTierCluster)
 	if err != nil {
-		log.Fatalf("Error getting GKE context: %v", err)
+		fatal("Error applying the free tier", "error", err)
 	}
 
-	clusterName := currentContext[3]
-	clusterRegion := currentContext[2]
-	clusterProject := currentContext[1]
-	clusterLocation := fmt.Sprintf("projects/%s/locations/%s/clusters/%s", clusterProject, clusterRegion, clusterName)
+	clusterObject, err := newClusterCache(svc).Get(currentCluster.path())
+	if err != nil {
+		fatal("Error getting GKE cluster information", "cluster", currentCluster.Name, "error", err)
+	}
 
-	clusterObject, err := svc.Projects.Locations.Clusters.Get(clusterLocation).Do()
+	// Autopilot clusters are billed per pod already, so their workloads can still be reported on
+	if isAutopilot(clusterObject) {
+		if !options.allowAutopilot {
+			fatal("This is already an Autopilot cluster, aborting. Use -allow-autopilot to report the cost of its workloads.")
+		}
+		if options.compareOnly {
+			fatal("This is already an Autopilot cluster, there are no Standard nodes to compare with.")
+		}
+		slog.Warn("This is already an Autopilot cluster, reporting the current cost of its workloads without comparing to Standard.")
+	}
+
+	estimate, err := options.newClusterEstimate(currentCluster, clusterObject, kubeConfig, fees[currentCluster.path()])
 	if err != nil {
-		log.Fatalf("Error getting GKE cluster information: %s, %v", clusterName, err)
+		fatal("Error setting up the estimate of the cluster", "error", err)
 	}
 
-	if clusterObject.Autopilot != nil && clusterObject.Autopilot.Enabled {
-		log.Fatalf("This is already an Autopilot cluster, `aborting`")
+	if options.explainPricing {
+		displayPricingExplanation(os.Stdout, calculator.RegionPricing{Autopilot: estimate.pricingService.AutopilotPricing, GCE: estimate.pricingService.GCEPricing}.Explain())
+		return
+	}
+	if err := estimate.configure(ctx, options); err != nil {
+		fatal("Error configuring the pricing service", "error", err)
 	}
 
-	nodes, err := cluster.GetClusterNodes(clientset)
+	estimate.nodes, estimate.workloads, err = estimate.estimateWorkloads(options, true)
+	if errors.Is(err, calculator.ErrMetricsForbidden) {
+		fmt.Fprintf(os.Stderr, "The credentials need to be bound to a ClusterRole like:\n\n%s\n\n", calculator.RequiredClusterRole)
+	}
+	if err != nil {
+		fatal("Error estimating the workloads", "error", err)
+	}
+
+	if options.watch {
+		if !terminal {
+			fatal("Watch mode needs a terminal")
+		}
+		if err := options.watchTable(estimate); err != nil {
+			fatal("Error displaying table", "error", err)
+		}
+		return
+	}
+
+	// Actual spend of the Standard cluster, to compare the estimate with
+	billedHourlyCost, err := options.billedHourlyCost(ctx, estimate)
+	if err != nil {
+		fatal("Error getting billed cluster cost", "error", err)
+	}
+
+	report, err := options.buildReport(estimate, billedHourlyCost)
+	if err != nil {
+		fatal("Error building the report", "error", err)
+	}
+
+	if exitCode := options.writeOutputs(estimate, report, warnings); exitCode != 0 {
+		os.Exit(exitCode)
+	}
+}
+
+// runPricing prints the Autopilot and GCE prices of a region as json, as read from the Cloud Billing Catalog.
+func runPricing(args []string) {
+	flags := flag.NewFlagSet("pricing", flag.ExitOnError)
+	regionFlag := flags.String("region", "", "Region to print the prices of, eg. us-central1")
+	explainPricingFlag := flags.Bool("explain-pricing", false, "Print the SKU each price field was set from instead of the json price lists")
+	skuMapFlag := flags.String("sku-map", "", "JSON file mapping price fields to regular expressions of their SKU descriptions, to override the built-in matching")
+	billingProjectFlag := flags.String("billing-project", "", "Project billed for the quota of the Cloud Billing API requests, defaults to the one of the credentials")
+	pricingDateFlag := flags.String("pricing-date", "", "Date of the prices to estimate with, as 2006-01-02 or RFC 3339, for historical estimates or scheduled price changes, defaults to now")
+	logs := addLogFlags(flags, "Only write the prices, without logs below errors")
+	flags.Parse(args)
+	logs.setup()
+
+	pricingDate, err := parsePricingDate(*pricingDateFlag)
+	if err != nil {
+		fatal("Invalid -pricing-date", "error", err)
+	}
+
+	if *regionFlag == "" {
+		fatal("-region is required")
+	}
+
+	cfg, err := ini.Load("config.ini")
 	if err != nil {
-		log.Fatalf("Error getting cluster nodes: %v", err)
+		fatal("Error loading config.ini", "error", err)
 	}
 
+	pricingSKUs, skuMap, billingOptions, err := pricingSources(cfg, *skuMapFlag, *billingProjectFlag)
+	if err != nil {
+		fatal("Error loading sku map", "error", err)
+	}
+
+	pricing, err := calculator.GetRegionPricing(pricingSKUs, skuMap, *regionFlag, pricingDate, billingOptions...)
+	if err != nil {
+		fatal("Error getting the pricing of the region", "region", *regionFlag, "error", err)
+	}
+
+	if *explainPricingFlag {
+		displayPricingExplanation(os.Stdout, pricing.Explain())
+		return
+	}
+	contents, _ := json.MarshalIndent(pricing, "", "    ")
+	fmt.Printf("%s\n", contents)
+}
+
+// runWhatIf estimates the Autopilot cost of the workloads of a manifest before deploying them, from their requests
+// and replicas and the pricing of a region, without cluster access.
+func runWhatIf(args []string) {
+	flags := flag.NewFlagSet("what-if", flag.ExitOnError)
+	manifestFlag := flags.String("manifest", "", "YAML or JSON manifest of the Pods, Deployments, StatefulSets, ReplicaSets or Jobs to estimate")
+	regionFlag := flags.String("region", "", "Region to price the workloads in, eg. us-central1")
+	jsonFlag := flags.Bool("json", false, "Print the estimates as json")
+	gkeVersionFlag := flags.String("gke-version", "", "Apply the Autopilot rules of a GKE version (eg. 1.23), defaults to the current rules")
+	skuMapFlag := flags.String("sku-map", "", "JSON file mapping price fields to regular expressions of their SKU descriptions, to override the built-in matching")
+	billingProjectFlag := flags.String("billing-project", "", "Project billed for the quota of the Cloud Billing API requests, defaults to the one of the credentials")
+	pricingDateFlag := flags.String("pricing-date", "", "Date of the prices to estimate with, as 2006-01-02 or RFC 3339, for historical estimates or scheduled price changes, defaults to now")
+	logs := addLogFlags(flags, "Only write the estimates, without logs below errors")
+	flags.Parse(args)
+	logs.setup()
+
+	pricingDate, err := parsePricingDate(*pricingDateFlag)
+	if err != nil {
+		fatal("Invalid -pricing-date", "error", err)
+	}
+
+	if *manifestFlag == "" || *regionFlag == "" {
+		fatal("-manifest and -region are required")
+	}
+
+	manifest, err := os.Open(*manifestFlag)
+	if err != nil {
+		fatal("Error opening manifest", "error", err)
+	}
+	workloads, err := calculator.ParseManifest(manifest)
+	manifest.Close()
+	if err != nil {
+		fatal("Error parsing manifest", "error", err)
+	}
+
+	cfg, err := ini.Load("config.ini")
+	if err != nil {
+		fatal("Error loading config.ini", "error", err)
+	}
+
+	pricingSKUs, skuMap, billingOptions, err := pricingSources(cfg, *skuMapFlag, *billingProjectFlag)
+	if err != nil {
+		fatal("Error loading sku map", "error", err)
+	}
+
+	pricingService, err := calculator.NewService(pricingSKUs, skuMap, *regionFlag, pricingDate, nil, nil, cfg, billingOptions...)
+	if err != nil {
+		fatal("Error initializing pricing service", "error", err)
+	}
+	pricingService.RulesVersion, err = calculator.RulesVersionFor(cfg, *gkeVersionFlag)
+	if err != nil {
+		fatal("Error selecting the Autopilot rules", "error", err)
+	}
+
+	estimates := pricingService.EstimateManifest(workloads)
+	if *jsonFlag {
+		contents, _ := json.MarshalIndent(estimates, "", "    ")
+		fmt.Printf("%s\n", contents)
+		return
+	}
+	displayManifestEstimates(os.Stdout, estimates)
+}
+
+// displayManifestEstimates writes the cost of each workload of a manifest per pod and for all its replicas, and
+// the total.
+func displayManifestEstimates(w io.Writer, estimates []calculator.ManifestEstimate) {
+	total := 0.0
+	for _, estimate := range estimates {
+		spot := ""
+		if estimate.Spot {
+			spot = ", spot"
+		}
+		fmt.Fprintf(w, "%s %s: %d x %d mCPU, %d MB memory, %d MB storage on %s%s, $%.4f per pod per hour, $%.4f per hour, $%.2f per month\n",
+			estimate.Kind, estimate.Name, estimate.Replicas, estimate.Cpu, estimate.Memory, estimate.Storage, estimate.Class, spot,
+			estimate.PodCost, estimate.TotalCost, estimate.TotalCost*calculator.HOURS_PER_MONTH)
+		total += estimate.TotalCost
+	}
+
+	fmt.Fprintf(w, "Total: $%.4f per hour, $%.2f per month, without the cluster fee\n", total, total*calculator.HOURS_PER_MONTH)
+}
+
+// displayPricingExplanation writes a line per price field with the ID and description of the SKU it was set from
+// and its price, or UNMATCHED when no SKU set it.
+func displayPricingExplanation(w io.Writer, sources []calculator.PriceSource) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "LIST\tFIELD\tPRICE\tSKU\tDESCRIPTION")
+	for _, source := range sources {
+		if source.SkuID == "" {
+			fmt.Fprintf(tw, "%s\t%s\tUNMATCHED\t\t\n", source.List, source.Field)
+			continue
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", source.List, source.Field, strconv.FormatFloat(source.Price, 'f', -1, 64), source.SkuID, source.Description)
+	}
+	tw.Flush()
+}
+
+// pricingSources returns the Cloud Billing services of the config, the SKU map and the Cloud Billing client
+// options the pricing is fetched with.
+func pricingSources(cfg *ini.File, skuMapPath string, billingProject string) (map[string]string, calculator.SKUMap, []option.ClientOption, error) {
 	pricingSKUs := map[string]string{
 		"autopilot": cfg.Section("").Key("autopilot_sku").String(),
 		"gce":       cfg.Section("").Key("gce_sku").String(),
 	}
-	pricingService, err := calculator.NewService(pricingSKUs, clusterRegion, clientset, metricsClientset, cfg)
+
+	var skuMap calculator.SKUMap
+	if skuMapPath != "" {
+		var err error
+		skuMap, err = calculator.LoadSKUMap(skuMapPath)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	var billingOptions []option.ClientOption
+	if billingProject != "" {
+		billingOptions = append(billingOptions, option.WithQuotaProject(billingProject))
+	}
+
+	return pricingSKUs, skuMap, billingOptions, nil
+}
+
+// currencyCode matches ISO 4217 currency codes like USD or EUR.
+var currencyCode = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// parseCurrencies parses the comma separated currencies of -currency, upper cased and without duplicates.
+func parseCurrencies(value string) ([]string, error) {
+	var currencies []string
+	for _, currency := range strings.Split(value, ",") {
+		currency = strings.ToUpper(strings.TrimSpace(currency))
+		if currency == "" || slices.Contains(currencies, currency) {
+			continue
+		}
+		if !currencyCode.MatchString(currency) {
+			return nil, fmt.Errorf("%q isn't a currency code like USD or EUR", currency)
+		}
+		currencies = append(currencies, currency)
+	}
+
+	return currencies, nil
+}
+
+// parsePricingDate parses the -pricing-date flag, a date like 2023-07-01 for midnight UTC or an RFC 3339 time. An
+// empty value is the zero time, for the current prices.
+func parsePricingDate(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if date, err := time.Parse(time.DateOnly, value); err == nil {
+		return date, nil
+	}
+
+	date, err := time.Parse(time.RFC3339, value)
 	if err != nil {
-		log.Fatalf("Error initializing pricing service: %v", err)
+		return time.Time{}, fmt.Errorf("expected a date like 2023-07-01 or an RFC 3339 time, got %q", value)
+	}
+	return date, nil
+}
+
+// logLevel returns the minimum level of the logs, quiet only keeps the errors.
+func logLevel(level string, quiet bool) string {
+	if quiet {
+		return "error"
+	}
+
+	return level
+}
+
+// newLogger returns a logger writing to w at the minimum level (debug, info, warn or error)
+// in the format (text or json).
+func newLogger(w io.Writer, level string, format string) (*slog.Logger, error) {
+	var logLevel slog.Level
+	if err := logLevel.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q: %v", level, err)
+	}
+
+	options := slog.HandlerOptions{Level: logLevel}
+	switch format {
+	case "text":
+		return slog.New(slog.NewTextHandler(w, &options)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, &options)), nil
+	}
+
+	return nil, fmt.Errorf("invalid log format %q, expected text or json", format)
+}
+
+// logFlags are the flags setting up the logs of a subcommand.
+type logFlags struct {
+	level  *string
+	format *string
+	quiet  *bool
+}
+
+// addLogFlags defines the -log-level, -log-format and -quiet flags on the flags of a subcommand. The usage of -quiet
+// tells what the subcommand still writes.
+func addLogFlags(flags *flag.FlagSet, quietUsage string) logFlags {
+	return logFlags{
+		level:  flags.String("log-level", "info", "Minimum level of the logs written to stderr: debug, info, warn or error"),
+		format: flags.String("log-format", "text", "Format of the logs written to stderr: text or json"),
+		quiet:  flags.Bool("quiet", false, quietUsage),
 	}
+}
 
-	workloads, err := pricingService.PopulateWorkloads(nodes)
+// setup makes the logger of the parsed flags the default one, used by slog and fatal, and returns it. It exits with
+// the standard logger when the flags are invalid.
+func (logs logFlags) setup() *slog.Logger {
+	logger, err := newLogger(os.Stderr, logLevel(*logs.level, *logs.quiet), *logs.format)
 	if err != nil {
-		log.Fatalf(err.Error())
+		log.Fatalf("Error setting up logging: %v", err)
 	}
+	slog.SetDefault(logger)
 
-	if *jsonFlag {
-		contents, _ := json.MarshalIndent(nodes, "", "    ")
+	return logger
+}
 
-		if *jsonFileFlag != "" {
-			jsonOutput, err := os.Create(*jsonFileFlag)
-			if err != nil {
-				log.Fatalf("Error creating file for json output: %s", err.Error())
-			}
+// fatal logs the error and exits, like log.Fatal does for the standard logger.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
 
-			_, err = jsonOutput.Write(contents)
-			if err != nil {
-				log.Printf("Error writing json to file: %s", err.Error())
-			}
-			log.Printf("JSON output saved to %s.", *jsonFileFlag)
-		} else {
-			fmt.Printf("%s", contents)
+// projectIDPattern matches project IDs, optionally scoped by a domain like example.com:my-project
+var projectIDPattern = regexp.MustCompile(`^([a-z0-9.-]+:)?[a-z][a-z0-9-]{4,28}[a-z0-9]$`)
+
+// resolveClusterProject returns the project of the cluster: the override when set, otherwise the project in the
+// name of the current context, like gke_PROJECT_LOCATION_CLUSTER.
+func resolveClusterProject(override string, currentContext []string) (string, error) {
+	project := override
+	if project == "" {
+		if len(currentContext) < 2 {
+			return "", fmt.Errorf("no project in the current context %q, set it with -project", strings.Join(currentContext, "_"))
 		}
+		project = currentContext[1]
+	}
+
+	if !projectIDPattern.MatchString(project) {
+		return "", fmt.Errorf("invalid project ID %q", project)
+	}
+
+	return project, nil
+}
+
+// displayReport writes the node and workload tables of the estimate to w. In report-only mode the cluster is
+// already Autopilot, so the nodes are left out and only the current cost of the workloads is shown. With -wide the
+// node table lists more details of the nodes, see DisplayNodeTable.
+func displayReport(w io.Writer, estimate *clusterEstimate, options *estimateOptions) {
+	clusterObject, nodes, workloads := estimate.clusterObject, estimate.nodes, estimate.workloads
+	fmt.Fprintln(w, pinkTextStyle.Render(fmt.Sprintf("Cluster %q (%s) on version: v%s", clusterObject.Name, clusterObject.Status, clusterObject.CurrentMasterVersion)))
+	fmt.Fprintln(w)
+
+	if estimate.reportOnly {
+		fmt.Fprintln(w, greenTextStyle.Render(fmt.Sprintf("%d workloads from your Autopilot cluster (%s) with their current cost.", len(workloads), clusterObject.Name)))
+	} else {
+		fmt.Fprintln(w, blueTextStyle.Render(fmt.Sprintf("Nodes that you currently have at your cluster in %s: %d", estimate.region, len(nodes))))
+		DisplayNodeTable(w, nodes, options.wide)
+		fmt.Fprintln(w)
+
+		fmt.Fprintln(w, greenTextStyle.Render(fmt.Sprintf("%d workloads from your cluster (%s) mapped to GKE Autopilot mode.", len(workloads), clusterObject.Name)))
+	}
+	fmt.Fprintln(w)
 
+	// The table still shows the totals, which are down to the cluster fee
+	if len(workloads) == 0 {
+		fmt.Fprintln(w, redTextStyle.Render(noBillableWorkloadsMessage))
 	} else {
-		fmt.Println(pinkTextStyle.Render(fmt.Sprintf("Cluster %q (%s) on version: v%s", clusterObject.Name, clusterObject.Status, clusterObject.CurrentMasterVersion)))
-		fmt.Println()
+		fmt.Fprintln(w, redTextStyle.Render("Displayed values for mCPU, Memory and Storage are a snapshot of this point in time. Those are not requets/limits but currently used values"))
+	}
+
+	oneYearDiscount, threeYearDiscount, highlight := workloadTableSettings(options.cfg, options.colors)
+	DisplayWorkloadTable(w, nodes, oneYearDiscount, threeYearDiscount, estimate.fee, highlight, options.top, options.minCost, options.breakdown, options.showAdjustments)
+	if len(workloads) > 0 {
+		distribution := classDistribution(workloads)
+		fmt.Fprintln(w, blueTextStyle.Render("Workloads per compute class: "+formatClassDistribution(distribution)))
+		fmt.Fprintln(w, blueTextStyle.Render("Monthly cost per compute class: "+formatClassCosts(distribution)))
+	}
+}
 
-		fmt.Println(blueTextStyle.Render(fmt.Sprintf("Nodes that you currently have at your cluster in %s: %d", clusterRegion, len(nodes))))
-		DisplayNodeTable(nodes)
-		fmt.Println()
+// displayComparison writes the Standard nodes next to the Autopilot cost of their workloads, and the totals of the
+// nodes with a GCE price. The cluster fee is the same on both modes. Wide lists more details of the nodes.
+func displayComparison(w io.Writer, clusterRegion string, nodes map[string]cluster.Node, clusterFee float64, wide bool) {
+	fmt.Fprintln(w, blueTextStyle.Render(fmt.Sprintf("Nodes that you currently have at your cluster in %s: %d", clusterRegion, len(nodes))))
+	DisplayNodeTable(w, nodes, wide)
+	fmt.Fprintln(w)
 
-		oneYearDiscount, err := cfg.Section("discounts").Key("oneyear_commit").Float64()
-		if err != nil {
-			oneYearDiscount = 1
+	standard, autopilot, unpriced := comparisonTotals(nodes)
+	fmt.Fprintln(w, blueTextStyle.Render(fmt.Sprintf("Standard cost of the priced nodes: $%.2f per month, Autopilot cost of their workloads: $%.2f per month, both plus the cluster fee of $%.2f per month",
+		standard*calculator.HOURS_PER_MONTH, autopilot*calculator.HOURS_PER_MONTH, clusterFee*calculator.HOURS_PER_MONTH)))
+	if unpriced > 0 {
+		fmt.Fprintln(w, redTextStyle.Render(fmt.Sprintf("%d nodes have no GCE price and are left out, use -billing-export to compare with the billed cost", unpriced)))
+	}
+}
+
+// comparisonTotals returns the hourly Standard cost of the nodes with a GCE price and the Autopilot cost of their
+// workloads, and the number of nodes without a price.
+func comparisonTotals(nodes map[string]cluster.Node) (float64, float64, int) {
+	var standard, autopilot float64
+	unpriced := 0
+	for _, node := range nodes {
+		// Workloads without a listed node aren't running on a Standard node
+		if node.Name == cluster.UnscheduledNodeName {
+			continue
 		}
-		threeYearDiscount, err := cfg.Section("discounts").Key("threeyear_commit").Float64()
-		if err != nil {
-			threeYearDiscount = 1
+		if node.StandardCost <= 0 {
+			unpriced++
+			continue
 		}
 
-		fmt.Println(greenTextStyle.Render(fmt.Sprintf("%d workloads from your cluster (%s) mapped to GKE Autopilot mode.", len(workloads), clusterName)))
-		fmt.Println()
-		fmt.Println(redTextStyle.Render("Displayed values for mCPU, Memory and Storage are a snapshot of this point in time. Those are not requets/limits but currently used values"))
+		standard += node.StandardCost
+		autopilot += node.Cost
+	}
 
-		cluster_fee, err := cfg.Section("fees").Key("cluster_fee").Float64()
-		if err != nil {
-			cluster_fee = calculator.CLUSTER_FEE
+	return standard, autopilot, unpriced
+}
+
+// workloadTableSettings returns the commit discounts and, when colors are enabled, the cost highlights
+// of the workload table from the config.
+func workloadTableSettings(cfg *ini.File, colors bool) (float64, float64, *CostHighlight) {
+	oneYearDiscount, err := cfg.Section("discounts").Key("oneyear_commit").Float64()
+	if err != nil {
+		oneYearDiscount = 1
+	}
+	threeYearDiscount, err := cfg.Section("discounts").Key("threeyear_commit").Float64()
+	if err != nil {
+		threeYearDiscount = 1
+	}
+
+	var highlight *CostHighlight
+	if colors {
+		highlight = &CostHighlight{MediumShare: 0.05, HighShare: 0.2}
+		if share, err := cfg.Section("highlights").Key("cost_share_medium").Float64(); err == nil {
+			highlight.MediumShare = share
+		}
+		if share, err := cfg.Section("highlights").Key("cost_share_high").Float64(); err == nil {
+			highlight.HighShare = share
 		}
+	}
+
+	return oneYearDiscount, threeYearDiscount, highlight
+}
 
-		DisplayWorkloadTable(nodes, oneYearDiscount, threeYearDiscount, cluster_fee)
+// commitmentDiscount returns the multiplier of the on-demand prices for a 1y or 3y commitment from the discounts
+// config section, 1 without commitment.
+func commitmentDiscount(cfg *ini.File, commitment string) (float64, error) {
+	oneYearDiscount, threeYearDiscount, _ := workloadTableSettings(cfg, false)
+	switch commitment {
+	case "":
+		return 1, nil
+	case "1y":
+		return oneYearDiscount, nil
+	case "3y":
+		return threeYearDiscount, nil
 	}
+
+	return 0, fmt.Errorf("unknown commitment %q, use 1y or 3y", commitment)
+}
+
+// clusterFee returns the hourly cluster management fee from the config, or the default one.
+func clusterFee(cfg *ini.File) float64 {
+	fee, err := cfg.Section("fees").Key("cluster_fee").Float64()
+	if err != nil {
+		return calculator.CLUSTER_FEE
+	}
+
+	return fee
+}
+
+// billableWorkloads returns the workloads without the system ones, which are normally managed by GKE.
+func billableWorkloads(workloads []cluster.Workload) []cluster.Workload {
+	var billable []cluster.Workload
+	for _, workload := range workloads {
+		if !workload.System {
+			billable = append(billable, workload)
+		}
+	}
+
+	return billable
+}
+
+// isAutopilot returns whether the GKE cluster is in Autopilot mode. Older clusters and partial responses of the GKE
+// API have no Autopilot field, they are treated as Standard clusters.
+func isAutopilot(clusterObject *container.Cluster) bool {
+	return clusterObject.Autopilot != nil && clusterObject.Autopilot.Enabled
+}
+
+// clusterMachineFamilies returns the machine families of the node pools of the cluster. It returns false when the
+// cluster can run every compute class: Autopilot clusters and clusters creating node pools with node
+// auto-provisioning.
+func clusterMachineFamilies(clusterObject *container.Cluster) ([]string, bool) {
+	if isAutopilot(clusterObject) || (clusterObject.Autoscaling != nil && clusterObject.Autoscaling.EnableNodeAutoprovisioning) {
+		return nil, false
+	}
+
+	var families []string
+	for _, nodePool := range clusterObject.NodePools {
+		if nodePool.Config == nil {
+			continue
+		}
+		family, _, _ := strings.Cut(nodePool.Config.MachineType, "-")
+		if family != "" && !slices.Contains(families, family) {
+			families = append(families, family)
+		}
+	}
+
+	return families, true
+}
+
+// clusterFees returns the cluster management fee of each cluster by its path. The free tier of a billing account
+// waives the fee of a single cluster, the freeTierCluster, which must be one of the clusters. It's either the name
+// of the cluster or, when several clusters have that name, its path. Empty waives none.
+func clusterFees(clusters []fleetCluster, fee float64, freeTierCluster string) (map[string]float64, error) {
+	fees := make(map[string]float64, len(clusters))
+	var names, waived []string
+	for _, fc := range clusters {
+		fees[fc.path()] = fee
+		names = append(names, fc.Name)
+		if freeTierCluster == fc.Name || freeTierCluster == fc.path() {
+			waived = append(waived, fc.path())
+		}
+	}
+
+	switch {
+	case freeTierCluster == "":
+		return fees, nil
+	case len(waived) == 0:
+		return nil, fmt.Errorf("free tier cluster %q is not one of the estimated clusters: %s", freeTierCluster, strings.Join(names, ", "))
+	case len(waived) > 1:
+		return nil, fmt.Errorf("free tier cluster %q matches several clusters, use one of their paths: %s", freeTierCluster, strings.Join(waived, ", "))
+	}
+	fees[waived[0]] = 0
+
+	return fees, nil
+}
+
+// clusterCache keeps the GKE clusters fetched by location, so repeated lookups within a run don't call the
+// GKE API again. Failed lookups aren't cached.
+type clusterCache struct {
+	service  *container.Service
+	clusters map[string]*container.Cluster
+}
+
+func newClusterCache(service *container.Service) *clusterCache {
+	return &clusterCache{service: service, clusters: make(map[string]*container.Cluster)}
+}
+
+// Get returns the cluster at the location, like projects/PROJECT/locations/LOCATION/clusters/NAME.
+func (cache *clusterCache) Get(location string) (*container.Cluster, error) {
+	if clusterObject, ok := cache.clusters[location]; ok {
+		return clusterObject, nil
+	}
+
+	clusterObject, err := cache.service.Projects.Locations.Clusters.Get(location).Do()
+	if err != nil {
+		return nil, err
+	}
+	cache.clusters[location] = clusterObject
+
+	return clusterObject, nil
+}
+
+// othersWorkloadName names the workload aggregating the ones below the minimum cost.
+const othersWorkloadName = "others"
+
+// mixedClassName is the compute class shown for the others workload.
+const mixedClassName = "Mixed"
+
+// workloadClassName returns the name of the compute class of the workload, mixedClassName for the others workload.
+func workloadClassName(workload cluster.Workload) string {
+	if workload.ComputeClass == cluster.ComputeClassMixed {
+		return mixedClassName
+	}
+
+	return cluster.ComputeClasses[workload.ComputeClass]
+}
+
+// aggregateCheapWorkloads replaces the workloads costing less than minCost per hour with a single others workload
+// summing their resources and costs, so the totals don't change. A minCost of 0 keeps all the workloads. The others
+// workload has no node and the ComputeClassMixed class, as its workloads can run on several of both.
+func aggregateCheapWorkloads(workloads []cluster.Workload, minCost float64) []cluster.Workload {
+	var kept []cluster.Workload
+	others := cluster.Workload{Name: othersWorkloadName, ComputeClass: cluster.ComputeClassMixed}
+	for _, workload := range workloads {
+		// System workloads aren't billable, so they aren't aggregated with the billable ones
+		if workload.Cost >= minCost || workload.System {
+			kept = append(kept, workload)
+			continue
+		}
+
+		others.Containers += workload.Containers
+		others.Cpu += workload.Cpu
+		others.Memory += workload.Memory
+		others.RawCpu += workload.RawCpu
+		others.RawMemory += workload.RawMemory
+		others.Storage += workload.Storage
+		others.Cost += workload.Cost
+		others.SpotCost += workload.SpotCost
+		others.RequestCost += workload.RequestCost
+		others.UsageCost += workload.UsageCost
+		others.LimitCost += workload.LimitCost
+		others.Burstable = others.Burstable || workload.Burstable
+		others.Breakdown.CPU += workload.Breakdown.CPU
+		others.Breakdown.Memory += workload.Breakdown.Memory
+		others.Breakdown.Storage += workload.Breakdown.Storage
+		others.Breakdown.GPU += workload.Breakdown.GPU
+		others.Breakdown.Total += workload.Breakdown.Total
+		others.PercentOfTotal += workload.PercentOfTotal
+	}
+
+	if len(kept) == len(workloads) {
+		return workloads
+	}
+
+	return append(kept, others)
+}
+
+// summaryLine returns a single line with the headline numbers for scripts. The keys are stable.
+func summaryLine(clusterName string, clusterRegion string, workloads []cluster.Workload, clusterFee float64) string {
+	totalHourly := clusterFee
+	for _, workload := range workloads {
+		totalHourly += workload.Cost
+	}
+
+	return fmt.Sprintf("TOTAL_HOURLY=%.2f TOTAL_MONTHLY=%.2f CLUSTER=%s REGION=%s WORKLOADS=%d", totalHourly, totalHourly*calculator.HOURS_PER_MONTH, clusterName, clusterRegion, len(workloads))
+}
+
+// checkBudget compares the estimated monthly cost with the monthly budget. When it's exceeded, the costliest
+// workloads that push it over are written to w and the exit code 2 is returned, 0 otherwise.
+func checkBudget(w io.Writer, workloads []cluster.Workload, clusterFee float64, budget float64) int {
+	monthlyCost := clusterFee * calculator.HOURS_PER_MONTH
+	for _, workload := range workloads {
+		monthlyCost += workload.Cost * calculator.HOURS_PER_MONTH
+	}
+
+	if monthlyCost <= budget {
+		return 0
+	}
+
+	fmt.Fprintf(w, "Estimated monthly cost $%.2f exceeds the budget of $%.2f by $%.2f.\n", monthlyCost, budget, monthlyCost-budget)
+	fmt.Fprintln(w, "Costliest workloads pushing it over the budget:")
+
+	sorted := make([]cluster.Workload, len(workloads))
+	copy(sorted, workloads)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Cost > sorted[j].Cost
+	})
+
+	over := monthlyCost - budget
+	for _, workload := range sorted {
+		if over <= 0 {
+			break
+		}
+		fmt.Fprintf(w, "  %s: $%.2f per month\n", workload.Name, workload.Cost*calculator.HOURS_PER_MONTH)
+		over -= workload.Cost * calculator.HOURS_PER_MONTH
+	}
+
+	return 2
+}
+
+// namespaceMonthlyCosts groups the monthly cost of the workloads by namespace. The cluster fee isn't attributed
+// to any namespace.
+func namespaceMonthlyCosts(workloads []cluster.Workload) map[string]float64 {
+	costs := map[string]float64{}
+	for _, workload := range workloads {
+		costs[workload.Namespace] += workload.Cost * calculator.HOURS_PER_MONTH
+	}
+
+	return costs
+}
+
+// checkNamespaceBudgets compares the estimated monthly cost of each namespace with its monthly budget. The
+// namespaces over their budget are written to w and the exit code 2 is returned, 0 otherwise.
+func checkNamespaceBudgets(w io.Writer, workloads []cluster.Workload, budgets map[string]float64) int {
+	costs := namespaceMonthlyCosts(workloads)
+
+	namespaces := make([]string, 0, len(budgets))
+	for namespace := range budgets {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+
+	exitCode := 0
+	for _, namespace := range namespaces {
+		cost, budget := costs[namespace], budgets[namespace]
+		if cost <= budget {
+			continue
+		}
+
+		fmt.Fprintf(w, "Estimated monthly cost $%.2f of namespace %s exceeds its budget of $%.2f by $%.2f.\n", cost, namespace, budget, cost-budget)
+		exitCode = 2
+	}
+
+	return exitCode
+}
+
+// costPercent returns the cost as a percentage of the total, or 0 when the total is 0.
+func costPercent(cost float64, total float64) float64 {
+	if total <= 0 {
+		return 0
+	}
+
+	return cost / total * 100
+}
+
+// setPercentOfTotal sets the share of the cluster total of every workload, both in the workloads and on
+// the nodes. The cluster fee is only part of the total when includeFee is set.
+func setPercentOfTotal(nodes map[string]cluster.Node, workloads []cluster.Workload, clusterFee float64, includeFee bool) {
+	total := 0.0
+	if includeFee {
+		total = clusterFee
+	}
+	for _, workload := range workloads {
+		if !workload.System {
+			total += workload.Cost
+		}
+	}
+
+	for i := range workloads {
+		workloads[i].PercentOfTotal = costPercent(workloads[i].Cost, total)
+	}
+	for _, node := range nodes {
+		for i := range node.Workloads {
+			node.Workloads[i].PercentOfTotal = costPercent(node.Workloads[i].Cost, total)
+		}
+	}
+}
+
+// spotHourlyCost sums the cost of the billable workloads on spot nodes, which are priced as Spot Pods.
+func spotHourlyCost(nodes map[string]cluster.Node) float64 {
+	total := 0.0
+	for _, node := range nodes {
+		if node.Spot {
+			total += node.Cost
+		}
+	}
+
+	return total
+}
+
+// estimatedHourlyCost sums the cost of all the billable workloads on the nodes plus the cluster fee. Workloads on spot
+// nodes are costed as Spot Pods, like the billed cost of the spot nodes they are compared with.
+func estimatedHourlyCost(nodes map[string]cluster.Node, clusterFee float64) float64 {
+	total := clusterFee
+	for _, node := range nodes {
+		for _, workload := range node.Workloads {
+			if workload.System {
+				continue
+			}
+			total += workload.Cost
+		}
+	}
+
+	return total
 }
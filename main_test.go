@@ -15,13 +15,55 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
 	"log"
 	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/GoogleCloudPlatform/autopilot-cost-calculator/calculator"
 	"github.com/GoogleCloudPlatform/autopilot-cost-calculator/cluster"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/muesli/termenv"
+	"golang.org/x/exp/slog"
+	"google.golang.org/api/bigquery/v2"
+	"google.golang.org/api/cloudbilling/v1"
+	container "google.golang.org/api/container/v1"
+	"google.golang.org/api/gkehub/v1"
+	"google.golang.org/api/monitoring/v3"
+	"google.golang.org/api/option"
+	"google.golang.org/api/storage/v1"
 	"gopkg.in/ini.v1"
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	metricsapi "k8s.io/metrics/pkg/apis/metrics/v1beta1"
+	metricsfake "k8s.io/metrics/pkg/client/clientset/versioned/fake"
 )
 
 const (
@@ -159,7 +201,7 @@ func TestValidateAndRoundResources(t *testing.T) {
 	var memoryWant int64 = 1000
 	var storageWant int64 = 1000
 
-	cpu, memory, storage := calculator.ValidateAndRoundResources(1000, 1000, 1000)
+	cpu, memory, storage := service.ValidateAndRoundResources(1000, 1000, 1000)
 	if cpu != cpuWant || memory != memoryWant || storage != storageWant {
 		t.Fatalf(`ValidateAndRoundResources(1000,1000,1000) = %d, %d, %d doesn't match expected %d %d %d`, cpu, memory, storage, cpuWant, memoryWant, storageWant)
 	}
@@ -169,7 +211,7 @@ func TestValidateAndRoundResources(t *testing.T) {
 	memoryWant = 52
 	storageWant = 10
 
-	cpu, memory, storage = calculator.ValidateAndRoundResources(249, 49, 9)
+	cpu, memory, storage = service.ValidateAndRoundResources(249, 49, 9)
 	if cpu != cpuWant || memory != memoryWant || storage != storageWant {
 		t.Fatalf(`ValidateAndRoundResources(249,52,5) = %d, %d, %d doesn't match expected %d %d %d`, cpu, memory, storage, cpuWant, memoryWant, storageWant)
 	}
@@ -179,13 +221,60 @@ func TestValidateAndRoundResources(t *testing.T) {
 	memoryWant = 1700
 	storageWant = 900
 
-	cpu, memory, storage = calculator.ValidateAndRoundResources(1618, 1700, 900)
+	cpu, memory, storage = service.ValidateAndRoundResources(1618, 1700, 900)
 	if cpu != cpuWant || memory != memoryWant || storage != storageWant {
 		t.Fatalf(`ValidateAndRoundResources(1650, 1700, 900) = %d, %d, %d doesn't match expected %d %d %d`, cpu, memory, storage, cpuWant, memoryWant, storageWant)
 	}
 
 }
 
+func TestRoundResources(t *testing.T) {
+	// Test Case #1
+	var cpuWant int64 = 300
+	var memoryWant int64 = 600
+
+	cpu, memory := service.RoundResources(cluster.ComputeClassGeneralPurpose, 300, 600)
+	if cpu != cpuWant || memory != memoryWant {
+		t.Fatalf(`RoundResources(General-purpose, 300, 600) = %d, %d doesn't match expected %d %d`, cpu, memory, cpuWant, memoryWant)
+	}
+
+	// Test Case #2
+	cpuWant = 500
+	memoryWant = 2000
+
+	cpu, memory = service.RoundResources(cluster.ComputeClassScaleout, 300, 600)
+	if cpu != cpuWant || memory != memoryWant {
+		t.Fatalf(`RoundResources(Scale-out, 300, 600) = %d, %d doesn't match expected %d %d`, cpu, memory, cpuWant, memoryWant)
+	}
+
+	// Test Case #3
+	cpuWant = 500
+	memoryWant = 600
+
+	cpu, memory = service.RoundResources(cluster.ComputeClassBalanced, 300, 600)
+	if cpu != cpuWant || memory != memoryWant {
+		t.Fatalf(`RoundResources(Balanced, 300, 600) = %d, %d doesn't match expected %d %d`, cpu, memory, cpuWant, memoryWant)
+	}
+
+	// Test Case #4
+	cpuWant = 300
+	memoryWant = 300
+
+	cpu, memory = service.RoundResources(cluster.ComputeClassGeneralPurpose, 260, 200)
+	if cpu != cpuWant || memory != memoryWant {
+		t.Fatalf(`RoundResources(General-purpose, 260, 200) = %d, %d doesn't match expected %d %d`, cpu, memory, cpuWant, memoryWant)
+	}
+
+	// Test Case #5
+	cpuWant = 1000
+	memoryWant = 6500
+
+	cpu, memory = service.RoundResources(cluster.ComputeClassGeneralPurpose, 300, 6500)
+	if cpu != cpuWant || memory != memoryWant {
+		t.Fatalf(`RoundResources(General-purpose, 300, 6500) = %d, %d doesn't match expected %d %d`, cpu, memory, cpuWant, memoryWant)
+	}
+}
+
 func TestDecideComputeClass(t *testing.T) {
 	// Test Case #1
 	computeClassWant := cluster.ComputeClassGeneralPurpose
@@ -213,13 +302,203 @@ func TestDecideComputeClass(t *testing.T) {
 
 }
 
+func TestExplainComputeClass(t *testing.T) {
+	testCases := []struct {
+		machineType string
+		mCPU        int64
+		memory      int64
+		arm64       bool
+		class       cluster.ComputeClass
+		reason      string
+	}{
+		{"e2-standard-4", 1000, 4000, false, cluster.ComputeClassGeneralPurpose, "ratio 4 within General-purpose 1-6.5"},
+		{"e2-standard-4", 40000, 160000, false, cluster.ComputeClassScaleout, "mCPU 40000 above General-purpose maximum 30000, ratio 4 within Scale-out 4-4"},
+		{"e2-standard-4", 35000, 10000, false, cluster.ComputeClassBalanced, "mCPU 35000 above General-purpose maximum 30000, ratio 1 outside Scale-out 4-4, ratio 1 within Balanced 1-8"},
+		{"c2-standard-8", 1000, 4000, false, cluster.ComputeClassPerformance, "machine type c2-standard-8 is compute optimized"},
+		{"t2a-standard-4", 1000, 4000, true, cluster.ComputeClassScaleoutArm, "arm64 node, ratio 4"},
+	}
+
+	for i, testCase := range testCases {
+		class, reason := service.ExplainComputeClass("test-pod", testCase.machineType, testCase.mCPU, testCase.memory, 0, "", testCase.arm64)
+		if class != testCase.class || reason != testCase.reason {
+			t.Fatalf(`#%d ExplainComputeClass(%s, %d, %d) = %s, %q doesn't match expected %s, %q`, i+1, testCase.machineType, testCase.mCPU, testCase.memory, cluster.ComputeClasses[class], reason, cluster.ComputeClasses[testCase.class], testCase.reason)
+		}
+	}
+
+	nodes := testNodes()
+	entry := nodes["node-1"]
+	entry.Workloads = []cluster.Workload{{Name: "test-pod", Cost: 0.01, ClassReason: testCases[0].reason}}
+	nodes["node-1"] = entry
+
+	var output bytes.Buffer
+	DisplayWorkloadTable(&output, nodes, 1, 1, 0.1, nil, 0, 0, false, false)
+	if !strings.Contains(output.String(), "Class Reason") || !strings.Contains(output.String(), testCases[0].reason) {
+		t.Fatalf(`DisplayWorkloadTable() output doesn't contain the class reason column: %q`, output.String())
+	}
+}
+
+func TestDecideComputeClassIdleWorkloads(t *testing.T) {
+	memory := resource.MustParse("8Gi")
+	idleMemory := cluster.MB(&memory)
+
+	// Test Case #1
+	computeClass, reason := service.ExplainComputeClass("idle-pod", "e2-standard-4", 250, idleMemory, 0, "", false)
+	if computeClass != cluster.ComputeClassBalanced || !strings.HasSuffix(reason, "ratio 8 within Balanced 1-8, ratio capped") {
+		t.Fatalf(`ExplainComputeClass(250, %d) = %s, %q doesn't match expected Balanced with a capped ratio`, idleMemory, cluster.ComputeClasses[computeClass], reason)
+	}
+
+	// Test Case #2
+	cpu, _ := service.RoundResources(computeClass, 250, idleMemory)
+	if cpu != 1250 {
+		t.Fatalf(`RoundResources(Balanced, 250, %d) = %d doesn't match expected 1250`, idleMemory, cpu)
+	}
+
+	// Test Case #3
+	computeClass = service.DecideComputeClass("idle-pod", "e2-standard-4", 0, idleMemory, 0, "", false)
+	if computeClass != cluster.ComputeClassBalanced {
+		t.Fatalf(`DecideComputeClass(0, %d) = %s doesn't match expected Balanced`, idleMemory, cluster.ComputeClasses[computeClass])
+	}
+}
+
+func TestDecideComputeClassHighMemoryRatio(t *testing.T) {
+	// 1 vCPU for 10 GB of memory, above the maximum ratio of every class
+
+	// Test Case #1
+	computeClass, reason := service.ExplainComputeClass("cache", "e2-standard-4", 1000, 10000, 0, "", false)
+	if computeClass != cluster.ComputeClassBalanced || !strings.HasSuffix(reason, "ratio 8 within Balanced 1-8, ratio capped") {
+		t.Fatalf(`ExplainComputeClass(1000, 10000) = %s, %q doesn't match expected Balanced with a capped ratio`, cluster.ComputeClasses[computeClass], reason)
+	}
+	if cpu, memory := service.RoundResources(computeClass, 1000, 10000); cpu != 1250 || memory != 10000 {
+		t.Fatalf(`RoundResources(Balanced, 1000, 10000) = %d, %d doesn't match expected 1250, 10000`, cpu, memory)
+	}
+
+	// Test Case #2
+	// Without Balanced, the ratio is capped to the General-purpose one instead of falling back to it as unmatched
+	testService := service
+	testService.AllowedClasses = map[cluster.ComputeClass]bool{cluster.ComputeClassGeneralPurpose: true}
+	computeClass, reason = testService.ExplainComputeClass("cache", "e2-standard-4", 1000, 10000, 0, "", false)
+	if computeClass != cluster.ComputeClassGeneralPurpose || reason != "ratio 6.5 within General-purpose 1-6.5, ratio capped" {
+		t.Fatalf(`ExplainComputeClass(1000, 10000) with General-purpose only = %s, %q doesn't match expected General-purpose with a capped ratio`, cluster.ComputeClasses[computeClass], reason)
+	}
+	if cpu, memory := testService.RoundResources(computeClass, 1000, 10000); cpu != 1550 || memory != 10000 {
+		t.Fatalf(`RoundResources(General-purpose, 1000, 10000) = %d, %d doesn't match expected 1550, 10000`, cpu, memory)
+	}
+
+	// Test Case #3
+	pod := testPod("default", "cache", "node-1", nil)
+	pod.Spec.Containers[0].Resources.Requests = corev1.ResourceList{
+		corev1.ResourceCPU:    resource.MustParse("1"),
+		corev1.ResourceMemory: resource.MustParse("10G"),
+	}
+	populateService := newTestService([]corev1.Pod{pod})
+	populateService.AllowedClasses = testService.AllowedClasses
+	workloads, err := populateService.PopulateWorkloads(testNodes())
+	if err != nil || len(workloads) != 1 || workloads[0].ComputeClass != cluster.ComputeClassGeneralPurpose || workloads[0].Cpu != 1550 {
+		t.Fatalf(`PopulateWorkloads() of a 1:10 pod with General-purpose only = %+v, %v doesn't match expected General-purpose billed for 1550 mCPU`, workloads, err)
+	}
+}
+
+func TestDecideComputeClassAllowedClasses(t *testing.T) {
+	testService := service
+	testService.AllowedClasses = map[cluster.ComputeClass]bool{
+		cluster.ComputeClassGeneralPurpose: true,
+		cluster.ComputeClassBalanced:       true,
+	}
+
+	// Test Case #1
+	computeClass := testService.DecideComputeClass("test-pod", "e2-standard-4", 40000, 160000, 0, "", false)
+	if computeClass != cluster.ComputeClassBalanced {
+		t.Fatalf(`DecideComputeClass(40000, 160000) without Scale-out = %s doesn't match expected %s`, cluster.ComputeClasses[computeClass], cluster.ComputeClasses[cluster.ComputeClassBalanced])
+	}
+
+	// Test Case #2
+	computeClass = testService.DecideComputeClass("test-pod", "t2a-standard-4", 1000, 4000, 0, "", true)
+	if computeClass != cluster.ComputeClassGeneralPurpose {
+		t.Fatalf(`DecideComputeClass(1000, 4000, true) without Scale-out arm64 = %s doesn't match expected %s`, cluster.ComputeClasses[computeClass], cluster.ComputeClasses[cluster.ComputeClassGeneralPurpose])
+	}
+
+	// Test Case #3
+	testService = service
+	testService.AutopilotPricing.CpuScaleoutPrice = 0
+	computeClass = testService.DecideComputeClass("test-pod", "e2-standard-4", 40000, 160000, 0, "", false)
+	if computeClass != cluster.ComputeClassBalanced {
+		t.Fatalf(`DecideComputeClass(40000, 160000) without Scale-out pricing = %s doesn't match expected %s`, cluster.ComputeClasses[computeClass], cluster.ComputeClasses[cluster.ComputeClassBalanced])
+	}
+
+	// Test Case #4
+	computeClass, err := cluster.ParseComputeClass(" scale-out arm64")
+	if err != nil || computeClass != cluster.ComputeClassScaleoutArm {
+		t.Fatalf(`ParseComputeClass(" scale-out arm64") = %d, %v doesn't match expected %d`, computeClass, err, cluster.ComputeClassScaleoutArm)
+	}
+	if _, err := cluster.ParseComputeClass("tiny"); err == nil {
+		t.Fatalf(`ParseComputeClass("tiny") = nil doesn't match expected error`)
+	}
+
+	// Test Case #5
+	// Above every class limit, the workload defaults to an allowed class instead of General-purpose
+	testService = service
+	testService.AllowedClasses = map[cluster.ComputeClass]bool{cluster.ComputeClassBalanced: true}
+	computeClass, reason := testService.ExplainComputeClass("test-pod", "e2-standard-4", 300000, 1200000, 0, "", false)
+	if computeClass != cluster.ComputeClassBalanced || !strings.HasSuffix(reason, "defaulted to Balanced") {
+		t.Fatalf(`ExplainComputeClass(300000, 1200000) with Balanced only = %s, %q doesn't match expected defaulted to Balanced`, cluster.ComputeClasses[computeClass], reason)
+	}
+
+	// Test Case #6
+	clusterObject := &container.Cluster{Name: "test-cluster", NodePools: []*container.NodePool{
+		{Name: "default-pool", Config: &container.NodeConfig{MachineType: "e2-standard-4"}},
+		{Name: "large-pool", Config: &container.NodeConfig{MachineType: "n2-highmem-8"}},
+	}}
+	families, ok := clusterMachineFamilies(clusterObject)
+	classes := service.ClusterComputeClasses(families)
+	if !ok || strings.Join(families, ",") != "e2,n2" || len(classes) != 2 || !classes[cluster.ComputeClassGeneralPurpose] || !classes[cluster.ComputeClassBalanced] {
+		t.Fatalf(`ClusterComputeClasses(%v) = %v doesn't match expected General-purpose and Balanced`, families, classes)
+	}
+	testService.AllowedClasses = classes
+	if computeClass := testService.DecideComputeClass("test-pod", "e2-standard-4", 40000, 160000, 0, "", false); computeClass != cluster.ComputeClassBalanced {
+		t.Fatalf(`DecideComputeClass(40000, 160000) on the cluster node pools = %s doesn't match expected %s`, cluster.ComputeClasses[computeClass], cluster.ComputeClasses[cluster.ComputeClassBalanced])
+	}
+
+	// Test Case #7
+	clusterObject.Autoscaling = &container.ClusterAutoscaling{EnableNodeAutoprovisioning: true}
+	if _, ok := clusterMachineFamilies(clusterObject); ok {
+		t.Fatalf(`clusterMachineFamilies() with node auto-provisioning = true doesn't match expected false`)
+	}
+}
+
+func TestValidateUpperLimits(t *testing.T) {
+	// Test Case #1
+	if !service.ValidateUpperLimits("test-pod", cluster.ComputeClassGeneralPurpose, "", 30000, 110000) {
+		t.Fatalf(`ValidateUpperLimits(General-purpose, 30000, 110000) = false doesn't match expected true`)
+	}
+
+	// Test Case #2
+	if service.ValidateUpperLimits("test-pod", cluster.ComputeClassGeneralPurpose, "", 30050, 110000) {
+		t.Fatalf(`ValidateUpperLimits(General-purpose, 30050, 110000) = true doesn't match expected false`)
+	}
+
+	// Test Case #3
+	if service.ValidateUpperLimits("test-pod", cluster.ComputeClassScaleoutArm, "", 43000, 172001) {
+		t.Fatalf(`ValidateUpperLimits(Scale-out arm64, 43000, 172001) = true doesn't match expected false`)
+	}
+
+	// Test Case #4
+	if !service.ValidateUpperLimits("test-pod", cluster.ComputeClassGPUPod, "nvidia-l4", 95000, 363000) {
+		t.Fatalf(`ValidateUpperLimits(GPU Pod, nvidia-l4, 95000, 363000) = false doesn't match expected true`)
+	}
+
+	// Test Case #5
+	if service.ValidateUpperLimits("test-pod", cluster.ComputeClassGPUPod, "nvidia-l4", 95050, 363000) {
+		t.Fatalf(`ValidateUpperLimits(GPU Pod, nvidia-l4, 95050, 363000) = true doesn't match expected false`)
+	}
+}
+
 func TestCalculatePricing(t *testing.T) {
 
 	// Test Case #1
 
 	computeClass := service.DecideComputeClass("test-pod", "e2-standard-4", 4000, 16000, 0, "", false)
 	priceWant := 0.3313796 // 0.000706 (cpu price * 4) + 0.1014736 (memory price * 16) +0.2292 (storage price * 10)
-	price := service.CalculatePricing(4000, 16000, 10000, 0, "", computeClass, "e2-standard-4", false)
+	price := service.CalculatePricing(4000, 16000, 10000, 0, "", computeClass, "e2-standard-4", false).Total
 
 	if !almostEqual(price, priceWant) {
 		t.Fatalf(`CalculatePricing(4000, 16000, 10000, {test-region-pricing}, %s, false) = %.7f doesn't match expected %.7f`, cluster.ComputeClasses[computeClass], price, priceWant)
@@ -228,7 +507,7 @@ func TestCalculatePricing(t *testing.T) {
 	// Test Case #2
 	computeClass = service.DecideComputeClass("test-pod", "e2-standard-4", 40000, 80000, 0, "", false)
 	priceWant = 4.0601700 // 3.324 (cpu price * 40) + 0.735464 (memory price * 80) + 0.2292 (storage price * 10)
-	price = service.CalculatePricing(40000, 80000, 10000, 0, "", computeClass, "e2-standard-4", false)
+	price = service.CalculatePricing(40000, 80000, 10000, 0, "", computeClass, "e2-standard-4", false).Total
 
 	if !almostEqual(price, priceWant) {
 		t.Fatalf(`CalculatePricing(4000, 16000, 10000, {test-region-pricing}, %s, false) = %.7f doesn't match expected %.7f`, cluster.ComputeClasses[computeClass], price, priceWant)
@@ -237,12 +516,4147 @@ func TestCalculatePricing(t *testing.T) {
 	// Test Case #3
 	computeClass = service.DecideComputeClass("test-pod", "e2-standard-4", 25000, 100000, 0, "", false)
 	priceWant = 0.6209660 // 0.43 (cpu spot price * 25) + 0.19026 (spot memory price * 100) + 0.000706 (spot storage price * 10)
-	price = service.CalculatePricing(25000, 100000, 10000, 0, "", computeClass, "e2-standard-4", true)
+	price = service.CalculatePricing(25000, 100000, 10000, 0, "", computeClass, "e2-standard-4", true).Total
 
 	if !almostEqual(price, priceWant) {
 		t.Fatalf(`CalculatePricing(4000, 16000, 10000, {test-region-pricing}, %s, false) = %.7f doesn't match expected %.7f`, cluster.ComputeClasses[computeClass], price, priceWant)
 	}
 
+	// Test Case #4
+	breakdown := service.CalculatePricing(4000, 16000, 10000, 0, "", cluster.ComputeClassGeneralPurpose, "e2-standard-4", false)
+	if !almostEqual(breakdown.Storage, 0.000706) || !almostEqual(breakdown.CPU+breakdown.Memory, breakdown.Total-0.000706) {
+		t.Fatalf(`CalculatePricing(4000, 16000, 10000, General-purpose, false) = %+v doesn't have the expected storage cost 0.000706`, breakdown)
+	}
+}
+
+func TestRulesVersions(t *testing.T) {
+	versions := []struct {
+		gkeVersion string
+		want       string
+	}{
+		{"", ""},
+		{"1.22.17-gke.1900", "1.23"},
+		{"v1.23", "1.23"},
+		{"1.27.3-gke.100", ""},
+	}
+	for i, version := range versions {
+		rulesVersion, err := calculator.RulesVersionFor(config, version.gkeVersion)
+		if err != nil || rulesVersion != version.want {
+			t.Fatalf(`Test Case #%d: RulesVersionFor(%q) = %q, %v doesn't match expected %q`, i+1, version.gkeVersion, rulesVersion, err, version.want)
+		}
+	}
+
+	// Test Case #5
+	if _, err := calculator.RulesVersionFor(config, "latest"); err == nil {
+		t.Fatalf(`RulesVersionFor("latest") error = nil doesn't match expected an invalid version error`)
+	}
+
+	// Test Case #6
+	legacyService := service
+	legacyService.RulesVersion = "1.23"
+	class := service.DecideComputeClass("test-pod", "e2-standard-4", 1000, 7000, 0, "", false)
+	legacyClass := legacyService.DecideComputeClass("test-pod", "e2-standard-4", 1000, 7000, 0, "", false)
+	if class != cluster.ComputeClassBalanced || legacyClass != cluster.ComputeClassGeneralPurpose {
+		t.Fatalf(`DecideComputeClass(1000, 7000) = %s, %s with rules 1.23 doesn't match expected Balanced, General-purpose`, cluster.ComputeClasses[class], cluster.ComputeClasses[legacyClass])
+	}
+
+	// Test Case #7
+	cpu, memory, storage := service.ValidateAndRoundResources(100, 100, 5)
+	legacyCPU, legacyMemory, legacyStorage := legacyService.ValidateAndRoundResources(100, 100, 5)
+	if cpu != 100 || memory != 100 || storage != 10 || legacyCPU != 250 || legacyMemory != 512 || legacyStorage != 10 {
+		t.Fatalf(`ValidateAndRoundResources(100, 100, 5) = %d, %d, %d and %d, %d, %d with rules 1.23 don't match expected 100, 100, 10 and 250, 512, 10`, cpu, memory, storage, legacyCPU, legacyMemory, legacyStorage)
+	}
+}
+
+func TestPopulateWorkloadsExclude(t *testing.T) {
+	pods := []corev1.Pod{
+		testPod("default", "payments-api", "node-1", nil),
+		testPod("default", "job-nightly-28117", "node-1", nil),
+		testPod("monitoring", "prometheus-0", "node-1", nil),
+	}
+
+	// Test Case #1
+	testService := newTestService(pods)
+	testService.Filter.Exclude = []string{"monitoring/*", "*/job-*"}
+	nodes := testNodes()
+	workloads, err := testService.PopulateWorkloads(nodes)
+	if err != nil || len(workloads) != 1 || workloads[0].Name != "payments-api" || len(nodes["node-1"].Workloads) != 1 {
+		t.Fatalf(`PopulateWorkloads() excluding monitoring/* and */job-* = %+v, %v doesn't match expected only payments-api`, workloads, err)
+	}
+
+	// Test Case #2
+	filter := calculator.WorkloadFilter{Exclude: []string{"default/payments-?pi"}}
+	if !filter.Excluded("default", "payments-api") || filter.Excluded("batch", "payments-api") {
+		t.Fatalf(`WorkloadFilter.Excluded() with default/payments-?pi doesn't only match default/payments-api`)
+	}
+}
+
+func testFlagSet() (*flag.FlagSet, *int, *float64, *stringSliceFlag) {
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags.String(configFlagName, "", "")
+	top := flags.Int("top", 0, "")
+	minCost := flags.Float64("min-cost", 0, "")
+	var namespaces stringSliceFlag
+	flags.Var(&namespaces, "namespace", "")
+	return flags, top, minCost, &namespaces
+}
+
+func TestApplyFlagLayers(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	err := os.WriteFile(configFile, []byte("top: 20\nmin-cost: 0.01\nnamespace: [default, payments]\n"), 0644)
+	if err != nil {
+		t.Fatalf(`os.WriteFile() returned error: %v`, err)
+	}
+
+	configValues, err := loadConfigFile(configFile)
+	if err != nil {
+		t.Fatalf(`loadConfigFile() returned error: %v`, err)
+	}
+
+	// Test Case #1
+	flags, top, minCost, namespaces := testFlagSet()
+	flags.Parse([]string{"-top=5"})
+	err = applyFlagLayers(flags, configValues)
+	if err != nil || *top != 5 || !almostEqual(*minCost, 0.01) || namespaces.String() != "default,payments" {
+		t.Fatalf(`applyFlagLayers() = top %d, min-cost %v, namespaces %q, %v doesn't match expected top 5 from the flags and the rest from the file`, *top, *minCost, namespaces.String(), err)
+	}
+
+	// Test Case #2
+	flags, top, _, namespaces = testFlagSet()
+	flags.Parse([]string{"-namespace=batch"})
+	err = applyFlagLayers(flags, configValues, flagValues{"top": {"30"}})
+	if err != nil || *top != 30 || namespaces.String() != "batch" {
+		t.Fatalf(`applyFlagLayers() = top %d, namespaces %q, %v doesn't match expected top 30 from the last layer and namespaces from the flags`, *top, namespaces.String(), err)
+	}
+
+	// Test Case #3
+	flags, top, _, _ = testFlagSet()
+	flags.Parse(nil)
+	err = applyFlagLayers(flags, flagValues{})
+	if err != nil || *top != 0 {
+		t.Fatalf(`applyFlagLayers() without layers = top %d, %v doesn't match expected the default 0`, *top, err)
+	}
+
+	// Test Case #4
+	flags, _, _, _ = testFlagSet()
+	if err := applyFlagLayers(flags, flagValues{"currency": {"EUR"}}); err == nil {
+		t.Fatalf(`applyFlagLayers() with an unknown flag didn't return an error`)
+	}
+	if err := applyFlagLayers(flags, flagValues{"top": {"many"}}); err == nil {
+		t.Fatalf(`applyFlagLayers() with an invalid value didn't return an error`)
+	}
+}
+
+func TestEstimateOptionsParse(t *testing.T) {
+	parseOptions := func(args ...string) (*estimateOptions, error) {
+		options := newEstimateOptions(flag.NewFlagSet("test", flag.ContinueOnError), false)
+		if err := options.flags.Parse(args); err != nil {
+			return nil, err
+		}
+		return options, options.parse()
+	}
+
+	// Test Case #1
+	options, err := parseOptions("-basis=requests", "-allowed-classes=Balanced", "-json", "-json-file=report.json")
+	if err != nil || options.billingBasis != calculator.BasisRequests || !options.allowedClassSet[cluster.ComputeClassBalanced] || !options.tablesOnStdout || options.cfg == nil {
+		t.Fatalf(`estimateOptions.parse() = %+v, %v doesn't match expected the requests basis, the Balanced class and the tables on stdout`, options, err)
+	}
+
+	// Test Case #2
+	if _, err := parseOptions("-precision=7"); err == nil {
+		t.Fatalf(`estimateOptions.parse() with -precision=7 didn't return an error`)
+	}
+
+	// Test Case #3
+	if _, err := parseOptions("-summary-only", "-json"); err == nil {
+		t.Fatalf(`estimateOptions.parse() with two reports on stdout didn't return an error`)
+	}
+
+	// Test Case #4
+	if _, err := parseOptions("-selector=team in (payments"); err == nil {
+		t.Fatalf(`estimateOptions.parse() with an invalid selector didn't return an error`)
+	}
+}
+
+func TestClusterFees(t *testing.T) {
+	prod := fleetCluster{Project: "fleet-project", Location: "us-central1", Name: "prod"}
+	euProd := fleetCluster{Project: "fleet-project", Location: "europe-west1", Name: "prod"}
+	staging := fleetCluster{Project: "fleet-project", Location: "us-central1", Name: "staging"}
+	dev := fleetCluster{Project: "fleet-project", Location: "us-central1-a", Name: "dev"}
+	clusters := []fleetCluster{prod, euProd, staging, dev}
+
+	// Test Case #1
+	fees, err := clusterFees(clusters, 0.1, "dev")
+	if err != nil || fees[prod.path()] != 0.1 || fees[euProd.path()] != 0.1 || fees[staging.path()] != 0.1 || fees[dev.path()] != 0 {
+		t.Fatalf(`clusterFees() with the dev free tier = %v, %v doesn't match expected the fee waived for dev only`, fees, err)
+	}
+
+	// Test Case #2
+	fees, err = clusterFees(clusters, 0.1, "")
+	if err != nil || len(fees) != 4 || fees[prod.path()] != 0.1 || fees[euProd.path()] != 0.1 || fees[dev.path()] != 0.1 {
+		t.Fatalf(`clusterFees() without free tier = %v, %v doesn't match expected the fee for all the clusters`, fees, err)
+	}
+
+	// Test Case #3
+	if _, err := clusterFees(clusters, 0.1, "test"); err == nil {
+		t.Fatalf(`clusterFees() with an unknown free tier cluster didn't return an error`)
+	}
+
+	// Test Case #4 - two clusters are named prod, the free tier one is selected by its path
+	if _, err := clusterFees(clusters, 0.1, "prod"); err == nil || !strings.Contains(err.Error(), euProd.path()) {
+		t.Fatalf(`clusterFees() with an ambiguous free tier cluster = %v doesn't match expected an error listing the paths`, err)
+	}
+	fees, err = clusterFees(clusters, 0.1, euProd.path())
+	if err != nil || fees[prod.path()] != 0.1 || fees[euProd.path()] != 0 {
+		t.Fatalf(`clusterFees() with the free tier of %s = %v, %v doesn't match expected the fee waived for it only`, euProd.path(), fees, err)
+	}
+}
+
+func TestResolveClusterProject(t *testing.T) {
+	currentContext := strings.Split("gke_context-project_europe-west1_test-cluster", "_")
+
+	// Test Case #1
+	project, err := resolveClusterProject("", currentContext)
+	if err != nil || project != "context-project" {
+		t.Fatalf(`resolveClusterProject() = %s, %v doesn't match expected context-project`, project, err)
+	}
+
+	// Test Case #2
+	project, err = resolveClusterProject("ci-project", currentContext)
+	if err != nil || project != "ci-project" {
+		t.Fatalf(`resolveClusterProject(ci-project) = %s, %v doesn't match expected the override ci-project`, project, err)
+	}
+
+	// Test Case #3
+	project, err = resolveClusterProject("example.com:ci-project", []string{"generated-context"})
+	if err != nil || project != "example.com:ci-project" {
+		t.Fatalf(`resolveClusterProject(example.com:ci-project) = %s, %v doesn't match expected the domain scoped project`, project, err)
+	}
+
+	// Test Case #4
+	for _, invalid := range []string{"CI_Project", "abc", "-project", "project-"} {
+		if _, err := resolveClusterProject(invalid, currentContext); err == nil {
+			t.Fatalf(`resolveClusterProject(%s) didn't return an error for an invalid project ID`, invalid)
+		}
+	}
+	if _, err := resolveClusterProject("", []string{"generated-context"}); err == nil {
+		t.Fatalf(`resolveClusterProject() without a project in the context didn't return an error`)
+	}
+}
+
+func TestEnvFlagValues(t *testing.T) {
+	env := map[string]string{
+		"AUTOPILOT_CALC_TOP":       "10",
+		"AUTOPILOT_CALC_MIN_COST":  "0.05",
+		"AUTOPILOT_CALC_NAMESPACE": "default,payments",
+		"AUTOPILOT_CALC_UNKNOWN":   "ignored",
+	}
+	lookupEnv := func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+
+	// Test Case #1
+	flags, top, minCost, namespaces := testFlagSet()
+	flags.Parse(nil)
+	err := applyFlagLayers(flags, envFlagValues(flags, lookupEnv))
+	if err != nil || *top != 10 || !almostEqual(*minCost, 0.05) || namespaces.String() != "default,payments" {
+		t.Fatalf(`applyFlagLayers() from the environment = top %d, min-cost %v, namespaces %q, %v doesn't match expected top 10, min-cost 0.05, namespaces default,payments`, *top, *minCost, namespaces.String(), err)
+	}
+
+	// Test Case #2
+	flags, top, minCost, _ = testFlagSet()
+	flags.Parse([]string{"-top=3"})
+	err = applyFlagLayers(flags, flagValues{"min-cost": {"1"}, "top": {"20"}}, envFlagValues(flags, lookupEnv))
+	if err != nil || *top != 3 || !almostEqual(*minCost, 0.05) {
+		t.Fatalf(`applyFlagLayers() with the flags, file and environment = top %d, min-cost %v, %v doesn't match expected top 3 from the flags and min-cost 0.05 from the environment`, *top, *minCost, err)
+	}
+
+	// Test Case #3
+	if name := envFlagName("percent-include-fee"); name != "AUTOPILOT_CALC_PERCENT_INCLUDE_FEE" {
+		t.Fatalf(`envFlagName(percent-include-fee) = %s doesn't match expected AUTOPILOT_CALC_PERCENT_INCLUDE_FEE`, name)
+	}
+}
+
+func TestPopulateWorkloadsRawResources(t *testing.T) {
+	// Test Case #1
+	testService := newTestService([]corev1.Pod{testPod("default", "payments-api", "node-1", nil)})
+	workloads, err := testService.PopulateWorkloads(testNodes())
+	if err != nil || len(workloads) != 1 {
+		t.Fatalf(`PopulateWorkloads() = %+v, %v doesn't match expected a single workload`, workloads, err)
+	}
+	if workloads[0].RawCpu != 100 || workloads[0].RawMemory != 100 {
+		t.Fatalf(`PopulateWorkloads() raw resources = %d mCPU, %d MB doesn't match expected 100 mCPU, 100 MB`, workloads[0].RawCpu, workloads[0].RawMemory)
+	}
+	if workloads[0].Cpu < workloads[0].RawCpu || workloads[0].Memory < workloads[0].RawMemory {
+		t.Fatalf(`PopulateWorkloads() billed resources = %d mCPU, %d MB are below the raw resources`, workloads[0].Cpu, workloads[0].Memory)
+	}
+
+	// Test Case #2
+	testService.RulesVersion = "1.23"
+	workloads, _ = testService.PopulateWorkloads(testNodes())
+	if len(workloads) != 1 || workloads[0].RawCpu != 100 || workloads[0].Cpu != 250 {
+		t.Fatalf(`PopulateWorkloads() with the 1.23 rules = %+v doesn't match expected raw 100 mCPU billed as 250 mCPU`, workloads)
+	}
+}
+
+func TestLargeMemoryQuantities(t *testing.T) {
+	// Test Case #1
+	large := resource.MustParse("256Gi")
+	if mb := cluster.MB(&large); mb != 274877 {
+		t.Fatalf(`MB(256Gi) = %d doesn't match expected 274877`, mb)
+	}
+
+	// Test Case #2, the milli-units of 16Pi overflow an int64
+	huge := resource.MustParse("16Pi")
+	if mb := cluster.MB(&huge); mb != 18014398509 {
+		t.Fatalf(`MB(16Pi) = %d doesn't match expected 18014398509`, mb)
+	}
+
+	// Test Case #3
+	largePod := testPod("default", "in-memory-db", "node-1", nil)
+	largePod.Spec.Containers[0].Resources.Requests = corev1.ResourceList{
+		corev1.ResourceCPU:    resource.MustParse("32"),
+		corev1.ResourceMemory: large,
+	}
+	testService := newTestService([]corev1.Pod{largePod})
+	workloads, err := testService.PopulateWorkloads(testNodes())
+	if err != nil || len(workloads) != 1 || workloads[0].RawMemory != 274877 {
+		t.Fatalf(`PopulateWorkloads() with a 256Gi request = %+v, %v doesn't match expected 274877 MB of raw memory`, workloads, err)
+	}
+}
+
+func TestRightSizingSavings(t *testing.T) {
+	// Every container uses 100 mCPU, 100 MB and 1000 MB of storage, the over-provisioned pod requests ten times
+	// the mCPU and memory
+	overProvisioned := testPod("default", "over-provisioned", "node-1", nil)
+	overProvisioned.Spec.Containers[0].Resources.Requests = corev1.ResourceList{
+		corev1.ResourceCPU:              resource.MustParse("1000m"),
+		corev1.ResourceMemory:           resource.MustParse("1000M"),
+		corev1.ResourceEphemeralStorage: resource.MustParse("1000M"),
+	}
+	testService := newTestService([]corev1.Pod{overProvisioned, testPod("default", "no-requests", "node-1", nil)})
+
+	workloads, err := testService.PopulateWorkloads(testNodes())
+	if err != nil || len(workloads) != 2 {
+		t.Fatalf(`PopulateWorkloads() = %+v, %v doesn't match expected two workloads`, workloads, err)
+	}
+	byName := map[string]cluster.Workload{}
+	for _, workload := range workloads {
+		byName[workload.Name] = workload
+	}
+
+	// Test Case #1
+	over := byName["over-provisioned"]
+	if !almostEqual(over.RequestCost, over.Cost) || !almostEqual(over.UsageCost, byName["no-requests"].Cost) || over.UsageCost >= over.RequestCost {
+		t.Fatalf(`PopulateWorkloads() over-provisioned = %+v doesn't match expected the requests billed and the usage costing like the pod without requests`, over)
+	}
+
+	// Test Case #2
+	report := newReport("test-cluster", "test-region-1", []cluster.Workload{over}, 0.1, -1)
+	expected := (over.RequestCost - over.UsageCost) * calculator.HOURS_PER_MONTH
+	if !almostEqual(report.RightSizingMonthlySavings, expected) || !almostEqual(report.RequestBasedMonthlyCost-report.UsageBasedMonthlyCost, expected) {
+		t.Fatalf(`newReport().RightSizingMonthlySavings = %v doesn't match expected %v`, report.RightSizingMonthlySavings, expected)
+	}
+	if line := report.rightSizingLine(); !strings.HasPrefix(line, fmt.Sprintf("You could save $%.2f/month", expected)) {
+		t.Fatalf(`rightSizingLine() = %q doesn't match expected the savings`, line)
+	}
+
+	// Test Case #3
+	report = newReport("test-cluster", "test-region-1", []cluster.Workload{byName["no-requests"]}, 0.1, -1)
+	if report.RightSizingMonthlySavings >= 0 || !strings.HasPrefix(report.rightSizingLine(), "Usage exceeds requests") {
+		t.Fatalf(`newReport() without requests = %v, %q doesn't match expected the usage exceeding the requests`, report.RightSizingMonthlySavings, report.rightSizingLine())
+	}
+
+	// Test Case #4
+	testService.Basis = calculator.BasisRequests
+	workloads, _ = testService.PopulateWorkloads(testNodes())
+	for _, workload := range workloads {
+		if !almostEqual(workload.RequestCost, byName[workload.Name].RequestCost) || !almostEqual(workload.UsageCost, byName[workload.Name].UsageCost) {
+			t.Fatalf(`PopulateWorkloads() with the requests basis = %+v doesn't match expected the same request and usage costs as %+v`, workload, byName[workload.Name])
+		}
+	}
+}
+
+func TestPopulateWorkloadsSystem(t *testing.T) {
+	testService := newTestService([]corev1.Pod{
+		testPod("default", "payments-api", "node-1", nil),
+		testPod("kube-system", "kube-dns", "node-1", nil),
+	})
+	testService.Filter.IncludeSystem = true
+
+	// Test Case #1
+	nodes := testNodes()
+	workloads, err := testService.PopulateWorkloads(nodes)
+	if err != nil || len(workloads) != 2 || len(nodes["node-1"].Workloads) != 2 {
+		t.Fatalf(`PopulateWorkloads() with system workloads = %+v, %v doesn't match expected two workloads`, workloads, err)
+	}
+
+	var billable, system cluster.Workload
+	for _, workload := range workloads {
+		if workload.System {
+			system = workload
+		} else {
+			billable = workload
+		}
+	}
+	if system.Name != "kube-dns" || billable.Name != "payments-api" {
+		t.Fatalf(`PopulateWorkloads() = %+v doesn't match expected kube-dns as the system workload`, workloads)
+	}
+
+	// Test Case #2
+	if !almostEqual(nodes["node-1"].Cost, billable.Cost) || !almostEqual(estimatedHourlyCost(nodes, 0.1), billable.Cost+0.1) {
+		t.Fatalf(`Node cost %v and estimated cost %v doesn't match expected only the billable %v`, nodes["node-1"].Cost, estimatedHourlyCost(nodes, 0.1), billable.Cost)
+	}
+	if len(billableWorkloads(workloads)) != 1 || len(aggregateCheapWorkloads(workloads, 1)) != 2 {
+		t.Fatalf(`billableWorkloads() and aggregateCheapWorkloads() don't leave out the system workload`)
+	}
+
+	// Test Case #3
+	model := workloadTableModel(nodes, 1, 1, 0, nil, 0, 0, false, false)
+	output := model.View()
+	if !strings.Contains(output, systemWorkloadLabel) || !strings.Contains(output, formatCost(billable.Cost)) {
+		t.Fatalf(`workloadTableModel() doesn't list the system workload or the billable total: %q`, output)
+	}
+	for _, row := range model.table.Rows() {
+		if row[0] == "Total cost per cluster per hour" && row[len(row)-1] != formatCost(billable.Cost) {
+			t.Fatalf(`workloadTableModel() total = %s doesn't match expected the billable %v`, row[len(row)-1], billable.Cost)
+		}
+	}
+}
+
+func TestPopulateWorkloadsDeletedPod(t *testing.T) {
+	testService := newTestService([]corev1.Pod{
+		testPod("default", "payments-api", "node-1", nil),
+		testPod("default", "job-finished", "node-1", nil),
+	})
+
+	// The metrics of the pod are still listed after it was deleted
+	err := testService.Clientset.CoreV1().Pods("default").Delete(context.Background(), "job-finished", metav1.DeleteOptions{})
+	if err != nil {
+		t.Fatalf(`Pods().Delete() returned error: %v`, err)
+	}
+
+	// Test Case #1
+	nodes := testNodes()
+	workloads, err := testService.PopulateWorkloads(nodes)
+	if err != nil || len(workloads) != 1 || workloads[0].Name != "payments-api" || len(nodes["node-1"].Workloads) != 1 {
+		t.Fatalf(`PopulateWorkloads() with a deleted pod = %+v, %v doesn't match expected only payments-api`, workloads, err)
+	}
+}
+
+func TestGetClusterNodesPaged(t *testing.T) {
+	pages := []corev1.NodeList{
+		{ListMeta: metav1.ListMeta{Continue: "page-2"}, Items: []corev1.Node{{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}}}},
+		{Items: []corev1.Node{{ObjectMeta: metav1.ObjectMeta{Name: "node-2"}}, {ObjectMeta: metav1.ObjectMeta{Name: "node-3"}}}},
+	}
+
+	// The fake clientset ignores Limit and Continue, so the reactor serves the pages in order and can fail the
+	// first call the way an expired continue token does
+	pagedClientset := func(expiredCalls int) (*fake.Clientset, *int) {
+		calls := 0
+		clientset := fake.NewSimpleClientset()
+		clientset.PrependReactor("list", "nodes", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			calls++
+			if calls <= expiredCalls {
+				return true, nil, apierrors.NewResourceExpired("continue token expired")
+			}
+			page := pages[(calls-expiredCalls-1)%len(pages)]
+			return true, &page, nil
+		})
+		return clientset, &calls
+	}
+
+	// Test Case #1
+	clientset, calls := pagedClientset(0)
+	nodes, err := cluster.GetClusterNodes(clientset, 1)
+	if err != nil || len(nodes) != 3 || *calls != 2 {
+		t.Fatalf(`GetClusterNodes() = %v, %v after %d calls doesn't match expected 3 nodes after 2 calls`, nodes, err, *calls)
+	}
+
+	// Test Case #2
+	clientset, calls = pagedClientset(1)
+	nodes, err = cluster.GetClusterNodes(clientset, 1)
+	if err != nil || len(nodes) != 3 || *calls != 3 {
+		t.Fatalf(`GetClusterNodes() with an expired token = %v, %v after %d calls doesn't match expected 3 nodes after 3 calls`, nodes, err, *calls)
+	}
+
+	// Test Case #3
+	clientset, _ = pagedClientset(10)
+	_, err = cluster.GetClusterNodes(clientset, 1)
+	if !apierrors.IsResourceExpired(err) {
+		t.Fatalf(`GetClusterNodes() with a token that keeps expiring = %v doesn't match expected a resource expired error`, err)
+	}
+}
+
+func TestCustomComputeClasses(t *testing.T) {
+	computeClass := func(name string, priorities ...interface{}) *unstructured.Unstructured {
+		return &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "cloud.google.com/v1",
+			"kind":       "ComputeClass",
+			"metadata":   map[string]interface{}{"name": name},
+			"spec":       map[string]interface{}{"priorities": priorities},
+		}}
+	}
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{calculator.ComputeClassResource: "ComputeClassList"},
+		computeClass("cost-optimized", map[string]interface{}{"spot": true}, map[string]interface{}{"machineFamily": "n2d"}),
+		computeClass("fast-cpu", map[string]interface{}{"machineType": "c2-standard-8"}),
+		computeClass("anything", map[string]interface{}{"spot": true}),
+	)
+
+	pod := testPod("default", "batch", "node-1", nil)
+	pod.Spec.NodeSelector = map[string]string{calculator.ComputeClassSelector: "cost-optimized"}
+	testService := newTestService([]corev1.Pod{pod})
+
+	// Test Case #1
+	if err := testService.LoadCustomComputeClasses(dynamicClient); err != nil {
+		t.Fatalf(`LoadCustomComputeClasses() returned error: %v`, err)
+	}
+	expected := map[string]cluster.ComputeClass{"cost-optimized": cluster.ComputeClassBalanced, "fast-cpu": cluster.ComputeClassPerformance}
+	if len(testService.CustomComputeClasses) != len(expected) {
+		t.Fatalf(`LoadCustomComputeClasses() = %v doesn't match expected %v`, testService.CustomComputeClasses, expected)
+	}
+	for name, class := range expected {
+		if testService.CustomComputeClasses[name] != class {
+			t.Fatalf(`LoadCustomComputeClasses() = %v doesn't match expected %v`, testService.CustomComputeClasses, expected)
+		}
+	}
+
+	// Test Case #2
+	workloads, err := testService.PopulateWorkloads(testNodes())
+	if err != nil || len(workloads) != 1 || workloads[0].ComputeClass != cluster.ComputeClassBalanced {
+		t.Fatalf(`PopulateWorkloads() with a pod selecting cost-optimized = %+v, %v doesn't match expected a Balanced workload`, workloads, err)
+	}
+
+	// Test Case #3
+	testService.CustomComputeClasses = nil
+	workloads, _ = testService.PopulateWorkloads(testNodes())
+	if len(workloads) != 1 || workloads[0].ComputeClass != cluster.ComputeClassGeneralPurpose {
+		t.Fatalf(`PopulateWorkloads() without custom compute classes = %+v doesn't match expected a General-purpose workload`, workloads)
+	}
+}
+
+func TestPopulateWorkloadsPodMinimums(t *testing.T) {
+	// The 1.23 rules have a 250 mCPU minimum and increment, every container uses 100 mCPU
+	tests := []struct {
+		containers int
+		cpu        int64
+	}{
+		{1, 250},
+		{3, 500},
+		{10, 1000},
+	}
+
+	for i, test := range tests {
+		pod := testPod("default", "sidecars", "node-1", nil)
+		pod.Spec.Containers = nil
+		for c := 0; c < test.containers; c++ {
+			pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{Name: fmt.Sprintf("container-%d", c)})
+		}
+
+		testService := newTestService([]corev1.Pod{pod})
+		testService.RulesVersion = "1.23"
+		workloads, err := testService.PopulateWorkloads(testNodes())
+		if err != nil || len(workloads) != 1 {
+			t.Fatalf(`Test Case #%d: PopulateWorkloads() = %+v, %v doesn't match expected a single workload`, i+1, workloads, err)
+		}
+		if workloads[0].RawCpu != int64(test.containers)*100 || workloads[0].Cpu != test.cpu {
+			t.Fatalf(`Test Case #%d: PopulateWorkloads() with %d containers = raw %d mCPU billed as %d mCPU doesn't match expected %d mCPU billed as %d mCPU`, i+1, test.containers, workloads[0].RawCpu, workloads[0].Cpu, test.containers*100, test.cpu)
+		}
+	}
+}
+
+func TestProjectHPAs(t *testing.T) {
+	pods := []corev1.Pod{
+		testPod("default", "web-1", "node-1", map[string]string{"app": "web"}),
+		testPod("default", "web-2", "node-1", map[string]string{"app": "web"}),
+		testPod("default", "worker", "node-1", map[string]string{"app": "worker"}),
+	}
+	testService := newTestService(pods)
+	workloads, err := testService.PopulateWorkloads(testNodes())
+	if err != nil {
+		t.Fatalf(`PopulateWorkloads() returned error: %v`, err)
+	}
+
+	minReplicas := int32(1)
+	tracker := testService.Clientset.(*fake.Clientset).Tracker()
+	tracker.Add(&appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec:       appsv1.DeploymentSpec{Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}}},
+	})
+	tracker.Add(&autoscalingv2.HorizontalPodAutoscaler{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec: autoscalingv2.HorizontalPodAutoscalerSpec{
+			ScaleTargetRef: autoscalingv2.CrossVersionObjectReference{Kind: "Deployment", Name: "web", APIVersion: "apps/v1"},
+			MinReplicas:    &minReplicas,
+			MaxReplicas:    10,
+		},
+		Status: autoscalingv2.HorizontalPodAutoscalerStatus{CurrentReplicas: 2},
+	})
+	tracker.Add(&autoscalingv2.HorizontalPodAutoscaler{
+		ObjectMeta: metav1.ObjectMeta{Name: "deleted", Namespace: "default"},
+		Spec: autoscalingv2.HorizontalPodAutoscalerSpec{
+			ScaleTargetRef: autoscalingv2.CrossVersionObjectReference{Kind: "Deployment", Name: "deleted", APIVersion: "apps/v1"},
+			MaxReplicas:    5,
+		},
+	})
+
+	// Test Case #1
+	projections, err := testService.ProjectHPAs(workloads)
+	if err != nil || len(projections) != 1 {
+		t.Fatalf(`ProjectHPAs() = %+v, %v doesn't match expected a single projection`, projections, err)
+	}
+	projection := projections[0]
+	replicaCost := workloads[0].Cost
+	if projection.Target != "Deployment/web" || projection.MinReplicas != 1 || projection.CurrentReplicas != 2 || projection.MaxReplicas != 10 {
+		t.Fatalf(`ProjectHPAs() = %+v doesn't match expected Deployment/web with 1 / 2 / 10 replicas`, projection)
+	}
+	if !almostEqual(projection.MinCost, replicaCost) || !almostEqual(projection.CurrentCost, 2*replicaCost) || !almostEqual(projection.MaxCost, 10*replicaCost) {
+		t.Fatalf(`ProjectHPAs() costs = %v / %v / %v doesn't match expected %v / %v / %v`, projection.MinCost, projection.CurrentCost, projection.MaxCost, replicaCost, 2*replicaCost, 10*replicaCost)
+	}
+
+	// Test Case #2
+	report := newReport("test-cluster", "test-region-1", workloads, 0.1, -1)
+	report.HPAProjections = projections
+	minCost, maxCost := report.hpaCostRange()
+	if !almostEqual(minCost, report.HourlyCost-replicaCost) || !almostEqual(maxCost, report.HourlyCost+8*replicaCost) {
+		t.Fatalf(`hpaCostRange() = %v, %v doesn't match expected %v, %v`, minCost, maxCost, report.HourlyCost-replicaCost, report.HourlyCost+8*replicaCost)
+	}
+}
+
+func TestProjectPDBs(t *testing.T) {
+	pods := []corev1.Pod{
+		testPod("default", "web-1", "node-1", map[string]string{"app": "web"}),
+		testPod("default", "web-2", "node-1", map[string]string{"app": "web"}),
+		testPod("default", "web-3", "node-1", map[string]string{"app": "web"}),
+		testPod("default", "worker-1", "node-1", map[string]string{"app": "worker"}),
+		testPod("default", "worker-2", "node-1", map[string]string{"app": "worker"}),
+	}
+	testService := newTestService(pods)
+	workloads, err := testService.PopulateWorkloads(testNodes())
+	if err != nil {
+		t.Fatalf(`PopulateWorkloads() returned error: %v`, err)
+	}
+
+	minAvailable := intstr.FromString("50%")
+	maxUnavailable := intstr.FromInt(0)
+	tracker := testService.Clientset.(*fake.Clientset).Tracker()
+	tracker.Add(&policyv1.PodDisruptionBudget{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec: policyv1.PodDisruptionBudgetSpec{
+			Selector:     &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+			MinAvailable: &minAvailable,
+		},
+	})
+	tracker.Add(&policyv1.PodDisruptionBudget{
+		ObjectMeta: metav1.ObjectMeta{Name: "worker", Namespace: "default"},
+		Spec: policyv1.PodDisruptionBudgetSpec{
+			Selector:       &metav1.LabelSelector{MatchLabels: map[string]string{"app": "worker"}},
+			MaxUnavailable: &maxUnavailable,
+		},
+	})
+
+	// Test Case #1
+	// 50% of 3 replicas rounds up to 2, the worker PDB doesn't allow any disruption so it has no lower floor
+	projections, err := testService.ProjectPDBs(workloads)
+	if err != nil || len(projections) != 1 {
+		t.Fatalf(`ProjectPDBs() = %+v, %v doesn't match expected a single projection`, projections, err)
+	}
+	projection := projections[0]
+	replicaCost := workloads[0].Cost
+	if projection.Name != "web" || projection.MinReplicas != 2 || projection.CurrentReplicas != 3 {
+		t.Fatalf(`ProjectPDBs() = %+v doesn't match expected web with 2 / 3 replicas`, projection)
+	}
+	if !almostEqual(projection.MinCost, 2*replicaCost) || !almostEqual(projection.CurrentCost, 3*replicaCost) {
+		t.Fatalf(`ProjectPDBs() costs = %v / %v doesn't match expected %v / %v`, projection.MinCost, projection.CurrentCost, 2*replicaCost, 3*replicaCost)
+	}
+
+	// Test Case #2
+	report := newReport("test-cluster", "test-region-1", workloads, 0.1, -1)
+	report.PDBProjections = projections
+	if floor := report.pdbCostFloor(); !almostEqual(floor, report.HourlyCost-replicaCost) {
+		t.Fatalf(`pdbCostFloor() = %v doesn't match expected %v`, floor, report.HourlyCost-replicaCost)
+	}
+
+	// Test Case #3
+	// A PDB without a selector selects no pods, instead of every pod of the namespace
+	minAvailable = intstr.FromInt(1)
+	tracker.Add(&policyv1.PodDisruptionBudget{
+		ObjectMeta: metav1.ObjectMeta{Name: "no-selector", Namespace: "default"},
+		Spec:       policyv1.PodDisruptionBudgetSpec{MinAvailable: &minAvailable},
+	})
+	projections, err = testService.ProjectPDBs(workloads)
+	if err != nil || len(projections) != 1 || projections[0].Name != "web" {
+		t.Fatalf(`ProjectPDBs() with a PDB without selector = %+v, %v doesn't match expected only web`, projections, err)
+	}
+
+	// Test Case #4
+	// Same-named StatefulSet pods of two namespaces on one node are only costed in their own namespace
+	pods = []corev1.Pod{
+		testPod("staging", "db-0", "node-1", map[string]string{"app": "db"}),
+		testPod("prod", "db-0", "node-1", map[string]string{"app": "db"}),
+	}
+	testService = newTestService(pods)
+	workloads, err = testService.PopulateWorkloads(testNodes())
+	if err != nil {
+		t.Fatalf(`PopulateWorkloads() returned error: %v`, err)
+	}
+	minAvailable = intstr.FromInt(0)
+	testService.Clientset.(*fake.Clientset).Tracker().Add(&policyv1.PodDisruptionBudget{
+		ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "prod"},
+		Spec: policyv1.PodDisruptionBudgetSpec{
+			Selector:     &metav1.LabelSelector{MatchLabels: map[string]string{"app": "db"}},
+			MinAvailable: &minAvailable,
+		},
+	})
+	projections, err = testService.ProjectPDBs(workloads)
+	if err != nil || len(projections) != 1 || projections[0].CurrentReplicas != 1 || !almostEqual(projections[0].CurrentCost, workloads[0].Cost) {
+		t.Fatalf(`ProjectPDBs() with same-named pods in two namespaces = %+v, %v doesn't match expected 1 replica costing %v`, projections, err, workloads[0].Cost)
+	}
+}
+
+func TestPopulateWorkloadsSelector(t *testing.T) {
+	pods := []corev1.Pod{
+		testPod("default", "payments-api", "node-1", map[string]string{"team": "payments"}),
+		testPod("default", "search-api", "node-1", map[string]string{"team": "search"}),
+		testPod("batch", "payments-worker", "node-1", map[string]string{"team": "payments"}),
+	}
+
+	// Test Case #1
+	testService := newTestService(pods)
+	testService.Filter.Selector, _ = labels.Parse("team=payments")
+	nodes := testNodes()
+
+	workloads, err := testService.PopulateWorkloads(nodes)
+	if err != nil {
+		t.Fatalf(`PopulateWorkloads() with selector returned error: %v`, err)
+	}
+	if len(workloads) != 2 || len(nodes["node-1"].Workloads) != 2 {
+		t.Fatalf(`PopulateWorkloads() with selector team=payments = %d workloads doesn't match expected 2`, len(workloads))
+	}
+	for _, workload := range workloads {
+		if workload.Name == "search-api" {
+			t.Fatalf(`PopulateWorkloads() with selector team=payments returned unmatched workload %s`, workload.Name)
+		}
+	}
+
+	// Test Case #2
+	testService = newTestService(pods)
+	testService.Filter.Namespaces = []string{"batch"}
+	testService.Filter.Selector, _ = labels.Parse("team=payments")
+	nodes = testNodes()
+
+	workloads, err = testService.PopulateWorkloads(nodes)
+	if err != nil {
+		t.Fatalf(`PopulateWorkloads() with namespace returned error: %v`, err)
+	}
+	if len(workloads) != 1 || workloads[0].Name != "payments-worker" {
+		t.Fatalf(`PopulateWorkloads() with namespace batch = %v doesn't match expected [payments-worker]`, workloads)
+	}
+}
+
+func TestSetPrice(t *testing.T) {
+	testCases := []struct {
+		description string
+		matched     bool
+	}{
+		{"Autopilot Balanced Pod mCPU Requests (test-region-1)", true},
+		{"Autopilot Balanced Pod mCPU Requests (test-region-1) ", true},
+		{"autopilot balanced pod mCPU requests (Test-Region-1)", true},
+		{"Autopilot  Balanced Pod mCPU Requests(test-region-1)", true},
+		{"Autopilot Balanced Pod mCPU Requests (test-region-2)", false},
+		{"Autopilot Balanced Pod mCPU Requests Preview (test-region-1)", false},
+	}
+
+	for i, testCase := range testCases {
+		pricing := calculator.AutopilotPriceList{}
+		matched := pricing.SetPrice("test-region-1", testCase.description, 0.0831, nil)
+		if matched != testCase.matched || (pricing.CpuBalancedPrice == 0.0831) != testCase.matched {
+			t.Fatalf(`#%d SetPrice(%q) = %t, %f doesn't match expected %t`, i+1, testCase.description, matched, pricing.CpuBalancedPrice, testCase.matched)
+		}
+	}
+
+	pricing := calculator.AutopilotPriceList{}
+	pricing.SetPrice("test-region-1", "Autopilot PD Balanced Premium (test-region-1)", 0.01, nil)
+	if pricing.PerformancePDPricePremium != 0.01 || pricing.SpotAcceleratorPDPricePremium != 0.01 {
+		t.Fatalf(`SetPrice() didn't set all the fields of the PD Balanced premium: %+v`, pricing)
+	}
+
+	// The Spot Pod GPU SKUs set the Spot prices, leaving the on demand ones
+	pricing = calculator.AutopilotPriceList{}
+	pricing.SetPrice("test-region-1", "Autopilot NVIDIA T4 Pod GPU Requests (test-region-1)", 0.2, nil)
+	pricing.SetPrice("test-region-1", "Autopilot NVIDIA T4 Spot Pod GPU Requests (test-region-1)", 0.07, nil)
+	pricing.SetPrice("test-region-1", "Autopilot NVIDIA A100 80GB Spot Pod mCPU Requests (test-region-1)", 0.01, nil)
+	pricing.SetPrice("test-region-1", "Autopilot GPU Pod Local SSD (test-region-1)", 0.0001, nil)
+	if pricing.NVIDIAT4PodGPUPrice != 0.2 || pricing.SpotNVIDIAT4PodGPUPrice != 0.07 || pricing.SpotGPUPodvCPUPrice != 0.01 || pricing.GPUPodvCPUPrice != 0 || pricing.GPUPodLocalSSDPrice != 0.0001 || pricing.SpotGPUPodLocalSSDPrice != 0 {
+		t.Fatalf(`SetPrice() didn't set the Spot Pod GPU prices apart from the on demand ones: %+v`, pricing)
+	}
+
+	gcePricing := calculator.GCEPriceList{}
+	if !gcePricing.SetPrice("test-region-1", "spot preemptible c2d AMD Instance Core running in Test ", 0.01, nil) || gcePricing.SpotC2DCpuPrice != 0.01 || gcePricing.C2DCpuPrice != 0 {
+		t.Fatalf(`SetPrice() didn't set the spot C2D price: %+v`, gcePricing)
+	}
+}
+
+func TestSKUMap(t *testing.T) {
+	skuMapFile := filepath.Join(t.TempDir(), "sku-map.json")
+	err := os.WriteFile(skuMapFile, []byte(`{"CpuScaleoutPrice": "^Autopilot Scale-Out x86 Pod vCPU Requests", "C2CpuPrice": "^Compute Optimized Instance Core"}`), 0644)
+	if err != nil {
+		t.Fatalf(`os.WriteFile() returned error: %v`, err)
+	}
+
+	skuMap, err := calculator.LoadSKUMap(skuMapFile)
+	if err != nil {
+		t.Fatalf(`LoadSKUMap() returned error: %v`, err)
+	}
+
+	// Test Case #1
+	pricing := calculator.AutopilotPriceList{}
+	if pricing.SetPrice("test-region-1", "Autopilot Scale-Out x86 Pod vCPU Requests (test-region-1)", 0.0722, nil) || pricing.CpuScaleoutPrice != 0 {
+		t.Fatalf(`SetPrice() without sku map matched the renamed SKU: %+v`, pricing)
+	}
+
+	// Test Case #2
+	if !pricing.SetPrice("test-region-1", "Autopilot Scale-Out x86 Pod vCPU Requests (test-region-1)", 0.0722, skuMap) || pricing.CpuScaleoutPrice != 0.0722 {
+		t.Fatalf(`SetPrice() with sku map = %f doesn't match expected 0.0722`, pricing.CpuScaleoutPrice)
+	}
+
+	// Test Case #3
+	if !pricing.SetPrice("test-region-1", "Autopilot Pod mCPU Requests (test-region-1)", 0.0573, skuMap) || pricing.CpuPrice != 0.0573 {
+		t.Fatalf(`SetPrice() with sku map didn't fall back to the built-in SKUs: %f`, pricing.CpuPrice)
+	}
+
+	// Test Case #4
+	gcePricing := calculator.GCEPriceList{}
+	if !gcePricing.SetPrice("test-region-1", "Compute Optimized Instance Core running in Test", 0.03, skuMap) || gcePricing.C2CpuPrice != 0.03 {
+		t.Fatalf(`SetPrice() with sku map = %f doesn't match expected 0.03`, gcePricing.C2CpuPrice)
+	}
+
+	// Test Case #5
+	err = os.WriteFile(skuMapFile, []byte(`{"CpuPriceTypo": "^Autopilot"}`), 0644)
+	if err != nil {
+		t.Fatalf(`os.WriteFile() returned error: %v`, err)
+	}
+	if _, err := calculator.LoadSKUMap(skuMapFile); err == nil {
+		t.Fatalf(`LoadSKUMap() with an unknown field = nil doesn't match expected error`)
+	}
+}
+
+func TestSKUPrice(t *testing.T) {
+	tests := []struct {
+		money    *cloudbilling.Money
+		expected float64
+	}{
+		{&cloudbilling.Money{Units: 0, Nanos: 57300000}, 0.0573},
+		{&cloudbilling.Money{Units: 1, Nanos: 500000000}, 1.5},
+		{&cloudbilling.Money{Units: 2}, 2},
+		{&cloudbilling.Money{Units: -1, Nanos: -250000000}, -1.25},
+		{nil, 0},
+	}
+
+	for i, test := range tests {
+		if price := calculator.PriceFromMoney(test.money); !almostEqual(price, test.expected) {
+			t.Fatalf(`Test Case #%d: PriceFromMoney(%+v) = %v doesn't match expected %v`, i+1, test.money, price, test.expected)
+		}
+	}
+
+	// Test Case #6
+	expression := &cloudbilling.PricingExpression{DisplayQuantity: 1, TieredRates: []*cloudbilling.TierRate{
+		{StartUsageAmount: 100, UnitPrice: &cloudbilling.Money{Nanos: 40000000}},
+		{StartUsageAmount: 0, UnitPrice: &cloudbilling.Money{}},
+		{StartUsageAmount: 10, UnitPrice: &cloudbilling.Money{Units: 1, Nanos: 500000000}},
+	}}
+	if price := calculator.SKUPrice(expression); !almostEqual(price, 1.5) {
+		t.Fatalf(`SKUPrice() with a free first tier = %v doesn't match expected the 1.5 of the first paid tier`, price)
+	}
+
+	// Test Case #7
+	expression = &cloudbilling.PricingExpression{DisplayQuantity: 1, TieredRates: []*cloudbilling.TierRate{{UnitPrice: &cloudbilling.Money{}}}}
+	if price := calculator.SKUPrice(expression); price != 0 {
+		t.Fatalf(`SKUPrice() of a free SKU = %v doesn't match expected 0`, price)
+	}
+}
+
+func TestNormalizePrice(t *testing.T) {
+	tests := []struct {
+		price     float64
+		usageUnit string
+		expected  float64
+	}{
+		{0.0445, "h", 0.0445},
+		{0.0049, "GiBy.h", 0.0049},
+		{0.073, "GiBy.mo", 0.0001},
+		{0.024, "GiBy.d", 0.001},
+		{0.0001, "MiBy.h", 0.1024},
+		{0.5, "count", 0.5},
+	}
+
+	for i, test := range tests {
+		if price := calculator.NormalizePrice(test.price, test.usageUnit); !almostEqual(price, test.expected) {
+			t.Fatalf(`Test Case #%d: NormalizePrice(%v, %s) = %v doesn't match expected %v`, i+1, test.price, test.usageUnit, price, test.expected)
+		}
+	}
+}
+
+func TestRegionFromLocation(t *testing.T) {
+	locations := []struct {
+		location string
+		want     string
+	}{
+		{"europe-west1-b", "europe-west1"},
+		{"europe-west1", "europe-west1"},
+		{"us-central1-c", "us-central1"},
+		{"northamerica-northeast1", "northamerica-northeast1"},
+	}
+	for i, location := range locations {
+		region, err := calculator.RegionFromLocation(location.location)
+		if err != nil || region != location.want {
+			t.Fatalf(`Test Case #%d: RegionFromLocation(%q) = %q, %v doesn't match expected %q`, i+1, location.location, region, err, location.want)
+		}
+	}
+
+	// Test Case #5
+	if region, err := calculator.RegionFromLocation("global"); err == nil {
+		t.Fatalf(`RegionFromLocation("global") = %q, nil doesn't match expected an error`, region)
+	}
+}
+
+func TestNewServiceBillingProject(t *testing.T) {
+	// Without authentication the quota project isn't sent, so the requests use an API key
+	var quotaProjects []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		quotaProjects = append(quotaProjects, r.Header.Get("X-Goog-User-Project"))
+		w.Write([]byte(`{"skus": [{"description": "Autopilot Pod mCPU Requests (europe-west1)", "serviceRegions": ["europe-west1"], "pricingInfo": [{"pricingExpression": {"displayQuantity": 1, "tieredRates": [{"unitPrice": {"units": "0", "nanos": 44500000}}]}}]}]}`))
+	}))
+	defer server.Close()
+
+	skus := map[string]string{"autopilot": "CCD8-9BF1-090E", "gce": "6F81-5844-456A"}
+
+	// Test Case #1
+	testService, err := calculator.NewService(skus, nil, "europe-west1", time.Time{}, fake.NewSimpleClientset(), metricsfake.NewSimpleClientset(), config,
+		option.WithEndpoint(server.URL+"/"), option.WithAPIKey("test-key"), option.WithQuotaProject("billing-project"))
+	if err != nil || !almostEqual(testService.AutopilotPricing.CpuPrice, 0.0445) {
+		t.Fatalf(`NewService() = %+v, %v doesn't match expected the CPU price 0.0445 from the Cloud Billing service`, testService, err)
+	}
+	if len(quotaProjects) != 2 || quotaProjects[0] != "billing-project" || quotaProjects[1] != "billing-project" {
+		t.Fatalf(`NewService() quota projects = %v don't match expected billing-project for the Autopilot and GCE pricing`, quotaProjects)
+	}
+}
+
+func TestClusterCache(t *testing.T) {
+	requests := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests[r.URL.Path]++
+		name := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+		w.Write([]byte(`{"name": "` + name + `", "status": "RUNNING"}`))
+	}))
+	defer server.Close()
+
+	containerService, err := container.NewService(context.Background(), option.WithEndpoint(server.URL+"/"), option.WithoutAuthentication())
+	if err != nil {
+		t.Fatalf(`container.NewService() returned error: %v`, err)
+	}
+	cache := newClusterCache(containerService)
+
+	// Test Case #1
+	for _, location := range []string{"projects/test-project/locations/europe-west1/clusters/first", "projects/test-project/locations/europe-west1/clusters/first", "projects/test-project/locations/us-central1-c/clusters/second"} {
+		clusterObject, err := cache.Get(location)
+		if err != nil || !strings.HasSuffix(location, "/"+clusterObject.Name) {
+			t.Fatalf(`clusterCache.Get(%s) = %+v, %v doesn't match expected the cluster`, location, clusterObject, err)
+		}
+	}
+	if len(requests) != 2 || requests["/v1/projects/test-project/locations/europe-west1/clusters/first"] != 1 {
+		t.Fatalf(`clusterCache.Get() requests = %v don't match expected one per cluster`, requests)
+	}
+}
+
+func TestGetBilledClusterCost(t *testing.T) {
+	var request bigquery.QueryRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/projects/billing-project/queries" {
+			t.Errorf(`GetBilledClusterCost() queried %s doesn't match expected /projects/billing-project/queries`, r.URL.Path)
+		}
+		json.NewDecoder(r.Body).Decode(&request)
+		w.Write([]byte(`{"jobComplete": true, "rows": [{"f": [{"v": "1234.5"}]}]}`))
+	}))
+	defer server.Close()
+
+	bigqueryService, err := bigquery.NewService(context.Background(), option.WithEndpoint(server.URL+"/"), option.WithoutAuthentication())
+	if err != nil {
+		t.Fatalf(`bigquery.NewService() returned error: %v`, err)
+	}
+
+	// Test Case #1
+	cost, err := calculator.GetBilledClusterCost(context.Background(), bigqueryService, "billing-project.billing.gcp_billing_export_v1", "test-project", "test-cluster", "test-region-1", 30)
+	if err != nil || !almostEqual(cost, 1234.5) {
+		t.Fatalf(`GetBilledClusterCost() = %f, %v doesn't match expected 1234.5`, cost, err)
+	}
+	if !strings.Contains(request.Query, "`billing-project.billing.gcp_billing_export_v1`") || !strings.Contains(request.Query, "goog-k8s-cluster-location") || len(request.QueryParameters) != 4 || request.QueryParameters[1].ParameterValue.Value != "test-cluster" || request.QueryParameters[2].ParameterValue.Value != "test-region-1" {
+		t.Fatalf(`GetBilledClusterCost() sent unexpected query %q with parameters %v`, request.Query, request.QueryParameters)
+	}
+
+	// Test Case #2
+	_, err = calculator.GetBilledClusterCost(context.Background(), bigqueryService, "billing`; DROP TABLE x", "test-project", "test-cluster", "test-region-1", 30)
+	if err == nil {
+		t.Fatalf(`GetBilledClusterCost() with an invalid table name didn't return an error`)
+	}
+}
+
+func TestCostStyle(t *testing.T) {
+	highlight := CostHighlight{MediumShare: 0.05, HighShare: 0.2}
+
+	// Test Case #1
+	if color := costStyle(0.01, highlight).GetForeground(); color != costColors[0] {
+		t.Fatalf(`costStyle(0.01) = %v doesn't match expected %v`, color, costColors[0])
+	}
+
+	// Test Case #2
+	if color := costStyle(0.05, highlight).GetForeground(); color != costColors[1] {
+		t.Fatalf(`costStyle(0.05) = %v doesn't match expected %v`, color, costColors[1])
+	}
+
+	// Test Case #3
+	if color := costStyle(0.5, highlight).GetForeground(); color != costColors[2] {
+		t.Fatalf(`costStyle(0.5) = %v doesn't match expected %v`, color, costColors[2])
+	}
+}
+
+func TestDisplayTablesWithoutTerminal(t *testing.T) {
+	out, err := os.Create(filepath.Join(t.TempDir(), "output.txt"))
+	if err != nil {
+		t.Fatalf(`os.Create() returned error: %v`, err)
+	}
+	defer out.Close()
+
+	lipgloss.SetColorProfile(termenv.ANSI256)
+	if ConfigureOutput(out, false) {
+		t.Fatalf(`ConfigureOutput() = true doesn't match expected false for a file`)
+	}
+
+	nodes := testNodes()
+	entry := nodes["node-1"]
+	entry.Workloads = []cluster.Workload{{Name: "test-pod", Containers: 1, Cpu: 250, Memory: 512, Storage: 10, Cost: 0.0153}}
+	nodes["node-1"] = entry
+
+	var output bytes.Buffer
+	DisplayNodeTable(&output, nodes, false)
+	DisplayWorkloadTable(&output, nodes, 0.8, 0.55, calculator.CLUSTER_FEE, &CostHighlight{MediumShare: 0.05, HighShare: 0.2}, 0, 0, false, false)
+
+	if !strings.Contains(output.String(), "test-pod") {
+		t.Fatalf(`DisplayWorkloadTable() output doesn't contain the workload: %q`, output.String())
+	}
+	if strings.Contains(output.String(), "\x1b[") {
+		t.Fatalf(`Display tables output contains ANSI escapes when not writing to a terminal: %q`, output.String())
+	}
+}
+
+func TestPopulateWorkloadsWindows(t *testing.T) {
+	nodes := testNodes()
+	nodes["windows-node"] = cluster.Node{Name: "windows-node", InstanceType: "n2-standard-4", Region: "test-region-1", OS: "windows"}
+
+	windowsPod := testPod("default", "windows-os-pod", "node-1", nil)
+	windowsPod.Spec.OS = &corev1.PodOS{Name: corev1.Windows}
+	pods := []corev1.Pod{
+		testPod("default", "linux-pod", "node-1", nil),
+		testPod("default", "windows-node-pod", "windows-node", nil),
+		windowsPod,
+	}
+	testService := newTestService(pods)
+
+	// Test Case #1
+	workloads, err := testService.PopulateWorkloads(nodes)
+	if err != nil || len(workloads) != 1 || workloads[0].Name != "linux-pod" {
+		t.Fatalf(`PopulateWorkloads() = %+v, %v doesn't match expected only linux-pod`, workloads, err)
+	}
+}
+
+func TestTopWorkloadRows(t *testing.T) {
+	var rows []workloadRow
+	for _, cost := range []float64{0.02, 0.5, 0.01, 0.1, 0.03} {
+		rows = append(rows, workloadRow{workload: cluster.Workload{Cost: cost}})
+	}
+
+	// Test Case #1
+	top, restCount, restCost := topWorkloadRows(rows, 2, 0)
+	if len(top) != 2 || top[0].workload.Cost != 0.5 || top[1].workload.Cost != 0.1 || restCount != 3 || !almostEqual(restCost, 0.06) {
+		t.Fatalf(`topWorkloadRows(2) = %v, %d, %f doesn't match expected [0.5 0.1], 3, 0.06`, top, restCount, restCost)
+	}
+
+	// Test Case #2
+	top, restCount, restCost = topWorkloadRows(rows, 0, 0)
+	if len(top) != 5 || restCount != 0 || restCost != 0 {
+		t.Fatalf(`topWorkloadRows(0) = %v, %d, %f doesn't match expected all rows`, top, restCount, restCost)
+	}
+
+	// Test Case #3
+	nodes := testNodes()
+	entry := nodes["node-1"]
+	entry.Workloads = []cluster.Workload{{Name: "small-pod", Cost: 0.01}, {Name: "large-pod", Cost: 0.5}, {Name: "medium-pod", Cost: 0.1}}
+	nodes["node-1"] = entry
+
+	var output bytes.Buffer
+	DisplayWorkloadTable(&output, nodes, 1, 1, 0.1, nil, 1, 0, false, false)
+	if !strings.Contains(output.String(), "large-pod") || strings.Contains(output.String(), "medium-pod") || !strings.Contains(output.String(), "... and 2 more (total $0.1100)") || !strings.Contains(output.String(), "$0.7100") {
+		t.Fatalf(`DisplayWorkloadTable(top 1) output doesn't aggregate the rest while keeping the totals: %q`, output.String())
+	}
+
+	// Test Case #4
+	top, restCount, restCost = topWorkloadRows(rows, 0, 0.03)
+	if len(top) != 3 || top[2].workload.Cost != 0.03 || restCount != 2 || !almostEqual(restCost, 0.03) {
+		t.Fatalf(`topWorkloadRows(0, 0.03) = %v, %d, %f doesn't match expected [0.5 0.1 0.03], 2, 0.03`, top, restCount, restCost)
+	}
+
+	// Test Case #5
+	output.Reset()
+	DisplayWorkloadTable(&output, nodes, 1, 1, 0.1, nil, 0, 0.05, false, false)
+	if !strings.Contains(output.String(), "medium-pod") || strings.Contains(output.String(), "small-pod") || !strings.Contains(output.String(), "... and 1 more (total $0.0100)") || !strings.Contains(output.String(), "$0.7100") {
+		t.Fatalf(`DisplayWorkloadTable(min cost 0.05) output doesn't aggregate the cheap workloads while keeping the totals: %q`, output.String())
+	}
+}
+
+func TestAggregateCheapWorkloads(t *testing.T) {
+	workloads := []cluster.Workload{
+		{Name: "large-pod", Node_name: "node-1", Cpu: 2000, Cost: 0.5, SpotCost: 0.2},
+		{Name: "small-pod", Node_name: "node-1", Cpu: 100, Cost: 0.01, SpotCost: 0.004, ComputeClass: cluster.ComputeClassBalanced},
+		{Name: "tiny-pod", Node_name: "node-2", Cpu: 50, Cost: 0.005, SpotCost: 0.002},
+	}
+
+	// Test Case #1
+	aggregated := aggregateCheapWorkloads(workloads, 0.05)
+	if len(aggregated) != 2 || aggregated[0].Name != "large-pod" || aggregated[1].Name != othersWorkloadName || aggregated[1].Cpu != 150 || !almostEqual(aggregated[1].Cost, 0.015) || !almostEqual(aggregated[1].SpotCost, 0.006) {
+		t.Fatalf(`aggregateCheapWorkloads(0.05) = %+v doesn't match expected large-pod and others with 150 mCPU costing 0.015`, aggregated)
+	}
+	if aggregated[1].Node_name != "" || aggregated[1].ComputeClass != cluster.ComputeClassMixed || workloadClassName(aggregated[1]) != mixedClassName {
+		t.Fatalf(`aggregateCheapWorkloads(0.05) others = %+v doesn't match expected no node and the mixed compute class`, aggregated[1])
+	}
+
+	// Test Case #2
+	if report, aggregatedReport := newReport("test-cluster", "test-region-1", workloads, 0.1, -1), newReport("test-cluster", "test-region-1", aggregated, 0.1, -1); !almostEqual(report.HourlyCost, aggregatedReport.HourlyCost) {
+		t.Fatalf(`newReport() hourly cost with the others workload = %f doesn't match expected %f`, aggregatedReport.HourlyCost, report.HourlyCost)
+	}
+
+	// Test Case #3
+	if aggregated = aggregateCheapWorkloads(workloads, 0); len(aggregated) != 3 {
+		t.Fatalf(`aggregateCheapWorkloads(0) = %+v doesn't match expected all the workloads`, aggregated)
+	}
+
+	// Test Case #4
+	// The others row of the CSV and Markdown reports has no node and the mixed class
+	report := newReport("test-cluster", "test-region-1", aggregateCheapWorkloads(workloads, 0.05), 0.1, -1)
+	report.ClassDistribution = classDistribution(workloads)
+	var output bytes.Buffer
+	if err := writeCSVReport(&output, report); err != nil || !strings.Contains(output.String(), ",others,,0,150,") || !strings.Contains(output.String(), ","+mixedClassName+",") {
+		t.Fatalf(`writeCSVReport() with the others workload = %v doesn't match expected no node and the mixed class: %q`, err, output.String())
+	}
+	output.Reset()
+	if err := writeMarkdownReport(&output, report); err != nil || !strings.Contains(output.String(), "| others |  | "+mixedClassName+" |") {
+		t.Fatalf(`writeMarkdownReport() with the others workload = %v doesn't match expected no node and the mixed class: %q`, err, output.String())
+	}
+}
+
+func TestSpotSavings(t *testing.T) {
+	nodes := testNodes()
+	entry := nodes["node-1"]
+	entry.Workloads = []cluster.Workload{{Name: "first-pod", Cost: 0.3, SpotCost: 0.1}, {Name: "second-pod", Cost: 0.2, SpotCost: 0.05}}
+	nodes["node-1"] = entry
+	nodes["spot-node"] = cluster.Node{Name: "spot-node", Spot: true, Workloads: []cluster.Workload{{Name: "spot-pod", Cost: 0.05, SpotCost: 0.05}}}
+
+	// Test Case #1
+	var output bytes.Buffer
+	DisplayWorkloadTable(&output, nodes, 1, 1, 0.1, nil, 0, 0, false, false)
+	rows := map[string]string{}
+	for _, line := range strings.Split(output.String(), "\n") {
+		line = strings.Trim(line, "│ ")
+		if strings.HasPrefix(line, "...") {
+			fields := strings.Fields(line)
+			rows[strings.Join(fields[:len(fields)-1], " ")] = fields[len(fields)-1]
+		}
+	}
+	if rows["... with all workloads on spot"] != "$0.3000" || rows["... savings with all on spot"] != "$0.3500" {
+		t.Fatalf(`DisplayWorkloadTable() spot rows = %v don't match expected 0.3 total and 0.35 savings`, rows)
+	}
+	// The workloads of spot-node are the spot total, the others the on-demand one, both without the cluster fee
+	if rows["... on-demand total per hour"] != "$0.5000" || rows["... spot total per hour"] != "$0.0500" {
+		t.Fatalf(`DisplayWorkloadTable() spot rows = %v don't match expected 0.5 on-demand and 0.05 spot`, rows)
+	}
+	// The hourly total includes the spot workloads and the cluster fee
+	for _, row := range workloadTableModel(nodes, 1, 1, 0.1, nil, 0, 0, false, false).table.Rows() {
+		if row[0] == "Total cost per cluster per hour" && row[len(row)-1] != "$0.6500" {
+			t.Fatalf(`workloadTableModel() total = %s doesn't match expected 0.65 with the spot workloads`, row[len(row)-1])
+		}
+	}
+
+	// Test Case #3
+	entry.Workloads = []cluster.Workload{{Name: "first-pod", Cost: 0.3, Breakdown: cluster.CostBreakdown{Storage: 0.01}}}
+	nodes = map[string]cluster.Node{"node-1": entry}
+	output.Reset()
+	DisplayWorkloadTable(&output, nodes, 1, 1, 0.1, nil, 0, 0, false, false)
+	if !strings.Contains(output.String(), "... of which storage per month") || !strings.Contains(output.String(), "$7.3000") {
+		t.Fatalf(`DisplayWorkloadTable() = %q doesn't have the expected storage subtotal 7.3`, output.String())
+	}
+
+	// Test Case #2
+	testService := newTestService([]corev1.Pod{testPod("default", "test-pod", "node-1", nil)})
+	workloads, err := testService.PopulateWorkloads(testNodes())
+	if err != nil || len(workloads) != 1 || workloads[0].SpotCost <= 0 || workloads[0].SpotCost >= workloads[0].Cost {
+		t.Fatalf(`PopulateWorkloads() = %+v, %v doesn't have a spot cost below the on-demand cost`, workloads, err)
+	}
+}
+
+func TestWorkloadTableBreakdown(t *testing.T) {
+	nodes := testNodes()
+	entry := nodes["node-1"]
+	entry.Workloads = []cluster.Workload{{Name: "first-pod", Cost: 0.35, Breakdown: cluster.CostBreakdown{CPU: 0.25, Memory: 0.075, Storage: 0.025, Total: 0.35}}}
+	nodes["node-1"] = entry
+
+	// Test Case #1
+	var output bytes.Buffer
+	DisplayWorkloadTable(&output, nodes, 1, 1, 0.1, nil, 0, 0, false, false)
+	if strings.Contains(output.String(), "CPU $/H") {
+		t.Fatalf(`DisplayWorkloadTable(breakdown false) output contains the breakdown columns: %q`, output.String())
+	}
+
+	// Test Case #2
+	output.Reset()
+	DisplayWorkloadTable(&output, nodes, 1, 1, 0.1, nil, 0, 0, true, false)
+	for _, line := range strings.Split(output.String(), "\n") {
+		if strings.Contains(line, "first-pod") {
+			fields := strings.Fields(strings.Trim(line, "│ "))
+			if got := strings.Join(fields[len(fields)-4:], " "); got != "$0.2500 $0.0750 $0.0250 $0.3500" {
+				t.Fatalf(`DisplayWorkloadTable(breakdown true) row costs = %q doesn't match expected "$0.2500 $0.0750 $0.0250 $0.3500"`, got)
+			}
+			return
+		}
+	}
+	t.Fatalf(`DisplayWorkloadTable(breakdown true) output doesn't contain the workload: %q`, output.String())
+}
+
+func TestSummarizeNodes(t *testing.T) {
+	nodes := map[string]cluster.Node{
+		"node-1": {Name: "node-1", InstanceType: "e2-standard-4", Cpu: 3920, Memory: 13000},
+		"node-2": {Name: "node-2", InstanceType: "e2-standard-8", Spot: true, Cpu: 7910, Memory: 29000},
+		"node-3": {Name: "node-3", InstanceType: "n2-highmem-2", Cpu: 1930, Memory: 13500},
+		"node-4": {Name: "node-4"},
+	}
+
+	// Test Case #1
+	summary := summarizeNodes(nodes)
+	if summary.total != 4 || summary.spot != 1 || summary.onDemand != 3 || summary.cpu != 13760 || summary.memory != 55500 {
+		t.Fatalf(`summarizeNodes() = %+v doesn't match expected 4 nodes, 1 spot, 3 on-demand, 13760 mCPU and 55500 MB`, summary)
+	}
+
+	// Test Case #2
+	if summary.families["e2"] != 2 || summary.families["n2"] != 1 || summary.families["unknown"] != 1 {
+		t.Fatalf(`summarizeNodes() families = %v doesn't match expected e2: 2, n2: 1, unknown: 1`, summary.families)
+	}
+
+	// Test Case #3
+	var output bytes.Buffer
+	DisplayNodeTable(&output, nodes, false)
+	if !strings.Contains(output.String(), "Total nodes") || !strings.Contains(output.String(), "... e2 family") {
+		t.Fatalf(`DisplayNodeTable() output doesn't contain the node summary: %q`, output.String())
+	}
+	// The totals are in the units of their columns
+	if !strings.Contains(output.String(), formatCPU(13760)) || !strings.Contains(output.String(), formatMemory(55500)) {
+		t.Fatalf(`DisplayNodeTable() output doesn't contain the totals %s and %s: %q`, formatCPU(13760), formatMemory(55500), output.String())
+	}
+}
+
+func TestSortedNodes(t *testing.T) {
+	nodes := map[string]cluster.Node{
+		"node-c": {Name: "node-c", Cost: 0.1},
+		"node-a": {Name: "node-a", Cost: 0.1, Workloads: []cluster.Workload{{Name: "b", Cost: 0.05}, {Name: "c", Cost: 0.01}, {Name: "a", Cost: 0.05}}},
+		"node-b": {Name: "node-b", Cost: 0.3},
+		"node-d": {Name: "node-d"},
+	}
+
+	// Test Case #1
+	sorted := sortedNodes(nodes)
+	var names []string
+	for _, node := range sorted {
+		names = append(names, node.Name)
+	}
+	if strings.Join(names, ",") != "node-b,node-a,node-c,node-d" {
+		t.Fatalf(`sortedNodes() = %v doesn't match expected node-b,node-a,node-c,node-d`, names)
+	}
+
+	// Test Case #2
+	workloads := sorted[1].Workloads
+	if workloads[0].Name != "a" || workloads[1].Name != "b" || workloads[2].Name != "c" {
+		t.Fatalf(`sortedNodes() workloads = %+v doesn't match expected a, b, c`, workloads)
+	}
+
+	// Test Case #3
+	var first bytes.Buffer
+	DisplayNodeTable(&first, nodes, false)
+	for i := 0; i < 10; i++ {
+		var output bytes.Buffer
+		DisplayNodeTable(&output, nodes, false)
+		if output.String() != first.String() {
+			t.Fatalf(`DisplayNodeTable() output changed between runs: %q and %q`, first.String(), output.String())
+		}
+	}
+}
+
+func TestNodeEfficiency(t *testing.T) {
+	// Test Case #1
+	if score := calculator.EfficiencyScore(0.07, 0.28); !almostEqual(score, 0.25) {
+		t.Fatalf(`EfficiencyScore(0.07, 0.28) = %v doesn't match expected 0.25`, score)
+	}
+
+	// Test Case #2
+	if score := calculator.EfficiencyScore(0.07, 0); score != 0 {
+		t.Fatalf(`EfficiencyScore(0.07, 0) = %v doesn't match expected 0 without a Standard cost`, score)
+	}
+
+	// Test Case #3
+	testService := service
+	testService.GCEPricing.C2CpuPrice = 0.05
+	testService.GCEPricing.C2MemoryPrice = 0.005
+	nodes := map[string]cluster.Node{
+		"node-1":                    {Name: "node-1", InstanceType: "c2-standard-4", Cost: 0.07},
+		"node-2":                    {Name: "node-2", InstanceType: "c2-standard-4", Cost: 0.42},
+		"node-3":                    {Name: "node-3", InstanceType: "e2-medium", Cost: 0.01},
+		cluster.UnscheduledNodeName: {Name: cluster.UnscheduledNodeName, Cost: 0.02},
+	}
+	testService.PopulateNodeEfficiency(nodes)
+	if !almostEqual(nodes["node-1"].StandardCost, 0.28) || !almostEqual(nodes["node-1"].Efficiency, 0.25) || !almostEqual(nodes["node-2"].Efficiency, 1.5) {
+		t.Fatalf(`PopulateNodeEfficiency() = %+v doesn't match expected Standard cost 0.28 and scores 0.25 and 1.5`, nodes)
+	}
+	if nodes["node-3"].Efficiency != 0 || nodes[cluster.UnscheduledNodeName].Efficiency != 0 {
+		t.Fatalf(`PopulateNodeEfficiency() scored nodes without GCE pricing: %+v`, nodes)
+	}
+
+	// Test Case #4
+	summary := summarizeNodes(nodes)
+	if summary.lightlyLoaded != 1 || summary.denselyPacked != 1 {
+		t.Fatalf(`summarizeNodes() = %d lightly loaded, %d densely packed doesn't match expected 1 and 1`, summary.lightlyLoaded, summary.denselyPacked)
+	}
+}
+
+func TestSpotComparison(t *testing.T) {
+	testService := newTestService([]corev1.Pod{
+		testPod("default", "on-spot", "spot-node", nil),
+		testPod("default", "on-demand", "on-demand-node", nil),
+	})
+	testService.GCEPricing.C2CpuPrice = 0.05
+	testService.GCEPricing.C2MemoryPrice = 0.005
+	testService.GCEPricing.SpotC2CpuPrice = 0.015
+	testService.GCEPricing.SpotC2MemoryPrice = 0.0015
+	nodes := map[string]cluster.Node{
+		"spot-node":      {Name: "spot-node", InstanceType: "c2-standard-4", Region: "test-region-1", Spot: true},
+		"on-demand-node": {Name: "on-demand-node", InstanceType: "c2-standard-4", Region: "test-region-1"},
+	}
+
+	workloads, err := testService.PopulateWorkloads(nodes)
+	if err != nil || len(workloads) != 2 {
+		t.Fatalf(`PopulateWorkloads() = %+v, %v doesn't match expected two workloads`, workloads, err)
+	}
+	testService.PopulateNodeEfficiency(nodes)
+
+	// Test Case #1
+	spotWorkload, onDemandWorkload := nodes["spot-node"].Workloads[0], nodes["on-demand-node"].Workloads[0]
+	if !almostEqual(spotWorkload.Cost, spotWorkload.SpotCost) || onDemandWorkload.Cost <= onDemandWorkload.SpotCost {
+		t.Fatalf(`PopulateWorkloads() = %+v and %+v doesn't match expected Spot Pod pricing on the spot node only`, spotWorkload, onDemandWorkload)
+	}
+
+	// Test Case #2
+	if !almostEqual(nodes["spot-node"].StandardCost, 0.084) || !almostEqual(nodes["on-demand-node"].StandardCost, 0.28) {
+		t.Fatalf(`PopulateNodeEfficiency() Standard costs = %v and %v doesn't match expected 0.084 for spot and 0.28 on-demand`, nodes["spot-node"].StandardCost, nodes["on-demand-node"].StandardCost)
+	}
+
+	// Test Case #3
+	summary := summarizeNodes(nodes)
+	if !almostEqual(summary.spotCosts.standard, 0.084) || !almostEqual(summary.spotCosts.autopilot, spotWorkload.Cost) {
+		t.Fatalf(`summarizeNodes() spot costs = %+v doesn't match expected 0.084 and %v`, summary.spotCosts, spotWorkload.Cost)
+	}
+	if !almostEqual(summary.onDemandCosts.standard, 0.28) || !almostEqual(summary.onDemandCosts.autopilot, onDemandWorkload.Cost) {
+		t.Fatalf(`summarizeNodes() on-demand costs = %+v doesn't match expected 0.28 and %v`, summary.onDemandCosts, onDemandWorkload.Cost)
+	}
+}
+
+func TestWatchModelUpdate(t *testing.T) {
+	nodes := testNodes()
+	entry := nodes["node-1"]
+	entry.Workloads = []cluster.Workload{{Name: "first-pod", Cost: 0.01}}
+	nodes["node-1"] = entry
+	initial := workloadTableModel(nodes, 1, 1, 0.1, nil, 0, 0, false, false)
+
+	refreshErr := errors.New("metrics unavailable")
+	refreshes := 0
+	model := watchModel{tableModel: initial, interval: time.Second, refresh: func() (tableModel, error) {
+		refreshes++
+		if refreshes == 2 {
+			return tableModel{}, refreshErr
+		}
+		entry.Workloads = []cluster.Workload{{Name: "refreshed-pod", Cost: 0.02}}
+		nodes["node-1"] = entry
+		return workloadTableModel(nodes, 1, 1, 0.1, nil, 0, 0, false, false), nil
+	}}
+
+	// Test Case #1
+	updated, cmd := model.Update(tickMsg(time.Now()))
+	msg := cmd()
+	if _, ok := msg.(refreshMsg); !ok || refreshes != 1 {
+		t.Fatalf(`watchModel.Update(tick) command = %T doesn't match expected refreshMsg`, msg)
+	}
+
+	// Test Case #2
+	updated, cmd = updated.Update(msg)
+	if !strings.Contains(updated.View(), "refreshed-pod") || cmd == nil {
+		t.Fatalf(`watchModel.Update(refresh) didn't redraw the refreshed workloads and schedule the next tick: %q`, updated.View())
+	}
+
+	// Test Case #3
+	_, cmd = updated.Update(tickMsg(time.Now()))
+	updated, _ = updated.Update(cmd())
+	if !strings.Contains(updated.View(), "refreshed-pod") || !strings.Contains(updated.View(), refreshErr.Error()) {
+		t.Fatalf(`watchModel.Update(refresh error) didn't keep the last table and show the error: %q`, updated.View())
+	}
+
+	// Test Case #4
+	_, cmd = updated.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("q")})
+	if cmd == nil {
+		t.Fatalf(`watchModel.Update(q) = nil doesn't match expected quit`)
+	}
+	if _, ok := cmd().(tea.QuitMsg); !ok {
+		t.Fatalf(`watchModel.Update(q) command doesn't quit`)
+	}
+}
+
+func TestProgressModel(t *testing.T) {
+	var model tea.Model = newProgressModel()
+
+	// Test Case #1
+	if !strings.Contains(model.View(), "Listing pods") {
+		t.Fatalf(`progressModel.View() = %q doesn't match expected listing pods`, model.View())
+	}
+
+	// Test Case #2
+	model, _ = model.Update(progressMsg{done: 1, total: 3})
+	model, _ = model.Update(progressMsg{done: 2, total: 3})
+	if !strings.Contains(model.View(), "Describing pod 2/3") {
+		t.Fatalf(`progressModel.View() = %q doesn't match expected describing pod 2/3`, model.View())
+	}
+
+	// Test Case #3
+	model, cmd := model.Update(progressDoneMsg{})
+	if model.View() != "" || cmd == nil {
+		t.Fatalf(`progressModel.Update(done) = %q doesn't match expected an empty view and quit`, model.View())
+	}
+	if _, ok := cmd().(tea.QuitMsg); !ok {
+		t.Fatalf(`progressModel.Update(done) command doesn't quit`)
+	}
+
+	// Test Case #4
+	testService := newTestService([]corev1.Pod{testPod("default", "api", "node-1", nil), testPod("default", "worker", "node-1", nil)})
+	var reported []string
+	testService.Progress = func(done int, total int) {
+		reported = append(reported, fmt.Sprintf("%d/%d", done, total))
+	}
+	if _, err := testService.PopulateWorkloads(testNodes()); err != nil || strings.Join(reported, ",") != "1/2,2/2" {
+		t.Fatalf(`PopulateWorkloads() reported progress %v, %v doesn't match expected 1/2,2/2`, reported, err)
+	}
+}
+
+func TestDisplayReportAutopilot(t *testing.T) {
+	clusterObject := &container.Cluster{Name: "test-cluster", Status: "RUNNING", Autopilot: &container.Autopilot{Enabled: true}}
+
+	nodes := testNodes()
+	entry := nodes["node-1"]
+	entry.Workloads = []cluster.Workload{{Name: "test-pod", Containers: 1, Cpu: 250, Memory: 512, Storage: 10, Cost: 0.0153}}
+	nodes["node-1"] = entry
+
+	var output bytes.Buffer
+	estimate := &clusterEstimate{region: "test-region-1", clusterObject: clusterObject, reportOnly: true, fee: 0.1, nodes: nodes, workloads: entry.Workloads}
+	displayReport(&output, estimate, &estimateOptions{cfg: config})
+
+	if !strings.Contains(output.String(), "test-pod") || !strings.Contains(output.String(), "Autopilot cluster (test-cluster)") {
+		t.Fatalf(`displayReport() for an Autopilot cluster doesn't contain the workload report: %q`, output.String())
+	}
+	if strings.Contains(output.String(), "Nodes that you currently have") {
+		t.Fatalf(`displayReport() for an Autopilot cluster contains the Standard node table: %q`, output.String())
+	}
+}
+
+func TestIsAutopilot(t *testing.T) {
+	tests := []struct {
+		clusterObject *container.Cluster
+		expected      bool
+	}{
+		// Test Case #1 - no Autopilot field
+		{&container.Cluster{Name: "test-cluster", Status: "RUNNING"}, false},
+		// Test Case #2
+		{&container.Cluster{Name: "test-cluster", Autopilot: &container.Autopilot{Enabled: false}}, false},
+		// Test Case #3
+		{&container.Cluster{Name: "test-cluster", Autopilot: &container.Autopilot{Enabled: true}}, true},
+	}
+
+	for _, test := range tests {
+		if autopilot := isAutopilot(test.clusterObject); autopilot != test.expected {
+			t.Fatalf(`isAutopilot(%+v) = %v doesn't match expected %v`, test.clusterObject.Autopilot, autopilot, test.expected)
+		}
+	}
+
+	// Test Case #4
+	// A cluster decoded from a response without the autopilot field is a Standard cluster
+	var clusterObject container.Cluster
+	if err := json.Unmarshal([]byte(`{"name": "test-cluster", "status": "RUNNING"}`), &clusterObject); err != nil || isAutopilot(&clusterObject) {
+		t.Fatalf(`isAutopilot() of a cluster without the autopilot field = %v, %v doesn't match expected false`, isAutopilot(&clusterObject), err)
+	}
+}
+
+func TestEmptyCluster(t *testing.T) {
+	testService := newTestService(nil)
+	nodes := testNodes()
+	workloads, err := testService.PopulateWorkloads(nodes)
+	if err != nil || len(workloads) != 0 {
+		t.Fatalf(`PopulateWorkloads() without pods = %+v, %v doesn't match expected no workloads`, workloads, err)
+	}
+	clusterObject := &container.Cluster{Name: "test-cluster", Status: "RUNNING"}
+
+	// Test Case #1
+	var output bytes.Buffer
+	estimate := &clusterEstimate{region: "test-region-1", clusterObject: clusterObject, fee: 0.1, nodes: nodes, workloads: workloads}
+	displayReport(&output, estimate, &estimateOptions{cfg: config, breakdown: true, showAdjustments: true})
+	if !strings.Contains(output.String(), noBillableWorkloadsMessage) || !strings.Contains(output.String(), "Total cost per cluster per hour") || strings.Contains(output.String(), "Workloads per compute class") {
+		t.Fatalf(`displayReport() without workloads doesn't show the message and the totals only: %q`, output.String())
+	}
+
+	// Test Case #2
+	report := newReport("test-cluster", "test-region-1", workloads, 0.1, -1)
+	report.ClassDistribution = classDistribution(workloads)
+	report.Histogram = costHistogram(workloads)
+	contents, err := json.Marshal(report)
+	if err != nil || !almostEqual(report.HourlyCost, 0.1) {
+		t.Fatalf(`newReport() without workloads = %+v, %v doesn't match expected the cluster fee only`, report, err)
+	}
+	var value interface{}
+	if err := json.Unmarshal(contents, &value); err != nil {
+		t.Fatalf(`json.Unmarshal() returned error: %v`, err)
+	}
+	schemaContent, _ := reportSchemaFS.ReadFile(reportSchemaFile)
+	var schema map[string]interface{}
+	if err := json.Unmarshal(schemaContent, &schema); err != nil {
+		t.Fatalf(`json.Unmarshal() of the schema returned error: %v`, err)
+	}
+	if err := validateSchema(schema, schema, value, ""); err != nil {
+		t.Fatalf(`JSON report without workloads doesn't match the schema: %v`, err)
+	}
+
+	// Test Case #3
+	output.Reset()
+	if err := writeHTMLReport(&output, report); err != nil || !strings.Contains(output.String(), noBillableWorkloadsMessage) {
+		t.Fatalf(`writeHTMLReport() without workloads = %v doesn't show the message: %q`, err, output.String())
+	}
+}
+
+func TestSummaryLine(t *testing.T) {
+	workloads := []cluster.Workload{
+		{Name: "small-pod", Cost: 0.01},
+		{Name: "large-pod", Cost: 1},
+	}
+
+	summaryWant := "TOTAL_HOURLY=1.11 TOTAL_MONTHLY=810.30 CLUSTER=test-cluster REGION=test-region-1 WORKLOADS=2"
+	summary := summaryLine("test-cluster", "test-region-1", workloads, 0.1)
+	if summary != summaryWant {
+		t.Fatalf(`summaryLine() = %q doesn't match expected %q`, summary, summaryWant)
+	}
+}
+
+func TestSetPercentOfTotal(t *testing.T) {
+	nodes := testNodes()
+	entry := nodes["node-1"]
+	entry.Workloads = []cluster.Workload{{Name: "small-pod", Cost: 0.1}, {Name: "large-pod", Cost: 0.3}}
+	nodes["node-1"] = entry
+	workloads := append([]cluster.Workload{}, entry.Workloads...)
+
+	// Test Case #1
+	setPercentOfTotal(nodes, workloads, 0.1, false)
+	if !almostEqual(workloads[0].PercentOfTotal, 25) || !almostEqual(workloads[1].PercentOfTotal, 75) || !almostEqual(nodes["node-1"].Workloads[1].PercentOfTotal, 75) {
+		t.Fatalf(`setPercentOfTotal() = %+v, %+v doesn't match expected 25%% and 75%%`, workloads, nodes["node-1"].Workloads)
+	}
+
+	// Test Case #2
+	setPercentOfTotal(nodes, workloads, 0.1, true)
+	if !almostEqual(workloads[0].PercentOfTotal, 20) || !almostEqual(workloads[1].PercentOfTotal, 60) {
+		t.Fatalf(`setPercentOfTotal() including the fee = %+v doesn't match expected 20%% and 60%%`, workloads)
+	}
+
+	// Test Case #3
+	if percent := costPercent(0, 0); percent != 0 {
+		t.Fatalf(`costPercent(0, 0) = %f doesn't match expected 0`, percent)
+	}
+
+	var output bytes.Buffer
+	DisplayWorkloadTable(&output, nodes, 1, 1, 0.1, nil, 0, 0, false, false)
+	if !strings.Contains(output.String(), "% of total") || !strings.Contains(output.String(), "60.0%") {
+		t.Fatalf(`DisplayWorkloadTable() output doesn't contain the percentage of total: %q`, output.String())
+	}
+}
+
+func TestCheckBudget(t *testing.T) {
+	workloads := []cluster.Workload{
+		{Name: "small-pod", Cost: 0.01},
+		{Name: "large-pod", Cost: 1},
+		{Name: "medium-pod", Cost: 0.1},
+	}
+
+	// Test Case #1
+	var output bytes.Buffer
+	exitCode := checkBudget(&output, workloads, 0.1, 1000)
+	if exitCode != 0 || output.Len() != 0 {
+		t.Fatalf(`checkBudget(1000) = %d, %q doesn't match expected 0 with no output`, exitCode, output.String())
+	}
+
+	// Test Case #2
+	output.Reset()
+	exitCode = checkBudget(&output, workloads, 0.1, 500)
+	if exitCode != 2 {
+		t.Fatalf(`checkBudget(500) = %d doesn't match expected 2`, exitCode)
+	}
+	if !strings.Contains(output.String(), "$883.30 exceeds the budget of $500.00") || !strings.Contains(output.String(), "large-pod: $730.00") || strings.Contains(output.String(), "medium-pod") {
+		t.Fatalf(`checkBudget(500) output doesn't list only large-pod over the budget: %q`, output.String())
+	}
+}
+
+func TestCompareRegions(t *testing.T) {
+	testService := newTestService([]corev1.Pod{
+		testPod("default", "payments-api", "node-1", nil),
+		testPod("default", "checkout", "node-1", nil),
+	})
+	nodes := testNodes()
+	workloads, err := testService.PopulateWorkloads(nodes)
+	if err != nil || len(workloads) != 2 {
+		t.Fatalf(`PopulateWorkloads() = %+v, %v doesn't match expected two workloads`, workloads, err)
+	}
+
+	// The second region charges twice as much per vCPU
+	cheap := calculator.RegionPricing{Autopilot: testService.AutopilotPricing, GCE: testService.GCEPricing}
+	cheap.Autopilot.Region = "test-region-1"
+	expensive := cheap
+	expensive.Autopilot.Region = "test-region-2"
+	expensive.Autopilot.CpuPrice *= 2
+
+	var hourlyCost, cpuCost float64
+	for _, workload := range workloads {
+		hourlyCost += workload.Cost
+		cpuCost += workload.Breakdown.CPU
+	}
+
+	// Test Case #1
+	costs := testService.CompareRegions(workloads, nodes, 0.1, []calculator.RegionPricing{cheap, expensive})
+	if len(costs) != 2 || costs[0].Region != "test-region-1" || !almostEqual(costs[0].HourlyCost, 0.1+hourlyCost) {
+		t.Fatalf(`CompareRegions() = %+v doesn't match expected test-region-1 at $%v per hour`, costs, 0.1+hourlyCost)
+	}
+
+	// Test Case #2
+	if costs[1].Region != "test-region-2" || !almostEqual(costs[1].HourlyCost, 0.1+hourlyCost+cpuCost) || !almostEqual(costs[1].MonthlyCost, costs[1].HourlyCost*calculator.HOURS_PER_MONTH) {
+		t.Fatalf(`CompareRegions() = %+v doesn't match expected test-region-2 at $%v per hour`, costs, 0.1+hourlyCost+cpuCost)
+	}
+
+	// Test Case #3
+	if !almostEqual(testService.AutopilotPricing.CpuPrice, cheap.Autopilot.CpuPrice) {
+		t.Fatalf(`CompareRegions() changed the pricing of the service to %v`, testService.AutopilotPricing.CpuPrice)
+	}
+
+	// Test Case #4 - a prorated Job running 73 hours a month stays billed for a tenth of the month
+	job := workloads[0]
+	job.JobRuntime, job.JobRuns = 3600, 73
+	costs = testService.CompareRegions([]cluster.Workload{job}, nodes, 0, []calculator.RegionPricing{cheap})
+	if len(costs) != 1 || !almostEqual(costs[0].HourlyCost, workloads[0].Cost*0.1) {
+		t.Fatalf(`CompareRegions() = %+v doesn't match expected the prorated Job at $%v per hour`, costs, workloads[0].Cost*0.1)
+	}
+}
+
+func TestCheckNamespaceBudgets(t *testing.T) {
+	workloads := []cluster.Workload{
+		{Name: "api", Namespace: "team-a", Cost: 0.5},
+		{Name: "worker", Namespace: "team-a", Cost: 0.3},
+		{Name: "web", Namespace: "team-b", Cost: 0.1},
+	}
+
+	budgets := namespaceBudgetFlag{}
+	if err := budgets.Set("team-a=500, team-b=200"); err != nil {
+		t.Fatalf(`namespaceBudgetFlag.Set() returned error: %v`, err)
+	}
+
+	// Test Case #1
+	var output bytes.Buffer
+	exitCode := checkNamespaceBudgets(&output, workloads, budgets)
+	if exitCode != 2 {
+		t.Fatalf(`checkNamespaceBudgets() = %d doesn't match expected 2`, exitCode)
+	}
+	if !strings.Contains(output.String(), "$584.00 of namespace team-a exceeds its budget of $500.00 by $84.00") || strings.Contains(output.String(), "team-b") {
+		t.Fatalf(`checkNamespaceBudgets() output doesn't list only team-a over its budget: %q`, output.String())
+	}
+
+	// Test Case #2
+	output.Reset()
+	budgets["team-a"] = 600
+	exitCode = checkNamespaceBudgets(&output, workloads, budgets)
+	if exitCode != 0 || output.Len() != 0 {
+		t.Fatalf(`checkNamespaceBudgets() within the budgets = %d, %q doesn't match expected 0 with no output`, exitCode, output.String())
+	}
+
+	// Test Case #3
+	for _, value := range []string{"team-a", "team-a=", "team-a=-5", "=500"} {
+		if err := (namespaceBudgetFlag{}).Set(value); err == nil {
+			t.Fatalf(`namespaceBudgetFlag.Set(%q) doesn't return an error`, value)
+		}
+	}
+}
+
+func TestPostSlackReport(t *testing.T) {
+	var message slackMessage
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&message); err != nil {
+			t.Fatalf(`Error decoding slack message: %v`, err)
+		}
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	workloads := []cluster.Workload{}
+	for i := 1; i <= 7; i++ {
+		workloads = append(workloads, cluster.Workload{Name: "pod-" + strings.Repeat("x", i*20), Cost: float64(i) / 100})
+	}
+
+	// Test Case #1
+	err := postSlackReport(server.URL, "test-cluster", "test-region-1", workloads, 0.1, 0.2)
+	if err != nil {
+		t.Fatalf(`postSlackReport() = %v doesn't match expected nil`, err)
+	}
+	payload, _ := json.Marshal(message)
+	if !strings.Contains(string(payload), "test-cluster (test-region-1)") || !strings.Contains(string(payload), "$277.40") || !strings.Contains(string(payload), "+131.40") {
+		t.Fatalf(`postSlackReport() payload doesn't contain the cluster, total and delta: %s`, payload)
+	}
+	topWorkloads := message.Blocks[len(message.Blocks)-1].Text.Text
+	if !strings.Contains(topWorkloads, "1. `pod-"+strings.Repeat("x", 75)+"…` $51.10") || strings.Contains(topWorkloads, "6. ") || !strings.Contains(topWorkloads, "and 2 more") {
+		t.Fatalf(`postSlackReport() doesn't list only the truncated top 5 workloads: %s`, topWorkloads)
+	}
+
+	// Test Case #2
+	message = slackMessage{}
+	err = postSlackReport(server.URL, "test-cluster", "test-region-1", workloads, 0.1, -1)
+	payload, _ = json.Marshal(message)
+	if err != nil || strings.Contains(string(payload), "Delta") {
+		t.Fatalf(`postSlackReport() without billed cost = %v, %+v doesn't match expected nil without delta`, err, message)
+	}
+
+	// Test Case #3
+	err = postSlackReport(server.URL+"/fail", "test-cluster", "test-region-1", workloads, 0.1, -1)
+	if err == nil {
+		t.Fatalf(`postSlackReport() with failing webhook = nil doesn't match expected error`)
+	}
+}
+
+// validateSchema validates a decoded json value against the subset of JSON Schema used by report.schema.json.
+func validateSchema(root map[string]interface{}, schema map[string]interface{}, value interface{}, path string) error {
+	if ref, ok := schema["$ref"].(string); ok {
+		defs := root["$defs"].(map[string]interface{})
+		return validateSchema(root, defs[strings.TrimPrefix(ref, "#/$defs/")].(map[string]interface{}), value, path)
+	}
+
+	var types []string
+	switch schemaType := schema["type"].(type) {
+	case string:
+		types = []string{schemaType}
+	case []interface{}:
+		for _, t := range schemaType {
+			types = append(types, t.(string))
+		}
+	}
+
+	typeOf := func(value interface{}) string {
+		switch value := value.(type) {
+		case nil:
+			return "null"
+		case bool:
+			return "boolean"
+		case string:
+			return "string"
+		case float64:
+			if value == math.Trunc(value) {
+				return "integer"
+			}
+			return "number"
+		case []interface{}:
+			return "array"
+		default:
+			return "object"
+		}
+	}
+	valueType := typeOf(value)
+	matches := false
+	for _, t := range types {
+		matches = matches || t == valueType || (t == "number" && valueType == "integer")
+	}
+	if !matches {
+		return fmt.Errorf("%s is a %s, expected %v", path, valueType, types)
+	}
+
+	switch value := value.(type) {
+	case map[string]interface{}:
+		properties, _ := schema["properties"].(map[string]interface{})
+		if required, ok := schema["required"].([]interface{}); ok {
+			for _, name := range required {
+				if _, ok := value[name.(string)]; !ok {
+					return fmt.Errorf("%s is missing %s", path, name)
+				}
+			}
+		}
+		for name, property := range value {
+			propertySchema, ok := properties[name].(map[string]interface{})
+			if !ok {
+				if schema["additionalProperties"] == false {
+					return fmt.Errorf("%s has %s, which isn't in the schema", path, name)
+				}
+				continue
+			}
+			if err := validateSchema(root, propertySchema, property, path+"."+name); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for i, item := range value {
+				if err := validateSchema(root, items, item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func TestReportNodesJSON(t *testing.T) {
+	nodes := map[string]cluster.Node{
+		"node-1": {Name: "node-1", Cost: 0.3, Workloads: []cluster.Workload{
+			{Name: "api", Cost: 0.2, ComputeClass: cluster.ComputeClassBalanced},
+			{Name: "worker", Cost: 0.1, ComputeClass: cluster.ComputeClassGeneralPurpose},
+			{Name: "cron", Cost: 0.001, ComputeClass: cluster.ComputeClassGeneralPurpose},
+			{Name: "kube-dns", Cost: 0.05, System: true},
+		}},
+	}
+
+	contents, err := json.Marshal(reportNodes(nodes, 0.01))
+	if err != nil {
+		t.Fatalf(`json.Marshal() returned error: %v`, err)
+	}
+
+	var decoded []struct {
+		Name              string
+		Workloads         []cluster.Workload
+		CostPerHour       float64      `json:"cost_per_hour"`
+		CostPerMonth      float64      `json:"cost_per_month"`
+		WorkloadCount     int          `json:"workload_count"`
+		ClassDistribution []classCount `json:"class_distribution"`
+	}
+	if err := json.Unmarshal(contents, &decoded); err != nil || len(decoded) != 1 {
+		t.Fatalf(`json.Unmarshal() of the nodes = %+v, %v doesn't match expected a single node`, decoded, err)
+	}
+	node := decoded[0]
+
+	// Test Case #1
+	if node.Name != "node-1" || !almostEqual(node.CostPerHour, 0.3) || !almostEqual(node.CostPerMonth, 0.3*calculator.HOURS_PER_MONTH) {
+		t.Fatalf(`Node JSON costs = %+v doesn't match expected $0.3 per hour`, node)
+	}
+
+	// Test Case #2
+	// The workloads are counted before cron is aggregated into others, and kube-dns isn't billable
+	if node.WorkloadCount != 3 || len(node.Workloads) != 4 {
+		t.Fatalf(`Node JSON workload_count = %d with %d workloads doesn't match expected 3 with 4`, node.WorkloadCount, len(node.Workloads))
+	}
+
+	// Test Case #3
+	tally := map[string]int{}
+	for _, count := range node.ClassDistribution {
+		tally[count.Class] = count.Workloads
+	}
+	if tally[cluster.ComputeClasses[cluster.ComputeClassGeneralPurpose]] != 2 || tally[cluster.ComputeClasses[cluster.ComputeClassBalanced]] != 1 {
+		t.Fatalf(`Node JSON class_distribution = %+v doesn't match expected 2 General-purpose and 1 Balanced`, node.ClassDistribution)
+	}
+}
+
+func TestReportSchema(t *testing.T) {
+	content, err := reportSchemaFS.ReadFile(reportSchemaFile)
+	if err != nil {
+		t.Fatalf(`reportSchemaFS.ReadFile() returned error: %v`, err)
+	}
+	var schema map[string]interface{}
+	if err := json.Unmarshal(content, &schema); err != nil {
+		t.Fatalf(`json.Unmarshal() of the schema returned error: %v`, err)
+	}
+
+	workloads := []cluster.Workload{
+		{Name: "web", Node_name: "node-1", Cpu: 250, Memory: 512, Cost: 0.02, LimitCost: 0.04, Burstable: true, ClassReason: "balanced ratio"},
+		{Name: "api", Node_name: "node-1", Cpu: 500, Memory: 1024, Cost: 0.04},
+		{Name: "kube-dns", Node_name: "node-1", Cpu: 250, Memory: 512, Cost: 0.02, System: true},
+	}
+	report := newReport("test-cluster", "test-region-1", workloads, 0.1, 0.2)
+	report.BurstableWorkloads = burstableWorkloads(workloads)
+	report.Nodes = reportNodes(map[string]cluster.Node{
+		"node-1": {Name: "node-1", InstanceType: "e2-standard-4", Workloads: workloads, Cost: 0.06},
+		"node-2": {Name: "node-2"},
+	}, 0)
+	report.ClassDistribution = classDistribution(workloads)
+	report.Histogram = costHistogram(workloads)
+	report.HPAProjections = []calculator.HPAProjection{{Namespace: "default", Name: "web", Target: "Deployment/web", MinReplicas: 1, CurrentReplicas: 2, MaxReplicas: 4}}
+	report.RegionComparison = []calculator.RegionCost{{Region: "europe-west1", HourlyCost: 0.1, MonthlyCost: 73}}
+	report.PDBProjections = []calculator.PDBProjection{{Namespace: "default", Name: "web", MinReplicas: 1, CurrentReplicas: 2}}
+	report.Warnings = []reportWarning{{Level: "WARN", Message: "ARM pricing is not available in the region, ARM workloads are priced without it", Attributes: map[string]string{"workloads": "2"}}}
+
+	// Test Case #1
+	contents, _ := json.Marshal(report)
+	var decoded interface{}
+	json.Unmarshal(contents, &decoded)
+	if err := validateSchema(schema, schema, decoded, "report"); err != nil {
+		t.Fatalf(`Report doesn't match the json schema: %v`, err)
+	}
+	// The memory and storage are in decimal MB, like the tables
+	for _, key := range []string{`"footprint":{"mcpu":750,"memory_mb":1536,"storage_mb":0}`, `"requested_memory_mb":`, `"used_memory_mb":`} {
+		if !strings.Contains(string(contents), key) {
+			t.Fatalf(`json.Marshal() of the report = %s doesn't contain %s`, contents, key)
+		}
+	}
+
+	// Test Case #2
+	contents, _ = json.Marshal(newReport("test-cluster", "test-region-1", nil, 0.1, -1))
+	json.Unmarshal(contents, &decoded)
+	if err := validateSchema(schema, schema, decoded, "report"); err != nil {
+		t.Fatalf(`Report without nodes doesn't match the json schema: %v`, err)
+	}
+
+	// Test Case #3
+	decoded.(map[string]interface{})["unexpected"] = true
+	if err := validateSchema(schema, schema, decoded, "report"); err == nil {
+		t.Fatalf(`validateSchema() accepted a property missing from the schema`)
+	}
+}
+
+func TestClassDistribution(t *testing.T) {
+	workloads := []cluster.Workload{
+		{Name: "web", ComputeClass: cluster.ComputeClassGeneralPurpose},
+		{Name: "api", ComputeClass: cluster.ComputeClassGeneralPurpose},
+		{Name: "cache", ComputeClass: cluster.ComputeClassBalanced},
+		{Name: "batch", ComputeClass: cluster.ComputeClassScaleoutArm},
+	}
+
+	// Test Case #1
+	distribution := classDistribution(workloads)
+	if len(distribution) != len(cluster.ComputeClasses) || distribution[0].Workloads != 2 || distribution[1].Workloads != 1 || distribution[2].Workloads != 0 || distribution[3].Workloads != 1 {
+		t.Fatalf(`classDistribution() = %+v doesn't match expected 2 General-purpose, 1 Balanced and 1 Scale-out arm64`, distribution)
+	}
+
+	// Test Case #2
+	line := formatClassDistribution(distribution)
+	if !strings.HasPrefix(line, "General-purpose: 2, Balanced: 1, Scale-out: 0, Scale-out arm64: 1, ") {
+		t.Fatalf(`formatClassDistribution() = %q doesn't start with the expected counts`, line)
+	}
+}
+
+func TestClassCosts(t *testing.T) {
+	workloads := []cluster.Workload{
+		{Name: "web", ComputeClass: cluster.ComputeClassGeneralPurpose, Cost: 0.1},
+		{Name: "api", ComputeClass: cluster.ComputeClassGeneralPurpose, Cost: 0.2},
+		{Name: "cache", ComputeClass: cluster.ComputeClassBalanced, Cost: 0.5},
+	}
+
+	// Test Case #1
+	distribution := classDistribution(workloads)
+	if !almostEqual(distribution[0].MonthlyCost, 0.3*calculator.HOURS_PER_MONTH) || !almostEqual(distribution[1].MonthlyCost, 0.5*calculator.HOURS_PER_MONTH) || distribution[2].MonthlyCost != 0 {
+		t.Fatalf(`classDistribution() = %+v doesn't match expected the monthly costs of General-purpose and Balanced`, distribution)
+	}
+
+	// Test Case #2
+	// The classes without workloads are left out
+	expected := fmt.Sprintf("General-purpose: $%.2f, Balanced: $%.2f", 0.3*calculator.HOURS_PER_MONTH, 0.5*calculator.HOURS_PER_MONTH)
+	if line := formatClassCosts(distribution); line != expected {
+		t.Fatalf(`formatClassCosts() = %q doesn't match expected %q`, line, expected)
+	}
+
+	// Test Case #3
+	report := newReport("test-cluster", "test-region-1", workloads, calculator.CLUSTER_FEE, -1)
+	report.ClassDistribution = distribution
+	var b bytes.Buffer
+	if err := writeJSONReport(&b, report); err != nil || !strings.Contains(b.String(), `"monthly_cost": `+fmt.Sprint(0.5*calculator.HOURS_PER_MONTH)) {
+		t.Fatalf(`writeJSONReport() = %s, %v doesn't contain the monthly cost of Balanced`, b.String(), err)
+	}
+}
+
+func TestCostHistogram(t *testing.T) {
+	workloads := []cluster.Workload{
+		{Name: "free", Cost: 0},
+		{Name: "tiny", Cost: 0.0005},
+		{Name: "edge", Cost: 0.001},
+		{Name: "small", Cost: 0.005},
+		{Name: "medium", Cost: 0.05},
+		{Name: "large", Cost: 0.5},
+		{Name: "whale", Cost: 25},
+	}
+
+	// Test Case #1
+	buckets := costHistogram(workloads)
+	expected := []costBucket{{"0.001", 3}, {"0.01", 1}, {"0.1", 1}, {"1", 1}, {"10", 0}, {"+Inf", 1}}
+	if len(buckets) != len(expected) {
+		t.Fatalf(`costHistogram() = %+v doesn't match expected %+v`, buckets, expected)
+	}
+	for i := range expected {
+		if buckets[i] != expected[i] {
+			t.Fatalf(`costHistogram() = %+v doesn't match expected %+v`, buckets, expected)
+		}
+	}
+
+	// Test Case #2
+	var output bytes.Buffer
+	displayCostHistogram(&output, buckets)
+	if !strings.Contains(output.String(), "<= $+Inf") || strings.Count(output.String(), "\n") != len(expected)+1 {
+		t.Fatalf(`displayCostHistogram() output doesn't list the buckets: %q`, output.String())
+	}
+}
+
+func TestEstimatedMonthlyDelta(t *testing.T) {
+	workloads := []cluster.Workload{{Name: "test-pod", Cost: 0.3}}
+
+	// Test Case #1
+	report := newReport("test-cluster", "test-region-1", workloads, 0.1, 0.5)
+	if report.EstimatedMonthlyDelta == nil || !almostEqual(*report.EstimatedMonthlyDelta, -73) || report.monthlyDeltaHeadline() != "Estimated monthly savings by moving to Autopilot: $73.00 (20.0%)" {
+		t.Fatalf(`newReport(billed 0.5) delta = %v, %q doesn't match expected savings of 73 (20%%)`, report.EstimatedMonthlyDelta, report.monthlyDeltaHeadline())
+	}
+
+	// Test Case #2
+	report = newReport("test-cluster", "test-region-1", workloads, 0.1, 0.2)
+	if report.EstimatedMonthlyDelta == nil || !almostEqual(*report.EstimatedMonthlyDelta, 146) || report.monthlyDeltaHeadline() != "Estimated monthly increase by moving to Autopilot: $146.00 (100.0%)" {
+		t.Fatalf(`newReport(billed 0.2) delta = %v, %q doesn't match expected an increase of 146 (100%%)`, report.EstimatedMonthlyDelta, report.monthlyDeltaHeadline())
+	}
+	contents, _ := json.Marshal(report)
+	if !strings.Contains(string(contents), `"estimated_monthly_delta":146`) {
+		t.Fatalf(`json.Marshal(report) = %s doesn't contain the estimated_monthly_delta`, contents)
+	}
+
+	// Test Case #3
+	report = newReport("test-cluster", "test-region-1", workloads, 0.1, -1)
+	contents, _ = json.Marshal(report)
+	if report.EstimatedMonthlyDelta != nil || report.monthlyDeltaHeadline() != "" || strings.Contains(string(contents), "estimated_monthly_delta") {
+		t.Fatalf(`newReport(unknown billed cost) = %s doesn't match expected no delta`, contents)
+	}
+
+	// Test Case #4
+	// The 3 year discount applies to the on-demand workload only, not to the spot one nor the cluster fee
+	discount, err := commitmentDiscount(config, "3y")
+	if err != nil || discount != 0.55 {
+		t.Fatalf(`commitmentDiscount(3y) = %v, %v doesn't match expected 0.55`, discount, err)
+	}
+	report = newReport("test-cluster", "test-region-1", append(workloads, cluster.Workload{Name: "spot-pod", Cost: 0.1}), 0.1, 0.5)
+	report.applyCommitment("3y", discount, 0.1)
+	if report.EstimatedMonthlyDelta == nil || !almostEqual(*report.EstimatedMonthlyDelta, -98.55) || report.monthlyDeltaHeadline() != "Estimated monthly savings by moving to Autopilot with a 3y commitment: $98.55 (27.0%)" {
+		t.Fatalf(`applyCommitment(3y) delta = %v, %q doesn't match expected savings of 98.55 (27%%)`, report.EstimatedMonthlyDelta, report.monthlyDeltaHeadline())
+	}
+	if _, err := commitmentDiscount(config, "5y"); err == nil {
+		t.Fatalf(`commitmentDiscount(5y) = nil doesn't match expected error`)
+	}
+}
+
+func TestWriteHTMLReport(t *testing.T) {
+	workloads := []cluster.Workload{
+		{Name: "small-pod", Node_name: "node-1", Cost: 0.01},
+		{Name: "<script>", Node_name: "node-1", Cost: 0.5, ComputeClass: cluster.ComputeClassBalanced},
+	}
+
+	// Test Case #1
+	report := newReport("test-cluster", "test-region-1", workloads, 0.1, 0.5)
+	if report.Workloads[0].Name != "<script>" || !almostEqual(report.MonthlyCost, 445.3) {
+		t.Fatalf(`newReport() = %+v doesn't match expected sorted workloads and $445.30 per month`, report)
+	}
+
+	var output bytes.Buffer
+	if err := writeHTMLReport(&output, report); err != nil {
+		t.Fatalf(`writeHTMLReport() returned error: %v`, err)
+	}
+	if !strings.Contains(output.String(), "test-cluster") || !strings.Contains(output.String(), "$445.30") || !strings.Contains(output.String(), "Standard vs Autopilot") {
+		t.Fatalf(`writeHTMLReport() output doesn't contain the cluster, total and comparison: %s`, output.String())
+	}
+	if strings.Contains(output.String(), "<script>") {
+		t.Fatalf(`writeHTMLReport() output doesn't escape the workload names: %s`, output.String())
+	}
+
+	// Test Case #2
+	output.Reset()
+	if err := writeHTMLReport(&output, newReport("test-cluster", "test-region-1", workloads, 0.1, -1)); err != nil || strings.Contains(output.String(), "<svg") {
+		t.Fatalf(`writeHTMLReport() without billed cost = %v contains the comparison chart: %s`, err, output.String())
+	}
+}
+
+func TestPopulateWorkloadsStorageRequests(t *testing.T) {
+	requestingPod := testPod("default", "requesting-pod", "node-1", nil)
+	requestingPod.Spec.Containers[0].Resources.Requests = corev1.ResourceList{
+		corev1.ResourceMemory:           resource.MustParse("200M"),
+		corev1.ResourceEphemeralStorage: resource.MustParse("10G"),
+	}
+	testService := newTestService([]corev1.Pod{
+		requestingPod,
+		testPod("default", "using-pod", "node-1", nil),
+	})
+
+	workloads, err := testService.PopulateWorkloads(testNodes())
+	if err != nil {
+		t.Fatalf(`PopulateWorkloads() returned error: %v`, err)
+	}
+
+	for _, workload := range workloads {
+		// Test Case #1
+		if workload.Name == "requesting-pod" && (workload.Storage != 10000 || workload.Memory != 200) {
+			t.Fatalf(`PopulateWorkloads() storage, memory of requesting-pod = %d, %d doesn't match expected 10000, 200`, workload.Storage, workload.Memory)
+		}
+
+		// Test Case #2
+		if workload.Name == "using-pod" && workload.Storage != 1000 {
+			t.Fatalf(`PopulateWorkloads() storage of using-pod = %d doesn't match expected 1000`, workload.Storage)
+		}
+	}
+}
+
+func TestPopulateWorkloadsOrphanPod(t *testing.T) {
+	testService := newTestService([]corev1.Pod{
+		testPod("default", "scheduled-pod", "node-1", nil),
+		testPod("default", "orphan-pod", "deleted-node", nil),
+		testPod("default", "pending-pod", "", nil),
+	})
+	nodes := testNodes()
+
+	workloads, err := testService.PopulateWorkloads(nodes)
+	if err != nil {
+		t.Fatalf(`PopulateWorkloads() returned error: %v`, err)
+	}
+
+	workloadsCost := 0.0
+	for _, workload := range workloads {
+		workloadsCost += workload.Cost
+	}
+	nodesCost := 0.0
+	for _, node := range nodes {
+		nodesCost += node.Cost
+	}
+
+	if len(workloads) != 3 || !almostEqual(workloadsCost, nodesCost) {
+		t.Fatalf(`PopulateWorkloads() node totals %.7f don't match workload totals %.7f for %d workloads`, nodesCost, workloadsCost, len(workloads))
+	}
+	if len(nodes["deleted-node"].Workloads) != 1 || len(nodes[cluster.UnscheduledNodeName].Workloads) != 1 {
+		t.Fatalf(`PopulateWorkloads() didn't group orphan pods under placeholder nodes: %v`, nodes)
+	}
+}
+
+func TestPopulateWorkloadsPodPhases(t *testing.T) {
+	terminatingPod := testPod("default", "terminating-pod", "node-1", nil)
+	terminatingPod.DeletionTimestamp = &metav1.Time{}
+	pendingPod := testPod("default", "pending-pod", "", nil)
+	pendingPod.Status.Phase = corev1.PodPending
+	pendingPod.Spec.Containers[0].Resources.Requests = corev1.ResourceList{
+		corev1.ResourceCPU:    resource.MustParse("500m"),
+		corev1.ResourceMemory: resource.MustParse("1G"),
+	}
+	pendingPodWithoutRequests := testPod("default", "pending-pod-without-requests", "", nil)
+	pendingPodWithoutRequests.Status.Phase = corev1.PodPending
+	pods := []corev1.Pod{testPod("default", "running-pod", "node-1", nil), terminatingPod, pendingPod, pendingPodWithoutRequests}
+
+	workloadNames := func(workloads []cluster.Workload) []string {
+		var names []string
+		for _, workload := range workloads {
+			names = append(names, workload.Name)
+		}
+		return names
+	}
+
+	// Test Case #1
+	testService := newTestService(pods)
+	workloads, err := testService.PopulateWorkloads(testNodes())
+	if err != nil || len(workloads) != 1 || workloads[0].Name != "running-pod" {
+		t.Fatalf(`PopulateWorkloads() = %v, %v doesn't match expected [running-pod]`, workloadNames(workloads), err)
+	}
+
+	// Test Case #2
+	testService = newTestService(pods)
+	testService.Filter.IncludePending = true
+	nodes := testNodes()
+	workloads, err = testService.PopulateWorkloads(nodes)
+	if err != nil || len(workloads) != 2 || workloads[1].Name != "pending-pod" || workloads[1].Cpu != 500 {
+		t.Fatalf(`PopulateWorkloads() with pending pods = %v, %v doesn't match expected [running-pod pending-pod]`, workloadNames(workloads), err)
+	}
+	if len(nodes[cluster.UnscheduledNodeName].Workloads) != 1 {
+		t.Fatalf(`PopulateWorkloads() didn't group the pending pod under %s: %v`, cluster.UnscheduledNodeName, nodes)
+	}
+
+	// Test Case #3
+	testService = newTestService(pods)
+	testService.Basis = calculator.BasisRequests
+	workloads, err = testService.PopulateWorkloads(testNodes())
+	if err != nil || len(workloads) != 2 || workloads[0].Cpu != 50 || workloads[1].Cpu != 500 {
+		t.Fatalf(`PopulateWorkloads() with requests basis = %+v, %v doesn't match expected running-pod at the minimum and pending-pod`, workloads, err)
+	}
+
+	// Test Case #4
+	// A pending H100 pod has no node, so its Accelerator cost leaves out the machine price
+	pendingGPUPod := testPod("default", "pending-gpu-pod", "", nil)
+	pendingGPUPod.Status.Phase = corev1.PodPending
+	pendingGPUPod.Spec.NodeSelector = map[string]string{"cloud.google.com/gke-accelerator": "nvidia-h100-80gb"}
+	pendingGPUPod.Spec.Containers[0].Resources.Requests = corev1.ResourceList{
+		corev1.ResourceCPU:    resource.MustParse("8"),
+		corev1.ResourceMemory: resource.MustParse("64G"),
+		"nvidia.com/gpu":      resource.MustParse("1"),
+	}
+	testService = newTestService([]corev1.Pod{pendingGPUPod})
+	testService.Filter.IncludePending = true
+	workloads, err = testService.PopulateWorkloads(testNodes())
+	if err != nil || len(workloads) != 1 || workloads[0].ComputeClass != cluster.ComputeClassAccelerator {
+		t.Fatalf(`PopulateWorkloads() with a pending H100 pod = %+v, %v doesn't match expected an Accelerator workload`, workloads, err)
+	}
+	gpuWant := testService.CalculatePricing(workloads[0].Cpu, workloads[0].Memory, workloads[0].Storage, 1, "nvidia-h100-80gb", cluster.ComputeClassAccelerator, "", false).Total
+	if !almostEqual(workloads[0].Cost, gpuWant) {
+		t.Fatalf(`PopulateWorkloads() with a pending H100 pod = cost %v doesn't match expected %v`, workloads[0].Cost, gpuWant)
+	}
+}
+
+func TestPopulateWorkloadsPerContainer(t *testing.T) {
+	pod := testPod("default", "two-container-pod", "node-1", nil)
+	pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{
+		Name: "sidecar",
+		Resources: corev1.ResourceRequirements{Requests: corev1.ResourceList{
+			corev1.ResourceCPU: resource.MustParse("500m"),
+		}},
+	})
+
+	// Test Case #1
+	testService := newTestService([]corev1.Pod{pod})
+	workloads, err := testService.PopulateWorkloads(testNodes())
+	if err != nil || len(workloads) != 1 || workloads[0].Containers != 2 || workloads[0].Cpu != 600 {
+		t.Fatalf(`PopulateWorkloads() = %+v, %v doesn't match expected one pod with 600 mCPU`, workloads, err)
+	}
+	podCost := workloads[0].Cost
+
+	// Test Case #2
+	testService = newTestService([]corev1.Pod{pod})
+	testService.PerContainer = true
+	nodes := testNodes()
+	workloads, err = testService.PopulateWorkloads(nodes)
+	if err != nil || len(workloads) != 2 {
+		t.Fatalf(`PopulateWorkloads() per container = %+v, %v doesn't match expected 2 containers`, workloads, err)
+	}
+	if workloads[0].Name != "two-container-pod/app" || workloads[0].Cpu != 100 || workloads[1].Name != "two-container-pod/sidecar" || workloads[1].Cpu != 500 {
+		t.Fatalf(`PopulateWorkloads() per container = %+v doesn't match expected app with 100 mCPU and sidecar with 500 mCPU`, workloads)
+	}
+	if workloads[1].Cost <= workloads[0].Cost || workloads[0].Cost+workloads[1].Cost > podCost {
+		t.Fatalf(`PopulateWorkloads() per container costs %f, %f don't add up within the pod cost %f`, workloads[0].Cost, workloads[1].Cost, podCost)
+	}
+	if len(nodes["node-1"].Workloads) != 2 || !almostEqual(nodes["node-1"].Cost, workloads[0].Cost+workloads[1].Cost) {
+		t.Fatalf(`PopulateWorkloads() per container didn't attach the containers to the node: %+v`, nodes["node-1"])
+	}
+}
+
+func TestNewLogger(t *testing.T) {
+	// Test Case #1
+	var output bytes.Buffer
+	logger, err := newLogger(&output, "warn", "json")
+	if err != nil {
+		t.Fatalf(`newLogger(warn, json) returned error: %v`, err)
+	}
+
+	logger.Info("hidden")
+	logger.Warn("Requested ARM pricing is not available in the region", "region", "test-region-1")
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal(output.Bytes(), &entry); err != nil || entry["level"] != "WARN" || entry["region"] != "test-region-1" {
+		t.Fatalf(`newLogger(warn, json) wrote %q doesn't match expected a single WARN json entry`, output.String())
+	}
+
+	// Test Case #2
+	if _, err := newLogger(&output, "loud", "text"); err == nil {
+		t.Fatalf(`newLogger(loud, text) didn't return an error`)
+	}
+
+	// Test Case #3
+	if _, err := newLogger(&output, "info", "xml"); err == nil {
+		t.Fatalf(`newLogger(info, xml) didn't return an error`)
+	}
+}
+
+func TestPopulateWorkloadsMixedArchitectures(t *testing.T) {
+	var logs bytes.Buffer
+	defaultLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&logs, nil)))
+	defer slog.SetDefault(defaultLogger)
+
+	nodes := testNodes()
+	nodes["node-arm"] = cluster.Node{Name: "node-arm", InstanceType: "t2a-standard-4", Region: "test-region-1"}
+
+	// Test Case #1
+	testService := newTestService([]corev1.Pod{
+		testPod("default", "x86-pod", "node-1", nil),
+	})
+	if _, err := testService.PopulateWorkloads(nodes); err != nil {
+		t.Fatalf(`PopulateWorkloads() returned error: %v`, err)
+	}
+	if strings.Contains(logs.String(), "ARM pricing is not available") {
+		t.Fatalf(`PopulateWorkloads() without ARM workloads warned about ARM pricing: %q`, logs.String())
+	}
+
+	// Test Case #2
+	testService = newTestService([]corev1.Pod{
+		testPod("default", "x86-pod", "node-1", nil),
+		testPod("default", "arm-pod", "node-arm", nil),
+		testPod("default", "other-arm-pod", "node-arm", nil),
+	})
+	if _, err := testService.PopulateWorkloads(nodes); err != nil {
+		t.Fatalf(`PopulateWorkloads() returned error: %v`, err)
+	}
+	if strings.Count(logs.String(), "ARM pricing is not available") != 1 || !strings.Contains(logs.String(), `"workloads":2`) {
+		t.Fatalf(`PopulateWorkloads() with 2 ARM workloads didn't warn once about them: %q`, logs.String())
+	}
+}
+
+func TestArmScaleoutOnDemandPricing(t *testing.T) {
+	// Test Case #1
+	// The on-demand and Spot Scale-Out Arm SKUs set their own prices
+	pricing := calculator.AutopilotPriceList{}
+	skus := map[string]float64{
+		"Autopilot Scale-Out Arm Pod mCPU Requests (test-region-1)":        0.0349,
+		"Autopilot Scale-Out Arm Pod Memory Requests (test-region-1)":      0.0039,
+		"Autopilot Scale-Out Arm Spot Pod mCPU Requests (test-region-1)":   0.0105,
+		"Autopilot Scale-Out Arm Spot Pod Memory Requests (test-region-1)": 0.0012,
+	}
+	for description, price := range skus {
+		if !pricing.SetPrice("test-region-1", description, price, nil) {
+			t.Fatalf(`SetPrice(%q) didn't match any price`, description)
+		}
+	}
+	if pricing.CpuArmScaleoutPrice != 0.0349 || pricing.MemoryArmScaleoutPrice != 0.0039 || pricing.SpotArmCpuScaleoutPrice != 0.0105 || pricing.SpotArmMemoryScaleoutPrice != 0.0012 {
+		t.Fatalf(`SetPrice() = %+v doesn't match expected on-demand Arm 0.0349/0.0039 and Spot Arm 0.0105/0.0012`, pricing)
+	}
+
+	// Test Case #2
+	// ARM nodes get the Scale-Out Arm class, the decision doesn't depend on spot
+	if computeClass := service.DecideComputeClass("arm-pod", "t2a-standard-4", 1000, 4000, 0, "", true); computeClass != cluster.ComputeClassScaleoutArm {
+		t.Fatalf(`DecideComputeClass() on an on-demand arm64 node = %s doesn't match expected %s`, cluster.ComputeClasses[computeClass], cluster.ComputeClasses[cluster.ComputeClassScaleoutArm])
+	}
+
+	// Test Case #3
+	armService := service
+	armService.AutopilotPricing.CpuArmScaleoutPrice = pricing.CpuArmScaleoutPrice
+	armService.AutopilotPricing.MemoryArmScaleoutPrice = pricing.MemoryArmScaleoutPrice
+	armService.AutopilotPricing.SpotArmCpuScaleoutPrice = pricing.SpotArmCpuScaleoutPrice
+	armService.AutopilotPricing.SpotArmMemoryScaleoutPrice = pricing.SpotArmMemoryScaleoutPrice
+	breakdown := armService.CalculatePricing(1000, 4000, 0, 0, "", cluster.ComputeClassScaleoutArm, "t2a-standard-4", false)
+	if !almostEqual(breakdown.CPU, 0.0349) || !almostEqual(breakdown.Memory, 0.0156) {
+		t.Fatalf(`CalculatePricing(1000, 4000, Scale-Out Arm, false) = %+v doesn't match expected the on-demand CPU 0.0349 and memory 0.0156`, breakdown)
+	}
+
+	// Test Case #4
+	breakdown = armService.CalculatePricing(1000, 4000, 0, 0, "", cluster.ComputeClassScaleoutArm, "t2a-standard-4", true)
+	if !almostEqual(breakdown.CPU, 0.0105) || !almostEqual(breakdown.Memory, 0.0048) {
+		t.Fatalf(`CalculatePricing(1000, 4000, Scale-Out Arm, true) = %+v doesn't match expected the Spot CPU 0.0105 and memory 0.0048`, breakdown)
+	}
+
+	// Test Case #5
+	// On-demand ARM workloads priced with the on-demand prices don't warn about missing ARM pricing
+	var logs bytes.Buffer
+	logger, _ := newLogger(&logs, "warn", "json")
+	defaultLogger := slog.Default()
+	slog.SetDefault(logger)
+	defer slog.SetDefault(defaultLogger)
+
+	nodes := testNodes()
+	nodes["node-arm"] = cluster.Node{Name: "node-arm", InstanceType: "t2a-standard-4", Region: "test-region-1"}
+	testService := newTestService([]corev1.Pod{testPod("default", "arm-pod", "node-arm", nil)})
+	testService.AutopilotPricing = armService.AutopilotPricing
+	workloads, err := testService.PopulateWorkloads(nodes)
+	if err != nil {
+		t.Fatalf(`PopulateWorkloads() returned error: %v`, err)
+	}
+	if len(workloads) != 1 || workloads[0].ComputeClass != cluster.ComputeClassScaleoutArm || workloads[0].Cost <= 0 || strings.Contains(logs.String(), "ARM pricing is not available") {
+		t.Fatalf(`PopulateWorkloads() on an on-demand arm64 node = %+v with logs %q doesn't match expected a priced Scale-Out Arm workload`, workloads, logs.String())
+	}
+}
+
+func TestResourceFootprint(t *testing.T) {
+	workloads := []cluster.Workload{
+		{Name: "web", Node_name: "node-1", Cpu: 250, Memory: 512, Storage: 1024, Cost: 0.02},
+		{Name: "api", Node_name: "node-1", Cpu: 500, Memory: 2048, Storage: 1024, Cost: 0.04},
+		{Name: "kube-dns", Node_name: "node-1", Cpu: 250, Memory: 512, Storage: 1024, Cost: 0.02, System: true},
+	}
+
+	// Test Case #1
+	// System workloads are left out, like in the totals
+	expected := resourceFootprint{Cpu: 750, Memory: 2560, Storage: 2048}
+	if total := newReport("test-cluster", "test-region-1", workloads, 0.1, -1).Footprint; total != expected {
+		t.Fatalf(`newReport().Footprint = %+v doesn't match expected %+v`, total, expected)
+	}
+
+	// Test Case #2
+	// The totals are the same when cheap workloads are aggregated
+	if total := footprint(aggregateCheapWorkloads(workloads, 0.03)); total != expected {
+		t.Fatalf(`footprint(aggregateCheapWorkloads()) = %+v doesn't match expected %+v`, total, expected)
+	}
+
+	// Test Case #3
+	nodes := map[string]cluster.Node{"node-1": {Name: "node-1", Workloads: workloads}}
+	model := workloadTableModel(nodes, 1, 1, 0, nil, 1, 0, false, false)
+	found := false
+	for _, row := range model.table.Rows() {
+		if row[0] == "Total billed resources" {
+			found = row[4] == "750m" && row[5] == "2.6G" && row[6] == "2G"
+		}
+	}
+	if !found {
+		t.Fatalf(`workloadTableModel() rows = %v don't have the expected footprint 750m, 2.6G and 2G`, model.table.Rows())
+	}
+}
+
+func TestWriteReportFiles(t *testing.T) {
+	workloads := []cluster.Workload{
+		{Name: "web", Namespace: "default", Node_name: "node-1", Cpu: 250, Memory: 512, Cost: 0.02},
+		{Name: "api", Namespace: "default", Node_name: "node-1", Cpu: 500, Memory: 1024, Cost: 0.04},
+	}
+	report := newReport("test-cluster", "test-region-1", workloads, 0.1, -1)
+	report.ClassDistribution = classDistribution(workloads)
+
+	// Test Case #1
+	formats, err := parseFormats("json, CSV,md,html")
+	if err != nil || strings.Join(formats, ",") != "json,csv,md,html" {
+		t.Fatalf(`parseFormats() = %v, %v doesn't match expected json,csv,md,html`, formats, err)
+	}
+	if _, err := parseFormats("json,pdf"); err == nil {
+		t.Fatalf(`parseFormats() with an unknown format = nil doesn't match expected error`)
+	}
+
+	// Test Case #2
+	dir := filepath.Join(t.TempDir(), "reports")
+	now := time.Date(2023, 7, 15, 9, 30, 0, 0, time.UTC)
+	paths, err := writeReportFiles(dir, formats, report, now)
+	if err != nil || len(paths) != 4 {
+		t.Fatalf(`writeReportFiles() = %v, %v doesn't match expected 4 files`, paths, err)
+	}
+	for _, format := range formats {
+		path := filepath.Join(dir, "report-test-cluster-20230715T093000Z."+format)
+		if info, err := os.Stat(path); err != nil || info.Size() == 0 {
+			t.Fatalf(`writeReportFiles() didn't write %s: %v`, path, err)
+		}
+	}
+
+	// Test Case #3
+	contents, _ := os.ReadFile(filepath.Join(dir, "report-test-cluster-20230715T093000Z.json"))
+	var decoded Report
+	if err := json.Unmarshal(contents, &decoded); err != nil || decoded.Cluster != "test-cluster" {
+		t.Fatalf(`writeReportFiles() json = %s doesn't match expected the report of test-cluster: %v`, contents, err)
+	}
+
+	// Test Case #4
+	contents, _ = os.ReadFile(filepath.Join(dir, "report-test-cluster-20230715T093000Z.csv"))
+	lines := strings.Split(strings.TrimSpace(string(contents)), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[1], "default,api,node-1,0,500,1024,0,") {
+		t.Fatalf(`writeReportFiles() csv = %q doesn't match expected a header and the api workload first`, contents)
+	}
+
+	// Test Case #5
+	contents, _ = os.ReadFile(filepath.Join(dir, "report-test-cluster-20230715T093000Z.md"))
+	if !strings.Contains(string(contents), "| api | node-1 |") || !strings.Contains(string(contents), "Monthly cost: $") {
+		t.Fatalf(`writeReportFiles() md = %q doesn't match expected the totals and workload table`, contents)
+	}
+}
+
+func TestWriteReportFile(t *testing.T) {
+	// Enough workloads for the json to span several buffers
+	var workloads []cluster.Workload
+	for i := 0; i < 2000; i++ {
+		workloads = append(workloads, cluster.Workload{Name: fmt.Sprintf("web-%d", i), Namespace: "default", Node_name: "node-1", Cpu: 250, Memory: 512, Cost: 0.02})
+	}
+	report := newReport("test-cluster", "test-region-1", workloads, 0.1, -1)
+	report.Nodes = reportNodes(map[string]cluster.Node{"node-1": {Name: "node-1", Workloads: workloads}}, 0)
+
+	// Test Case #1
+	path := filepath.Join(t.TempDir(), "report.json")
+	if err := writeReportFile(path, writeJSONReport, report); err != nil {
+		t.Fatalf(`writeReportFile() returned error: %v`, err)
+	}
+	contents, _ := os.ReadFile(path)
+	var decoded Report
+	if err := json.Unmarshal(contents, &decoded); err != nil || len(decoded.Nodes) != 1 || len(decoded.Nodes[0].Workloads) != len(workloads) {
+		t.Fatalf(`writeReportFile() wrote %d bytes that don't decode to the %d workloads: %v`, len(contents), len(workloads), err)
+	}
+
+	// Test Case #2
+	if err := writeReportFile(filepath.Join(t.TempDir(), "missing", "report.json"), writeJSONReport, report); err == nil {
+		t.Fatalf(`writeReportFile() in a missing directory = nil doesn't match expected error`)
+	}
+
+	// Test Case #3
+	failing := func(w io.Writer, report Report) error { return fmt.Errorf("template error") }
+	if err := writeReportFile(path, failing, report); err == nil || !strings.Contains(err.Error(), "template error") {
+		t.Fatalf(`writeReportFile() with a failing writer = %v doesn't match expected the writer error`, err)
+	}
+}
+
+const testManifest = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  namespace: shop
+spec:
+  replicas: 3
+  selector:
+    matchLabels:
+      app: web
+  template:
+    metadata:
+      labels:
+        app: web
+    spec:
+      containers:
+      - name: app
+        image: nginx
+        resources:
+          requests:
+            cpu: 500m
+            memory: 2G
+      - name: sidecar
+        image: envoy
+        resources:
+          requests:
+            cpu: 250m
+            memory: 1G
+---
+apiVersion: v1
+kind: Pod
+metadata:
+  name: batch
+spec:
+  nodeSelector:
+    cloud.google.com/gke-spot: "true"
+  containers:
+  - name: job
+    image: busybox
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: settings
+`
+
+func TestEstimateManifest(t *testing.T) {
+	// Test Case #1
+	workloads, err := calculator.ParseManifest(strings.NewReader(testManifest))
+	if err != nil || len(workloads) != 2 {
+		t.Fatalf(`ParseManifest() = %+v, %v doesn't match expected the Deployment and the Pod`, workloads, err)
+	}
+	if workloads[0].Kind != "Deployment" || workloads[0].Replicas != 3 || len(workloads[0].Spec.Containers) != 2 || workloads[1].Kind != "Pod" || workloads[1].Replicas != 1 {
+		t.Fatalf(`ParseManifest() = %+v doesn't match expected 3 replicas of web and 1 of batch`, workloads)
+	}
+
+	// Test Case #2
+	// The requests of the containers are summed, then priced like the pods of a cluster
+	estimates := service.EstimateManifest(workloads)
+	web := estimates[0]
+	podWant := service.CalculatePricing(750, 3000, 10, 0, "", cluster.ComputeClassGeneralPurpose, "", false).Total
+	if web.Cpu != 750 || web.Memory != 3000 || web.ComputeClass != cluster.ComputeClassGeneralPurpose || !almostEqual(web.PodCost, podWant) || !almostEqual(web.TotalCost, 3*podWant) {
+		t.Fatalf(`EstimateManifest() = %+v doesn't match expected 750 mCPU and 3000 MB at %v per pod`, web, podWant)
+	}
+
+	// Test Case #3
+	// Pods without requests get the minimums, and the spot node selector makes them Spot Pods
+	batch := estimates[1]
+	mCPUMin, memoryMin, storageMin := service.ValidateAndRoundResources(0, 0, 0)
+	spotWant := service.CalculatePricing(mCPUMin, memoryMin, storageMin, 0, "", cluster.ComputeClassGeneralPurpose, "", true).Total
+	if !batch.Spot || batch.Cpu != mCPUMin || !almostEqual(batch.TotalCost, spotWant) {
+		t.Fatalf(`EstimateManifest() = %+v doesn't match expected a Spot Pod at the minimums costing %v`, batch, spotWant)
+	}
+
+	// Test Case #4
+	var out bytes.Buffer
+	displayManifestEstimates(&out, estimates)
+	if !strings.Contains(out.String(), "Deployment web: 3 x 750 mCPU") || !strings.Contains(out.String(), fmt.Sprintf("Total: $%.4f per hour", 3*podWant+spotWant)) {
+		t.Fatalf(`displayManifestEstimates() = %q doesn't match expected the web line and the total`, out.String())
+	}
+
+	// Test Case #5
+	// Performance and Accelerator pods have no node whose machine type is priced, only their Autopilot premiums are
+	workloads, err = calculator.ParseManifest(strings.NewReader(`
+apiVersion: v1
+kind: Pod
+metadata:
+  name: solver
+spec:
+  nodeSelector:
+    cloud.google.com/compute-class: Performance
+  containers:
+  - name: solver
+    image: solver
+    resources:
+      requests:
+        cpu: "4"
+        memory: 16G
+---
+apiVersion: v1
+kind: Pod
+metadata:
+  name: trainer
+spec:
+  nodeSelector:
+    cloud.google.com/gke-accelerator: nvidia-h100-80gb
+  containers:
+  - name: trainer
+    image: trainer
+    resources:
+      requests:
+        cpu: "8"
+        memory: 64G
+        nvidia.com/gpu: "1"
+`))
+	if err != nil || len(workloads) != 2 {
+		t.Fatalf(`ParseManifest() = %+v, %v doesn't match expected the solver and trainer pods`, workloads, err)
+	}
+	estimates = service.EstimateManifest(workloads)
+	solver, trainer := estimates[0], estimates[1]
+	solverWant := service.CalculatePricing(solver.Cpu, solver.Memory, solver.Storage, 0, "", cluster.ComputeClassPerformance, "", false).Total
+	if solver.ComputeClass != cluster.ComputeClassPerformance || !almostEqual(solver.PodCost, solverWant) {
+		t.Fatalf(`EstimateManifest() = %+v doesn't match expected a Performance pod costing %v`, solver, solverWant)
+	}
+
+	// Test Case #6
+	trainerWant := service.CalculatePricing(trainer.Cpu, trainer.Memory, trainer.Storage, 1, "nvidia-h100-80gb", cluster.ComputeClassAccelerator, "", false).Total
+	if trainer.ComputeClass != cluster.ComputeClassAccelerator || !almostEqual(trainer.PodCost, trainerWant) {
+		t.Fatalf(`EstimateManifest() = %+v doesn't match expected an Accelerator pod with an H100 costing %v`, trainer, trainerWant)
+	}
+}
+
+func TestBurstableWorkloads(t *testing.T) {
+	// Every container uses 100 mCPU and 100 MB, below the requests of the burstable pod and far below its limits
+	burstable := testPod("default", "burstable", "node-1", nil)
+	burstable.Spec.Containers[0].Resources = corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{
+			corev1.ResourceCPU:              resource.MustParse("500m"),
+			corev1.ResourceMemory:           resource.MustParse("1000M"),
+			corev1.ResourceEphemeralStorage: resource.MustParse("1000M"),
+		},
+		Limits: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse("2000m"),
+			corev1.ResourceMemory: resource.MustParse("4000M"),
+		},
+	}
+	guaranteed := testPod("default", "guaranteed", "node-1", nil)
+	guaranteed.Spec.Containers[0].Resources = corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("500m"), corev1.ResourceMemory: resource.MustParse("1000M"), corev1.ResourceEphemeralStorage: resource.MustParse("1000M")},
+		Limits:   corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("500m"), corev1.ResourceMemory: resource.MustParse("1000M")},
+	}
+	pods := []corev1.Pod{burstable, guaranteed}
+
+	byName := func(workloads []cluster.Workload) map[string]cluster.Workload {
+		named := map[string]cluster.Workload{}
+		for _, workload := range workloads {
+			named[workload.Name] = workload
+		}
+		return named
+	}
+
+	// Test Case #1
+	// By default the requests are billed, and the limits only give the potential cost
+	testService := newTestService(pods)
+	workloads, err := testService.PopulateWorkloads(testNodes())
+	if err != nil || len(workloads) != 2 {
+		t.Fatalf(`PopulateWorkloads() = %+v, %v doesn't match expected two workloads`, workloads, err)
+	}
+	named := byName(workloads)
+	limitWant := testService.CalculatePricing(2000, 4000, 1000, 0, "", named["burstable"].ComputeClass, "", false).Total
+	if !named["burstable"].Burstable || !almostEqual(named["burstable"].Cost, named["burstable"].RequestCost) || !almostEqual(named["burstable"].LimitCost, limitWant) {
+		t.Fatalf(`PopulateWorkloads() burstable = %+v doesn't match expected the requests billed and a limit cost of %v`, named["burstable"], limitWant)
+	}
+	if named["guaranteed"].Burstable || !almostEqual(named["guaranteed"].LimitCost, named["guaranteed"].RequestCost) {
+		t.Fatalf(`PopulateWorkloads() guaranteed = %+v doesn't match expected the limits costing like the requests`, named["guaranteed"])
+	}
+
+	// Test Case #2
+	testService = newTestService(pods)
+	testService.Basis = calculator.BasisLimits
+	workloads, err = testService.PopulateWorkloads(testNodes())
+	if err != nil {
+		t.Fatalf(`PopulateWorkloads() returned error: %v`, err)
+	}
+	named = byName(workloads)
+	if !almostEqual(named["burstable"].Cost, limitWant) || !almostEqual(named["guaranteed"].Cost, named["guaranteed"].RequestCost) {
+		t.Fatalf(`PopulateWorkloads() with the limits basis = %+v doesn't match expected the burstable pod billed on its limits`, workloads)
+	}
+
+	// Test Case #3
+	report := newReport("test-cluster", "test-region-1", workloads, 0.1, -1)
+	report.BurstableWorkloads = burstableWorkloads(workloads)
+	expected := fmt.Sprintf("1 burstable workloads with limits above their requests: guaranteed $%.2f/month on requests, potential $%.2f/month on limits",
+		report.RequestBasedMonthlyCost, report.LimitBasedMonthlyCost)
+	if report.LimitBasedMonthlyCost <= report.RequestBasedMonthlyCost || report.burstingLine() != expected {
+		t.Fatalf(`burstingLine() = %q doesn't match expected %q`, report.burstingLine(), expected)
+	}
+}
+
+func TestFormatCost(t *testing.T) {
+	defer func() { costPrecision = defaultCostPrecision }()
+
+	tests := []struct {
+		cost      float64
+		precision int
+		expected  string
+	}{
+		// Test Case #1
+		{0.0573, 4, "$0.0573"},
+		// Test Case #2
+		// Tiny costs aren't in scientific notation
+		{0.00001234568, 4, "$0.0000"},
+		// Test Case #3
+		{0.00001234568, 6, "$0.000012"},
+		// Test Case #4
+		// Large costs are grouped by thousands
+		{1234567.891, 2, "$1,234,567.89"},
+		// Test Case #5
+		{123456789012, 2, "$123,456,789,012.00"},
+		// Test Case #6
+		{-1234.5, 2, "-$1,234.50"},
+		// Test Case #7
+		// Negative costs rounding to zero aren't negative
+		{-0.00001, 2, "$0.00"},
+		// Test Case #8
+		{0, 4, "$0.0000"},
+	}
+
+	for i, test := range tests {
+		costPrecision = test.precision
+		if formatted := formatCost(test.cost); formatted != test.expected {
+			t.Fatalf(`Test Case #%d: formatCost(%v) with precision %d = %q doesn't match expected %q`, i+1, test.cost, test.precision, formatted, test.expected)
+		}
+	}
+}
+
+func TestNodePoolFilter(t *testing.T) {
+	clientset := fake.NewSimpleClientset(
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1", Labels: map[string]string{cluster.NodePoolLabel: "default-pool"}}},
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-2", Labels: map[string]string{cluster.NodePoolLabel: "batch-pool"}}},
+	)
+
+	// Test Case #1
+	nodes, err := cluster.GetClusterNodes(clientset, 0)
+	if err != nil || nodes["node-1"].NodePool != "default-pool" || nodes["node-2"].NodePool != "batch-pool" {
+		t.Fatalf(`GetClusterNodes() = %+v, %v doesn't match expected the node pools from the labels`, nodes, err)
+	}
+
+	// Test Case #2
+	filtered := cluster.FilterNodePool(nodes, "batch-pool")
+	if _, ok := filtered["node-2"]; len(filtered) != 1 || !ok {
+		t.Fatalf(`FilterNodePool(batch-pool) = %+v doesn't match expected only node-2`, filtered)
+	}
+
+	// Test Case #3
+	// Only the pods of the pool are costed, the pods of other pools and the pending ones aren't grouped as unscheduled
+	pending := testPod("default", "pending-pod", "", nil)
+	pending.Status.Phase = corev1.PodPending
+	pending.Spec.Containers[0].Resources.Requests = corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("500m")}
+	testService := newTestService([]corev1.Pod{
+		testPod("default", "web", "node-1", nil),
+		testPod("default", "batch-job", "node-2", nil),
+		pending,
+	})
+	testService.Filter.NodePool = "batch-pool"
+	testService.Filter.IncludePending = true
+	for name, node := range filtered {
+		node.InstanceType = "e2-standard-4"
+		filtered[name] = node
+	}
+	workloads, err := testService.PopulateWorkloads(filtered)
+	if err != nil || len(workloads) != 1 || workloads[0].Name != "batch-job" || len(filtered) != 1 {
+		t.Fatalf(`PopulateWorkloads() in batch-pool = %+v, %v with nodes %+v doesn't match expected only batch-job`, workloads, err, filtered)
+	}
+
+	// Test Case #4
+	if total := estimatedHourlyCost(filtered, 0); !almostEqual(total, workloads[0].Cost) {
+		t.Fatalf(`estimatedHourlyCost() in batch-pool = %v doesn't match expected the cost of batch-job %v`, total, workloads[0].Cost)
+	}
+}
+
+func TestSelectSKUs(t *testing.T) {
+	sku := func(id string, description string, effectiveTime string) *cloudbilling.Sku {
+		return &cloudbilling.Sku{SkuId: id, Description: description, PricingInfo: []*cloudbilling.PricingInfo{{EffectiveTime: effectiveTime, PricingExpression: &cloudbilling.PricingExpression{}}}}
+	}
+	ids := func(skus []*cloudbilling.Sku) string {
+		var result []string
+		for _, sku := range skus {
+			result = append(result, sku.SkuId)
+		}
+		return strings.Join(result, ",")
+	}
+
+	// Test Case #1 - the SKU whose pricing took effect last is kept, whatever the order of the API
+	legacy := sku("B", "Autopilot Pod mCPU Requests (us-central1)", "2023-01-01T00:00:00Z")
+	current := sku("C", "autopilot pod  mCPU requests (us-central1)", "2023-07-01T00:00:00Z")
+	memory := sku("A", "Autopilot Pod Memory Requests (us-central1)", "2023-01-01T00:00:00Z")
+	expected := "C,A"
+	if got := ids(calculator.SelectSKUs([]*cloudbilling.Sku{legacy, current, memory}, time.Now())); got != expected {
+		t.Fatalf(`SelectSKUs() = %v doesn't match expected %v`, got, expected)
+	}
+	if got := ids(calculator.SelectSKUs([]*cloudbilling.Sku{memory, current, legacy}, time.Now())); got != expected {
+		t.Fatalf(`SelectSKUs() = %v doesn't match expected %v`, got, expected)
+	}
+
+	// Test Case #2 - with the same effective time, the lowest ID is kept
+	tied := sku("D", "Autopilot Pod mCPU Requests (us-central1)", "2023-07-01T00:00:00Z")
+	expected = "C"
+	if got := ids(calculator.SelectSKUs([]*cloudbilling.Sku{tied, current}, time.Now())); got != expected {
+		t.Fatalf(`SelectSKUs() = %v doesn't match expected %v`, got, expected)
+	}
+
+	// Test Case #3 - an unknown effective time loses to a known one
+	unknown := sku("0", "Autopilot Pod mCPU Requests (us-central1)", "")
+	expected = "C"
+	if got := ids(calculator.SelectSKUs([]*cloudbilling.Sku{unknown, current}, time.Now())); got != expected {
+		t.Fatalf(`SelectSKUs() = %v doesn't match expected %v`, got, expected)
+	}
+}
+
+func TestSKUPricingInfo(t *testing.T) {
+	pricingInfo := func(effectiveTime string, nanos int64) *cloudbilling.PricingInfo {
+		return &cloudbilling.PricingInfo{EffectiveTime: effectiveTime, PricingExpression: &cloudbilling.PricingExpression{
+			DisplayQuantity: 1, TieredRates: []*cloudbilling.TierRate{{UnitPrice: &cloudbilling.Money{Nanos: nanos}}},
+		}}
+	}
+	sku := &cloudbilling.Sku{SkuId: "A", Description: "Autopilot Pod mCPU Requests (us-central1)", PricingInfo: []*cloudbilling.PricingInfo{
+		pricingInfo("2024-01-01T00:00:00Z", 50000000),
+		pricingInfo("2023-01-01T00:00:00Z", 30000000),
+		pricingInfo("2023-07-01T00:00:00Z", 40000000),
+	}}
+	price := func(pricingInfo *cloudbilling.PricingInfo) float64 {
+		if pricingInfo == nil {
+			return -1
+		}
+		return calculator.SKUPrice(pricingInfo.PricingExpression)
+	}
+
+	cases := []struct {
+		at   string
+		want float64
+	}{
+		// Test Case #1 - the current price, not the scheduled one
+		{"2023-09-01T00:00:00Z", 0.04},
+		// Test Case #2 - a past price
+		{"2023-03-01T00:00:00Z", 0.03},
+		// Test Case #3 - the scheduled price once it took effect, and exactly when it does
+		{"2024-02-01T00:00:00Z", 0.05},
+		{"2024-01-01T00:00:00Z", 0.05},
+		// Test Case #4 - before any price
+		{"2022-01-01T00:00:00Z", -1},
+	}
+	for i, c := range cases {
+		at, _ := time.Parse(time.RFC3339, c.at)
+		if got := price(calculator.SKUPricingInfo(sku, at)); !almostEqual(got, c.want) {
+			t.Fatalf(`Test Case #%d: SKUPricingInfo(%s) price = %v doesn't match expected %v`, i+1, c.at, got, c.want)
+		}
+	}
+
+	// Test Case #5 - the selected SKUs only keep the pricing effective at the date
+	at, _ := time.Parse(time.RFC3339, "2023-09-01T00:00:00Z")
+	selected := calculator.SelectSKUs([]*cloudbilling.Sku{sku}, at)
+	if len(selected) != 1 || len(selected[0].PricingInfo) != 1 || !almostEqual(price(selected[0].PricingInfo[0]), 0.04) || len(sku.PricingInfo) != 3 {
+		t.Fatalf(`SelectSKUs() = %+v doesn't match expected the SKU with its 0.04 pricing only`, selected)
+	}
+}
+
+func TestParsePricingDate(t *testing.T) {
+	// Test Case #1
+	if date, err := parsePricingDate(""); err != nil || !date.IsZero() {
+		t.Fatalf(`parsePricingDate("") = %v, %v doesn't match expected the zero time`, date, err)
+	}
+
+	// Test Case #2
+	expected := time.Date(2023, 7, 1, 0, 0, 0, 0, time.UTC)
+	if date, err := parsePricingDate("2023-07-01"); err != nil || !date.Equal(expected) {
+		t.Fatalf(`parsePricingDate("2023-07-01") = %v, %v doesn't match expected %v`, date, err, expected)
+	}
+
+	// Test Case #3
+	expected = time.Date(2023, 7, 1, 12, 30, 0, 0, time.UTC)
+	if date, err := parsePricingDate("2023-07-01T14:30:00+02:00"); err != nil || !date.Equal(expected) {
+		t.Fatalf(`parsePricingDate("2023-07-01T14:30:00+02:00") = %v, %v doesn't match expected %v`, date, err, expected)
+	}
+
+	// Test Case #4
+	if date, err := parsePricingDate("07/01/2023"); err == nil {
+		t.Fatalf(`parsePricingDate("07/01/2023") = %v, nil doesn't match expected an error`, date)
+	}
+}
+
+func TestHistoricalPricing(t *testing.T) {
+	var startTimes []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		startTimes = append(startTimes, r.URL.Query().Get("startTime"))
+		w.Write([]byte(`{"skus": [{"description": "Autopilot Pod mCPU Requests (europe-west1)", "serviceRegions": ["europe-west1"], "pricingInfo": [
+			{"effectiveTime": "2023-01-01T00:00:00Z", "pricingExpression": {"displayQuantity": 1, "tieredRates": [{"unitPrice": {"units": "0", "nanos": 40000000}}]}},
+			{"effectiveTime": "2023-07-01T00:00:00Z", "pricingExpression": {"displayQuantity": 1, "tieredRates": [{"unitPrice": {"units": "0", "nanos": 44500000}}]}}
+		]}]}`))
+	}))
+	defer server.Close()
+
+	skus := map[string]string{"autopilot": "CCD8-9BF1-090E", "gce": "6F81-5844-456A"}
+	options := []option.ClientOption{option.WithEndpoint(server.URL + "/"), option.WithAPIKey("test-key")}
+
+	// Test Case #1 - past prices are requested from the API for the date
+	date := time.Date(2023, 3, 1, 0, 0, 0, 0, time.UTC)
+	pricing, err := calculator.GetRegionPricing(skus, nil, "europe-west1", date, options...)
+	if err != nil || !almostEqual(pricing.Autopilot.CpuPrice, 0.04) {
+		t.Fatalf(`GetRegionPricing(%v) = %+v, %v doesn't match expected the CPU price 0.04`, date, pricing.Autopilot, err)
+	}
+	if len(startTimes) != 2 || startTimes[0] != "2023-03-01T00:00:00Z" {
+		t.Fatalf(`GetRegionPricing(%v) start times = %v don't match expected 2023-03-01T00:00:00Z`, date, startTimes)
+	}
+
+	// Test Case #2 - the current prices are the latest ones
+	startTimes = nil
+	pricing, err = calculator.GetRegionPricing(skus, nil, "europe-west1", time.Time{}, options...)
+	if err != nil || !almostEqual(pricing.Autopilot.CpuPrice, 0.0445) {
+		t.Fatalf(`GetRegionPricing() = %+v, %v doesn't match expected the CPU price 0.0445`, pricing.Autopilot, err)
+	}
+	if len(startTimes) != 2 || startTimes[0] != "" {
+		t.Fatalf(`GetRegionPricing() start times = %v don't match expected none`, startTimes)
+	}
+}
+
+func TestCurrencyTotals(t *testing.T) {
+	var currencies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		currency := r.URL.Query().Get("currencyCode")
+		currencies = append(currencies, currency)
+		nanos, rate := 44500000, 1.0
+		if currency == "EUR" {
+			nanos, rate = 40050000, 0.9
+		}
+		fmt.Fprintf(w, `{"skus": [{"description": "Autopilot Pod mCPU Requests (europe-west1)", "serviceRegions": ["europe-west1"], "pricingInfo": [
+			{"currencyConversionRate": %v, "pricingExpression": {"displayQuantity": 1, "tieredRates": [{"unitPrice": {"units": "0", "nanos": %d}}]}}
+		]}]}`, rate, nanos)
+	}))
+	defer server.Close()
+
+	skus := map[string]string{"autopilot": "CCD8-9BF1-090E", "gce": "6F81-5844-456A"}
+	options := []option.ClientOption{option.WithEndpoint(server.URL + "/"), option.WithAPIKey("test-key")}
+
+	// Test Case #1
+	parsed, err := parseCurrencies(" usd, EUR,USD")
+	if err != nil || strings.Join(parsed, ",") != "USD,EUR" {
+		t.Fatalf(`parseCurrencies() = %v, %v doesn't match expected USD,EUR`, parsed, err)
+	}
+	if _, err := parseCurrencies("USD,euro"); err == nil {
+		t.Fatalf(`parseCurrencies("USD,euro") didn't return an error`)
+	}
+
+	// Test Case #2
+	var pricings []calculator.RegionPricing
+	for _, currency := range parsed {
+		pricing, err := calculator.GetCurrencyPricing(skus, nil, "europe-west1", currency, time.Time{}, options...)
+		if err != nil || pricing.Autopilot.Currency != currency {
+			t.Fatalf(`GetCurrencyPricing(%s) = %+v, %v doesn't match expected the prices in %s`, currency, pricing.Autopilot, err, currency)
+		}
+		pricings = append(pricings, pricing)
+	}
+	if strings.Join(currencies, ",") != "USD,USD,EUR,EUR" {
+		t.Fatalf(`GetCurrencyPricing() requested the currencies %v, expected USD,USD,EUR,EUR`, currencies)
+	}
+
+	// Test Case #3
+	// The workloads are repriced in each currency, the cluster fee is converted at the rate of the SKUs
+	testService := newTestService(nil)
+	workloads := []cluster.Workload{{Name: "web", Node_name: "node-1", Cpu: 1000, ComputeClass: cluster.ComputeClassGeneralPurpose}}
+	totals := testService.CompareCurrencies(workloads, testNodes(), calculator.CLUSTER_FEE, pricings)
+	if len(totals) != 2 || totals[0].Currency != "USD" || !almostEqual(totals[0].HourlyCost, 0.1445) || totals[1].Currency != "EUR" || !almostEqual(totals[1].HourlyCost, 0.13005) {
+		t.Fatalf(`CompareCurrencies() = %+v doesn't match expected 0.1445 USD and 0.13005 EUR per hour`, totals)
+	}
+
+	// Test Case #4
+	var output bytes.Buffer
+	displayCurrencyTotals(&output, totals)
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	if len(lines) != 3 || strings.Join(strings.Fields(lines[0]), " ") != "Total USD EUR" ||
+		strings.Join(strings.Fields(lines[2]), " ") != fmt.Sprintf("per month %.2f %.2f", totals[0].MonthlyCost, totals[1].MonthlyCost) {
+		t.Fatalf(`displayCurrencyTotals() = %q doesn't match expected a USD and a EUR column`, output.String())
+	}
+
+	// Test Case #5 - a prorated Job running 73 hours a month stays billed for a tenth of the month
+	workloads[0].JobRuntime, workloads[0].JobRuns = 7200, 36.5
+	totals = testService.CompareCurrencies(workloads, testNodes(), calculator.CLUSTER_FEE, pricings)
+	if len(totals) != 2 || !almostEqual(totals[0].HourlyCost, 0.10445) || !almostEqual(totals[1].HourlyCost, 0.094005) {
+		t.Fatalf(`CompareCurrencies() = %+v doesn't match expected 0.10445 USD and 0.094005 EUR per hour`, totals)
+	}
+}
+
+func TestWideNodeTable(t *testing.T) {
+	clientset := fake.NewSimpleClientset(&corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1", Labels: map[string]string{
+			cluster.NodePoolLabel:           "default-pool",
+			corev1.LabelTopologyZone:        "test-region-1-b",
+			"topology.kubernetes.io/region": "test-region-1",
+		}},
+		Status: corev1.NodeStatus{
+			NodeInfo: corev1.NodeSystemInfo{KubeletVersion: "v1.27.3-gke.100"},
+			Addresses: []corev1.NodeAddress{
+				{Type: corev1.NodeInternalIP, Address: "10.128.0.2"},
+				{Type: corev1.NodeInternalIP, Address: "fd20:1:2:3::2"},
+				{Type: corev1.NodeExternalIP, Address: "34.1.2.3"},
+			},
+		},
+	})
+
+	// Test Case #1
+	nodes, err := cluster.GetClusterNodes(clientset, 0)
+	node := nodes["node-1"]
+	if err != nil || node.Zone != "test-region-1-b" || node.KubeletVersion != "v1.27.3-gke.100" || strings.Join(node.InternalIPs, ",") != "10.128.0.2,fd20:1:2:3::2" {
+		t.Fatalf(`GetClusterNodes() = %+v, %v doesn't match expected the zone, kubelet version and dual-stack internal IPs`, node, err)
+	}
+
+	// Test Case #2
+	var output bytes.Buffer
+	DisplayNodeTable(&output, nodes, false)
+	if strings.Contains(output.String(), "Zone") || strings.Contains(output.String(), "10.128.0.2") {
+		t.Fatalf(`DisplayNodeTable() output contains the wide columns: %q`, output.String())
+	}
+
+	// Test Case #3
+	output.Reset()
+	DisplayNodeTable(&output, nodes, true)
+	for _, expected := range []string{"Zone", "Node Pool", "Version", "Internal IPs", "test-region-1-b", "default-pool", "v1.27.3-gke.100", "10.128.0.2,fd20:1:2:3::2"} {
+		if !strings.Contains(output.String(), expected) {
+			t.Fatalf(`DisplayNodeTable() wide output doesn't contain %q: %q`, expected, output.String())
+		}
+	}
+}
+
+func TestGetContainerUsage(t *testing.T) {
+	var filters, aligners []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v3/projects/test-project/timeSeries" {
+			t.Errorf(`GetContainerUsage() queried %s doesn't match expected /v3/projects/test-project/timeSeries`, r.URL.Path)
+		}
+		filter := r.URL.Query().Get("filter")
+		filters = append(filters, filter)
+		aligners = append(aligners, r.URL.Query().Get("aggregation.perSeriesAligner"))
+
+		resource := `"resource": {"type": "k8s_container", "labels": {"namespace_name": "default", "pod_name": "web", "container_name": "app"}}`
+		if strings.Contains(filter, "core_usage_time") {
+			var points []string
+			for i := 1; i <= 20; i++ {
+				points = append(points, fmt.Sprintf(`{"value": {"doubleValue": %g}}`, float64(i)*0.05))
+			}
+			fmt.Fprintf(w, `{"timeSeries": [{%s, "points": [%s]}]}`, resource, strings.Join(points, ","))
+			return
+		}
+		fmt.Fprintf(w, `{"timeSeries": [{%s, "points": [{"value": {"doubleValue": 400000000}}, {"value": {"doubleValue": 200000000}}]}]}`, resource)
+	}))
+	defer server.Close()
+
+	monitoringService, err := monitoring.NewService(context.Background(), option.WithEndpoint(server.URL+"/"), option.WithoutAuthentication())
+	if err != nil {
+		t.Fatalf(`monitoring.NewService() returned error: %v`, err)
+	}
+
+	// Test Case #1
+	now := time.Date(2023, 7, 2, 0, 0, 0, 0, time.UTC)
+	usage, err := calculator.GetContainerUsage(context.Background(), monitoringService, "test-project", "test-region-1", "test-cluster", 24*time.Hour, 95, now)
+	app := usage["default/web/app"]
+	if err != nil || app.Cpu().MilliValue() != 950 || app.Memory().Value() != 400000000 {
+		t.Fatalf(`GetContainerUsage() = %v, %v doesn't match expected the p95 of 950m CPU and 400M memory`, usage, err)
+	}
+	if len(filters) != 2 || !strings.Contains(filters[0], `resource.labels.cluster_name="test-cluster"`) || !strings.Contains(filters[1], `memory_type="non-evictable"`) || aligners[0] != "ALIGN_RATE" || aligners[1] != "ALIGN_MEAN" {
+		t.Fatalf(`GetContainerUsage() sent unexpected filters %q with aligners %v`, filters, aligners)
+	}
+
+	// Test Case #2
+	// The usage over the window replaces the metrics-server snapshot of 100m CPU and 100M memory
+	testService := newTestService([]corev1.Pod{testPod("default", "web", "node-1", nil), testPod("default", "api", "node-1", nil)})
+	testService.Usage = usage
+	workloads, err := testService.PopulateWorkloads(testNodes())
+	if err != nil || len(workloads) != 2 {
+		t.Fatalf(`PopulateWorkloads() = %+v, %v doesn't match expected 2 workloads`, workloads, err)
+	}
+	for _, workload := range workloads {
+		expectedCpu, expectedMemory := int64(950), int64(400)
+		if workload.Name == "api" {
+			expectedCpu, expectedMemory = 100, 100
+		}
+		if workload.RawCpu != expectedCpu || workload.RawMemory != expectedMemory {
+			t.Fatalf(`PopulateWorkloads() %s = %d mCPU, %d MB doesn't match expected %d mCPU, %d MB`, workload.Name, workload.RawCpu, workload.RawMemory, expectedCpu, expectedMemory)
+		}
+	}
+
+	// Test Case #3
+	if p := calculator.Percentile([]float64{3, 1, 2}, 50); p != 2 {
+		t.Fatalf(`Percentile() = %v doesn't match expected 2`, p)
+	}
+}
+
+func TestProrateJobs(t *testing.T) {
+	deadline := int64(600)
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{Name: "nightly-report", Namespace: "default", UID: "job-uid"},
+		Spec:       batchv1.JobSpec{ActiveDeadlineSeconds: &deadline},
+	}
+	jobPod := testPod("default", "nightly-report-abcde", "node-1", nil)
+	controller := true
+	jobPod.OwnerReferences = []metav1.OwnerReference{{APIVersion: "batch/v1", Kind: "Job", Name: job.Name, UID: job.UID, Controller: &controller}}
+	workloadCost := func(testService calculator.PricingService) (cluster.Workload, cluster.Workload) {
+		if _, err := testService.Clientset.BatchV1().Jobs("default").Create(context.Background(), job, metav1.CreateOptions{}); err != nil {
+			t.Fatalf(`Jobs().Create() returned error: %v`, err)
+		}
+		workloads, err := testService.PopulateWorkloads(testNodes())
+		if err != nil || len(workloads) != 2 {
+			t.Fatalf(`PopulateWorkloads() = %+v, %v doesn't match expected 2 workloads`, workloads, err)
+		}
+		if workloads[0].Name == "web" {
+			return workloads[1], workloads[0]
+		}
+		return workloads[0], workloads[1]
+	}
+
+	// Test Case #1 - without proration, the Job pod costs like any other pod
+	testService := newTestService([]corev1.Pod{jobPod, testPod("default", "web", "node-1", nil)})
+	jobWorkload, webWorkload := workloadCost(testService)
+	if !almostEqual(jobWorkload.Cost, webWorkload.Cost) || jobWorkload.JobRuntime != 0 {
+		t.Fatalf(`PopulateWorkloads() Job pod cost = %v doesn't match expected %v`, jobWorkload.Cost, webWorkload.Cost)
+	}
+	hourlyCost := webWorkload.Cost
+
+	// Test Case #2 - prorated to the 10 minutes deadline of the Job, once a month
+	testService = newTestService([]corev1.Pod{jobPod, testPod("default", "web", "node-1", nil)})
+	testService.ProrateJobs = true
+	jobWorkload, webWorkload = workloadCost(testService)
+	expected := hourlyCost * (10.0 / 60) / calculator.HOURS_PER_MONTH
+	if !almostEqual(jobWorkload.Cost, expected) || !almostEqual(jobWorkload.Breakdown.Total, expected) || jobWorkload.JobRuntime != 600 || !almostEqual(webWorkload.Cost, hourlyCost) {
+		t.Fatalf(`PopulateWorkloads() Job pod = %v, %v seconds doesn't match expected %v, 600 seconds`, jobWorkload.Cost, jobWorkload.JobRuntime, expected)
+	}
+
+	// Test Case #3 - the runtime of -job-runtime takes precedence, and is billed for a minute at least
+	testService = newTestService([]corev1.Pod{jobPod, testPod("default", "web", "node-1", nil)})
+	testService.ProrateJobs = true
+	testService.JobRuntime = 20 * time.Second
+	jobWorkload, _ = workloadCost(testService)
+	expected = hourlyCost * (1.0 / 60) / calculator.HOURS_PER_MONTH
+	if !almostEqual(jobWorkload.Cost, expected) || jobWorkload.JobRuntime != 60 {
+		t.Fatalf(`PopulateWorkloads() Job pod = %v, %v seconds doesn't match expected %v, 60 seconds`, jobWorkload.Cost, jobWorkload.JobRuntime, expected)
+	}
+
+	// Test Case #4
+	if billed := calculator.BilledRuntime(90*time.Second + time.Millisecond); billed != 91*time.Second {
+		t.Fatalf(`BilledRuntime() = %v doesn't match expected 1m31s`, billed)
+	}
+
+	// Test Case #5 - a Job of a CronJob running every 5 minutes is billed for each of its 8760 runs per month
+	cronJob := &batchv1.CronJob{
+		ObjectMeta: metav1.ObjectMeta{Name: "sync", Namespace: "default", UID: "cronjob-uid"},
+		Spec:       batchv1.CronJobSpec{Schedule: "*/5 * * * *"},
+	}
+	cronJobRun := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "sync-28000000",
+			Namespace:       "default",
+			UID:             "cronjob-run-uid",
+			OwnerReferences: []metav1.OwnerReference{{APIVersion: "batch/v1", Kind: "CronJob", Name: cronJob.Name, UID: cronJob.UID, Controller: &controller}},
+		},
+	}
+	cronJobPod := testPod("default", "sync-28000000-abcde", "node-1", nil)
+	cronJobPod.OwnerReferences = []metav1.OwnerReference{{APIVersion: "batch/v1", Kind: "Job", Name: cronJobRun.Name, UID: cronJobRun.UID, Controller: &controller}}
+	testService = newTestService([]corev1.Pod{cronJobPod})
+	testService.ProrateJobs = true
+	testService.JobRuntime = time.Minute
+	if _, err := testService.Clientset.BatchV1().CronJobs("default").Create(context.Background(), cronJob, metav1.CreateOptions{}); err != nil {
+		t.Fatalf(`CronJobs().Create() returned error: %v`, err)
+	}
+	if _, err := testService.Clientset.BatchV1().Jobs("default").Create(context.Background(), cronJobRun, metav1.CreateOptions{}); err != nil {
+		t.Fatalf(`Jobs().Create() returned error: %v`, err)
+	}
+	workloads, err := testService.PopulateWorkloads(testNodes())
+	if err != nil || len(workloads) != 1 {
+		t.Fatalf(`PopulateWorkloads() = %+v, %v doesn't match expected 1 workload`, workloads, err)
+	}
+	expected = hourlyCost * 8760 / 60 / calculator.HOURS_PER_MONTH
+	if !almostEqual(workloads[0].Cost, expected) || workloads[0].JobRuns != 8760 || workloads[0].JobRuntime != 60 {
+		t.Fatalf(`PopulateWorkloads() CronJob pod = %v, %v runs doesn't match expected %v, 8760 runs`, workloads[0].Cost, workloads[0].JobRuns, expected)
+	}
+
+	// Test Case #6 - runs per month of schedules, averaged over 2023
+	for schedule, expectedRuns := range map[string]float64{
+		"@hourly":                 8760.0 / 12,
+		"@weekly":                 53.0 / 12,
+		"0 0 1,15 * *":            2,
+		"30 9 * * mon-fri":        260.0 / 12,
+		"0 0 1 * 0":               (12.0 + 53 - 2) / 12,
+		"CRON_TZ=UTC 0 */6 * * *": 4 * 365.0 / 12,
+		"0 12 * jan-mar/2 *":      (31.0 + 31) / 12,
+		"0 0 * * 7":               53.0 / 12,
+	} {
+		runs, err := calculator.RunsPerMonth(schedule)
+		if err != nil || !almostEqual(runs, expectedRuns) {
+			t.Fatalf(`RunsPerMonth(%q) = %v, %v doesn't match expected %v`, schedule, runs, err, expectedRuns)
+		}
+	}
+	for _, schedule := range []string{"61 * * * *", "* * * *", "@often", "*/0 * * * *", "5-1 * * * *"} {
+		if _, err := calculator.RunsPerMonth(schedule); err == nil {
+			t.Fatalf(`RunsPerMonth(%q) returned no error`, schedule)
+		}
+	}
+}
+
+func TestExportMonitoringMetrics(t *testing.T) {
+	var requests []monitoring.CreateTimeSeriesRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v3/projects/test-project/timeSeries" {
+			t.Errorf(`exportMonitoringMetrics() wrote to %s doesn't match expected /v3/projects/test-project/timeSeries`, r.URL.Path)
+		}
+		var request monitoring.CreateTimeSeriesRequest
+		json.NewDecoder(r.Body).Decode(&request)
+		requests = append(requests, request)
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	monitoringService, err := monitoring.NewService(context.Background(), option.WithEndpoint(server.URL+"/"), option.WithoutAuthentication())
+	if err != nil {
+		t.Fatalf(`monitoring.NewService() returned error: %v`, err)
+	}
+
+	workloads := []cluster.Workload{
+		{Name: "web", Namespace: "default", Cost: 0.5, ComputeClass: cluster.ComputeClassBalanced},
+		{Name: "kube-dns", Namespace: "kube-system", Cost: 0.2, System: true},
+	}
+
+	// Test Case #1
+	err = exportMonitoringMetrics(context.Background(), monitoringService, "test-project", "test-region-1", "test-cluster", workloads, 0.1)
+	if err != nil || len(requests) != 1 || len(requests[0].TimeSeries) != 3 {
+		t.Fatalf(`exportMonitoringMetrics() = %v with requests %+v doesn't match expected 3 time series in one request`, err, requests)
+	}
+	web, total := requests[0].TimeSeries[0], requests[0].TimeSeries[2]
+	if web.Metric.Type != "custom.googleapis.com/autopilot/estimated_cost" || web.Resource.Type != "k8s_pod" ||
+		web.Resource.Labels["cluster_name"] != "test-cluster" || web.Resource.Labels["namespace_name"] != "default" || web.Resource.Labels["pod_name"] != "web" ||
+		web.Metric.Labels["compute_class"] != "Balanced" || !almostEqual(*web.Points[0].Value.DoubleValue, 0.5) {
+		t.Fatalf(`exportMonitoringMetrics() workload time series = %+v doesn't match expected the cost of web`, web)
+	}
+	// The system workload is left out of the total
+	if total.Resource.Type != "k8s_cluster" || total.Resource.Labels["location"] != "test-region-1" || !almostEqual(*total.Points[0].Value.DoubleValue, 0.6) {
+		t.Fatalf(`exportMonitoringMetrics() cluster time series = %+v doesn't match expected the total of 0.6`, total)
+	}
+
+	// Test Case #2
+	requests = nil
+	workloads = nil
+	for i := 0; i < 250; i++ {
+		workloads = append(workloads, cluster.Workload{Name: fmt.Sprintf("pod-%d", i), Namespace: "default", Cost: 0.01})
+	}
+	err = exportMonitoringMetrics(context.Background(), monitoringService, "test-project", "test-region-1", "test-cluster", workloads, 0.1)
+	if err != nil || len(requests) != 2 || len(requests[0].TimeSeries) != 200 || len(requests[1].TimeSeries) != 51 {
+		t.Fatalf(`exportMonitoringMetrics() = %v with %d requests doesn't match expected batches of 200 and 51 time series`, err, len(requests))
+	}
+}
+
+func TestExplainPricing(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"skus": [
+			{"skuId": "AAAA-1111", "description": "Autopilot Pod mCPU Requests (europe-west1)", "serviceRegions": ["europe-west1"], "pricingInfo": [{"pricingExpression": {"usageUnit": "h", "displayQuantity": 1, "tieredRates": [{"unitPrice": {"units": "0", "nanos": 44500000}}]}}]},
+			{"skuId": "BBBB-2222", "description": "Compute optimized Instance Core running in Belgium", "serviceRegions": ["europe-west1"], "pricingInfo": [{"pricingExpression": {"usageUnit": "h", "displayQuantity": 1, "tieredRates": [{"unitPrice": {"units": "0", "nanos": 37000000}}]}}]},
+			{"skuId": "CCCC-3333", "description": "Unrelated SKU", "serviceRegions": ["europe-west1"], "pricingInfo": [{"pricingExpression": {"usageUnit": "h", "displayQuantity": 1, "tieredRates": [{"unitPrice": {"units": "1"}}]}}]}
+		]}`))
+	}))
+	defer server.Close()
+
+	skus := map[string]string{"autopilot": "CCD8-9BF1-090E", "gce": "6F81-5844-456A"}
+	pricing, err := calculator.GetRegionPricing(skus, nil, "europe-west1", time.Time{}, option.WithEndpoint(server.URL+"/"), option.WithAPIKey("test-key"))
+	if err != nil {
+		t.Fatalf(`GetRegionPricing() returned error: %v`, err)
+	}
+
+	// Test Case #1
+	sources := pricing.Explain()
+	var cpu, memory calculator.PriceSource
+	for _, source := range sources {
+		if source.List == "autopilot" && source.Field == "CpuPrice" {
+			cpu = source
+		}
+		if source.List == "autopilot" && source.Field == "MemoryPrice" {
+			memory = source
+		}
+	}
+	if cpu.SkuID != "AAAA-1111" || cpu.Description != "Autopilot Pod mCPU Requests (europe-west1)" || !almostEqual(cpu.Price, 0.0445) || memory.SkuID != "" {
+		t.Fatalf(`Explain() = %+v, %+v doesn't match expected the CPU SKU and an unmatched memory price`, cpu, memory)
+	}
+	if sources[0].List != "autopilot" || sources[len(sources)-1].List != "gce" {
+		t.Fatalf(`Explain() = %+v doesn't list the autopilot then the gce fields`, sources)
+	}
+
+	// Test Case #2
+	var output bytes.Buffer
+	displayPricingExplanation(&output, sources)
+	for _, expected := range []string{
+		"autopilot  CpuPrice",
+		"0.0445",
+		"AAAA-1111",
+		"gce        C2CpuPrice",
+		"BBBB-2222  Compute optimized Instance Core running in Belgium",
+	} {
+		if !strings.Contains(output.String(), expected) {
+			t.Fatalf(`displayPricingExplanation() output doesn't contain %q: %q`, expected, output.String())
+		}
+	}
+	unmatched := false
+	for _, line := range strings.Split(output.String(), "\n") {
+		if strings.HasPrefix(line, "autopilot  MemoryPrice ") {
+			unmatched = strings.Contains(line, "UNMATCHED")
+		}
+	}
+	if !unmatched {
+		t.Fatalf(`displayPricingExplanation() output doesn't list MemoryPrice as UNMATCHED: %q`, output.String())
+	}
+	if strings.Contains(output.String(), "CCCC-3333") {
+		t.Fatalf(`displayPricingExplanation() output contains the unrelated SKU: %q`, output.String())
+	}
+}
+
+func TestFleetEstimate(t *testing.T) {
+	// The memberships of the fleet span two pages, with a cluster outside of GKE and a deleted GKE cluster
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/projects/fleet-project/locations/-/memberships" {
+			t.Errorf(`listFleetClusters() listed %s doesn't match expected /v1/projects/fleet-project/locations/-/memberships`, r.URL.Path)
+		}
+		if r.URL.Query().Get("pageToken") == "" {
+			w.Write([]byte(`{"resources": [
+				{"name": "projects/fleet-project/locations/us-central1/memberships/prod", "endpoint": {"gkeCluster": {"resourceLink": "//container.googleapis.com/projects/prod-project/locations/us-central1/clusters/prod"}}},
+				{"name": "projects/fleet-project/locations/global/memberships/on-prem", "endpoint": {"onPremCluster": {"resourceLink": "//gkeonprem.googleapis.com/projects/fleet-project/locations/us-west1/vmwareClusters/on-prem"}}}
+			], "nextPageToken": "page-2"}`))
+			return
+		}
+		w.Write([]byte(`{"resources": [
+			{"name": "projects/fleet-project/locations/europe-west1/memberships/staging", "endpoint": {"gkeCluster": {"resourceLink": "//container.googleapis.com/projects/fleet-project/zones/europe-west1-b/clusters/staging"}}},
+			{"name": "projects/fleet-project/locations/us-east1/memberships/deleted", "endpoint": {"gkeCluster": {"resourceLink": "//container.googleapis.com/projects/fleet-project/locations/us-east1/clusters/deleted", "clusterMissing": true}}}
+		]}`))
+	}))
+	defer server.Close()
+
+	hubService, err := gkehub.NewService(context.Background(), option.WithEndpoint(server.URL+"/"), option.WithoutAuthentication())
+	if err != nil {
+		t.Fatalf(`gkehub.NewService() returned error: %v`, err)
+	}
+
+	// Test Case #1
+	clusters, err := listFleetClusters(context.Background(), hubService, "fleet-project")
+	var paths []string
+	for _, fc := range clusters {
+		paths = append(paths, fc.path())
+	}
+	if err != nil || strings.Join(paths, ",") != "projects/prod-project/locations/us-central1/clusters/prod,projects/fleet-project/locations/europe-west1-b/clusters/staging" {
+		t.Fatalf(`listFleetClusters() = %+v, %v doesn't match expected the prod and staging clusters`, clusters, err)
+	}
+
+	// Test Case #2, the staging cluster can't be reached and the fleet total only includes prod
+	fleet := estimateFleet("fleet-project", clusters, func(fc fleetCluster) (Report, error) {
+		if fc.Name == "staging" {
+			return Report{}, fmt.Errorf("unable to reach the cluster: connection refused")
+		}
+		return newReport(fc.Name, fc.Location, []cluster.Workload{{Name: "web", Namespace: "default", Cost: 0.5}}, 0.1, -1), nil
+	})
+	if fleet.Failed != 1 || len(fleet.Clusters) != 2 || fleet.Clusters[0].Report == nil || fleet.Clusters[1].Error == "" || !almostEqual(fleet.HourlyCost, 0.6) {
+		t.Fatalf(`estimateFleet() = %+v doesn't match expected prod estimated at 0.6 per hour and an error for staging`, fleet)
+	}
+
+	// Test Case #3
+	var output bytes.Buffer
+	displayFleetReport(&output, fleet)
+	if !strings.Contains(output.String(), "connection refused") || !strings.Contains(output.String(), "1 of 2 clusters estimated") {
+		t.Fatalf(`displayFleetReport() = %q doesn't contain the error of staging and the count of estimated clusters`, output.String())
+	}
+
+	// Test Case #4
+	if fc, err := parseGKEResourceLink("//container.googleapis.com/projects/p/clusters/c"); err == nil {
+		t.Fatalf(`parseGKEResourceLink() = %+v doesn't match expected an error`, fc)
+	}
+}
+
+func TestNodeArch(t *testing.T) {
+	testCases := []struct {
+		node  cluster.Node
+		arm64 bool
+	}{
+		// Test Case #1
+		{cluster.Node{InstanceType: "t2a-standard-4"}, true},
+		// Test Case #2
+		{cluster.Node{InstanceType: "c4a-standard-8"}, true},
+		// Test Case #3
+		{cluster.Node{InstanceType: "n2-standard-4"}, false},
+		// Test Case #4, the kubernetes.io/arch label takes precedence over the machine type
+		{cluster.Node{InstanceType: "n2-standard-4", Arch: "arm64"}, true},
+		// Test Case #5
+		{cluster.Node{InstanceType: "t2a-standard-4", Arch: "amd64"}, false},
+	}
+
+	for _, testCase := range testCases {
+		if arm64 := testCase.node.Arm64(); arm64 != testCase.arm64 {
+			t.Fatalf(`Node{%s, %q}.Arm64() = %t doesn't match expected %t`, testCase.node.InstanceType, testCase.node.Arch, arm64, testCase.arm64)
+		}
+	}
+
+	// Test Case #6
+	if arch := cluster.MachineTypeArch("C4A-HIGHMEM-16"); arch != "arm64" {
+		t.Fatalf(`MachineTypeArch(C4A-HIGHMEM-16) = %s doesn't match expected arm64`, arch)
+	}
+}
+
+func TestOverProvisioning(t *testing.T) {
+	workloads := []cluster.Workload{
+		{Name: "over-provisioned", RequestedCpu: 1000, UsedCpu: 250, RequestedMemory: 4000, UsedMemory: 1000, RequestCost: 0.4, UsageCost: 0.1},
+		{Name: "no-requests", UsedCpu: 500, UsedMemory: 500, UsageCost: 0.2},
+		{Name: "kube-dns", Namespace: "kube-system", RequestedCpu: 1000, RequestCost: 1, System: true},
+	}
+
+	// Test Case #1
+	// The workload using more than it requests doesn't offset the wasted cost, the system workload is left out
+	totals := overProvisioningTotals(workloads)
+	if totals.RequestedCpu != 1000 || totals.UsedCpu != 750 || !almostEqual(totals.CpuUtilization, 75) ||
+		totals.RequestedMemory != 4000 || totals.UsedMemory != 1500 || !almostEqual(totals.MemoryUtilization, 37.5) ||
+		!almostEqual(totals.WastedMonthlyCost, 0.3*calculator.HOURS_PER_MONTH) {
+		t.Fatalf(`overProvisioningTotals() = %+v doesn't match expected 75%% CPU and 37.5%% memory utilization, wasting 0.3 per hour`, totals)
+	}
+
+	// Test Case #2
+	report := newReport("test-cluster", "test-region-1", workloads, 0.1, -1)
+	if lines := report.overProvisioningLines(); !strings.HasPrefix(lines[0], "Total requested vs total used: 1.00 vCPU requested, 0.75 used (75% utilization)") {
+		t.Fatalf(`overProvisioningLines() = %q doesn't match expected 1.00 vCPU requested, 0.75 used (75%% utilization)`, lines)
+	}
+
+	// Test Case #3
+	if totals := overProvisioningTotals(workloads[1:2]); totals.CpuUtilization != 0 || totals.WastedMonthlyCost != 0 {
+		t.Fatalf(`overProvisioningTotals() without requests = %+v doesn't match expected no utilization and no waste`, totals)
+	}
+
+	// Test Case #4
+	overProvisioned := testPod("default", "over-provisioned", "node-1", nil)
+	overProvisioned.Spec.Containers[0].Resources.Requests = corev1.ResourceList{
+		corev1.ResourceCPU:    resource.MustParse("1000m"),
+		corev1.ResourceMemory: resource.MustParse("1000M"),
+	}
+	testService := newTestService([]corev1.Pod{overProvisioned})
+	populated, err := testService.PopulateWorkloads(testNodes())
+	if err != nil || len(populated) != 1 || populated[0].RequestedCpu != 1000 || populated[0].UsedCpu != 100 || populated[0].RequestedMemory != 1000 || populated[0].UsedMemory != 100 {
+		t.Fatalf(`PopulateWorkloads() = %+v, %v doesn't match expected 1000 mCPU and 1000 MB requested, 100 mCPU and 100 MB used`, populated, err)
+	}
+}
+
+func TestReportToStdout(t *testing.T) {
+	stdout := os.Stdout
+	stdoutReader, stdoutWriter, _ := os.Pipe()
+	os.Stdout = stdoutWriter
+	defer func() {
+		os.Stdout = stdout
+	}()
+
+	report := newReport("test-cluster", "test-region-1", []cluster.Workload{{Name: "web", Namespace: "default", Cost: 0.5}}, 0.1, -1)
+	jsonErr := writeReportFile(stdoutPath, writeJSONReport, report)
+	paths, csvErr := writeReportFiles(stdoutPath, []string{"csv"}, report, time.Now())
+	_, multipleErr := writeReportFiles(stdoutPath, []string{"json", "csv"}, report, time.Now())
+
+	stdoutWriter.Close()
+	os.Stdout = stdout
+	stdoutContent, _ := io.ReadAll(stdoutReader)
+
+	// Test Case #1
+	var written Report
+	jsonOutput, csvOutput, _ := strings.Cut(string(stdoutContent), "namespace,workload")
+	if jsonErr != nil || json.Unmarshal([]byte(jsonOutput), &written) != nil || written.Cluster != "test-cluster" || !almostEqual(written.HourlyCost, 0.6) {
+		t.Fatalf(`writeReportFile(-) = %v wrote %q doesn't match expected the json report on stdout`, jsonErr, stdoutContent)
+	}
+
+	// Test Case #2
+	if csvErr != nil || len(paths) != 1 || paths[0] != stdoutPath || !strings.Contains(csvOutput, "default,web") {
+		t.Fatalf(`writeReportFiles(-, csv) = %v, %v wrote %q doesn't match expected the csv report on stdout`, paths, csvErr, stdoutContent)
+	}
+
+	// Test Case #3
+	if multipleErr == nil {
+		t.Fatalf(`writeReportFiles(-, json,csv) doesn't match expected an error for more than one format on stdout`)
+	}
+
+	// Test Case #4
+	if _, err := os.Stat(stdoutPath); !os.IsNotExist(err) {
+		t.Fatalf(`writeReportFile(-) created a file named -`)
+	}
+}
+
+func TestDiffReports(t *testing.T) {
+	// The before report has web, api and a system workload, the after one right-sizes web, drops api and adds a worker
+	fixture := func(path string, workloads []cluster.Workload) {
+		nodes := map[string]cluster.Node{"node-1": {Name: "node-1", Workloads: workloads}}
+		report := newReport("test-cluster", "test-region-1", billableWorkloads(workloads), 0.1, -1)
+		report.Nodes = reportNodes(nodes, 0)
+		if err := writeReportFile(path, writeJSONReport, report); err != nil {
+			t.Fatalf(`writeReportFile(%s) returned error: %v`, path, err)
+		}
+	}
+	beforePath, afterPath := filepath.Join(t.TempDir(), "before.json"), filepath.Join(t.TempDir(), "after.json")
+	fixture(beforePath, []cluster.Workload{
+		{Name: "web", Namespace: "default", Cost: 0.5},
+		{Name: "api", Namespace: "default", Cost: 0.2},
+		{Name: "kube-dns", Namespace: "kube-system", Cost: 0.1, System: true},
+	})
+	fixture(afterPath, []cluster.Workload{
+		{Name: "web", Namespace: "default", Cost: 0.25},
+		{Name: "worker", Namespace: "jobs", Cost: 0.1},
+		{Name: "kube-dns", Namespace: "kube-system", Cost: 0.3, System: true},
+	})
+
+	before, beforeErr := readReportFile(beforePath)
+	after, afterErr := readReportFile(afterPath)
+	if beforeErr != nil || afterErr != nil || len(before.Workloads) != 2 {
+		t.Fatalf(`readReportFile() = %+v, %v, %v doesn't match expected the two billable workloads`, before.Workloads, beforeErr, afterErr)
+	}
+
+	// Test Case #1
+	diff := diffReports(before, after)
+	var changes []string
+	for _, workload := range diff.Workloads {
+		changes = append(changes, workload.Change+" "+workload.Namespace+"/"+workload.Name)
+	}
+	if strings.Join(changes, ",") != "changed default/web,removed default/api,added jobs/worker" || diff.Added != 1 || diff.Removed != 1 || diff.Changed != 1 {
+		t.Fatalf(`diffReports() = %+v doesn't match expected web changed, api removed and worker added, largest change first`, diff)
+	}
+	web := diff.Workloads[0]
+	if !almostEqual(web.Before, 0.5*calculator.HOURS_PER_MONTH) || !almostEqual(web.After, 0.25*calculator.HOURS_PER_MONTH) {
+		t.Fatalf(`diffReports() web = %+v doesn't match expected the monthly cost of 0.5 then 0.25 per hour`, web)
+	}
+
+	// Test Case #2
+	if !almostEqual(diff.After-diff.Before, -0.35*calculator.HOURS_PER_MONTH) {
+		t.Fatalf(`diffReports() net change = %f doesn't match expected %f`, diff.After-diff.Before, -0.35*calculator.HOURS_PER_MONTH)
+	}
+
+	// Test Case #3
+	var output bytes.Buffer
+	displayReportDiff(&output, diff)
+	if !strings.Contains(output.String(), "~ default/web: $365.00 -> $182.50 per month (-182.50)") || !strings.Contains(output.String(), "1 added, 1 removed, 1 changed workloads") ||
+		!strings.Contains(output.String(), "net change -255.50 (-43.8%)") {
+		t.Fatalf(`displayReportDiff() = %q doesn't contain the change of web, the counts and the net change`, output.String())
+	}
+
+	// Test Case #4
+	if diff := diffReports(before, before); len(diff.Workloads) != 0 || diff.After != diff.Before {
+		t.Fatalf(`diffReports() of a report with itself = %+v doesn't match expected no change`, diff)
+	}
+
+	// Test Case #5 - a rollout replaces the pods of a Deployment, they are matched by their owner
+	rollout := func(podNames ...string) Report {
+		var workloads []cluster.Workload
+		for _, name := range podNames {
+			workloads = append(workloads, cluster.Workload{Name: name, Namespace: "default", Owner: "Deployment/web", Cost: 0.5 / float64(len(podNames))})
+		}
+		return Report{Workloads: workloads}
+	}
+	diff = diffReports(rollout("web-7d4b9c-abcde", "web-7d4b9c-fghij"), rollout("web-5f8c6d-klmno"))
+	if len(diff.Workloads) != 0 || diff.Changed != 0 {
+		t.Fatalf(`diffReports() of a rollout with the same cost = %+v doesn't match expected no change`, diff)
+	}
+	resized := rollout("web-5f8c6d-klmno")
+	resized.Workloads[0].Cost = 0.25
+	diff = diffReports(rollout("web-7d4b9c-abcde"), resized)
+	if len(diff.Workloads) != 1 || diff.Workloads[0].Change != workloadChanged || diff.Workloads[0].Name != "Deployment/web" {
+		t.Fatalf(`diffReports() of a right-sizing rollout = %+v doesn't match expected Deployment/web changed`, diff)
+	}
+
+	// Test Case #6
+	replicaSetPod := testPod("default", "web-7d4b9c-abcde", "node-1", map[string]string{"pod-template-hash": "7d4b9c"})
+	statefulSetPod := testPod("default", "db-0", "node-1", nil)
+	controller := true
+	replicaSetPod.OwnerReferences = []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "web-7d4b9c", Controller: &controller}}
+	statefulSetPod.OwnerReferences = []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: "StatefulSet", Name: "db", Controller: &controller}}
+	bare := testPod("default", "debug", "node-1", nil)
+	if owners := []string{calculator.PodOwner(&replicaSetPod), calculator.PodOwner(&statefulSetPod), calculator.PodOwner(&bare)}; strings.Join(owners, ",") != "Deployment/web,StatefulSet/db," {
+		t.Fatalf(`PodOwner() = %q doesn't match expected Deployment/web, StatefulSet/db and none`, owners)
+	}
+}
+
+func TestForbiddenPodMetrics(t *testing.T) {
+	testService := newTestService([]corev1.Pod{testPod("default", "payments-api", "node-1", nil)})
+	metricsClientset := metricsfake.NewSimpleClientset()
+	metricsClientset.PrependReactor("list", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewForbidden(schema.GroupResource{Group: "metrics.k8s.io", Resource: "pods"}, "", fmt.Errorf("RBAC: access denied"))
+	})
+	testService.MetricsClientset = metricsClientset
+
+	// Test Case #1
+	_, err := testService.PopulateWorkloads(testNodes())
+	if !errors.Is(err, calculator.ErrMetricsForbidden) || !strings.Contains(err.Error(), "forbidden across all namespaces") {
+		t.Fatalf(`PopulateWorkloads() with forbidden pod metrics = %v doesn't match expected ErrMetricsForbidden across all namespaces`, err)
+	}
+	if !strings.Contains(calculator.RequiredClusterRole, `apiGroups: ["metrics.k8s.io"]`) {
+		t.Fatalf(`RequiredClusterRole = %q doesn't grant the pod metrics`, calculator.RequiredClusterRole)
+	}
+
+	// Test Case #2
+	testService.Filter.Namespaces = []string{"payments"}
+	_, err = testService.PopulateWorkloads(testNodes())
+	if err == nil || !strings.Contains(err.Error(), "in namespace payments") {
+		t.Fatalf(`PopulateWorkloads() with forbidden pod metrics in a namespace = %v doesn't match expected the namespace in the error`, err)
+	}
+}
+
+func TestDisplayTableWithoutTerminal(t *testing.T) {
+	// Like in a CronJob, stdout is a file and there is no TTY to open
+	out, err := os.Create(filepath.Join(t.TempDir(), "output.txt"))
+	if err != nil {
+		t.Fatalf(`os.Create() returned error: %v`, err)
+	}
+	defer out.Close()
+	defer func() { terminal = false }()
+
+	// Test Case #1
+	terminal = true
+	ConfigureOutput(out, false)
+	if terminal {
+		t.Fatalf(`ConfigureOutput() of a file left terminal = true, expected false`)
+	}
+
+	// Test Case #2
+	// The table is written as is, without the cursor and screen escapes of a bubbletea program
+	nodes := testNodes()
+	entry := nodes["node-1"]
+	entry.Workloads = []cluster.Workload{{Name: "test-pod", Containers: 1, Cpu: 250, Memory: 512, Storage: 10, Cost: 0.0153}}
+	nodes["node-1"] = entry
+	model := workloadTableModel(nodes, 0.8, 0.55, calculator.CLUSTER_FEE, nil, 0, 0, false, false)
+	var output bytes.Buffer
+	displayTable(&output, model)
+	if output.String() != model.View() || !strings.Contains(output.String(), "test-pod") || strings.Contains(output.String(), "\x1b[?25l") {
+		t.Fatalf(`displayTable() without a terminal = %q doesn't match expected the plain table %q`, output.String(), model.View())
+	}
+}
+
+func TestPositionalPodFilter(t *testing.T) {
+	pods := []corev1.Pod{
+		testPod("default", "web", "node-1", nil),
+		testPod("payments", "web", "node-1", nil),
+		testPod("default", "api", "node-1", nil),
+	}
+
+	// Test Case #1
+	// Named pods are matched in any namespace, the others and the missing names are left out
+	testService := newTestService(pods)
+	testService.Filter.Pods = []string{"web", "missing"}
+	nodes := testNodes()
+	workloads, err := testService.PopulateWorkloads(nodes)
+	if err != nil || len(workloads) != 2 || workloads[0].Name != "web" || workloads[1].Name != "web" || workloads[0].Namespace == workloads[1].Namespace {
+		t.Fatalf(`PopulateWorkloads() with pods web, missing = %+v, %v doesn't match expected web in default and payments`, workloads, err)
+	}
+
+	// Test Case #2
+	// The totals only reflect the named pods
+	allService := newTestService(pods)
+	allNodes := testNodes()
+	all, err := allService.PopulateWorkloads(allNodes)
+	if err != nil || len(all) != 3 {
+		t.Fatalf(`PopulateWorkloads() without pods = %+v, %v doesn't match expected 3 workloads`, all, err)
+	}
+	if total, expected := estimatedHourlyCost(nodes, 0), workloads[0].Cost+workloads[1].Cost; !almostEqual(total, expected) || total >= estimatedHourlyCost(allNodes, 0) {
+		t.Fatalf(`estimatedHourlyCost() with pods web = %v doesn't match expected %v`, total, expected)
+	}
+}
+
+func TestResourceFormatting(t *testing.T) {
+	defer func() { rawUnits = false }()
+
+	tests := []struct {
+		mcpu   int64
+		mb     int64
+		cpu    string
+		memory string
+		raw    bool
+	}{
+		// Test Case #1
+		{mcpu: 4000, mb: 131072, cpu: "4", memory: "131.1G"},
+		// Test Case #2
+		{mcpu: 250, mb: 512, cpu: "250m", memory: "512M"},
+		// Test Case #3
+		{mcpu: 0, mb: 0, cpu: "0", memory: "0M"},
+		// Test Case #4
+		{mcpu: 1500, mb: 2000000, cpu: "1500m", memory: "2T"},
+		// Test Case #5
+		{mcpu: 4000, mb: 131072, cpu: "4000", memory: "131072", raw: true},
+	}
+
+	for _, test := range tests {
+		rawUnits = test.raw
+		if cpu, memory := formatCPU(test.mcpu), formatMemory(test.mb); cpu != test.cpu || memory != test.memory {
+			t.Fatalf(`formatCPU(%d), formatMemory(%d) with raw units %v = %q, %q doesn't match expected %q, %q`, test.mcpu, test.mb, test.raw, cpu, memory, test.cpu, test.memory)
+		}
+	}
+
+	// Test Case #6
+	rawUnits = true
+	model := workloadTableModel(map[string]cluster.Node{"node-1": {Name: "node-1", Workloads: []cluster.Workload{{Name: "web", Cpu: 500, Memory: 2048}}}}, 1, 1, 0, nil, 0, 0, false, false)
+	if view, row := model.table.View(), model.table.Rows()[0]; !strings.Contains(view, "Memory MB") || row[4] != "500" || row[5] != "2048" {
+		t.Fatalf(`workloadTableModel() with raw units = %v, %v doesn't match expected plain mCPU and MB`, view, row)
+	}
+}
+
+func TestCheckCompatibility(t *testing.T) {
+	hostPath := testPod("default", "log-collector", "node-1", nil)
+	hostPath.Spec.Volumes = []corev1.Volume{
+		{Name: "docker", VolumeSource: corev1.VolumeSource{HostPath: &corev1.HostPathVolumeSource{Path: "/var/lib/docker"}}},
+		{Name: "logs", VolumeSource: corev1.VolumeSource{HostPath: &corev1.HostPathVolumeSource{Path: "/var/log"}}},
+	}
+	hostPath.Spec.Containers[0].VolumeMounts = []corev1.VolumeMount{{Name: "docker", MountPath: "/docker"}, {Name: "logs", MountPath: "/logs", ReadOnly: true}}
+	privileged := testPod("default", "debugger", "node-1", nil)
+	privilegedFlag := true
+	privileged.Spec.Containers[0].SecurityContext = &corev1.SecurityContext{
+		Privileged:   &privilegedFlag,
+		Capabilities: &corev1.Capabilities{Add: []corev1.Capability{"NET_BIND_SERVICE", "SYS_ADMIN"}},
+	}
+	readOnlyLogs := testPod("default", "log-reader", "node-1", nil)
+	readOnlyLogs.Spec.Volumes = hostPath.Spec.Volumes[1:]
+	readOnlyLogs.Spec.Containers[0].VolumeMounts = hostPath.Spec.Containers[0].VolumeMounts[1:]
+
+	// Test Case #1
+	// Only the hostPath outside of /var/log is rejected, the read-only one under it is allowed
+	if reasons := calculator.AutopilotIncompatibilities(&hostPath); strings.Join(reasons, ";") != "hostPath volume docker (/var/lib/docker)" {
+		t.Fatalf(`AutopilotIncompatibilities(log-collector) = %v doesn't match expected the docker hostPath volume`, reasons)
+	}
+
+	// Test Case #2
+	if reasons := calculator.AutopilotIncompatibilities(&privileged); strings.Join(reasons, ";") != "privileged container app;capability SYS_ADMIN of container app" {
+		t.Fatalf(`AutopilotIncompatibilities(debugger) = %v doesn't match expected privileged and SYS_ADMIN`, reasons)
+	}
+
+	// Test Case #3
+	testService := newTestService([]corev1.Pod{hostPath, privileged, readOnlyLogs, testPod("default", "web", "node-1", nil)})
+	testService.CheckCompatibility = true
+	workloads, err := testService.PopulateWorkloads(testNodes())
+	if err != nil || len(workloads) != 4 {
+		t.Fatalf(`PopulateWorkloads() = %+v, %v doesn't match expected 4 workloads`, workloads, err)
+	}
+	incompatible := incompatibleWorkloads(workloads)
+	names := make(map[string]bool)
+	for _, workload := range incompatible {
+		names[workload.Name] = true
+	}
+	if len(incompatible) != 2 || !names["debugger"] || !names["log-collector"] {
+		t.Fatalf(`incompatibleWorkloads() = %+v doesn't match expected debugger and log-collector`, incompatible)
+	}
+
+	// Test Case #4
+	var output bytes.Buffer
+	displayIncompatibleWorkloads(&output, incompatible)
+	if !strings.HasPrefix(output.String(), "2 workloads, $") || !strings.Contains(output.String(), "default/debugger: privileged container app, capability SYS_ADMIN of container app\n") {
+		t.Fatalf(`displayIncompatibleWorkloads() = %q doesn't list the rejected workloads`, output.String())
+	}
+
+	// Test Case #5
+	// Without -check-compatibility the pods aren't scanned
+	testService = newTestService([]corev1.Pod{privileged})
+	workloads, err = testService.PopulateWorkloads(testNodes())
+	if err != nil || len(incompatibleWorkloads(workloads)) != 0 {
+		t.Fatalf(`PopulateWorkloads() without CheckCompatibility = %+v, %v doesn't match expected no incompatibilities`, workloads, err)
+	}
+}
+
+func TestCostTrend(t *testing.T) {
+	// Reports are listed out of order, with the reports of another cluster and objects that aren't reports
+	reports := map[string]float64{
+		"reports/report-prod-20230703T090000Z.json":    1100,
+		"reports/report-prod-20230701T090000Z.json":    1000,
+		"reports/report-prod-20230704T090000Z.json":    990,
+		"reports/report-prod-20230702T090000Z.json":    1200,
+		"reports/report-prod-eu-20230705T090000Z.json": 500,
+		"reports/summary.json":                         10,
+	}
+	var downloaded []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/b/test-bucket/o" {
+			prefix := r.URL.Query().Get("prefix")
+			if !strings.HasPrefix(prefix, "reports/") {
+				t.Errorf(`Objects.List() prefix = %q doesn't match expected reports/`, prefix)
+			}
+			var items []string
+			for name := range reports {
+				if strings.HasPrefix(name, prefix) {
+					items = append(items, fmt.Sprintf(`{"name": %q, "timeCreated": "2023-08-01T00:00:00Z"}`, name))
+				}
+			}
+			items = append(items, `{"name": "reports/report-prod-20230705T090000Z.html"}`)
+			fmt.Fprintf(w, `{"items": [%s]}`, strings.Join(items, ","))
+			return
+		}
+
+		name := strings.TrimPrefix(r.URL.Path, "/b/test-bucket/o/")
+		cost, ok := reports[name]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		downloaded = append(downloaded, name)
+		clusterName := "prod"
+		if strings.Contains(name, "prod-eu") {
+			clusterName = "prod-eu"
+		}
+		fmt.Fprintf(w, `{"cluster": %q, "monthly_cost": %v}`, clusterName, cost)
+	}))
+	defer server.Close()
+
+	storageService, err := storage.NewService(context.Background(), option.WithEndpoint(server.URL+"/"), option.WithoutAuthentication())
+	if err != nil {
+		t.Fatalf(`storage.NewService() returned error: %v`, err)
+	}
+
+	// Test Case #1
+	// Only the last 3 reports of the cluster are read, oldest first
+	points, err := costTrend(context.Background(), storageService, "gs://test-bucket/reports/", "prod", 3)
+	if err != nil || len(points) != 3 || len(downloaded) != 3 {
+		t.Fatalf(`costTrend() = %+v, %v after downloading %v doesn't match expected 3 reports`, points, err, downloaded)
+	}
+	var costs []string
+	for _, point := range points {
+		costs = append(costs, fmt.Sprint(point.MonthlyCost))
+	}
+	if strings.Join(costs, ",") != "1200,1100,990" || !points[0].Time.Equal(time.Date(2023, 7, 2, 9, 0, 0, 0, time.UTC)) {
+		t.Fatalf(`costTrend() = %+v doesn't match expected the costs 1200, 1100 and 990 from July 2nd`, points)
+	}
+
+	// Test Case #2
+	var output bytes.Buffer
+	displayCostTrend(&output, points)
+	if !strings.Contains(output.String(), "2023-07-03 09:00 UTC  $1100.00  -8.3%") ||
+		!strings.Contains(output.String(), "Monthly cost from $1200.00 to $990.00 over 3 reports, net change -210.00 (-17.5%)") {
+		t.Fatalf(`displayCostTrend() = %q doesn't contain the changes`, output.String())
+	}
+
+	// Test Case #3
+	// Without a cluster, the reports of several clusters aren't mixed in a single trend
+	if _, err := costTrend(context.Background(), storageService, "gs://test-bucket/reports/", "", 0); err == nil || !strings.Contains(err.Error(), "prod, prod-eu") {
+		t.Fatalf(`costTrend() of several clusters = %v doesn't match expected an error listing prod and prod-eu`, err)
+	}
+	if points, err := costTrend(context.Background(), storageService, "gs://test-bucket/reports/report-prod-eu-", "", 0); err != nil || len(points) != 1 || points[0].MonthlyCost != 500 {
+		t.Fatalf(`costTrend() of the prod-eu prefix = %+v, %v doesn't match expected its single report`, points, err)
+	}
+
+	// Test Case #4
+	if _, _, err := parseGCSPrefix("/tmp/reports"); err == nil {
+		t.Fatalf(`parseGCSPrefix("/tmp/reports") didn't return an error`)
+	}
+	if bucket, prefix, err := parseGCSPrefix("gs://test-bucket"); err != nil || bucket != "test-bucket" || prefix != "" {
+		t.Fatalf(`parseGCSPrefix("gs://test-bucket") = %q, %q, %v doesn't match expected the bucket without prefix`, bucket, prefix, err)
+	}
+}
+
+func TestRateOverrides(t *testing.T) {
+	ratesFile := filepath.Join(t.TempDir(), "rates.json")
+	err := os.WriteFile(ratesFile, []byte(`{"discount_percent": 5, "fields": {"CpuPrice": {"discount_percent": 10}, "MemoryPrice": {"price": 0.005}}}`), 0644)
+	if err != nil {
+		t.Fatalf(`os.WriteFile() returned error: %v`, err)
+	}
+
+	overrides, err := calculator.LoadRateOverrides(ratesFile)
+	if err != nil {
+		t.Fatalf(`LoadRateOverrides() returned error: %v`, err)
+	}
+
+	testService := newTestService(nil)
+	listPricing := testService.AutopilotPricing
+	listCost := testService.CalculatePricing(1000, 1000, 1000, 0, "", cluster.ComputeClassGeneralPurpose, "e2-standard-4", false)
+
+	// Test Case #1
+	// Per field overrides take precedence over the uniform discount
+	fields := overrides.Apply(&testService.AutopilotPricing)
+	pricing := testService.AutopilotPricing
+	if !almostEqual(pricing.CpuPrice, listPricing.CpuPrice*0.9) || !almostEqual(pricing.MemoryPrice, 0.005) || !almostEqual(pricing.StoragePrice, listPricing.StoragePrice*0.95) {
+		t.Fatalf(`RateOverrides.Apply() = %+v doesn't match expected the discounted CPU and storage and the replaced memory price`, pricing)
+	}
+	if joined := "," + strings.Join(fields, ",") + ","; !strings.Contains(joined, ",CpuPrice,") || !strings.Contains(joined, ",MemoryPrice,") || !strings.Contains(joined, ",StoragePrice,") {
+		t.Fatalf(`RateOverrides.Apply() = %v doesn't match expected the changed fields`, fields)
+	}
+
+	// Test Case #2
+	cost := testService.CalculatePricing(1000, 1000, 1000, 0, "", cluster.ComputeClassGeneralPurpose, "e2-standard-4", false)
+	expected := listPricing.CpuPrice*0.9 + 0.005 + listPricing.StoragePrice*0.95
+	if !almostEqual(cost.Total, expected) || almostEqual(cost.Total, listCost.Total) {
+		t.Fatalf(`CalculatePricing() with rate overrides = %v doesn't match expected %v, list cost %v`, cost.Total, expected, listCost.Total)
+	}
+
+	// Test Case #3
+	for _, invalid := range []string{
+		`{"fields": {"CpuPrise": {"discount_percent": 10}}}`,
+		`{"discount_percent": 120}`,
+		`{"fields": {"CpuPrice": {"price": 0.03, "discount_percent": 10}}}`,
+		`{"discount": 10}`,
+	} {
+		if err := os.WriteFile(ratesFile, []byte(invalid), 0644); err != nil {
+			t.Fatalf(`os.WriteFile() returned error: %v`, err)
+		}
+		if _, err := calculator.LoadRateOverrides(ratesFile); err == nil {
+			t.Fatalf(`LoadRateOverrides(%s) didn't return an error`, invalid)
+		}
+	}
+}
+
+func TestWorkloadTableColumns(t *testing.T) {
+	nodes := testNodes()
+	entry := nodes["node-1"]
+	entry.Workloads = []cluster.Workload{
+		{Name: "first-pod", Cost: 0.3, ClassReason: "ratio 4 within General-purpose 1-6.5"},
+		{Name: "second-pod", Cost: 0.2, ClassReason: "ratio 4 within General-purpose 1-6.5"},
+	}
+	nodes["node-1"] = entry
+
+	// Test Case #1
+	// Every row, the rest and the totals included, has a cell per column, so the totals stay under the price
+	for _, top := range []int{0, 1} {
+		for _, breakdown := range []bool{false, true} {
+			for _, adjustments := range []bool{false, true} {
+				columns, rows, _ := workloadTable(nodes, 0.8, 0.55, 0.1, nil, top, 0, breakdown, adjustments)
+				for _, row := range rows {
+					if len(row) != len(columns) {
+						t.Fatalf(`workloadTable(top %d, breakdown %t, adjustments %t) row %q has %d cells, doesn't match expected %d columns`, top, breakdown, adjustments, row[0], len(row), len(columns))
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestQuietLogging(t *testing.T) {
+	stdout, stderr := os.Stdout, os.Stderr
+	stdoutReader, stdoutWriter, _ := os.Pipe()
+	stderrReader, stderrWriter, _ := os.Pipe()
+	os.Stdout, os.Stderr = stdoutWriter, stderrWriter
+	defaultLogger := slog.Default()
+	defer func() {
+		os.Stdout, os.Stderr = stdout, stderr
+		slog.SetDefault(defaultLogger)
+	}()
+
+	logger, err := newLogger(os.Stderr, logLevel("debug", true), "text")
+	if err != nil {
+		t.Fatalf(`newLogger() returned error: %v`, err)
+	}
+	slog.SetDefault(logger)
+
+	// ARM workloads without ARM pricing in the region warn about it
+	nodes := testNodes()
+	nodes["node-arm"] = cluster.Node{Name: "node-arm", InstanceType: "t2a-standard-4", Region: "test-region-1"}
+	testService := newTestService([]corev1.Pod{testPod("default", "arm-pod", "node-arm", nil)})
+	if _, err := testService.PopulateWorkloads(nodes); err != nil {
+		t.Fatalf(`PopulateWorkloads() returned error: %v`, err)
+	}
+	slog.Error("Error writing json to file")
+
+	stdoutWriter.Close()
+	stderrWriter.Close()
+	stdoutContent, _ := io.ReadAll(stdoutReader)
+	stderrContent, _ := io.ReadAll(stderrReader)
+
+	// Test Case #1
+	if len(stdoutContent) != 0 {
+		t.Fatalf(`Quiet run wrote to stdout: %q`, stdoutContent)
+	}
+
+	// Test Case #2
+	if strings.Contains(string(stderrContent), "ARM pricing") || strings.Count(string(stderrContent), "\n") != 1 || !strings.Contains(string(stderrContent), "level=ERROR") {
+		t.Fatalf(`Quiet run wrote %q to stderr, expected the error only`, stderrContent)
+	}
+
+	// Test Case #3
+	if logLevel("debug", false) != "debug" {
+		t.Fatalf(`logLevel(debug, false) = %q doesn't match expected debug`, logLevel("debug", false))
+	}
+}
+
+func TestWarningCollector(t *testing.T) {
+	var logs bytes.Buffer
+	logger, _ := newLogger(&logs, "error", "text")
+	warnings := newWarningCollector(logger.Handler())
+	defaultLogger := slog.Default()
+	slog.SetDefault(slog.New(warnings))
+	defer slog.SetDefault(defaultLogger)
+
+	// ARM workloads without ARM pricing in the region warn about it
+	nodes := testNodes()
+	nodes["node-arm"] = cluster.Node{Name: "node-arm", InstanceType: "t2a-standard-4", Region: "test-region-1"}
+	testService := newTestService([]corev1.Pod{
+		testPod("default", "x86-pod", "node-1", nil),
+		testPod("default", "arm-pod", "node-arm", nil),
+	})
+	if _, err := testService.PopulateWorkloads(nodes); err != nil {
+		t.Fatalf(`PopulateWorkloads() returned error: %v`, err)
+	}
+
+	// Test Case #1
+	var armWarning *reportWarning
+	collected := warnings.Warnings()
+	for i := range collected {
+		if collected[i].Message == "ARM pricing is not available in the region, ARM workloads are priced without it" {
+			armWarning = &collected[i]
+		}
+	}
+	if armWarning == nil || armWarning.Level != "WARN" || armWarning.Attributes["region"] != "test-region-1" || armWarning.Attributes["workloads"] != "1" {
+		t.Fatalf(`Warnings() = %+v doesn't contain the missing ARM pricing warning for 1 workload in test-region-1`, collected)
+	}
+
+	// Test Case #2
+	// The warnings are collected below the log level, and each one once
+	slog.Warn("Skipping workload whose pod was deleted", "pod", "job-finished", "namespace", "default")
+	slog.Warn("Skipping workload whose pod was deleted", "pod", "job-finished", "namespace", "default")
+	slog.Info("Not a warning")
+	collected = warnings.Warnings()
+	last := collected[len(collected)-1]
+	if logs.Len() != 0 || last.Workload != "job-finished" || last.Attributes["namespace"] != "default" || collected[len(collected)-2].Workload == "job-finished" {
+		t.Fatalf(`Warnings() = %+v with logs %q doesn't match expected job-finished once and no logs`, collected, logs.String())
+	}
+
+	// Test Case #3
+	slog.Error("Error writing json to file", "error", "disk full")
+	if !strings.Contains(logs.String(), "disk full") || warnings.Warnings()[len(collected)].Level != "ERROR" {
+		t.Fatalf(`Error logs = %q, %+v don't match expected the error logged and collected`, logs.String(), warnings.Warnings())
+	}
+}
+
+func TestParseSubcommand(t *testing.T) {
+	var dispatched []string
+	commands := map[string]func(args []string){
+		"estimate": func(args []string) { dispatched = append([]string{"estimate"}, args...) },
+		"pricing":  func(args []string) { dispatched = append([]string{"pricing"}, args...) },
+		"compare":  func(args []string) { dispatched = append([]string{"compare"}, args...) },
+		"what-if":  func(args []string) { dispatched = append([]string{"what-if"}, args...) },
+		"diff":     func(args []string) { dispatched = append([]string{"diff"}, args...) },
+		"trend":    func(args []string) { dispatched = append([]string{"trend"}, args...) },
+	}
+
+	testCases := []struct {
+		args     []string
+		expected []string
+	}{
+		// Test Case #1
+		{nil, []string{"estimate"}},
+		// Test Case #2
+		// Flags without a subcommand are the ones of estimate, for backward compatibility
+		{[]string{"-json", "-json-file", "out.json"}, []string{"estimate", "-json", "-json-file", "out.json"}},
+		// Test Case #3
+		{[]string{"estimate", "-json"}, []string{"estimate", "-json"}},
+		// Test Case #4
+		{[]string{"pricing", "-region", "us-central1"}, []string{"pricing", "-region", "us-central1"}},
+		// Test Case #5
+		{[]string{"compare"}, []string{"compare"}},
+		// Test Case #6
+		// Only the first argument names a subcommand
+		{[]string{"-namespace", "pricing"}, []string{"estimate", "-namespace", "pricing"}},
+		// Test Case #7
+		{[]string{"what-if", "-manifest", "app.yaml"}, []string{"what-if", "-manifest", "app.yaml"}},
+		// Test Case #8
+		{[]string{"diff", "before.json", "after.json"}, []string{"diff", "before.json", "after.json"}},
+		// Test Case #9
+		{[]string{"trend", "-last", "6", "gs://bucket/reports/"}, []string{"trend", "-last", "6", "gs://bucket/reports/"}},
+	}
+
+	for i, testCase := range testCases {
+		name, args := parseSubcommand(testCase.args, commands)
+		commands[name](args)
+		if strings.Join(dispatched, " ") != strings.Join(testCase.expected, " ") {
+			t.Fatalf(`Test Case #%d: parseSubcommand(%v) dispatched %v doesn't match expected %v`, i+1, testCase.args, dispatched, testCase.expected)
+		}
+	}
+
+	for name := range subcommands {
+		if _, ok := commands[name]; !ok {
+			t.Fatalf(`subcommands has %s, which isn't tested`, name)
+		}
+	}
+}
+
+func TestComparisonTotals(t *testing.T) {
+	nodes := map[string]cluster.Node{
+		"node-1":                    {Name: "node-1", StandardCost: 0.2, Cost: 0.1},
+		"node-2":                    {Name: "node-2", StandardCost: 0.3, Cost: 0.4},
+		"node-3":                    {Name: "node-3", Cost: 0.5},
+		cluster.UnscheduledNodeName: {Name: cluster.UnscheduledNodeName, Cost: 1},
+	}
+
+	// Test Case #1
+	standard, autopilot, unpriced := comparisonTotals(nodes)
+	if !almostEqual(standard, 0.5) || !almostEqual(autopilot, 0.5) || unpriced != 1 {
+		t.Fatalf(`comparisonTotals() = %v, %v, %v doesn't match expected 0.5, 0.5, 1`, standard, autopilot, unpriced)
+	}
+
+	// Test Case #2
+	var out bytes.Buffer
+	displayComparison(&out, "test-region-1", nodes, 0.1, false)
+	if !strings.Contains(out.String(), "$365.00 per month, Autopilot cost of their workloads: $365.00") || !strings.Contains(out.String(), "1 nodes have no GCE price") {
+		t.Fatalf(`displayComparison() = %q doesn't match expected totals of $365.00 and 1 unpriced node`, out.String())
+	}
+}
+
+// newTestService returns a pricing service backed by fake clients serving the given pods
+// and a pod metrics entry for each of them.
+func newTestService(pods []corev1.Pod) calculator.PricingService {
+	var objects []runtime.Object
+	var podMetrics []metricsapi.PodMetrics
+	for i := range pods {
+		objects = append(objects, &pods[i])
+
+		// Pending pods aren't running yet, so they have no metrics
+		if pods[i].Status.Phase == corev1.PodPending {
+			continue
+		}
+
+		var containers []metricsapi.ContainerMetrics
+		for _, container := range pods[i].Spec.Containers {
+			containers = append(containers, metricsapi.ContainerMetrics{
+				Name: container.Name,
+				Usage: corev1.ResourceList{
+					corev1.ResourceCPU:              resource.MustParse("100m"),
+					corev1.ResourceMemory:           resource.MustParse("100M"),
+					corev1.ResourceEphemeralStorage: resource.MustParse("1G"),
+				},
+			})
+		}
+		podMetrics = append(podMetrics, metricsapi.PodMetrics{
+			ObjectMeta: metav1.ObjectMeta{Name: pods[i].Name, Namespace: pods[i].Namespace, Labels: pods[i].Labels},
+			Containers: containers,
+		})
+	}
+
+	// The fake metrics clientset doesn't serve PodMetrics from its tracker, so the list is served by a reactor
+	metricsClientset := metricsfake.NewSimpleClientset()
+	metricsClientset.PrependReactor("list", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		list := &metricsapi.PodMetricsList{}
+		for _, podMetric := range podMetrics {
+			if action.GetNamespace() == "" || action.GetNamespace() == podMetric.Namespace {
+				list.Items = append(list.Items, podMetric)
+			}
+		}
+		return true, list, nil
+	})
+
+	testService := service
+	testService.Clientset = fake.NewSimpleClientset(objects...)
+	testService.MetricsClientset = metricsClientset
+
+	return testService
+}
+
+func testPod(namespace string, name string, nodeName string, podLabels map[string]string) corev1.Pod {
+	return corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: podLabels},
+		Spec: corev1.PodSpec{
+			NodeName:   nodeName,
+			Containers: []corev1.Container{{Name: "app"}},
+		},
+		Status: corev1.PodStatus{Phase: corev1.PodRunning},
+	}
+}
+
+func testNodes() map[string]cluster.Node {
+	return map[string]cluster.Node{
+		"node-1": {Name: "node-1", InstanceType: "e2-standard-4", Region: "test-region-1"},
+	}
 }
 
 func almostEqual(a, b float64) bool {
@@ -0,0 +1,205 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/GoogleCloudPlatform/autopilot-cost-calculator/calculator"
+)
+
+// reportFormats are the formats -output-dir can write, by file extension, in the order they are listed in errors.
+var reportFormats = []string{"json", "csv", "md", "html"}
+
+// reportWriters write the report in each of the reportFormats.
+var reportWriters = map[string]func(w io.Writer, report Report) error{
+	"json": writeJSONReport,
+	"csv":  writeCSVReport,
+	"md":   writeMarkdownReport,
+	"html": writeHTMLReport,
+}
+
+// parseFormats parses the comma separated formats of -formats, failing on unknown ones.
+func parseFormats(value string) ([]string, error) {
+	var formats []string
+	for _, format := range strings.Split(value, ",") {
+		format = strings.ToLower(strings.TrimSpace(format))
+		if format == "" {
+			continue
+		}
+		if _, ok := reportWriters[format]; !ok {
+			return nil, fmt.Errorf("unknown format %q, expected one of %s", format, strings.Join(reportFormats, ", "))
+		}
+		formats = append(formats, format)
+	}
+
+	if len(formats) == 0 {
+		return nil, fmt.Errorf("no format given, expected one of %s", strings.Join(reportFormats, ", "))
+	}
+	return formats, nil
+}
+
+// stdoutPath is the file path, and the -output-dir, that writes a report to stdout instead
+const stdoutPath = "-"
+
+// reportTimeFormat is the format of the time of a run in the name of its report files
+const reportTimeFormat = "20060102T150405Z"
+
+// reportBaseName is the name of the report files of a run, without extension, like
+// report-my-cluster-20230715T093000Z.
+func reportBaseName(clusterName string, now time.Time) string {
+	return fmt.Sprintf("report-%s-%s", clusterName, now.UTC().Format(reportTimeFormat))
+}
+
+// writeReportFiles writes the report in each format to the directory, which is created if needed, and returns the
+// paths of the files written. The stdoutPath directory writes the report to stdout, in a single format.
+func writeReportFiles(dir string, formats []string, report Report, now time.Time) ([]string, error) {
+	if dir == stdoutPath {
+		if len(formats) != 1 {
+			return nil, fmt.Errorf("only a single format can be written to stdout, got %s", strings.Join(formats, ", "))
+		}
+		return []string{stdoutPath}, writeReportFile(stdoutPath, reportWriters[formats[0]], report)
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("error creating output directory: %v", err)
+	}
+
+	baseName := reportBaseName(report.Cluster, now)
+	var paths []string
+	for _, format := range formats {
+		path := filepath.Join(dir, baseName+"."+format)
+		if err := writeReportFile(path, reportWriters[format], report); err != nil {
+			return paths, err
+		}
+		paths = append(paths, path)
+	}
+
+	return paths, nil
+}
+
+// writeReportFile writes the report, of a cluster or of a fleet, to the file at path with write, or to stdout when
+// the path is stdoutPath. The file is buffered, and only complete once the buffer is flushed and the file closed, so
+// the errors of both are returned too.
+func writeReportFile[T any](path string, write func(w io.Writer, report T) error, report T) (err error) {
+	if path == stdoutPath {
+		buffered := bufio.NewWriter(os.Stdout)
+		if err := write(buffered, report); err != nil {
+			return fmt.Errorf("error writing to stdout: %v", err)
+		}
+		if err := buffered.Flush(); err != nil {
+			return fmt.Errorf("error writing to stdout: %v", err)
+		}
+		return nil
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("error creating %s: %v", path, err)
+	}
+	defer func() {
+		if closeErr := file.Close(); err == nil && closeErr != nil {
+			err = fmt.Errorf("error closing %s: %v", path, closeErr)
+		}
+	}()
+
+	buffered := bufio.NewWriter(file)
+	if err := write(buffered, report); err != nil {
+		return fmt.Errorf("error writing %s: %v", path, err)
+	}
+	if err := buffered.Flush(); err != nil {
+		return fmt.Errorf("error writing %s: %v", path, err)
+	}
+
+	return nil
+}
+
+// writeJSONReport writes the report as indented json, like the -json output.
+func writeJSONReport(w io.Writer, report Report) error {
+	contents, err := json.MarshalIndent(report, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(contents)
+	return err
+}
+
+// writeCSVReport writes a row per workload, costliest first, with a header row.
+func writeCSVReport(w io.Writer, report Report) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"namespace", "workload", "node", "containers", "mcpu", "memory_mb", "storage_mb", "compute_class", "cost_per_hour", "cost_per_month"})
+	for _, workload := range report.Workloads {
+		writer.Write([]string{
+			workload.Namespace,
+			workload.Name,
+			workload.Node_name,
+			strconv.Itoa(workload.Containers),
+			strconv.FormatInt(workload.Cpu, 10),
+			strconv.FormatInt(workload.Memory, 10),
+			strconv.FormatInt(workload.Storage, 10),
+			workloadClassName(workload),
+			strconv.FormatFloat(workload.Cost, 'f', -1, 64),
+			strconv.FormatFloat(workload.Cost*calculator.HOURS_PER_MONTH, 'f', -1, 64),
+		})
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// writeMarkdownReport writes the totals and a table of the workloads, costliest first, as Markdown.
+func writeMarkdownReport(w io.Writer, report Report) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Autopilot cost estimate of %s (%s)\n\n", report.Cluster, report.Region)
+	fmt.Fprintf(&b, "- Hourly cost: $%.4f\n", report.HourlyCost)
+	fmt.Fprintf(&b, "- Monthly cost: $%.2f\n", report.MonthlyCost)
+	fmt.Fprintf(&b, "- Cluster fee: $%.4f per hour\n", report.ClusterFee)
+	if report.EstimatedMonthlyDelta != nil {
+		fmt.Fprintf(&b, "- Monthly delta with the billed Standard cost: %+.2f\n", *report.EstimatedMonthlyDelta)
+	}
+	fmt.Fprintf(&b, "- Compute classes: %s\n", formatClassDistribution(report.ClassDistribution))
+	fmt.Fprintf(&b, "- Monthly cost per compute class: %s\n\n", formatClassCosts(report.ClassDistribution))
+
+	fmt.Fprintln(&b, "## Workloads")
+	fmt.Fprintln(&b)
+	if len(report.Workloads) == 0 {
+		fmt.Fprintln(&b, noBillableWorkloadsMessage)
+	} else {
+		fmt.Fprintln(&b, "| Workload | Node | Compute Class | mCPU | Memory MB | Storage MB | Price $/H |")
+		fmt.Fprintln(&b, "| --- | --- | --- | ---: | ---: | ---: | ---: |")
+		for _, workload := range report.Workloads {
+			fmt.Fprintf(&b, "| %s | %s | %s | %d | %d | %d | %.4f |\n", markdownEscape(workload.Name), markdownEscape(workload.Node_name),
+				workloadClassName(workload), workload.Cpu, workload.Memory, workload.Storage, workload.Cost)
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// markdownEscape escapes the pipes of a table cell.
+func markdownEscape(cell string) string {
+	return strings.ReplaceAll(cell, "|", `\|`)
+}
@@ -0,0 +1,584 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"embed"
+	"fmt"
+	"html/template"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/GoogleCloudPlatform/autopilot-cost-calculator/calculator"
+	"github.com/GoogleCloudPlatform/autopilot-cost-calculator/cluster"
+)
+
+// reportSchemaFile is the JSON Schema of the json output, the Report, printed by -print-schema
+const reportSchemaFile = "report.schema.json"
+
+//go:embed report.schema.json
+var reportSchemaFS embed.FS
+
+// noBillableWorkloadsMessage is shown instead of the workloads when the cluster has none to bill, eg. when it only
+// runs system workloads.
+const noBillableWorkloadsMessage = "No billable workloads found"
+
+// Report is the estimate of a cluster with its totals, as rendered by the report outputs.
+type Report struct {
+	Cluster string `json:"cluster"`
+	Region  string `json:"region"`
+	// Nodes are only set for the json output, where they list the workloads, sorted like the node table
+	Nodes       []reportNode       `json:"nodes,omitempty"`
+	Workloads   []cluster.Workload `json:"-"`
+	ClusterFee  float64            `json:"cluster_fee"`
+	HourlyCost  float64            `json:"hourly_cost"`
+	MonthlyCost float64            `json:"monthly_cost"`
+	// BilledHourlyCost is the actual cost of the Standard cluster, negative when unknown
+	BilledHourlyCost float64 `json:"-"`
+	// EstimatedMonthlyDelta is the monthly cost on Autopilot minus the billed cost of the Standard cluster,
+	// negative when moving to Autopilot saves money. It's nil when the billed cost is unknown.
+	EstimatedMonthlyDelta *float64 `json:"estimated_monthly_delta,omitempty"`
+	// Commitment is the committed use discount applied to the Autopilot side of the EstimatedMonthlyDelta, see
+	// applyCommitment, empty when it compares the on-demand prices
+	Commitment string `json:"commitment,omitempty"`
+	// RequestBasedMonthlyCost and UsageBasedMonthlyCost bill the workloads on their requests alone and on their
+	// usage alone, RightSizingMonthlySavings is their difference, negative when the usage exceeds the requests
+	RequestBasedMonthlyCost   float64 `json:"request_based_monthly_cost"`
+	UsageBasedMonthlyCost     float64 `json:"usage_based_monthly_cost"`
+	RightSizingMonthlySavings float64 `json:"right_sizing_monthly_savings"`
+	// LimitBasedMonthlyCost bills the workloads on their limits above their requests, the potential cost of the
+	// BurstableWorkloads if their requests were raised to their limits
+	LimitBasedMonthlyCost float64 `json:"limit_based_monthly_cost"`
+	BurstableWorkloads    int     `json:"burstable_workloads"`
+	// ClassDistribution counts the workloads per compute class and sums their monthly cost
+	ClassDistribution []classCount `json:"class_distribution"`
+	// Footprint sums the billed resources of the workloads
+	Footprint resourceFootprint `json:"footprint"`
+	// OverProvisioning compares the requests of the workloads with their usage
+	OverProvisioning overProvisioning `json:"over_provisioning"`
+	// HPAProjections are only set with -include-hpa
+	HPAProjections []calculator.HPAProjection `json:"hpa_projections,omitempty"`
+	// PDBProjections are only set with -pdb-aware
+	PDBProjections []calculator.PDBProjection `json:"pdb_projections,omitempty"`
+	// Warnings are the warnings and errors logged while estimating, only set for the json output
+	Warnings []reportWarning `json:"warnings,omitempty"`
+	// Histogram is only set with -histogram
+	Histogram []costBucket `json:"histogram,omitempty"`
+	// RegionComparison is only set with -compare-regions
+	RegionComparison []calculator.RegionCost `json:"region_comparison,omitempty"`
+	// RateOverrides are the Autopilot price fields whose list price was replaced with -rate-overrides
+	RateOverrides []string `json:"rate_overrides,omitempty"`
+	// CurrencyTotals are only set with -currency
+	CurrencyTotals []calculator.CurrencyCost `json:"currency_totals,omitempty"`
+}
+
+// reportNode is a node of the json output, with the totals of its billable workloads.
+type reportNode struct {
+	cluster.Node
+	CostPerHour       float64      `json:"cost_per_hour"`
+	CostPerMonth      float64      `json:"cost_per_month"`
+	WorkloadCount     int          `json:"workload_count"`
+	ClassDistribution []classCount `json:"class_distribution"`
+}
+
+// reportNodes returns the nodes sorted like the node table, with their workloads below minCost aggregated. The
+// totals are counted before the aggregation and leave the system workloads out, like the cost of the node.
+func reportNodes(nodes map[string]cluster.Node, minCost float64) []reportNode {
+	sorted := sortedNodes(nodes)
+	result := make([]reportNode, 0, len(sorted))
+	for _, node := range sorted {
+		billable := billableWorkloads(node.Workloads)
+		node.Workloads = aggregateCheapWorkloads(node.Workloads, minCost)
+		result = append(result, reportNode{
+			Node:              node,
+			CostPerHour:       node.Cost,
+			CostPerMonth:      node.Cost * calculator.HOURS_PER_MONTH,
+			WorkloadCount:     len(billable),
+			ClassDistribution: classDistribution(billable),
+		})
+	}
+
+	return result
+}
+
+// classCount is the number of workloads placed on a compute class, and their monthly cost.
+type classCount struct {
+	Class       string  `json:"class"`
+	Workloads   int     `json:"workloads"`
+	MonthlyCost float64 `json:"monthly_cost"`
+}
+
+// resourceFootprint is the total mCPU, memory and storage billed for the workloads, after Autopilot's minimums
+// and rounding, to compare with the capacity of the node pools.
+type resourceFootprint struct {
+	Cpu     int64 `json:"mcpu"`
+	Memory  int64 `json:"memory_mb"`
+	Storage int64 `json:"storage_mb"`
+}
+
+// footprint sums the resources of the workloads, leaving out the system ones like the totals do.
+func footprint(workloads []cluster.Workload) resourceFootprint {
+	var total resourceFootprint
+	for _, workload := range workloads {
+		if workload.System {
+			continue
+		}
+
+		total.Cpu += workload.Cpu
+		total.Memory += workload.Memory
+		total.Storage += workload.Storage
+	}
+
+	return total
+}
+
+// overProvisioning is the total requests and usage of the workloads, with the utilization of the requests in
+// percent, and the monthly cost of the requests left unused when billed on requests.
+type overProvisioning struct {
+	RequestedCpu      int64   `json:"requested_mcpu"`
+	UsedCpu           int64   `json:"used_mcpu"`
+	CpuUtilization    float64 `json:"cpu_utilization_percent"`
+	RequestedMemory   int64   `json:"requested_memory_mb"`
+	UsedMemory        int64   `json:"used_memory_mb"`
+	MemoryUtilization float64 `json:"memory_utilization_percent"`
+	WastedMonthlyCost float64 `json:"wasted_monthly_cost"`
+}
+
+// overProvisioningTotals sums the requests and usage of the workloads, leaving out the system ones like the
+// totals do. Only the workloads using less than they request add to the wasted cost, the ones using more don't
+// offset it.
+func overProvisioningTotals(workloads []cluster.Workload) overProvisioning {
+	var totals overProvisioning
+	wastedHourlyCost := 0.0
+	for _, workload := range workloads {
+		if workload.System {
+			continue
+		}
+
+		totals.RequestedCpu += workload.RequestedCpu
+		totals.UsedCpu += workload.UsedCpu
+		totals.RequestedMemory += workload.RequestedMemory
+		totals.UsedMemory += workload.UsedMemory
+		if workload.RequestCost > workload.UsageCost {
+			wastedHourlyCost += workload.RequestCost - workload.UsageCost
+		}
+	}
+
+	totals.CpuUtilization = costPercent(float64(totals.UsedCpu), float64(totals.RequestedCpu))
+	totals.MemoryUtilization = costPercent(float64(totals.UsedMemory), float64(totals.RequestedMemory))
+	totals.WastedMonthlyCost = wastedHourlyCost * calculator.HOURS_PER_MONTH
+	return totals
+}
+
+// classDistribution tallies the workloads per compute class and sums their monthly cost, listing all the classes
+// in the order of cluster.ComputeClasses.
+func classDistribution(workloads []cluster.Workload) []classCount {
+	distribution := make([]classCount, len(cluster.ComputeClasses))
+	for class, name := range cluster.ComputeClasses {
+		distribution[class].Class = name
+	}
+
+	for _, workload := range workloads {
+		if workload.ComputeClass >= 0 && int(workload.ComputeClass) < len(distribution) {
+			distribution[workload.ComputeClass].Workloads++
+			distribution[workload.ComputeClass].MonthlyCost += workload.Cost * calculator.HOURS_PER_MONTH
+		}
+	}
+
+	return distribution
+}
+
+// formatClassDistribution formats the distribution on one line, like "General-purpose: 80, Balanced: 12".
+func formatClassDistribution(distribution []classCount) string {
+	counts := make([]string, 0, len(distribution))
+	for _, count := range distribution {
+		counts = append(counts, fmt.Sprintf("%s: %d", count.Class, count.Workloads))
+	}
+
+	return strings.Join(counts, ", ")
+}
+
+// formatClassCosts formats the monthly cost per compute class on one line, like "General-purpose: $120.00",
+// leaving out the classes without workloads.
+func formatClassCosts(distribution []classCount) string {
+	costs := make([]string, 0, len(distribution))
+	for _, count := range distribution {
+		if count.Workloads > 0 {
+			costs = append(costs, fmt.Sprintf("%s: $%.2f", count.Class, count.MonthlyCost))
+		}
+	}
+
+	return strings.Join(costs, ", ")
+}
+
+// costHistogramBounds are the upper bounds of the hourly cost buckets of the histogram, on a log scale. A last
+// bucket holds the workloads above them.
+var costHistogramBounds = []float64{0.001, 0.01, 0.1, 1, 10}
+
+// costBucket counts the workloads costing more than the previous bucket and at most the upper bound per hour,
+// with the bound formatted like the le label of a Prometheus histogram.
+type costBucket struct {
+	UpperBound string `json:"le"`
+	Count      int    `json:"count"`
+}
+
+// costHistogram buckets the workloads by hourly cost, to tell apart a long tail of tiny workloads from a few
+// costly ones.
+func costHistogram(workloads []cluster.Workload) []costBucket {
+	buckets := make([]costBucket, len(costHistogramBounds)+1)
+	for i, bound := range costHistogramBounds {
+		buckets[i].UpperBound = strconv.FormatFloat(bound, 'f', -1, 64)
+	}
+	buckets[len(costHistogramBounds)].UpperBound = "+Inf"
+
+	for _, workload := range workloads {
+		buckets[sort.SearchFloat64s(costHistogramBounds, workload.Cost)].Count++
+	}
+
+	return buckets
+}
+
+// hpaCostRange returns the hourly cost of the report with the HPAs at their minimum and at their maximum replicas.
+func (report Report) hpaCostRange() (float64, float64) {
+	minCost, maxCost := report.HourlyCost, report.HourlyCost
+	for _, projection := range report.HPAProjections {
+		minCost += projection.MinCost - projection.CurrentCost
+		maxCost += projection.MaxCost - projection.CurrentCost
+	}
+
+	return minCost, maxCost
+}
+
+// displayHPAProjections writes the monthly cost of each HPA at its minimum, current and maximum replicas, and the
+// range of the monthly cost of the cluster.
+func displayHPAProjections(w io.Writer, report Report) {
+	fmt.Fprintln(w, "Workloads scaled by an HPA, monthly cost at min / current / max replicas:")
+	for _, projection := range report.HPAProjections {
+		fmt.Fprintf(w, "  %s/%s (%s): %d / %d / %d replicas, $%.2f / $%.2f / $%.2f\n", projection.Namespace, projection.Name, projection.Target,
+			projection.MinReplicas, projection.CurrentReplicas, projection.MaxReplicas,
+			projection.MinCost*calculator.HOURS_PER_MONTH, projection.CurrentCost*calculator.HOURS_PER_MONTH, projection.MaxCost*calculator.HOURS_PER_MONTH)
+	}
+
+	minCost, maxCost := report.hpaCostRange()
+	fmt.Fprintf(w, "Estimated monthly cost with the HPAs scaled: $%.2f to $%.2f, $%.2f at the current replicas\n", minCost*calculator.HOURS_PER_MONTH, maxCost*calculator.HOURS_PER_MONTH, report.MonthlyCost)
+}
+
+// displayRegionComparison writes the cost of the workloads in each compared region, next to the difference with
+// the region of the cluster.
+func displayRegionComparison(w io.Writer, report Report) {
+	fmt.Fprintf(w, "Estimated cost of the workloads per region, compared to %s at $%.2f per month:\n", report.Region, report.MonthlyCost)
+	for _, region := range report.RegionComparison {
+		fmt.Fprintf(w, "  %-24s $%10.4f per hour  $%10.2f per month  %+.2f per month\n", region.Region, region.HourlyCost, region.MonthlyCost, region.MonthlyCost-report.MonthlyCost)
+	}
+}
+
+// displayCurrencyTotals writes the hourly and monthly totals with a column per currency.
+func displayCurrencyTotals(w io.Writer, totals []calculator.CurrencyCost) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprint(tw, "Total\t")
+	for _, total := range totals {
+		fmt.Fprintf(tw, "%s\t", total.Currency)
+	}
+	fmt.Fprint(tw, "\nper hour\t")
+	for _, total := range totals {
+		fmt.Fprintf(tw, "%.4f\t", total.HourlyCost)
+	}
+	fmt.Fprint(tw, "\nper month\t")
+	for _, total := range totals {
+		fmt.Fprintf(tw, "%.2f\t", total.MonthlyCost)
+	}
+	fmt.Fprintln(tw)
+	tw.Flush()
+}
+
+// incompatibleWorkloads returns the workloads Autopilot would reject at admission, with -check-compatibility.
+func incompatibleWorkloads(workloads []cluster.Workload) []cluster.Workload {
+	var incompatible []cluster.Workload
+	for _, workload := range workloads {
+		if len(workload.Incompatibilities) > 0 {
+			incompatible = append(incompatible, workload)
+		}
+	}
+
+	return incompatible
+}
+
+// displayIncompatibleWorkloads writes the workloads Autopilot would reject with their settings, and their monthly
+// cost that is part of the estimate although they can't move as they are.
+func displayIncompatibleWorkloads(w io.Writer, workloads []cluster.Workload) {
+	cost := 0.0
+	for _, workload := range workloads {
+		cost += workload.Cost
+	}
+
+	fmt.Fprintf(w, "%d workloads, $%.2f per month of the estimate, would be rejected by Autopilot unless changed:\n", len(workloads), cost*calculator.HOURS_PER_MONTH)
+	for _, workload := range workloads {
+		fmt.Fprintf(w, "  %s/%s: %s\n", workload.Namespace, workload.Name, strings.Join(workload.Incompatibilities, ", "))
+	}
+}
+
+// pdbCostFloor returns the hourly cost of the report with the workloads covered by a PDB at its minimum replicas.
+func (report Report) pdbCostFloor() float64 {
+	floor := report.HourlyCost
+	for _, projection := range report.PDBProjections {
+		floor -= projection.CurrentCost - projection.MinCost
+	}
+
+	return floor
+}
+
+// displayPDBProjections writes the monthly cost of each PDB at its current and minimum replicas, and the monthly
+// cost of the cluster with all of them at their minimum.
+func displayPDBProjections(w io.Writer, report Report) {
+	fmt.Fprintln(w, "Workloads covered by a PDB, monthly cost at current / minimum available replicas:")
+	for _, projection := range report.PDBProjections {
+		fmt.Fprintf(w, "  %s/%s: %d / %d replicas, $%.2f / $%.2f\n", projection.Namespace, projection.Name,
+			projection.CurrentReplicas, projection.MinReplicas,
+			projection.CurrentCost*calculator.HOURS_PER_MONTH, projection.MinCost*calculator.HOURS_PER_MONTH)
+	}
+
+	fmt.Fprintf(w, "Estimated monthly cost floor with the PDBs at their minimum: $%.2f, $%.2f at the current replicas\n", report.pdbCostFloor()*calculator.HOURS_PER_MONTH, report.MonthlyCost)
+}
+
+// histogramBarWidth is the width in characters of the largest bar of the text histogram
+const histogramBarWidth = 40
+
+// displayCostHistogram writes the histogram as text, with a bar per bucket scaled to the largest one.
+func displayCostHistogram(w io.Writer, buckets []costBucket) {
+	largest := 0
+	for _, bucket := range buckets {
+		if bucket.Count > largest {
+			largest = bucket.Count
+		}
+	}
+
+	fmt.Fprintln(w, "Workloads by hourly cost:")
+	for _, bucket := range buckets {
+		bar := 0
+		if largest > 0 {
+			bar = int(math.Ceil(float64(bucket.Count) / float64(largest) * histogramBarWidth))
+		}
+		fmt.Fprintf(w, "  <= $%-6s %6d %s\n", bucket.UpperBound, bucket.Count, strings.Repeat("#", bar))
+	}
+}
+
+// newReport builds the report with the workloads sorted by cost, costliest first.
+func newReport(clusterName string, region string, workloads []cluster.Workload, clusterFee float64, billedHourlyCost float64) Report {
+	sorted := make([]cluster.Workload, len(workloads))
+	copy(sorted, workloads)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Cost > sorted[j].Cost
+	})
+
+	hourlyCost, requestHourlyCost, usageHourlyCost, limitHourlyCost := clusterFee, clusterFee, clusterFee, clusterFee
+	for _, workload := range workloads {
+		hourlyCost += workload.Cost
+		requestHourlyCost += workload.RequestCost
+		usageHourlyCost += workload.UsageCost
+		limitHourlyCost += workload.LimitCost
+	}
+
+	report := Report{
+		Cluster:          clusterName,
+		Region:           region,
+		Workloads:        sorted,
+		ClusterFee:       clusterFee,
+		HourlyCost:       hourlyCost,
+		MonthlyCost:      hourlyCost * calculator.HOURS_PER_MONTH,
+		BilledHourlyCost: billedHourlyCost,
+		Footprint:        footprint(workloads),
+		OverProvisioning: overProvisioningTotals(workloads),
+
+		RequestBasedMonthlyCost:   requestHourlyCost * calculator.HOURS_PER_MONTH,
+		UsageBasedMonthlyCost:     usageHourlyCost * calculator.HOURS_PER_MONTH,
+		RightSizingMonthlySavings: (requestHourlyCost - usageHourlyCost) * calculator.HOURS_PER_MONTH,
+		LimitBasedMonthlyCost:     limitHourlyCost * calculator.HOURS_PER_MONTH,
+	}
+
+	// The billed cost is net of the spot discounts of the Standard nodes, like the estimate prices the workloads
+	// of spot nodes as Spot Pods. The others are priced on demand, see applyCommitment.
+	if billedHourlyCost >= 0 {
+		delta := (hourlyCost - billedHourlyCost) * calculator.HOURS_PER_MONTH
+		report.EstimatedMonthlyDelta = &delta
+	}
+
+	return report
+}
+
+// applyCommitment compares the billed cost, which is net of the committed use discounts of the Standard cluster,
+// with the Autopilot cost under the same commitment: the discount multiplies the cost of the on-demand workloads,
+// while the workloads of spot nodes, spotHourlyCost, and the cluster fee aren't discounted.
+func (report *Report) applyCommitment(commitment string, discount float64, spotHourlyCost float64) {
+	if report.EstimatedMonthlyDelta == nil {
+		return
+	}
+
+	onDemandHourlyCost := report.HourlyCost - report.ClusterFee - spotHourlyCost
+	committedHourlyCost := report.ClusterFee + spotHourlyCost + onDemandHourlyCost*discount
+	delta := (committedHourlyCost - report.BilledHourlyCost) * calculator.HOURS_PER_MONTH
+	report.EstimatedMonthlyDelta = &delta
+	report.Commitment = commitment
+}
+
+// monthlyDeltaHeadline describes the monthly savings, or increase, of moving the Standard cluster to Autopilot,
+// with its share of the billed cost. It's empty when the billed cost is unknown.
+func (report Report) monthlyDeltaHeadline() string {
+	if report.EstimatedMonthlyDelta == nil {
+		return ""
+	}
+
+	delta := *report.EstimatedMonthlyDelta
+	percent := 0.0
+	if billed := report.BilledMonthlyCost(); billed > 0 {
+		percent = math.Abs(delta) / billed * 100
+	}
+
+	move := "moving to Autopilot"
+	if report.Commitment != "" {
+		move += " with a " + report.Commitment + " commitment"
+	}
+
+	if delta > 0 {
+		return fmt.Sprintf("Estimated monthly increase by %s: $%.2f (%.1f%%)", move, delta, percent)
+	}
+
+	return fmt.Sprintf("Estimated monthly savings by %s: $%.2f (%.1f%%)", move, -delta, percent)
+}
+
+// rightSizingLine compares billing the requests with billing the usage, to tell whether right-sizing the requests
+// to match the usage would save money.
+func (report Report) rightSizingLine() string {
+	savings := report.RightSizingMonthlySavings
+	if savings >= 0 {
+		return fmt.Sprintf("You could save $%.2f/month by right-sizing requests to match usage (requests: $%.2f/month, usage: $%.2f/month)", savings, report.RequestBasedMonthlyCost, report.UsageBasedMonthlyCost)
+	}
+
+	return fmt.Sprintf("Usage exceeds requests, right-sizing requests to match usage would add $%.2f/month (requests: $%.2f/month, usage: $%.2f/month)", -savings, report.RequestBasedMonthlyCost, report.UsageBasedMonthlyCost)
+}
+
+// overProvisioningLines compare the total requests with the total usage of the workloads, and give the cost of
+// the unused requests.
+func (report Report) overProvisioningLines() []string {
+	totals := report.OverProvisioning
+	return []string{
+		fmt.Sprintf("Total requested vs total used: %.2f vCPU requested, %.2f used (%.0f%% utilization), %d MB of memory requested, %d used (%.0f%% utilization)",
+			float64(totals.RequestedCpu)/1000, float64(totals.UsedCpu)/1000, totals.CpuUtilization, totals.RequestedMemory, totals.UsedMemory, totals.MemoryUtilization),
+		fmt.Sprintf("Unused requests cost $%.2f/month when billed on requests", totals.WastedMonthlyCost),
+	}
+}
+
+// burstingLine compares the guaranteed cost of the workloads, billed on their requests, with their potential cost
+// billed on their limits. Autopilot bills the requests, the limits are what burstable pods use when the capacity
+// is there, so the potential cost is what raising their requests to their limits would cost.
+func (report Report) burstingLine() string {
+	return fmt.Sprintf("%d burstable workloads with limits above their requests: guaranteed $%.2f/month on requests, potential $%.2f/month on limits",
+		report.BurstableWorkloads, report.RequestBasedMonthlyCost, report.LimitBasedMonthlyCost)
+}
+
+// burstableWorkloads counts the workloads with limits above their requests.
+func burstableWorkloads(workloads []cluster.Workload) int {
+	count := 0
+	for _, workload := range workloads {
+		if workload.Burstable {
+			count++
+		}
+	}
+
+	return count
+}
+
+// BilledMonthlyCost returns the actual monthly cost of the Standard cluster, negative when unknown.
+func (report Report) BilledMonthlyCost() float64 {
+	if report.BilledHourlyCost < 0 {
+		return report.BilledHourlyCost
+	}
+
+	return report.BilledHourlyCost * calculator.HOURS_PER_MONTH
+}
+
+// comparisonBarWidth is the width in pixels of the largest bar of the Standard-vs-Autopilot chart
+const comparisonBarWidth = 400
+
+var htmlReportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"noWorkloads": func() string {
+		return noBillableWorkloadsMessage
+	},
+	"workloadClass": workloadClassName,
+	"barWidth": func(value float64, other float64) float64 {
+		if value <= 0 || other < 0 {
+			return 0
+		}
+		if value >= other {
+			return comparisonBarWidth
+		}
+		return math.Round(comparisonBarWidth * value / other)
+	},
+	// Labels follow the bars, which start after the 140 pixels of the legend
+	"labelX": func(barWidth float64) float64 {
+		return 148 + barWidth
+	},
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Autopilot cost estimate for {{.Cluster}}</title>
+<style>
+body { font-family: Arial, Helvetica, sans-serif; margin: 2em; color: #202124; }
+h1 { font-size: 1.5em; }
+.totals { display: flex; gap: 1em; margin-bottom: 2em; }
+.total { border: 1px solid #dadce0; border-radius: 8px; padding: 1em 1.5em; }
+.total .value { font-size: 1.6em; font-weight: bold; }
+table { border-collapse: collapse; width: 100%; }
+th, td { border-bottom: 1px solid #dadce0; padding: 0.4em 0.8em; text-align: left; }
+th { background: #f1f3f4; }
+td.number { text-align: right; font-variant-numeric: tabular-nums; }
+</style>
+</head>
+<body>
+<h1>Autopilot cost estimate for {{.Cluster}} ({{.Region}})</h1>
+<div class="totals">
+<div class="total"><div>Per hour</div><div class="value">${{printf "%.2f" .HourlyCost}}</div></div>
+<div class="total"><div>Per month</div><div class="value">${{printf "%.2f" .MonthlyCost}}</div></div>
+<div class="total"><div>Workloads</div><div class="value">{{len .Workloads}}</div></div>
+</div>
+{{if ge .BilledHourlyCost 0.0}}
+<h2>Standard vs Autopilot per month</h2>
+<svg width="660" height="70" role="img" aria-label="Standard vs Autopilot monthly cost">
+<text x="0" y="20">Standard (billed)</text>
+<rect x="140" y="6" height="20" width="{{barWidth .BilledMonthlyCost .MonthlyCost}}" fill="#4285f4"></rect>
+<text x="{{labelX (barWidth .BilledMonthlyCost .MonthlyCost)}}" y="20">${{printf "%.2f" .BilledMonthlyCost}}</text>
+<text x="0" y="55">Autopilot</text>
+<rect x="140" y="41" height="20" width="{{barWidth .MonthlyCost .BilledMonthlyCost}}" fill="#34a853"></rect>
+<text x="{{labelX (barWidth .MonthlyCost .BilledMonthlyCost)}}" y="55">${{printf "%.2f" .MonthlyCost}}</text>
+</svg>
+{{end}}
+<h2>Workloads</h2>
+<table>
+<tr><th>Workload</th><th>Node</th><th>Compute Class</th><th>mCPU</th><th>Memory MB</th><th>Storage MB</th><th>Price $/H</th></tr>
+{{range .Workloads}}<tr><td>{{.Name}}</td><td>{{.Node_name}}</td><td>{{workloadClass .}}</td><td class="number">{{.Cpu}}</td><td class="number">{{.Memory}}</td><td class="number">{{.Storage}}</td><td class="number">{{printf "%.4f" .Cost}}</td></tr>
+{{else}}<tr><td colspan="7">{{noWorkloads}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// writeHTMLReport renders the report as a standalone HTML page to w.
+func writeHTMLReport(w io.Writer, report Report) error {
+	return htmlReportTemplate.Execute(w, report)
+}
@@ -0,0 +1,135 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/GoogleCloudPlatform/autopilot-cost-calculator/calculator"
+	"github.com/GoogleCloudPlatform/autopilot-cost-calculator/cluster"
+)
+
+const (
+	slackTopWorkloads = 5
+	// Slack rejects section texts longer than 3000 characters
+	slackMaxTextLength    = 3000
+	slackMaxWorkloadName  = 80
+	slackRequestTimeout   = 10 * time.Second
+	slackTruncationSuffix = "…"
+)
+
+// slackMessage is a Slack Block Kit message, see https://api.slack.com/block-kit
+type slackMessage struct {
+	Text   string       `json:"text"`
+	Blocks []slackBlock `json:"blocks"`
+}
+
+type slackBlock struct {
+	Type   string      `json:"type"`
+	Text   *slackText  `json:"text,omitempty"`
+	Fields []slackText `json:"fields,omitempty"`
+}
+
+type slackText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// postSlackReport posts the report summary with the costliest workloads to a Slack incoming webhook.
+// The delta is shown when the billed hourly cost of the Standard cluster is known (not negative).
+func postSlackReport(webhookURL string, clusterName string, clusterRegion string, workloads []cluster.Workload, clusterFee float64, billedHourlyCost float64) error {
+	totalHourly := clusterFee
+	for _, workload := range workloads {
+		totalHourly += workload.Cost
+	}
+
+	title := fmt.Sprintf("Autopilot cost estimate for %s (%s)", clusterName, clusterRegion)
+	fields := []slackText{
+		{Type: "mrkdwn", Text: fmt.Sprintf("*Total monthly*\n$%.2f", totalHourly*calculator.HOURS_PER_MONTH)},
+		{Type: "mrkdwn", Text: fmt.Sprintf("*Workloads*\n%d", len(workloads))},
+	}
+	if billedHourlyCost >= 0 {
+		fields = append(fields, slackText{Type: "mrkdwn", Text: fmt.Sprintf("*Delta vs Standard (monthly)*\n%+.2f", (totalHourly-billedHourlyCost)*calculator.HOURS_PER_MONTH)})
+	}
+
+	message := slackMessage{
+		Text: title,
+		Blocks: []slackBlock{
+			{Type: "header", Text: &slackText{Type: "plain_text", Text: truncate(title, 150)}},
+			{Type: "section", Fields: fields},
+		},
+	}
+
+	topWorkloads := noBillableWorkloadsMessage
+	if len(workloads) > 0 {
+		topWorkloads = topWorkloadsText(workloads)
+	}
+	message.Blocks = append(message.Blocks, slackBlock{Type: "section", Text: &slackText{Type: "mrkdwn", Text: topWorkloads}})
+
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("error encoding slack message: %v", err)
+	}
+
+	client := &http.Client{Timeout: slackRequestTimeout}
+	response, err := client.Post(webhookURL, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("error posting slack message: %v", err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("error posting slack message: %s", response.Status)
+	}
+
+	return nil
+}
+
+// topWorkloadsText lists the costliest workloads, truncated to fit in a Slack section.
+func topWorkloadsText(workloads []cluster.Workload) string {
+	sorted := make([]cluster.Workload, len(workloads))
+	copy(sorted, workloads)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Cost > sorted[j].Cost
+	})
+
+	var text strings.Builder
+	text.WriteString(fmt.Sprintf("*Top %d costliest workloads (monthly)*", slackTopWorkloads))
+	for i, workload := range sorted {
+		if i == slackTopWorkloads {
+			text.WriteString(fmt.Sprintf("\n… and %d more", len(sorted)-slackTopWorkloads))
+			break
+		}
+		text.WriteString(fmt.Sprintf("\n%d. `%s` $%.2f", i+1, truncate(workload.Name, slackMaxWorkloadName), workload.Cost*calculator.HOURS_PER_MONTH))
+	}
+
+	return truncate(text.String(), slackMaxTextLength)
+}
+
+// truncate shortens text to at most length runes, marking it when shortened.
+func truncate(text string, length int) string {
+	runes := []rune(text)
+	if len(runes) <= length {
+		return text
+	}
+
+	return string(runes[:length-1]) + slackTruncationSuffix
+}
@@ -0,0 +1,192 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"golang.org/x/exp/slog"
+	"google.golang.org/api/option"
+	"google.golang.org/api/storage/v1"
+)
+
+// reportFileName matches the cluster and the time of the run in the name of a json report, see reportBaseName
+var reportFileName = regexp.MustCompile(`(?:^|/)report-(.+)-([0-9]{8}T[0-9]{6}Z)\.json$`)
+
+// trendPoint is the monthly cost of the cluster in a report, at the time of its run.
+type trendPoint struct {
+	Time        time.Time
+	Object      string
+	Cluster     string
+	MonthlyCost float64
+}
+
+// parseGCSPrefix splits a gs://BUCKET/PREFIX URI in its bucket and object prefix, which can be empty.
+func parseGCSPrefix(uri string) (string, string, error) {
+	path, ok := strings.CutPrefix(uri, "gs://")
+	bucket, prefix, _ := strings.Cut(path, "/")
+	if !ok || bucket == "" {
+		return "", "", fmt.Errorf("expected a GCS prefix like gs://BUCKET/PREFIX, got %q", uri)
+	}
+
+	return bucket, prefix, nil
+}
+
+// costTrend reads the last json reports of the cluster under the GCS prefix, oldest first, and returns the monthly
+// cost of the cluster in each of them. Only objects named like the json reports of -output-dir are read, and
+// without a clusterName the prefix must hold the reports of a single cluster.
+func costTrend(ctx context.Context, storageService *storage.Service, uri string, clusterName string, last int) ([]trendPoint, error) {
+	bucket, prefix, err := parseGCSPrefix(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	var objects []trendPoint
+	clusters := make(map[string]bool)
+	err = storageService.Objects.List(bucket).Prefix(prefix).Pages(ctx, func(response *storage.Objects) error {
+		for _, object := range response.Items {
+			match := reportFileName.FindStringSubmatch(object.Name)
+			if match == nil || (clusterName != "" && match[1] != clusterName) {
+				continue
+			}
+			objectTime, err := time.Parse(reportTimeFormat, match[2])
+			if err != nil {
+				slog.Warn("Skipping report with an invalid time", "object", object.Name, "error", err)
+				continue
+			}
+			clusters[match[1]] = true
+			objects = append(objects, trendPoint{Time: objectTime, Object: object.Name, Cluster: match[1]})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list the reports under %s: %v", uri, err)
+	}
+
+	if len(clusters) > 1 {
+		names := make([]string, 0, len(clusters))
+		for name := range clusters {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("the reports under %s are of several clusters, select one of %s with -cluster", uri, strings.Join(names, ", "))
+	}
+
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].Time.Before(objects[j].Time)
+	})
+	if last > 0 && len(objects) > last {
+		objects = objects[len(objects)-last:]
+	}
+
+	points := make([]trendPoint, 0, len(objects))
+	for _, object := range objects {
+		report, err := readGCSReport(ctx, storageService, bucket, object.Object)
+		if err != nil {
+			return nil, err
+		}
+		// Objects named like a report of the cluster can still hold another report, like a renamed one
+		if report.Cluster != object.Cluster {
+			slog.Warn("Skipping report of another cluster", "object", object.Object, "cluster", report.Cluster)
+			continue
+		}
+		object.MonthlyCost = report.MonthlyCost
+		points = append(points, object)
+	}
+
+	return points, nil
+}
+
+// readGCSReport downloads and parses a json report from GCS.
+func readGCSReport(ctx context.Context, storageService *storage.Service, bucket string, name string) (Report, error) {
+	response, err := storageService.Objects.Get(bucket, name).Context(ctx).Download()
+	if err != nil {
+		return Report{}, fmt.Errorf("error downloading gs://%s/%s: %v", bucket, name, err)
+	}
+	defer response.Body.Close()
+
+	var report Report
+	if err := json.NewDecoder(response.Body).Decode(&report); err != nil {
+		return Report{}, fmt.Errorf("error parsing gs://%s/%s: %v", bucket, name, err)
+	}
+
+	return report, nil
+}
+
+// displayCostTrend writes the monthly cost of each report with its change from the previous one, then the change
+// from the first to the last report.
+func displayCostTrend(w io.Writer, points []trendPoint) {
+	if len(points) == 0 {
+		fmt.Fprintln(w, "No json reports found")
+		return
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TIME\tMONTHLY\tCHANGE\tREPORT")
+	for i, point := range points {
+		change := ""
+		if i > 0 {
+			change = fmt.Sprintf("%+.1f%%", costPercent(point.MonthlyCost-points[i-1].MonthlyCost, points[i-1].MonthlyCost))
+		}
+		fmt.Fprintf(tw, "%s\t$%.2f\t%s\t%s\n", point.Time.UTC().Format("2006-01-02 15:04 MST"), point.MonthlyCost, change, point.Object)
+	}
+	tw.Flush()
+
+	first, last := points[0].MonthlyCost, points[len(points)-1].MonthlyCost
+	fmt.Fprintf(w, "\nMonthly cost from $%.2f to $%.2f over %d reports, net change %+.2f (%+.1f%%)\n", first, last, len(points), last-first, costPercent(last-first, first))
+}
+
+// runTrend prints the monthly cost of the cluster over time from the json reports under a GCS prefix, like the
+// ones written with -output-dir and copied to a bucket.
+func runTrend(args []string) {
+	flags := flag.NewFlagSet("trend", flag.ExitOnError)
+	lastFlag := flags.Int("last", 12, "Number of the latest reports to read, 0 reads all of them")
+	clusterFlag := flags.String("cluster", "", "Cluster to read the reports of, required when the prefix has the reports of several clusters")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s trend [flags] gs://BUCKET/PREFIX\n\nPrints the monthly cost of the json reports of a cluster under the prefix over time. Flags:\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	logs := addLogFlags(flags, "Only write the trend, without logs below errors")
+	flags.Parse(args)
+	logs.setup()
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(1)
+	}
+
+	ctx := context.Background()
+	storageService, err := storage.NewService(ctx, option.WithScopes(storage.DevstorageReadOnlyScope))
+	if err != nil {
+		fatal("Unable to initialize the storage service", "error", err)
+	}
+
+	points, err := costTrend(ctx, storageService, flags.Arg(0), *clusterFlag, *lastFlag)
+	if err != nil {
+		fatal("Error reading the reports", "error", err)
+	}
+
+	displayCostTrend(os.Stdout, points)
+}
@@ -16,13 +16,23 @@ package main
 
 import (
 	"fmt"
+	"io"
+	"math"
 	"os"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/GoogleCloudPlatform/autopilot-cost-calculator/calculator"
 	"github.com/GoogleCloudPlatform/autopilot-cost-calculator/cluster"
+	"github.com/charmbracelet/bubbles/spinner"
 	"github.com/charmbracelet/bubbles/table"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
+	"github.com/muesli/termenv"
+	"golang.org/x/exp/slog"
+	"golang.org/x/term"
 )
 
 var (
@@ -33,8 +43,52 @@ var (
 	greenTextStyle = lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("25")).Background(lipgloss.Color("192"))
 )
 
+// terminal is true when the output is an interactive terminal, with a terminal as input too. Tables are then
+// drawn through a bubbletea program, otherwise they are written as plain text: bubbletea opens a TTY for its input
+// when stdin isn't one, which fails or hangs where there is none, like in a CronJob.
+var terminal bool
+
+// costPrecision is the number of decimals of the costs in the tables, set with -precision between
+// minCostPrecision and maxCostPrecision.
+var costPrecision = defaultCostPrecision
+
+// rawUnits keeps the mCPU and MB of the tables as plain integers for machine parsing, set with -raw-units.
+var rawUnits bool
+
+const (
+	defaultCostPrecision = 4
+	minCostPrecision     = 2
+	maxCostPrecision     = 6
+)
+
+// ConfigureOutput detects whether out and stdin are terminals and disables all styling when out isn't one,
+// when NO_COLOR is set (https://no-color.org) or when noColor is true. Returns whether colors are enabled.
+func ConfigureOutput(out *os.File, noColor bool) bool {
+	outTerminal := term.IsTerminal(int(out.Fd()))
+	terminal = outTerminal && term.IsTerminal(int(os.Stdin.Fd()))
+
+	if _, noColorEnv := os.LookupEnv("NO_COLOR"); !outTerminal || noColorEnv || noColor {
+		lipgloss.SetColorProfile(termenv.Ascii)
+		return false
+	}
+
+	return true
+}
+
+// costColors are the cost cell colors from the lowest to the highest share of the cluster total.
+var costColors = [3]lipgloss.Color{"34", "220", "196"}
+
+// CostHighlight holds the shares of the cluster total from which a workload cost is shown
+// as medium (yellow) or high (red). Lower shares are shown green.
+type CostHighlight struct {
+	MediumShare float64
+	HighShare   float64
+}
+
 type tableModel struct {
 	table table.Model
+	// cellStyles are applied to the last cell of the rows with the same index
+	cellStyles map[int]lipgloss.Style
 }
 
 func (m tableModel) Init() tea.Cmd { return nil }
@@ -45,21 +99,383 @@ func (m tableModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 }
 
 func (m tableModel) View() string {
-	return baseStyle.Render(m.table.View()) + "\n"
+	return baseStyle.Render(m.styleCells(m.table.View())) + "\n"
+}
+
+// watchModel redraws the workload table with refreshed workloads on every interval, until q is pressed.
+type watchModel struct {
+	tableModel
+	interval time.Duration
+	refresh  func() (tableModel, error)
+	updated  time.Time
+	err      error
+}
+
+// tickMsg is sent when the workloads are due for a refresh
+type tickMsg time.Time
+
+// refreshMsg carries the table with the refreshed workloads, or the error refreshing them
+type refreshMsg struct {
+	model tableModel
+	err   error
+}
+
+func (m watchModel) tick() tea.Cmd {
+	return tea.Tick(m.interval, func(t time.Time) tea.Msg {
+		return tickMsg(t)
+	})
+}
+
+func (m watchModel) Init() tea.Cmd { return m.tick() }
+
+func (m watchModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		if msg.String() == "q" || msg.Type == tea.KeyCtrlC {
+			return m, tea.Quit
+		}
+	case tickMsg:
+		// Refreshing lists the pods and their metrics, so it runs outside of the update loop
+		return m, func() tea.Msg {
+			model, err := m.refresh()
+			return refreshMsg{model: model, err: err}
+		}
+	case refreshMsg:
+		// The last table is kept on errors, they are usually transient API failures
+		m.err = msg.err
+		if msg.err == nil {
+			m.tableModel = msg.model
+			m.updated = time.Now()
+		}
+		return m, m.tick()
+	}
+
+	return m, nil
+}
+
+func (m watchModel) View() string {
+	status := fmt.Sprintf("Refreshed at %s, every %s. Press q to quit.", m.updated.Format(time.TimeOnly), m.interval)
+	if m.err != nil {
+		status = fmt.Sprintf("Error refreshing the workloads: %v. Press q to quit.", m.err)
+	}
+
+	return m.tableModel.View() + status + "\n"
+}
+
+// watchWorkloadTable draws the workload table and refreshes it every interval until q is pressed.
+func watchWorkloadTable(w io.Writer, model tableModel, interval time.Duration, refresh func() (tableModel, error)) error {
+	program := tea.NewProgram(watchModel{tableModel: model, interval: interval, refresh: refresh, updated: time.Now()}, tea.WithOutput(w))
+	_, err := program.Run()
+	return err
+}
+
+// progressMsg reports the number of pods described so far and the total
+type progressMsg struct {
+	done  int
+	total int
+}
+
+// progressDoneMsg clears the progress and quits
+type progressDoneMsg struct{}
+
+// progressModel shows a spinner with the number of pods described so far, which takes a while on large clusters.
+type progressModel struct {
+	spinner spinner.Model
+	done    int
+	total   int
+	quit    bool
+}
+
+func newProgressModel() progressModel {
+	return progressModel{spinner: spinner.New(spinner.WithSpinner(spinner.Dot))}
+}
+
+func (m progressModel) Init() tea.Cmd { return m.spinner.Tick }
+
+func (m progressModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case progressMsg:
+		m.done, m.total = msg.done, msg.total
+		return m, nil
+	case progressDoneMsg:
+		m.quit = true
+		return m, tea.Quit
+	}
+
+	var cmd tea.Cmd
+	m.spinner, cmd = m.spinner.Update(msg)
+	return m, cmd
+}
+
+func (m progressModel) View() string {
+	// The last view stays on the screen, so quitting draws nothing
+	if m.quit {
+		return ""
+	}
+	if m.total == 0 {
+		return m.spinner.View() + " Listing pods\n"
+	}
+
+	return fmt.Sprintf("%s Describing pod %d/%d\n", m.spinner.View(), m.done, m.total)
+}
+
+// startProgress draws the progress of describing the pods to w. It returns the function reporting the progress,
+// for PricingService.Progress, and the one clearing it once done.
+func startProgress(w io.Writer) (func(done int, total int), func()) {
+	program := tea.NewProgram(newProgressModel(), tea.WithOutput(w), tea.WithInput(nil))
+	finished := make(chan struct{})
+	go func() {
+		if _, err := program.Run(); err != nil {
+			slog.Debug("Error displaying the progress", "error", err)
+		}
+		close(finished)
+	}()
+
+	report := func(done int, total int) {
+		program.Send(progressMsg{done: done, total: total})
+	}
+	stop := func() {
+		program.Send(progressDoneMsg{})
+		<-finished
+	}
+
+	return report, stop
 }
 
-func DisplayNodeTable(nodes map[string]cluster.Node) {
+// styleCells applies the cell styles to the rendered table. The table truncates cells by counting
+// runes, so styles can't be part of the row values without breaking the column widths.
+func (m tableModel) styleCells(view string) string {
+	if len(m.cellStyles) == 0 {
+		return view
+	}
+
+	rows := m.table.Rows()
+	lines := strings.Split(view, "\n")
+	headerLines := len(lines) - len(rows)
+	if headerLines < 0 {
+		return view
+	}
+
+	for rowID, style := range m.cellStyles {
+		value := rows[rowID][len(rows[rowID])-1]
+		line := lines[headerLines+rowID]
+
+		// Truncated values are left unstyled
+		position := strings.LastIndex(line, value)
+		if position < 0 {
+			continue
+		}
+		lines[headerLines+rowID] = line[:position] + style.Render(value) + line[position+len(value):]
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// costStyle returns the style for a cost given its share of the cluster total.
+func costStyle(share float64, highlight CostHighlight) lipgloss.Style {
+	color := costColors[0]
+	if share >= highlight.HighShare {
+		color = costColors[2]
+	} else if share >= highlight.MediumShare {
+		color = costColors[1]
+	}
+
+	return lipgloss.NewStyle().Foreground(color)
+}
+
+// displayTable draws the table model to w, through bubbletea only when the output is an interactive terminal.
+func displayTable(w io.Writer, model tableModel) {
+	if !terminal {
+		fmt.Fprint(w, model.View())
+		return
+	}
+
+	// The table quits right after drawing, it never reads any input
+	program := tea.NewProgram(model, tea.WithOutput(w), tea.WithInput(nil))
+	_, err := program.Run()
+	if err != nil {
+		fatal("Error displaying table", "error", err)
+	}
+}
+
+// nodeSummary aggregates the nodes of the cluster for the footer of the node table.
+type nodeSummary struct {
+	total    int
+	spot     int
+	onDemand int
+	// families counts the nodes per machine family, like e2 for e2-standard-4
+	families map[string]int
+	cpu      int64
+	memory   int64
+	// lightlyLoaded and denselyPacked count the nodes much cheaper on Autopilot and the ones cheaper on Standard
+	lightlyLoaded int
+	denselyPacked int
+	// spotCosts and onDemandCosts compare the Standard cost of the nodes with a GCE price with the Autopilot cost of their
+	// workloads, spot nodes with Spot Pods and on-demand nodes with regular pods
+	spotCosts     provisioningCosts
+	onDemandCosts provisioningCosts
+}
+
+// provisioningCosts is the hourly Standard and Autopilot cost of the nodes of a provisioning model.
+type provisioningCosts struct {
+	standard  float64
+	autopilot float64
+}
+
+// cells formats the costs for the Standard $/H and Autopilot $/H columns of the node table.
+func (costs provisioningCosts) cells() []string {
+	if costs.standard == 0 {
+		return []string{"", ""}
+	}
+
+	return []string{formatCost(costs.standard), formatCost(costs.autopilot)}
+}
+
+// lightlyLoadedEfficiency is the efficiency score below which the workloads of a node cost at most half as much
+// on Autopilot, making it a consolidation candidate.
+const lightlyLoadedEfficiency = 0.5
+
+// efficiencyVerdict describes the efficiency score of a node for the node table, empty when it has no score.
+func efficiencyVerdict(efficiency float64) string {
+	switch {
+	case efficiency <= 0:
+		return ""
+	case efficiency < lightlyLoadedEfficiency:
+		return "Autopilot, lightly loaded"
+	case efficiency < 1:
+		return "Autopilot"
+	default:
+		return "Standard, densely packed"
+	}
+}
+
+// summarizeNodes counts the nodes by provisioning model and machine family, and sums their allocatable resources.
+func summarizeNodes(nodes map[string]cluster.Node) nodeSummary {
+	summary := nodeSummary{families: make(map[string]int)}
+	for _, node := range nodes {
+		summary.total++
+		costs := &summary.onDemandCosts
+		if node.Spot {
+			summary.spot++
+			costs = &summary.spotCosts
+		} else {
+			summary.onDemand++
+		}
+		// Workload costs already use the Spot Pod prices on spot nodes
+		if node.StandardCost > 0 {
+			costs.standard += node.StandardCost
+			costs.autopilot += node.Cost
+		}
+
+		family, _, _ := strings.Cut(node.InstanceType, "-")
+		if family == "" {
+			family = "unknown"
+		}
+		summary.families[family]++
+
+		summary.cpu += node.Cpu
+		summary.memory += node.Memory
+
+		if node.Efficiency > 0 && node.Efficiency < lightlyLoadedEfficiency {
+			summary.lightlyLoaded++
+		} else if node.Efficiency >= 1 {
+			summary.denselyPacked++
+		}
+	}
+
+	return summary
+}
+
+// sortedNodes lists the nodes by cost descending then by name, with their workloads sorted the same way, so that
+// the outputs are stable across runs.
+func sortedNodes(nodes map[string]cluster.Node) []cluster.Node {
+	sorted := make([]cluster.Node, 0, len(nodes))
+	for _, node := range nodes {
+		workloads := make([]cluster.Workload, len(node.Workloads))
+		copy(workloads, node.Workloads)
+		sort.Slice(workloads, func(i, j int) bool {
+			if workloads[i].Cost != workloads[j].Cost {
+				return workloads[i].Cost > workloads[j].Cost
+			}
+			return workloads[i].Name < workloads[j].Name
+		})
+		node.Workloads = workloads
+		sorted = append(sorted, node)
+	}
+
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Cost != sorted[j].Cost {
+			return sorted[i].Cost > sorted[j].Cost
+		}
+		return sorted[i].Name < sorted[j].Name
+	})
+
+	return sorted
+}
+
+// DisplayNodeTable writes the nodes sorted by cost, followed by their totals. Like kubectl's -o wide, wide also
+// lists the zone, node pool, kubelet version and internal IPs of each node after its region.
+func DisplayNodeTable(w io.Writer, nodes map[string]cluster.Node, wide bool) {
 	columns := []table.Column{
 		{Title: "Name", Width: 55},
 		{Title: "Type", Width: 15},
 		{Title: "Region", Width: 20},
+	}
+	if wide {
+		columns = append(columns,
+			table.Column{Title: "Zone", Width: 20},
+			table.Column{Title: "Node Pool", Width: 20},
+			table.Column{Title: "Version", Width: 20},
+			table.Column{Title: "Internal IPs", Width: 40},
+		)
+	}
+	cpuTitle, memoryTitle, _ := resourceTitles()
+	columns = append(columns, []table.Column{
 		{Title: "Accelerator", Width: 25},
 		{Title: "Spot?", Width: 10},
+		{Title: cpuTitle, Width: 10},
+		{Title: memoryTitle, Width: 10},
+		{Title: "Standard $/H", Width: 12},
+		{Title: "Autopilot $/H", Width: 13},
+		{Title: "Efficiency", Width: 10},
+		{Title: "Cheaper on", Width: 25},
+	}...)
+
+	// row inserts the wide cells after the region, or empty ones when there are none
+	row := func(cells table.Row, wideCells ...string) table.Row {
+		if !wide {
+			return cells
+		}
+		wideCells = append(wideCells, make([]string, 4-len(wideCells))...)
+		return append(append(append(table.Row{}, cells[:3]...), wideCells...), cells[3:]...)
 	}
 
 	var rows []table.Row
-	for _, node := range nodes {
-		rows = append(rows, table.Row{node.Name, node.InstanceType, node.Region, node.Accelerator, strconv.FormatBool(node.Spot)})
+	for _, node := range sortedNodes(nodes) {
+		standardCost, efficiency := "", ""
+		if node.Efficiency > 0 {
+			standardCost = formatCost(node.StandardCost)
+			efficiency = strconv.FormatFloat(node.Efficiency, 'f', 2, 64)
+		}
+		rows = append(rows, row(table.Row{node.Name, node.InstanceType, node.Region, node.Accelerator, strconv.FormatBool(node.Spot), formatCPU(node.Cpu), formatMemory(node.Memory), standardCost, formatCost(node.Cost), efficiency, efficiencyVerdict(node.Efficiency)},
+			node.Zone, node.NodePool, node.KubeletVersion, strings.Join(node.InternalIPs, ",")))
+	}
+
+	summary := summarizeNodes(nodes)
+	rows = append(rows, row(table.Row{"Total nodes", strconv.Itoa(summary.total), "", "", "", formatCPU(summary.cpu), formatMemory(summary.memory), "", "", "", ""}))
+	spotCosts, onDemandCosts := summary.spotCosts.cells(), summary.onDemandCosts.cells()
+	rows = append(rows, row(table.Row{"... spot", strconv.Itoa(summary.spot), "", "", "", "", "", spotCosts[0], spotCosts[1], "", ""}))
+	rows = append(rows, row(table.Row{"... on-demand", strconv.Itoa(summary.onDemand), "", "", "", "", "", onDemandCosts[0], onDemandCosts[1], "", ""}))
+	rows = append(rows, row(table.Row{"... lightly loaded", strconv.Itoa(summary.lightlyLoaded), "", "", "", "", "", "", "", "", ""}))
+	rows = append(rows, row(table.Row{"... densely packed", strconv.Itoa(summary.denselyPacked), "", "", "", "", "", "", "", "", ""}))
+
+	families := make([]string, 0, len(summary.families))
+	for family := range summary.families {
+		families = append(families, family)
+	}
+	sort.Strings(families)
+	for _, family := range families {
+		rows = append(rows, row(table.Row{fmt.Sprintf("... %s family", family), strconv.Itoa(summary.families[family]), "", "", "", "", "", "", "", "", ""}))
 	}
 
 	tbl := table.New(
@@ -81,58 +497,123 @@ func DisplayNodeTable(nodes map[string]cluster.Node) {
 		Bold(false)
 	tbl.SetStyles(stl)
 
-	program := tea.NewProgram(tableModel{tbl})
-	_, err := program.Run()
-	if err != nil {
-		fmt.Printf("Error: %v\n", err)
-		os.Exit(1)
+	displayTable(w, tableModel{table: tbl})
+}
+
+// workloadRow is a workload with the node it runs on, as listed in the workload table
+type workloadRow struct {
+	node     cluster.Node
+	workload cluster.Workload
+}
+
+// topWorkloadRows sorts the rows by cost descending and keeps the top ones costing at least minCost. It also
+// returns how many rows were left out and their total cost. A top of 0 keeps all the rows above minCost.
+func topWorkloadRows(rows []workloadRow, top int, minCost float64) ([]workloadRow, int, float64) {
+	sort.SliceStable(rows, func(i, j int) bool {
+		return rows[i].workload.Cost > rows[j].workload.Cost
+	})
+
+	keep := sort.Search(len(rows), func(i int) bool {
+		return rows[i].workload.Cost < minCost
+	})
+	if top > 0 && top < keep {
+		keep = top
 	}
+
+	restCost := 0.0
+	for _, row := range rows[keep:] {
+		restCost += row.workload.Cost
+	}
+
+	return rows[:keep], len(rows) - keep, restCost
 }
 
-func DisplayWorkloadTable(nodes map[string]cluster.Node, oneYearDiscount float64, threeYearDiscount float64, clusterFee float64) {
-	columns := []table.Column{
-		{Title: "Node", Width: 55},
-		{Title: "Workload", Width: 40},
-		{Title: "Containers", Width: 10},
-		{Title: "Spot", Width: 10},
-		{Title: "mCPU", Width: 10},
-		{Title: "Memory MiB", Width: 10},
-		{Title: "Storage MiB", Width: 12},
-		{Title: "Compute Class", Width: 13},
-		{Title: "Price $/H", Width: 10},
+// formatCost formats a cost for the tables as fixed-point dollars with costPrecision decimals and thousands
+// separators, like $1,234.5678, never in scientific notation.
+func formatCost(cost float64) string {
+	digits := strconv.FormatFloat(math.Abs(cost), 'f', costPrecision, 64)
+	integer, decimals, _ := strings.Cut(digits, ".")
+
+	var grouped strings.Builder
+	for i, digit := range integer {
+		if i > 0 && (len(integer)-i)%3 == 0 {
+			grouped.WriteByte(',')
+		}
+		grouped.WriteRune(digit)
 	}
 
-	var rows []table.Row
-	totalCost := 0.0 // Cluster fee is fixed amount
-	totalCostSpot := 0.0
+	sign := ""
+	// Costs rounding to zero aren't shown as negative
+	if cost < 0 && strings.Trim(digits, "0.") != "" {
+		sign = "-"
+	}
+	return sign + "$" + grouped.String() + "." + decimals
+}
 
-	for _, node := range nodes {
-		for _, workload := range node.Workloads {
-			// Nodes on spot don't amount for 1 or 3 year commit discounts
-			if node.Spot {
-				totalCostSpot += workload.Cost
-			} else {
-				totalCost += workload.Cost
-			}
-			rows = append(rows,
-				table.Row{
-					node.Name,
-					workload.Name,
-					strconv.Itoa(workload.Containers),
-					strconv.FormatBool(node.Spot),
-					strconv.FormatInt(workload.Cpu, 10),
-					strconv.FormatInt(workload.Memory, 10),
-					strconv.FormatInt(workload.Storage, 10),
-					cluster.ComputeClasses[workload.ComputeClass],
-					strconv.FormatFloat(workload.Cost, 'G', 7, 64),
-				},
-			)
-		}
-	}
-
-	rows = append(rows, table.Row{"Total cost per cluster per hour", "", "", "", "", "", "", "", strconv.FormatFloat(totalCost+clusterFee, 'G', 7, 64)})
-	rows = append(rows, table.Row{"... 1 year commit", "", "", "", "", "", "", "", strconv.FormatFloat((totalCostSpot+totalCost*oneYearDiscount)+clusterFee, 'G', 7, 64)})
-	rows = append(rows, table.Row{"... with 3 year commit", "", "", "", "", "", "", "", strconv.FormatFloat((totalCostSpot+totalCost*threeYearDiscount)+clusterFee, 'G', 7, 64)})
+// formatCPU formats mCPU for the tables, in whole vCPUs like "4" or else in mCPU like "250m". With rawUnits it's
+// the plain mCPU.
+func formatCPU(mcpu int64) string {
+	if rawUnits {
+		return strconv.FormatInt(mcpu, 10)
+	}
+	if mcpu%1000 == 0 {
+		return strconv.FormatInt(mcpu/1000, 10)
+	}
+	return strconv.FormatInt(mcpu, 10) + "m"
+}
+
+// formatMemory formats the MB of memory or storage for the tables, millions of bytes, with the decimal suffixes
+// of Kubernetes quantities and up to one decimal, like "512M", "1.5G" or "2T". With rawUnits it's the plain MB.
+func formatMemory(mb int64) string {
+	if rawUnits {
+		return strconv.FormatInt(mb, 10)
+	}
+
+	value, suffix := float64(mb), "M"
+	for _, larger := range []string{"G", "T", "P"} {
+		if math.Abs(value) < 1000 {
+			break
+		}
+		value, suffix = value/1000, larger
+	}
+	return strconv.FormatFloat(math.Round(value*10)/10, 'f', -1, 64) + suffix
+}
+
+// resourceTitles are the titles of the mCPU, memory and storage columns, with their unit when they are raw.
+func resourceTitles() (string, string, string) {
+	if rawUnits {
+		return "mCPU", "Memory MB", "Storage MB"
+	}
+	return "CPU", "Memory", "Storage"
+}
+
+// formatPercent formats a percentage for the tables.
+func formatPercent(percent float64) string {
+	return strconv.FormatFloat(percent, 'f', 1, 64) + "%"
+}
+
+// insertBeforePrice inserts the extra cells before the last cell, so the price stays the last column.
+func insertBeforePrice[T any](cells []T, extra ...T) []T {
+	price := cells[len(cells)-1]
+	cells = append(cells[:len(cells)-1:len(cells)-1], extra...)
+	return append(cells, price)
+}
+
+// systemWorkloadLabel prefixes the name of the system workloads in the workload table
+const systemWorkloadLabel = "system (normally managed): "
+
+// DisplayWorkloadTable writes the workloads sorted by cost. With a top above 0 only the costliest workloads are
+// listed, and workloads costing less than minCost per hour are left out. They are aggregated in a row following
+// the listed workloads, while the totals still cover all the workloads. With breakdown
+// the CPU, memory and storage costs of each workload are listed before the price, and with adjustments the raw
+// mCPU and memory before Autopilot's minimums and rounding.
+func DisplayWorkloadTable(w io.Writer, nodes map[string]cluster.Node, oneYearDiscount float64, threeYearDiscount float64, clusterFee float64, highlight *CostHighlight, top int, minCost float64, breakdown bool, adjustments bool) {
+	displayTable(w, workloadTableModel(nodes, oneYearDiscount, threeYearDiscount, clusterFee, highlight, top, minCost, breakdown, adjustments))
+}
+
+// workloadTableModel builds the workload table drawn by DisplayWorkloadTable.
+func workloadTableModel(nodes map[string]cluster.Node, oneYearDiscount float64, threeYearDiscount float64, clusterFee float64, highlight *CostHighlight, top int, minCost float64, breakdown bool, adjustments bool) tableModel {
+	columns, rows, cellStyles := workloadTable(nodes, oneYearDiscount, threeYearDiscount, clusterFee, highlight, top, minCost, breakdown, adjustments)
 
 	tbl := table.New(
 		table.WithColumns(columns),
@@ -154,10 +635,162 @@ func DisplayWorkloadTable(nodes map[string]cluster.Node, oneYearDiscount float64
 		Bold(false)
 	tbl.SetStyles(stl)
 
-	program := tea.NewProgram(tableModel{tbl})
-	_, err := program.Run()
-	if err != nil {
-		fmt.Printf("Error: %v\n", err)
-		os.Exit(1)
+	return tableModel{table: tbl, cellStyles: cellStyles}
+}
+
+// workloadTable returns the columns and rows of the workload table, and the styles of the workload costs.
+func workloadTable(nodes map[string]cluster.Node, oneYearDiscount float64, threeYearDiscount float64, clusterFee float64, highlight *CostHighlight, top int, minCost float64, breakdown bool, adjustments bool) ([]table.Column, []table.Row, map[int]lipgloss.Style) {
+	cpuTitle, memoryTitle, storageTitle := resourceTitles()
+	columns := []table.Column{
+		{Title: "Node", Width: 55},
+		{Title: "Workload", Width: 40},
+		{Title: "Containers", Width: 10},
+		{Title: "Spot", Width: 10},
+		{Title: cpuTitle, Width: 10},
+		{Title: memoryTitle, Width: 10},
+		{Title: storageTitle, Width: 12},
+		{Title: "Compute Class", Width: 13},
+		{Title: "% of total", Width: 10},
+		{Title: "Price $/H", Width: 10},
+	}
+
+	var workloadRows []workloadRow
+	totalCost := 0.0 // Cluster fee is fixed amount
+	totalCostSpot := 0.0
+	totalPercent := 0.0
+	totalCostAllSpot := 0.0
+	totalCostStorage := 0.0
+	var billable []cluster.Workload
+
+	for _, node := range sortedNodes(nodes) {
+		for _, workload := range node.Workloads {
+			// System workloads are listed but normally managed, so they are left out of the totals
+			if workload.System {
+				workloadRows = append(workloadRows, workloadRow{node: node, workload: workload})
+				continue
+			}
+
+			// Nodes on spot don't amount for 1 or 3 year commit discounts
+			if node.Spot {
+				totalCostSpot += workload.Cost
+			} else {
+				totalCost += workload.Cost
+			}
+			workloadRows = append(workloadRows, workloadRow{node: node, workload: workload})
+			totalPercent += workload.PercentOfTotal
+			totalCostAllSpot += workload.SpotCost
+			totalCostStorage += workload.Breakdown.Storage
+			billable = append(billable, workload)
+		}
+	}
+
+	workloadRows, restCount, restCost := topWorkloadRows(workloadRows, top, minCost)
+
+	// The class reasons are only set in explain mode
+	explain := false
+	for _, row := range workloadRows {
+		explain = explain || row.workload.ClassReason != ""
+	}
+
+	var rows []table.Row
+	var costs []float64
+	for _, row := range workloadRows {
+		name := row.workload.Name
+		if row.workload.System {
+			name = systemWorkloadLabel + name
+		}
+		rows = append(rows,
+			table.Row{
+				row.node.Name,
+				name,
+				strconv.Itoa(row.workload.Containers),
+				strconv.FormatBool(row.node.Spot),
+				formatCPU(row.workload.Cpu),
+				formatMemory(row.workload.Memory),
+				formatMemory(row.workload.Storage),
+				cluster.ComputeClasses[row.workload.ComputeClass],
+				formatPercent(row.workload.PercentOfTotal),
+				formatCost(row.workload.Cost),
+			},
+		)
+		costs = append(costs, row.workload.Cost)
+	}
+
+	if restCount > 0 {
+		restPercent := totalPercent
+		for _, row := range workloadRows {
+			restPercent -= row.workload.PercentOfTotal
+		}
+		rows = append(rows, table.Row{fmt.Sprintf("... and %d more (total %s)", restCount, formatCost(restCost)), "", "", "", "", "", "", "", formatPercent(restPercent), formatCost(restCost)})
+	}
+
+	cellStyles := make(map[int]lipgloss.Style)
+	if highlight != nil && totalCost+totalCostSpot > 0 {
+		for rowID, cost := range costs {
+			cellStyles[rowID] = costStyle(cost/(totalCost+totalCostSpot), *highlight)
+		}
 	}
+
+	total := footprint(billable)
+	rows = append(rows, table.Row{"Total billed resources", "", "", "", formatCPU(total.Cpu), formatMemory(total.Memory), formatMemory(total.Storage), "", "", ""})
+	rows = append(rows, table.Row{"Total cost per cluster per hour", "", "", "", "", "", "", "", "", formatCost(totalCost + totalCostSpot + clusterFee)})
+	rows = append(rows, table.Row{"... per month", "", "", "", "", "", "", "", "", formatCost((totalCost + totalCostSpot + clusterFee) * calculator.HOURS_PER_MONTH)})
+	rows = append(rows, table.Row{"... of which storage per month", "", "", "", "", "", "", "", "", formatCost(totalCostStorage * calculator.HOURS_PER_MONTH)})
+	// The workloads on Spot nodes are the spot exposure of the cluster, the cluster fee is in neither total
+	rows = append(rows, table.Row{"... on-demand total per hour", "", "", "", "", "", "", "", "", formatCost(totalCost)})
+	rows = append(rows, table.Row{"... spot total per hour", "", "", "", "", "", "", "", "", formatCost(totalCostSpot)})
+	rows = append(rows, table.Row{"... 1 year commit", "", "", "", "", "", "", "", "", formatCost((totalCostSpot + totalCost*oneYearDiscount) + clusterFee)})
+	rows = append(rows, table.Row{"... with 3 year commit", "", "", "", "", "", "", "", "", formatCost((totalCostSpot + totalCost*threeYearDiscount) + clusterFee)})
+	rows = append(rows, table.Row{"... with all workloads on spot", "", "", "", "", "", "", "", "", formatCost(totalCostAllSpot + clusterFee)})
+	rows = append(rows, table.Row{"... savings with all on spot", "", "", "", "", "", "", "", "", formatCost(totalCost + totalCostSpot - totalCostAllSpot)})
+
+	if explain {
+		columns = insertBeforePrice(columns, table.Column{Title: "Class Reason", Width: 60})
+		for i := range rows {
+			reason := ""
+			if i < len(workloadRows) {
+				reason = workloadRows[i].workload.ClassReason
+			}
+			rows[i] = insertBeforePrice(rows[i], reason)
+		}
+	}
+
+	if adjustments {
+		columns = insertBeforePrice(columns,
+			table.Column{Title: "Raw " + cpuTitle, Width: 10},
+			table.Column{Title: "Raw " + memoryTitle, Width: 14},
+		)
+		for i := range rows {
+			cells := []string{"", ""}
+			if i < len(workloadRows) {
+				cells = []string{
+					formatCPU(workloadRows[i].workload.RawCpu),
+					formatMemory(workloadRows[i].workload.RawMemory),
+				}
+			}
+			rows[i] = insertBeforePrice(rows[i], cells...)
+		}
+	}
+
+	if breakdown {
+		columns = insertBeforePrice(columns,
+			table.Column{Title: "CPU $/H", Width: 10},
+			table.Column{Title: "Memory $/H", Width: 10},
+			table.Column{Title: "Storage $/H", Width: 11},
+		)
+		for i := range rows {
+			cells := []string{"", "", ""}
+			if i < len(workloadRows) {
+				costs := workloadRows[i].workload.Breakdown
+				cells = []string{
+					formatCost(costs.CPU),
+					formatCost(costs.Memory),
+					formatCost(costs.Storage),
+				}
+			}
+			rows[i] = insertBeforePrice(rows[i], cells...)
+		}
+	}
+
+	return columns, rows, cellStyles
 }
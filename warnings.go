@@ -0,0 +1,117 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"sync"
+
+	"golang.org/x/exp/slog"
+)
+
+// reportWarning is a warning logged while estimating, as listed in the json output. The message is stable, the
+// attributes are the ones of the log entry, with the affected workload, if any, in its own field.
+type reportWarning struct {
+	Level      string            `json:"level"`
+	Message    string            `json:"message"`
+	Workload   string            `json:"workload,omitempty"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+// warningLog holds the warnings collected by the handlers derived from the same warningCollector.
+type warningLog struct {
+	mu       sync.Mutex
+	warnings []reportWarning
+	seen     map[string]bool
+}
+
+// warningCollector is a slog.Handler collecting the warnings and errors for the report, whatever the log level,
+// and passing the records on to the handler writing the logs.
+type warningCollector struct {
+	handler slog.Handler
+	attrs   []slog.Attr
+	log     *warningLog
+}
+
+func newWarningCollector(handler slog.Handler) *warningCollector {
+	return &warningCollector{handler: handler, log: &warningLog{seen: map[string]bool{}}}
+}
+
+func (c *warningCollector) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= slog.LevelWarn || c.handler.Enabled(ctx, level)
+}
+
+func (c *warningCollector) Handle(ctx context.Context, record slog.Record) error {
+	if record.Level >= slog.LevelWarn {
+		c.collect(record)
+	}
+
+	if !c.handler.Enabled(ctx, record.Level) {
+		return nil
+	}
+	return c.handler.Handle(ctx, record)
+}
+
+func (c *warningCollector) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &warningCollector{handler: c.handler.WithAttrs(attrs), attrs: append(c.attrs[:len(c.attrs):len(c.attrs)], attrs...), log: c.log}
+}
+
+func (c *warningCollector) WithGroup(name string) slog.Handler {
+	return &warningCollector{handler: c.handler.WithGroup(name), attrs: c.attrs, log: c.log}
+}
+
+// collect adds the record to the warnings, once per message and attributes, since some warnings are logged for
+// every workload they affect.
+func (c *warningCollector) collect(record slog.Record) {
+	warning := reportWarning{Level: record.Level.String(), Message: record.Message}
+	key := warning.Level + " " + warning.Message
+
+	addAttr := func(attr slog.Attr) bool {
+		value := attr.Value.String()
+		key += " " + attr.Key + "=" + value
+		if attr.Key == "workload" || attr.Key == "pod" {
+			warning.Workload = value
+			return true
+		}
+
+		if warning.Attributes == nil {
+			warning.Attributes = map[string]string{}
+		}
+		warning.Attributes[attr.Key] = value
+		return true
+	}
+	for _, attr := range c.attrs {
+		addAttr(attr)
+	}
+	record.Attrs(addAttr)
+
+	c.log.mu.Lock()
+	defer c.log.mu.Unlock()
+	if c.log.seen[key] {
+		return
+	}
+	c.log.seen[key] = true
+	c.log.warnings = append(c.log.warnings, warning)
+}
+
+// Warnings returns the warnings collected so far, in the order they were logged.
+func (c *warningCollector) Warnings() []reportWarning {
+	c.log.mu.Lock()
+	defer c.log.mu.Unlock()
+
+	warnings := make([]reportWarning, len(c.log.warnings))
+	copy(warnings, c.log.warnings)
+	return warnings
+}